@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"order_book_exercise/service"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// binary converts input files between the text formats and the binary wire format, and with -events prints
+// the binary output of the main program as the text it would have printed. The result goes to -output, or
+// stdout when no -output is given.
+//
+//	go run ./cmd/binary -input input_file.csv -to BINARY -output input_file.bin
+//	go run ./cmd/binary -input input_file.bin -from BINARY -to CSV
+//	go run ./main.go -output-format BINARY > events.bin && go run ./cmd/binary -events -input events.bin -stamped
+func main() {
+	inputPath := flag.String("input", service.INPUT_PATH, "file to convert")
+	outputPath := flag.String("output", "", "file to write, stdout when empty")
+	from := flag.String("from", service.FORMAT_CSV, "format of the input file, CSV, JSONL or BINARY")
+	to := flag.String("to", service.FORMAT_BINARY, "format to convert to, CSV, JSONL or BINARY")
+	events := flag.Bool("events", false, "the input is binary event records, converted to -to CSV or JSONL")
+	stamped := flag.Bool("stamped", false, "lead each event converted to text with its sequence number and timestamp")
+	flag.Parse()
+
+	for _, format := range []string{*from, *to} {
+		if format != service.FORMAT_CSV && format != service.FORMAT_JSON_LINES && format != service.FORMAT_BINARY {
+			fmt.Fprintf(os.Stderr, "unknown format %v, expected %v, %v or %v\n", format, service.FORMAT_CSV, service.FORMAT_JSON_LINES, service.FORMAT_BINARY)
+			os.Exit(2)
+		}
+	}
+	if *events && *to == service.FORMAT_BINARY {
+		fmt.Fprintln(os.Stderr, "events can only be converted to CSV or JSONL")
+		os.Exit(2)
+	}
+
+	output := io.Writer(os.Stdout)
+	if *outputPath != "" {
+		file, err := os.Create(*outputPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, errors.Wrap(err, "error creating output in main function").Error())
+			os.Exit(1)
+		}
+		defer file.Close()
+		output = file
+	}
+	writer := bufio.NewWriter(output)
+	var err error
+	if *events {
+		err = convertEvents(*inputPath, *to, *stamped, writer)
+	} else {
+		err = convertCommands(*inputPath, *from, *to, writer)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+// convertCommands: writes the scenarios of an input file in another format
+func convertCommands(inputPath string, from string, to string, output io.Writer) error {
+	parserService := service.NewParserService()
+	parserService.InputFormat = from
+	orderBooks, err := parserService.ParseOrderBooks(inputPath)
+	if err != nil {
+		return errors.Wrap(err, "error parsing input file in convertCommands()")
+	}
+
+	if to == service.FORMAT_BINARY {
+		buffer := service.AppendBinaryHeader(nil, service.BINARY_COMMANDS)
+		for _, orderBook := range orderBooks {
+			for _, command := range orderBook {
+				if buffer, err = service.AppendBinaryCommand(buffer, command); err != nil {
+					return errors.Wrapf(err, "error encoding command %q in convertCommands()", parserService.FormatCommand(command))
+				}
+			}
+		}
+		if _, err := output.Write(buffer); err != nil {
+			return errors.Wrap(err, "error writing output in convertCommands()")
+		}
+		return nil
+	}
+
+	format := parserService.FormatCommand
+	if to == service.FORMAT_JSON_LINES {
+		format = parserService.FormatJSONCommand
+	}
+	for _, orderBook := range orderBooks {
+		for _, command := range orderBook {
+			if _, err := fmt.Fprintln(output, format(command)); err != nil {
+				return errors.Wrap(err, "error writing output in convertCommands()")
+			}
+		}
+	}
+	return nil
+}
+
+// convertEvents: prints binary event records as the output the main program would have printed
+func convertEvents(inputPath string, to string, isStamped bool, output io.Writer) error {
+	data, err := ioutil.ReadFile(inputPath)
+	if err != nil {
+		return errors.Wrap(err, "error reading input file in convertEvents()")
+	}
+	if err := service.WriteBinaryEvents(data, to, isStamped, output); err != nil {
+		return errors.Wrap(err, "error converting events in convertEvents()")
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"order_book_exercise/service"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// fix runs the engine behind a FIX 4.4 acceptor on FIX_ADDRESS, with a book for every symbol orders are
+// entered in. Every counterparty is given with the user its orders are entered for, and its messages are
+// kept in FIX_STORE_DIRECTORY for resending. The journal is not used as it records a single book.
+//
+//	go run ./cmd/fix -users BUYER=1,SELLER=2
+func main() {
+	users := flag.String("users", "", "counterparties as SenderCompID=userId, separated by commas")
+	flag.Parse()
+	counterparties, err := parseUsers(*users)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+
+	var instruments map[string]service.Instrument
+	if service.INSTRUMENTS_PATH != "" {
+		parsed, err := service.NewParserService().ParseInstruments(service.INSTRUMENTS_PATH)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, errors.Wrap(err, "error parsing instruments in main function").Error())
+			os.Exit(1)
+		}
+		instruments = parsed
+	}
+	newService := func() *service.OrderBookService {
+		orderbookService := service.NewOrderBookService()
+		orderbookService.Output = ioutil.Discard
+		orderbookService.Instruments = instruments
+		return orderbookService
+	}
+
+	acceptor, err := service.NewFIXAcceptor(newService, service.FIX_SENDER_COMP_ID, counterparties, service.FIX_STORE_DIRECTORY)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, errors.Wrap(err, "error creating FIX acceptor in main function").Error())
+		os.Exit(1)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		acceptor.Close()
+	}()
+
+	fmt.Printf("FIX acceptor %v listening on %v\n", service.FIX_SENDER_COMP_ID, service.FIX_ADDRESS)
+	if err := acceptor.ListenAndServe(service.FIX_ADDRESS); err != nil {
+		fmt.Fprintln(os.Stderr, errors.Wrap(err, "error serving FIX in main function").Error())
+		os.Exit(1)
+	}
+}
+
+// parseUsers: reads counterparties in the form BUYER=1,SELLER=2
+func parseUsers(value string) (map[string]int, error) {
+	users := make(map[string]int)
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.Split(entry, "=")
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, errors.Errorf("-users expects SenderCompID=userId pairs, received %q", entry)
+		}
+		userID, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, errors.Wrapf(err, "error converting user id of %v in parseUsers()", parts[0])
+		}
+		users[strings.TrimSpace(parts[0])] = userID
+	}
+	return users, nil
+}
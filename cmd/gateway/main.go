@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"order_book_exercise/service"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// gateway runs the engine as a long-lived TCP server on GATEWAY_ADDRESS, with a book for every symbol
+// orders are entered in. Clients send commands in the format of the input file, one per line, and receive
+// the lines the main program would print for them. The journal is not used as it records a single book.
+//
+//	go run ./cmd/gateway
+//	printf 'N, 1, IBM, 10, 100, B, 1\n' | nc localhost 9000
+func main() {
+	var instruments map[string]service.Instrument
+	if service.INSTRUMENTS_PATH != "" {
+		parsed, err := service.NewParserService().ParseInstruments(service.INSTRUMENTS_PATH)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, errors.Wrap(err, "error parsing instruments in main function").Error())
+			os.Exit(1)
+		}
+		instruments = parsed
+	}
+	gateway := service.NewGateway(func() *service.OrderBookService {
+		orderbookService := service.NewOrderBookService()
+		orderbookService.Output = ioutil.Discard
+		orderbookService.Instruments = instruments
+		return orderbookService
+	})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		gateway.Close()
+	}()
+
+	fmt.Printf("Gateway listening on %v\n", service.GATEWAY_ADDRESS)
+	if err := gateway.ListenAndServe(service.GATEWAY_ADDRESS); err != nil {
+		fmt.Fprintln(os.Stderr, errors.Wrap(err, "error serving gateway in main function").Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"order_book_exercise/service"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// http serves the JSON API on HTTP_ADDRESS, with a book for every symbol orders are submitted in, and
+// streams market data over a WebSocket on /marketdata. The journal is not used as it records a single book.
+//
+//	go run ./cmd/http
+//	curl -X POST localhost:8080/users/1/orders -d '{"userOrderId":1,"symbol":"IBM","side":"B","price":"10","quantity":100}'
+//	curl localhost:8080/books/IBM/top
+func main() {
+	var instruments map[string]service.Instrument
+	if service.INSTRUMENTS_PATH != "" {
+		parsed, err := service.NewParserService().ParseInstruments(service.INSTRUMENTS_PATH)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, errors.Wrap(err, "error parsing instruments in main function").Error())
+			os.Exit(1)
+		}
+		instruments = parsed
+	}
+	api := service.NewHTTPServer(func() *service.OrderBookService {
+		orderbookService := service.NewOrderBookService()
+		orderbookService.Output = ioutil.Discard
+		orderbookService.Instruments = instruments
+		orderbookService.DepthLevels = service.MARKET_DATA_DEPTH_LEVELS
+		return orderbookService
+	})
+
+	server := &http.Server{Addr: service.HTTP_ADDRESS, Handler: api}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		server.Shutdown(context.Background())
+	}()
+
+	fmt.Printf("HTTP API listening on %v\n", service.HTTP_ADDRESS)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Fprintln(os.Stderr, errors.Wrap(err, "error serving HTTP API in main function").Error())
+		os.Exit(1)
+	}
+}
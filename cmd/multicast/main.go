@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"order_book_exercise/service"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// multicast publishes the top of book and trades of the input file to MULTICAST_ADDRESS, one command every
+// -interval, and serves retransmissions on RETRANSMIT_ADDRESS until interrupted. With -receive it is the
+// reference receiver instead, printing each packet and the book it leaves.
+//
+//	go run ./cmd/multicast -input input_file.csv -interval 100ms
+//	go run ./cmd/multicast -receive -retransmit localhost:30002
+func main() {
+	inputPath := flag.String("input", service.INPUT_PATH, "input file in the CSV format of the main program")
+	symbol := flag.String("symbol", "IBM", "symbol the packets are published under")
+	interval := flag.Duration("interval", 0, "pause between commands")
+	receive := flag.Bool("receive", false, "receive instead of publishing")
+	retransmitAddress := flag.String("retransmit", service.RETRANSMIT_ADDRESS, "retransmission service of the publisher")
+	flag.Parse()
+
+	if *receive {
+		if err := receivePackets(*retransmitAddress); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	orderbookService := service.NewOrderBookService()
+	orderbookService.Output = ioutil.Discard
+	publisher, err := service.NewMulticastPublisher(orderbookService, *symbol, service.MULTICAST_ADDRESS)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, errors.Wrap(err, "error creating publisher in main function").Error())
+		os.Exit(1)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		publisher.Close()
+	}()
+	go func() {
+		if err := publishInput(orderbookService, *inputPath, *interval); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+	}()
+
+	fmt.Printf("Publishing to %v, retransmissions on %v\n", service.MULTICAST_ADDRESS, *retransmitAddress)
+	if err := publisher.ListenAndServe(*retransmitAddress); err != nil {
+		fmt.Fprintln(os.Stderr, errors.Wrap(err, "error serving retransmissions in main function").Error())
+		os.Exit(1)
+	}
+}
+
+// publishInput: applies the scenarios of the input file one after another, flushing the book in between
+func publishInput(orderbookService *service.OrderBookService, inputPath string, interval time.Duration) error {
+	parserService := service.NewParserService()
+	orderBookListData, err := parserService.ParseCSVFile(inputPath)
+	if err != nil {
+		return errors.Wrap(err, "error parsing input file in publishInput()")
+	}
+	orderBooks, err := parserService.TransformOrderBookListData(orderBookListData)
+	if err != nil {
+		return errors.Wrap(err, "error transforming input file in publishInput()")
+	}
+	for _, orderBook := range orderBooks {
+		for _, command := range orderBook {
+			if _, err := orderbookService.Apply(command); err != nil {
+				return errors.Wrap(err, "error applying command in publishInput()")
+			}
+			time.Sleep(interval)
+		}
+		if _, err := orderbookService.Apply(service.Order{Command: service.FLUSH_ORDER_BOOK}); err != nil {
+			return errors.Wrap(err, "error flushing book in publishInput()")
+		}
+	}
+	return nil
+}
+
+func receivePackets(retransmitAddress string) error {
+	receiver, err := service.NewMulticastReceiver(service.MULTICAST_ADDRESS, retransmitAddress)
+	if err != nil {
+		return errors.Wrap(err, "error joining group in receivePackets()")
+	}
+	defer receiver.Close()
+	fmt.Printf("Receiving from %v\n", service.MULTICAST_ADDRESS)
+	for {
+		packet, err := receiver.Receive(time.Hour)
+		if err != nil {
+			return errors.Wrap(err, "error receiving in receivePackets()")
+		}
+		book, _ := receiver.Book(packet.Symbol)
+		fmt.Printf("%v %v %v | bid %v | ask %v | last %v x %v, volume %v | gaps %v, lost %v\n", packet.Sequence, packet.Symbol, packet.Type,
+			topBook(book.Bid), topBook(book.Ask), book.LastTradePrice, book.LastTradeQuantity, book.Volume, receiver.Gaps, receiver.Lost)
+	}
+}
+
+func topBook(top service.TopBook) string {
+	if !top.IsSet {
+		return "-"
+	}
+	return fmt.Sprintf("%v x %v", top.Quantity, top.Price)
+}
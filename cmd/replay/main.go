@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"order_book_exercise/service"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// replay runs a recorded input file or journal through a fresh OrderBookService with the same
+// configuration as the main program. With -record the output is saved as the reference, with -expected
+// it is compared byte for byte against a reference recorded earlier. Input files run against a simulated
+// clock starting at the Unix epoch and journals replay the times they recorded, so stamped output is
+// reproducible too.
+//
+//	go run ./cmd/replay -input input_file.csv -record expected.txt
+//	go run ./cmd/replay -input input_file.csv -expected expected.txt
+//	go run ./cmd/replay -journal commands.journal -expected expected.txt
+//	go run ./cmd/replay -input commands.jsonl -input-format JSONL -output-format JSONL
+//	go run ./cmd/replay -input input_file.bin -input-format BINARY -expected expected.txt
+func main() {
+	inputPath := flag.String("input", "", "input file of the main program")
+	inputFormat := flag.String("input-format", service.INPUT_FORMAT, "format of the input file, CSV, JSONL or BINARY")
+	outputFormat := flag.String("output-format", service.OUTPUT_FORMAT, "format of the output, CSV, JSONL or BINARY")
+	journalPath := flag.String("journal", "", "journal of inbound commands")
+	expectedPath := flag.String("expected", "", "previously recorded output to verify against")
+	recordPath := flag.String("record", "", "file to record the replayed output to")
+	context := flag.Int("context", 3, "lines of context shown around a divergence")
+	flag.Parse()
+
+	if (*inputPath == "") == (*journalPath == "") {
+		fmt.Fprintln(os.Stderr, "exactly one of -input or -journal is required")
+		os.Exit(2)
+	}
+	for _, format := range []string{*inputFormat, *outputFormat} {
+		if format != service.FORMAT_CSV && format != service.FORMAT_JSON_LINES && format != service.FORMAT_BINARY {
+			fmt.Fprintf(os.Stderr, "unknown format %v, expected %v, %v or %v\n", format, service.FORMAT_CSV, service.FORMAT_JSON_LINES, service.FORMAT_BINARY)
+			os.Exit(2)
+		}
+	}
+	output, err := replay(*inputPath, *journalPath, *inputFormat, *outputFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	if *recordPath != "" {
+		if err := ioutil.WriteFile(*recordPath, output, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, errors.Wrap(err, "error recording output in main function").Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Recorded %v bytes of output to %v\n", len(output), *recordPath)
+	}
+	if *expectedPath != "" {
+		expected, err := ioutil.ReadFile(*expectedPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, errors.Wrap(err, "error reading expected output in main function").Error())
+			os.Exit(1)
+		}
+		if divergence := service.FindDivergence(expected, output, *context); divergence != nil {
+			fmt.Print(divergence)
+			os.Exit(1)
+		}
+		fmt.Printf("Output matches %v\n", *expectedPath)
+	}
+	if *recordPath == "" && *expectedPath == "" {
+		os.Stdout.Write(output)
+	}
+}
+
+// replay: everything the service prints for the input file or journal
+func replay(inputPath string, journalPath string, inputFormat string, outputFormat string) ([]byte, error) {
+	var output bytes.Buffer
+	orderbookService := service.NewOrderBookService()
+	orderbookService.Output = &output
+	orderbookService.Clock = service.NewSimulatedClock(time.Unix(0, 0))
+	orderbookService.OutputFormat = outputFormat
+	parserService := service.NewParserService()
+	parserService.InputFormat = inputFormat
+	if service.INSTRUMENTS_PATH != "" {
+		instruments, err := parserService.ParseInstruments(service.INSTRUMENTS_PATH)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing instruments in replay()")
+		}
+		orderbookService.Instruments = instruments
+	}
+
+	if inputPath != "" {
+		orderBooks, err := parserService.ParseOrderBooks(inputPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing input file in replay()")
+		}
+		if err := orderbookService.ProcessOrderBooks(orderBooks); err != nil {
+			return nil, errors.Wrap(err, "error replaying input file in replay()")
+		}
+		return output.Bytes(), nil
+	}
+
+	file, err := os.Open(journalPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening journal in replay()")
+	}
+	defer file.Close()
+	scan, err := service.ReadJournal(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading journal in replay()")
+	}
+	if scan.Damage != "" {
+		fmt.Fprintf(os.Stderr, "journal ends in a damaged record (%v), replaying the %v intact records\n", scan.Damage, len(scan.Records))
+	}
+	commands := make([]service.Order, 0, len(scan.Records))
+	for _, record := range scan.Records {
+		commands = append(commands, record.Command)
+	}
+	if err := orderbookService.ProcessOrderBook(commands); err != nil {
+		return nil, errors.Wrap(err, "error replaying journal in replay()")
+	}
+	return output.Bytes(), nil
+}
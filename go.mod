@@ -4,5 +4,6 @@ go 1.15
 
 require (
 	github.com/davecgh/go-spew v1.1.1
+	github.com/gorilla/websocket v1.4.2
 	github.com/pkg/errors v0.9.1
 )
@@ -1,45 +1,86 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"order_book_exercise/service"
+	"os"
 
 	"github.com/pkg/errors"
 )
 
 func main() {
-	fmt.Println("Order Book Excercise Started")
+	inputFormat := flag.String("input-format", service.INPUT_FORMAT, "format of the input file, CSV, JSONL or BINARY")
+	outputFormat := flag.String("output-format", service.OUTPUT_FORMAT, "format of the output, CSV, JSONL or BINARY")
+	flag.Parse()
+	for _, format := range []string{*inputFormat, *outputFormat} {
+		if format != service.FORMAT_CSV && format != service.FORMAT_JSON_LINES && format != service.FORMAT_BINARY {
+			fmt.Fprintf(os.Stderr, "unknown format %v, expected %v, %v or %v\n", format, service.FORMAT_CSV, service.FORMAT_JSON_LINES, service.FORMAT_BINARY)
+			os.Exit(2)
+		}
+	}
+	// the banner would be the one line of JSON Lines output that is not JSON
+	if *outputFormat == service.FORMAT_CSV {
+		fmt.Println("Order Book Excercise Started")
+	}
 	// Parse raw data
 	parserService := service.NewParserService()
-	orderBookListData, err := parserService.ParseCSV()
-	if err != nil {
-		err = errors.Wrap(err, "error parsing CSV in main function")
-		fmt.Println(err.Error())
-		panic(err)
-	}
-	// Transform raw data to supported struct
-	orderBooks, err := parserService.TransformOrderBookListData(orderBookListData)
+	parserService.InputFormat = *inputFormat
+	orderBooks, err := parserService.ParseOrderBooks(service.INPUT_PATH)
 	if err != nil {
-		err = errors.Wrap(err, "error transforming raw string data in main function")
+		err = errors.Wrap(err, "error parsing input in main function")
 		fmt.Println(err.Error())
 		panic(err)
 	}
 
 	orderbookService := service.NewOrderBookService()
-	// iterate through each constructed orderbook order, process and log the results
-	// TODO: Execute orderbook processing via threaded go routines and save the data to external database.
-	for i, orderBook := range orderBooks {
-
-		// Create a fresh orderbook and attach to the service
-		orderbookService.OrderBook = service.NewOrderBook()
-
-		fmt.Printf("Processing Order book %v\n", i+1)
-		err = orderbookService.ProcessOrderBook(orderBook)
+	orderbookService.OutputFormat = *outputFormat
+	if service.INSTRUMENTS_PATH != "" {
+		orderbookService.Instruments, err = parserService.ParseInstruments(service.INSTRUMENTS_PATH)
+		if err != nil {
+			err = errors.Wrap(err, "error parsing instruments in main function")
+			fmt.Println(err.Error())
+			panic(err)
+		}
+	}
+	if service.ORDER_FEED_PATH != "" {
+		orderFeedFile, err := os.Create(service.ORDER_FEED_PATH)
+		if err != nil {
+			err = errors.Wrap(err, "error creating order feed file in main function")
+			fmt.Println(err.Error())
+			panic(err)
+		}
+		defer orderFeedFile.Close()
+		orderbookService.OrderFeed = service.NewOrderFeed(orderFeedFile)
+	}
+	if service.JOURNAL_PATH != "" {
+		if service.RECOVER_FROM_JOURNAL {
+			scan, err := orderbookService.Recover(service.JOURNAL_PATH, service.SNAPSHOT_PATH)
+			if err != nil {
+				err = errors.Wrap(err, "error recovering from journal in main function")
+				fmt.Println(err.Error())
+				panic(err)
+			}
+			fmt.Printf("Recovered %v journaled commands\n", len(scan.Records))
+			if scan.Damage != "" {
+				fmt.Printf("Discarded damaged journal tail: %v\n", scan.Damage)
+			}
+		}
+		journal, err := service.OpenJournal(service.JOURNAL_PATH, service.JOURNAL_FSYNC_POLICY, service.JOURNAL_FSYNC_BATCH_SIZE)
 		if err != nil {
-			err = errors.Wrapf(err, "error Processing order book %v in main function", i+1)
+			err = errors.Wrap(err, "error opening journal in main function")
 			fmt.Println(err.Error())
 			panic(err)
 		}
-		fmt.Println()
+		defer journal.Close()
+		orderbookService.Journal = journal
+	}
+	// process each constructed orderbook concurrently and log the results in scenario order
+	// TODO: save the data to external database.
+	err = orderbookService.ProcessOrderBooksParallel(orderBooks, service.SCENARIO_WORKERS)
+	if err != nil {
+		err = errors.Wrap(err, "error processing order books in main function")
+		fmt.Println(err.Error())
+		panic(err)
 	}
 }
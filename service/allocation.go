@@ -0,0 +1,123 @@
+package service
+
+import "github.com/pkg/errors"
+
+// AllocationStrategy: decides how an incoming quantity is shared between the resting orders of a single
+// price level. level is in time priority and the returned slice holds the fill for each of its orders.
+// Implementations must hand out the smaller of quantity and the level's total size.
+type AllocationStrategy interface {
+	Allocate(quantity int, level []Order) []int
+}
+
+// PriceTimeAllocation: fills resting orders strictly first in, first out
+type PriceTimeAllocation struct{}
+
+// ProRataAllocation: fills resting orders in proportion to their size
+type ProRataAllocation struct {
+	MinimumAllocation int    // proportional shares below this are dropped and handed out as leftover
+	Rounding          string // ROUND_DOWN or ROUND_NEAREST for fractional shares
+}
+
+// HybridAllocation: the first order at the level is filled before anyone else, up to TopOrderMaximum
+// (0 for no limit), and what is left is shared pro-rata between the rest of the level
+type HybridAllocation struct {
+	TopOrderMaximum int
+	ProRata         ProRataAllocation
+}
+
+// NewAllocationStrategy: builds one of the allocation algorithms shipped with the service by name
+func NewAllocationStrategy(algorithm string) (AllocationStrategy, error) {
+	proRata := ProRataAllocation{
+		MinimumAllocation: PRO_RATA_MINIMUM_ALLOCATION,
+		Rounding:          PRO_RATA_ROUNDING,
+	}
+	switch algorithm {
+	case PRICE_TIME:
+		return PriceTimeAllocation{}, nil
+	case PRO_RATA:
+		return proRata, nil
+	case HYBRID:
+		return HybridAllocation{TopOrderMaximum: HYBRID_TOP_ORDER_MAXIMUM, ProRata: proRata}, nil
+	}
+	return nil, errors.Errorf("unknown allocation algorithm %v in NewAllocationStrategy()", algorithm)
+}
+
+func (a PriceTimeAllocation) Allocate(quantity int, level []Order) []int {
+	allocations := make([]int, len(level))
+	allocateLeftover(quantity, level, allocations)
+	return allocations
+}
+
+func (a ProRataAllocation) Allocate(quantity int, level []Order) []int {
+	allocations := make([]int, len(level))
+	total := 0
+	for _, order := range level {
+		total += order.Quantity
+	}
+	if quantity >= total {
+		for i, order := range level {
+			allocations[i] = order.Quantity
+		}
+		return allocations
+	}
+
+	allocated := 0
+	for i, order := range level {
+		share := quantity * order.Quantity / total
+		if a.Rounding == ROUND_NEAREST && (quantity*order.Quantity)%total*2 >= total {
+			share++
+		}
+		if share < a.MinimumAllocation {
+			share = 0
+		}
+		allocations[i] = share
+		allocated += share
+	}
+	// rounding to nearest can hand out a lot too many, the newest orders give it back first
+	for i := len(level) - 1; i >= 0 && allocated > quantity; i-- {
+		excess := allocated - quantity
+		if allocations[i] < excess {
+			excess = allocations[i]
+		}
+		allocations[i] -= excess
+		allocated -= excess
+	}
+	allocateLeftover(quantity-allocated, level, allocations)
+	return allocations
+}
+
+func (a HybridAllocation) Allocate(quantity int, level []Order) []int {
+	if len(level) == 0 {
+		return nil
+	}
+	topFill := level[0].Quantity
+	if a.TopOrderMaximum > 0 && a.TopOrderMaximum < topFill {
+		topFill = a.TopOrderMaximum
+	}
+	if quantity < topFill {
+		topFill = quantity
+	}
+
+	// the top order takes part in the pro-rata split with whatever it has left
+	rest := make([]Order, len(level))
+	copy(rest, level)
+	rest[0].Quantity -= topFill
+	allocations := a.ProRata.Allocate(quantity-topFill, rest)
+	allocations[0] += topFill
+	return allocations
+}
+
+// allocateLeftover: hands out quantity in time priority on top of what each order was already allocated
+func allocateLeftover(quantity int, level []Order, allocations []int) {
+	for i, order := range level {
+		if quantity == 0 {
+			return
+		}
+		fill := order.Quantity - allocations[i]
+		if quantity < fill {
+			fill = quantity
+		}
+		allocations[i] += fill
+		quantity -= fill
+	}
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAllocate(t *testing.T) {
+	level := []Order{
+		{UserID: 1, UserOrderID: 1, Quantity: 50},
+		{UserID: 2, UserOrderID: 2, Quantity: 30},
+		{UserID: 3, UserOrderID: 3, Quantity: 20},
+	}
+	tests := map[string]struct {
+		strategy    AllocationStrategy
+		quantity    int
+		allocations []int
+	}{
+		"Price time": {
+			strategy:    PriceTimeAllocation{},
+			quantity:    60,
+			allocations: []int{50, 10, 0},
+		},
+		"Pro rata exact shares": {
+			strategy:    ProRataAllocation{MinimumAllocation: 1, Rounding: ROUND_DOWN},
+			quantity:    60,
+			allocations: []int{30, 18, 12},
+		},
+		"Pro rata round down leftover goes by time": {
+			strategy:    ProRataAllocation{MinimumAllocation: 1, Rounding: ROUND_DOWN},
+			quantity:    7,
+			allocations: []int{4, 2, 1},
+		},
+		"Pro rata minimum allocation": {
+			strategy:    ProRataAllocation{MinimumAllocation: 2, Rounding: ROUND_DOWN},
+			quantity:    7,
+			allocations: []int{5, 2, 0},
+		},
+		"Pro rata round nearest gives back from newest": {
+			strategy:    ProRataAllocation{MinimumAllocation: 1, Rounding: ROUND_NEAREST},
+			quantity:    5,
+			allocations: []int{3, 2, 0},
+		},
+		"Pro rata sweeps the level": {
+			strategy:    ProRataAllocation{MinimumAllocation: 1, Rounding: ROUND_DOWN},
+			quantity:    150,
+			allocations: []int{50, 30, 20},
+		},
+		"Hybrid top order filled first": {
+			strategy:    HybridAllocation{ProRata: ProRataAllocation{MinimumAllocation: 1, Rounding: ROUND_DOWN}},
+			quantity:    60,
+			allocations: []int{50, 6, 4},
+		},
+		"Hybrid top order maximum": {
+			strategy:    HybridAllocation{TopOrderMaximum: 20, ProRata: ProRataAllocation{MinimumAllocation: 1, Rounding: ROUND_DOWN}},
+			quantity:    60,
+			allocations: []int{35, 15, 10},
+		},
+	}
+
+	for name, test := range tests {
+		allocations := test.strategy.Allocate(test.quantity, level)
+		if !reflect.DeepEqual(allocations, test.allocations) {
+			t.Errorf("Expected allocations %v, received %v for test %s", test.allocations, allocations, name)
+		}
+	}
+}
+
+func TestExecuteTradeAllocation(t *testing.T) {
+	testService := NewOrderBookService()
+	testService.IsTradingEnabled = true
+	testService.Allocation = ProRataAllocation{MinimumAllocation: 1, Rounding: ROUND_DOWN}
+	testService.orderBook = NewOrderBook()
+
+	orders := []*Order{
+		{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 300, Side: SELL},
+		{Command: NEW_ORDER, UserID: 2, UserOrderID: 2, Price: intPrice(10), Quantity: 100, Side: SELL},
+		{Command: NEW_ORDER, UserID: 3, UserOrderID: 3, Price: intPrice(11), Quantity: 100, Side: SELL},
+		{Command: NEW_ORDER, UserID: 4, UserOrderID: 4, Price: intPrice(11), Quantity: 450, Side: BUY},
+	}
+	for _, order := range orders {
+		if _, err := testService.newOrder(order); err != nil {
+			t.Fatalf("Expected no error, received %s", err)
+		}
+	}
+
+	events, err := testService.executeTrade(orders[3])
+	output := eventLines(events)
+	if err != nil {
+		t.Errorf("Expected no error, received %s", err)
+	}
+	expected := []string{
+		"T, 4, 4, 1, 1, 10, 300",
+		"T, 4, 4, 2, 2, 10, 100",
+		"T, 4, 4, 3, 3, 11, 50",
+	}
+	if !reflect.DeepEqual(output, expected) {
+		t.Errorf("Expected output %v, received %v", expected, output)
+	}
+	if len(testService.orderBook.Bids) != 0 || len(testService.orderBook.Asks) != 1 || testService.orderBook.Asks[0].Quantity != 50 {
+		t.Errorf("Expected a single ask of 50 left, received bids %v asks %v", testService.orderBook.Bids, testService.orderBook.Asks)
+	}
+}
@@ -0,0 +1,117 @@
+package service
+
+// Submit: enters a new order and returns every event it caused, numbered and stamped as they were published
+func (o *OrderBookService) Submit(order Order) ([]Event, error) {
+	order.Command = NEW_ORDER
+	return o.Apply(order)
+}
+
+// Cancel: takes an order out of the book and returns every event it caused
+func (o *OrderBookService) Cancel(userID int, userOrderID int) ([]Event, error) {
+	return o.Apply(Order{Command: CANCEL_ORDER, UserID: userID, UserOrderID: userOrderID})
+}
+
+// Amend: changes the price and quantity of a resting order and returns every event it caused. Reducing the
+// quantity at the same price keeps the order's time priority, any other change sends it to the back of
+// its new price level.
+func (o *OrderBookService) Amend(userID int, userOrderID int, price Price, quantity int) ([]Event, error) {
+	return o.Apply(Order{Command: AMEND_ORDER, UserID: userID, UserOrderID: userOrderID, Price: price, Quantity: quantity})
+}
+
+// Apply: processes any inbound command as a single step and returns every event it caused
+func (o *OrderBookService) Apply(command Order) ([]Event, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.processCommand(command)
+}
+
+// Book: a copy of the book as it stands between two commands, free for the caller to keep or change
+func (o *OrderBookService) Book() OrderBook {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return copyBook(o.orderBook)
+}
+
+// Order: the resting order with userOrderID, as it stands
+func (o *OrderBookService) Order(userOrderID int) (Order, bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	side, found := o.orderBook.OrderDict[userOrderID]
+	if !found {
+		return Order{}, false
+	}
+	order := o.findOrder(side, userOrderID)
+	if order == nil {
+		return Order{}, false
+	}
+	return *order, true
+}
+
+// Subscribe: returns a channel that receives every event published from now on, in order, and a function
+// that ends the subscription. Publishing never waits for a subscriber: one that lets buffer events pile up
+// is dropped and its channel closed, so it knows it missed events.
+func (o *OrderBookService) Subscribe(buffer int) (<-chan Event, func()) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.subscribers == nil {
+		o.subscribers = make(map[int]chan Event)
+	}
+	o.nextSubscriber++
+	id := o.nextSubscriber
+	events := make(chan Event, buffer)
+	o.subscribers[id] = events
+	unsubscribe := func() {
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+		if subscriber, found := o.subscribers[id]; found {
+			delete(o.subscribers, id)
+			close(subscriber)
+		}
+	}
+	return events, unsubscribe
+}
+
+// notifySubscribers: hands an event to every subscriber, the caller holds the mutex
+func (o *OrderBookService) notifySubscribers(event Event) {
+	for id, subscriber := range o.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			delete(o.subscribers, id)
+			close(subscriber)
+		}
+	}
+}
+
+// copyBook: a deep copy of a book that shares nothing with the original
+func copyBook(book OrderBook) OrderBook {
+	copied := book
+	copied.Bids = copyOrders(book.Bids)
+	copied.Asks = copyOrders(book.Asks)
+	copied.PublishedBidDepth = copyLevels(book.PublishedBidDepth)
+	copied.PublishedAskDepth = copyLevels(book.PublishedAskDepth)
+	if book.Expiries != nil {
+		copied.Expiries = append(ExpiryQueue{}, book.Expiries...)
+	}
+	if book.OrderDict != nil {
+		copied.OrderDict = make(map[int]string, len(book.OrderDict))
+		for userOrderID, side := range book.OrderDict {
+			copied.OrderDict[userOrderID] = side
+		}
+	}
+	return copied
+}
+
+func copyOrders(orders []Order) []Order {
+	if orders == nil {
+		return nil
+	}
+	return append([]Order{}, orders...)
+}
+
+func copyLevels(levels []DepthLevel) []DepthLevel {
+	if levels == nil {
+		return nil
+	}
+	return append([]DepthLevel{}, levels...)
+}
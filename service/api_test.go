@@ -0,0 +1,187 @@
+package service
+
+import (
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAmendOrder(t *testing.T) {
+	resting := []Order{
+		{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: BUY},
+		{Command: NEW_ORDER, UserID: 2, UserOrderID: 2, Price: intPrice(10), Quantity: 50, Side: BUY},
+		{Command: NEW_ORDER, UserID: 3, UserOrderID: 3, Price: intPrice(12), Quantity: 80, Side: SELL},
+	}
+	tests := map[string]struct {
+		isTradingEnabled bool
+		amend            Order
+		output           []string
+		bids             []int // user order ids in priority order
+		asks             []int
+	}{
+		"Reduce in place keeps priority": {
+			amend:  Order{UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 60},
+			output: []string{"A, 1, 1", "B, B, 10, 60"},
+			bids:   []int{1, 2},
+			asks:   []int{3},
+		},
+		"Increase loses priority": {
+			amend:  Order{UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 120},
+			output: []string{"A, 1, 1", "B, B, 10, 50"},
+			bids:   []int{2, 1},
+			asks:   []int{3},
+		},
+		"Price change moves the order": {
+			amend:  Order{UserID: 2, UserOrderID: 2, Price: intPrice(11), Quantity: 50},
+			output: []string{"A, 2, 2", "B, B, 11, 50"},
+			bids:   []int{2, 1},
+			asks:   []int{3},
+		},
+		"Amend that crosses trades": {
+			isTradingEnabled: true,
+			amend:            Order{UserID: 2, UserOrderID: 2, Price: intPrice(12), Quantity: 30},
+			output:           []string{"A, 2, 2", "T, 2, 2, 3, 3, 12, 30", "B, S, 12, 50"},
+			bids:             []int{1},
+			asks:             []int{3},
+		},
+		"Amend that would cross without trading is rejected": {
+			amend:  Order{UserID: 2, UserOrderID: 2, Price: intPrice(12), Quantity: 50},
+			output: []string{"R, 2, 2"},
+			bids:   []int{1, 2},
+			asks:   []int{3},
+		},
+		"Unknown order": {
+			amend:  Order{UserID: 4, UserOrderID: 9, Price: intPrice(10), Quantity: 10},
+			output: []string{"R, 4, 9, UNKNOWN_ORDER"},
+			bids:   []int{1, 2},
+			asks:   []int{3},
+		},
+		"Amend to a zero price": {
+			amend:  Order{UserID: 2, UserOrderID: 2, Price: intPrice(0), Quantity: 50},
+			output: []string{"A, 2, 2"},
+			bids:   []int{1, 2},
+			asks:   []int{3},
+		},
+		"Nothing left to amend to": {
+			amend:  Order{UserID: 1, UserOrderID: 1, Price: intPrice(10)},
+			output: []string{"R, 1, 1"},
+			bids:   []int{1, 2},
+			asks:   []int{3},
+		},
+	}
+
+	for name, test := range tests {
+		testService := NewOrderBookService()
+		testService.IsTradingEnabled = test.isTradingEnabled
+		testService.Output = ioutil.Discard
+		testService.Clock = NewSimulatedClock(time.Unix(0, 0))
+		if err := testService.ProcessOrderBook(resting); err != nil {
+			t.Fatalf("Expected no error, received %s for test %s", err, name)
+		}
+		events, err := testService.Amend(test.amend.UserID, test.amend.UserOrderID, test.amend.Price, test.amend.Quantity)
+		if err != nil {
+			t.Errorf("Expected no error, received %s for test %s", err, name)
+		}
+		if output := eventLines(events); !reflect.DeepEqual(output, test.output) {
+			t.Errorf("Expected output %v, received %v for test %s", test.output, output, name)
+		}
+		book := testService.Book()
+		for side, expected := range map[string][]int{BUY: test.bids, SELL: test.asks} {
+			orders := book.Bids
+			if side == SELL {
+				orders = book.Asks
+			}
+			var received []int
+			for _, order := range orders {
+				received = append(received, order.UserOrderID)
+			}
+			if !reflect.DeepEqual(received, expected) {
+				t.Errorf("Expected %v orders %v, received %v for test %s", side, expected, received, name)
+			}
+		}
+		if err := validateBook(book); err != nil {
+			t.Errorf("Expected a consistent book, received %s for test %s", err, name)
+		}
+	}
+}
+
+func TestConcurrentCallers(t *testing.T) {
+	testService := NewOrderBookService()
+	testService.IsTradingEnabled = true
+	testService.Output = ioutil.Discard
+	events, unsubscribe := testService.Subscribe(100000)
+	defer unsubscribe()
+
+	var wait sync.WaitGroup
+	for user := 1; user <= 8; user++ {
+		wait.Add(1)
+		go func(user int) {
+			defer wait.Done()
+			for i := 0; i < 100; i++ {
+				userOrderID := user*1000 + i
+				side := BUY
+				if (user+i)%2 == 0 {
+					side = SELL
+				}
+				if _, err := testService.Submit(Order{UserID: user, UserOrderID: userOrderID, Price: intPrice(int64(95 + i%10)), Quantity: 10, Side: side}); err != nil {
+					t.Errorf("Expected no error, received %s", err)
+					return
+				}
+				if _, err := testService.Amend(user, userOrderID, intPrice(int64(95+i%10)), 5); err != nil {
+					t.Errorf("Expected no error, received %s", err)
+					return
+				}
+				if i%3 == 0 {
+					if _, err := testService.Cancel(user, userOrderID); err != nil {
+						t.Errorf("Expected no error, received %s", err)
+						return
+					}
+				}
+				if order, found := testService.Order(userOrderID); found && order.UserID != user {
+					t.Errorf("Expected order %v to belong to user %v, received %v", userOrderID, user, order.UserID)
+				}
+				testService.Book()
+			}
+		}(user)
+	}
+	wait.Wait()
+	unsubscribe()
+
+	if err := validateBook(testService.Book()); err != nil {
+		t.Errorf("Expected a consistent book, received %s", err)
+	}
+	// the subscriber saw every numbered event exactly once and in order
+	expected := 1
+	for event := range events {
+		if event.Sequence == 0 {
+			continue
+		}
+		if event.Sequence != expected {
+			t.Fatalf("Expected event %v, received %v", expected, event.Sequence)
+		}
+		expected++
+	}
+	if expected == 1 {
+		t.Errorf("Expected the subscriber to receive events, received none")
+	}
+}
+
+func TestSubscribeSlowSubscriber(t *testing.T) {
+	testService := NewOrderBookService()
+	testService.Output = ioutil.Discard
+	events, unsubscribe := testService.Subscribe(1)
+	if _, err := testService.Submit(Order{UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 10, Side: BUY}); err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	// the acknowledgement filled the buffer, the top of book that followed dropped the subscriber
+	received := 0
+	for range events {
+		received++
+	}
+	if received != 1 {
+		t.Errorf("Expected 1 event before the subscription ended, received %v", received)
+	}
+	unsubscribe()
+}
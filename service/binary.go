@@ -0,0 +1,554 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The binary format starts with an 8 byte header, the magic bytes, the format version (uint16) and whether
+// commands or events follow, BINARY_COMMANDS or BINARY_EVENTS. Every record after it is laid out
+// little-endian as
+//
+// type (uint8) | a, b, c (uint8) | fields (int64 each)
+//
+// type is the command or event letter of the text format and fixes how many fields follow and what a, b
+// and c hold, so every record of a type has the same size and nothing has to be scanned for. Sides and
+// command letters are stored as their letter, price scales as a number and the other strings as their
+// position in the tables below, 0 for none. Times are Unix nanoseconds, an expiry of 0 is none.
+//
+//	N  side, price scale          | symbol (8 bytes, zero padded), user id, user order id, price, quantity, expires at
+//	C                             | user id, user order id
+//	F                             |
+//	S  session state              |
+//	M  price scale                | user id, user order id, price, quantity
+//	K                             | timestamp
+//
+// Event records start with the event's sequence number and timestamp and carry what its line of output
+// does, flushes included.
+//
+//	A  command                    | sequence, timestamp, user id, user order id
+//	R  command, reason            | sequence, timestamp, user id, user order id
+//	B  side, is set, price scale  | sequence, timestamp, price, quantity
+//	T  price scale                | sequence, timestamp, bid user id, bid user order id, ask user id, ask user order id, price, quantity
+//	S  session state              | sequence, timestamp
+//	H  price scale, ref. scale    | sequence, timestamp, price, reference price
+//	X  mode                       | sequence, timestamp, user id, user order id, cancelled quantity, remaining quantity
+//	D  side, action, price scale  | sequence, timestamp, price, quantity, order count
+//	E  command                    | sequence, timestamp, user id, user order id
+//	F                             | sequence, timestamp
+const (
+	binaryMagic        = "OBWF"
+	binaryHeaderSize   = 8
+	binaryRecordPrefix = 4 // type and the three one byte fields
+	binaryFieldSize    = 8
+	binarySymbolSize   = 8
+)
+
+// codes of the strings that are stored by their position, appending to a table keeps old files readable
+var (
+	binaryCommandLetters = []string{NEW_ORDER, CANCEL_ORDER, FLUSH_ORDER_BOOK, SESSION_STATE, AMEND_ORDER, ADVANCE_CLOCK}
+	binaryEventLetters   = []string{EVENT_ACK, EVENT_REJECT, EVENT_TOP_OF_BOOK, EVENT_TRADE, EVENT_SESSION, EVENT_HALT, EVENT_SELF_TRADE, EVENT_DEPTH, EVENT_EXPIRE, EVENT_FLUSH}
+	binarySides          = []string{BUY, SELL}
+	binarySessionStates  = []string{PRE_OPEN, OPENING_AUCTION, CONTINUOUS, HALTED, CLOSING_AUCTION, CLOSED}
+	binaryLevelActions   = []string{LEVEL_ADD, LEVEL_CHANGE, LEVEL_DELETE}
+	binaryReasons        = []string{
+		UNKNOWN_SYMBOL, INVALID_TICK, INVALID_LOT, BELOW_MIN_QTY, ABOVE_MAX_QTY, OUTSIDE_PRICE_BAND, ALREADY_EXPIRED,
+		UNKNOWN_ORDER, WRONG_USER, USER_IN_USE,
+		STP_NONE, CANCEL_NEWEST, CANCEL_OLDEST, CANCEL_BOTH, DECREMENT_AND_CANCEL,
+		PRICE_OVERFLOW,
+		UNKNOWN_STATE, INVALID_TRANSITION,
+		DUPLICATE_ORDER,
+	}
+)
+
+var (
+	ErrBinaryHeader     = errors.New("not a binary file of the expected content")
+	ErrBinaryVersion    = errors.New("unsupported binary format version")
+	ErrBinaryTruncated  = errors.New("binary record is cut short")
+	ErrBinaryRecordType = errors.New("unknown binary record type")
+	ErrBinaryValue      = errors.New("value has no binary encoding")
+)
+
+// AppendBinaryHeader: appends the header of a binary file holding content, BINARY_COMMANDS or BINARY_EVENTS
+func AppendBinaryHeader(buffer []byte, content byte) []byte {
+	buffer = append(buffer, binaryMagic...)
+	buffer = append(buffer, 0, 0, content, 0)
+	binary.LittleEndian.PutUint16(buffer[len(buffer)-4:], BINARY_VERSION)
+	return buffer
+}
+
+// ReadBinaryHeader: checks data starts with the header of a binary file holding content in this version and
+// returns the header's size
+func ReadBinaryHeader(data []byte, content byte) (int, error) {
+	if len(data) < binaryHeaderSize || string(data[:len(binaryMagic)]) != binaryMagic || data[6] != content {
+		return 0, ErrBinaryHeader
+	}
+	if binary.LittleEndian.Uint16(data[4:]) != BINARY_VERSION {
+		return 0, ErrBinaryVersion
+	}
+	return binaryHeaderSize, nil
+}
+
+// AppendBinaryCommand: appends the record of a command. A command it cannot encode leaves buffer as it was
+// and returns ErrBinaryRecordType or ErrBinaryValue.
+func AppendBinaryCommand(buffer []byte, command Order) ([]byte, error) {
+	fields := binaryCommandFields(command.Command)
+	if fields < 0 {
+		return buffer, ErrBinaryRecordType
+	}
+	start := len(buffer)
+	buffer = appendBinaryRecord(buffer, command.Command[0], fields)
+	if err := encodeBinaryCommand(buffer[start:], command); err != nil {
+		return buffer[:start], err
+	}
+	return buffer, nil
+}
+
+// AppendBinaryEvent: appends the record of an event. An event it cannot encode leaves buffer as it was and
+// returns ErrBinaryRecordType or ErrBinaryValue.
+func AppendBinaryEvent(buffer []byte, event Event) ([]byte, error) {
+	fields := binaryEventFields(event.Type)
+	if fields < 0 {
+		return buffer, ErrBinaryRecordType
+	}
+	start := len(buffer)
+	buffer = appendBinaryRecord(buffer, event.Type[0], fields)
+	if err := encodeBinaryEvent(buffer[start:], event); err != nil {
+		return buffer[:start], err
+	}
+	return buffer, nil
+}
+
+func encodeBinaryCommand(record []byte, command Order) error {
+	switch command.Command {
+	case NEW_ORDER:
+		side, isSideValid := binaryLetterCode(binarySides, command.Side)
+		scale, isScaleValid := binaryScale(command.Price)
+		if !isSideValid || !isScaleValid || len(command.Symbol) > binarySymbolSize {
+			return ErrBinaryValue
+		}
+		record[1], record[2] = side, scale
+		copy(record[binaryRecordPrefix:binaryRecordPrefix+binarySymbolSize], command.Symbol)
+		putBinaryField(record, 1, int64(command.UserID))
+		putBinaryField(record, 2, int64(command.UserOrderID))
+		putBinaryField(record, 3, command.Price.Units)
+		putBinaryField(record, 4, int64(command.Quantity))
+		putBinaryField(record, 5, binaryTime(command.ExpiresAt))
+	case CANCEL_ORDER:
+		putBinaryField(record, 0, int64(command.UserID))
+		putBinaryField(record, 1, int64(command.UserOrderID))
+	case SESSION_STATE:
+		state, isValid := binaryCode(binarySessionStates, command.SessionState)
+		if !isValid {
+			return ErrBinaryValue
+		}
+		record[1] = state
+	case AMEND_ORDER:
+		scale, isValid := binaryScale(command.Price)
+		if !isValid {
+			return ErrBinaryValue
+		}
+		record[1] = scale
+		putBinaryField(record, 0, int64(command.UserID))
+		putBinaryField(record, 1, int64(command.UserOrderID))
+		putBinaryField(record, 2, command.Price.Units)
+		putBinaryField(record, 3, int64(command.Quantity))
+	case ADVANCE_CLOCK:
+		putBinaryField(record, 0, command.Timestamp.UnixNano())
+	}
+	return nil
+}
+
+func encodeBinaryEvent(record []byte, event Event) error {
+	putBinaryField(record, 0, int64(event.Sequence))
+	putBinaryField(record, 1, binaryTime(event.Timestamp))
+	isValid := true
+	switch event.Type {
+	case EVENT_ACK, EVENT_REJECT, EVENT_EXPIRE:
+		record[1], isValid = binaryLetterCode(binaryCommandLetters, event.Command)
+		if event.Type == EVENT_REJECT {
+			var isReasonValid bool
+			record[2], isReasonValid = binaryCode(binaryReasons, event.Reason)
+			isValid = isValid && isReasonValid
+		}
+		putBinaryField(record, 2, int64(event.Order.UserID))
+		putBinaryField(record, 3, int64(event.Order.UserOrderID))
+	case EVENT_TOP_OF_BOOK:
+		var isScaleValid bool
+		record[1], isValid = binaryLetterCode(binarySides, event.Side)
+		record[3], isScaleValid = binaryScale(event.TopBook.Price)
+		isValid = isValid && isScaleValid
+		if event.TopBook.IsSet {
+			record[2] = 1
+		}
+		putBinaryField(record, 2, event.TopBook.Price.Units)
+		putBinaryField(record, 3, int64(event.TopBook.Quantity))
+	case EVENT_TRADE:
+		record[1], isValid = binaryScale(event.Price)
+		putBinaryField(record, 2, int64(event.Bid.UserID))
+		putBinaryField(record, 3, int64(event.Bid.UserOrderID))
+		putBinaryField(record, 4, int64(event.Ask.UserID))
+		putBinaryField(record, 5, int64(event.Ask.UserOrderID))
+		putBinaryField(record, 6, event.Price.Units)
+		putBinaryField(record, 7, int64(event.Quantity))
+	case EVENT_SESSION:
+		record[1], isValid = binaryCode(binarySessionStates, event.State)
+	case EVENT_HALT:
+		var isReferenceValid bool
+		record[1], isValid = binaryScale(event.Price)
+		record[2], isReferenceValid = binaryScale(event.Reference)
+		isValid = isValid && isReferenceValid
+		putBinaryField(record, 2, event.Price.Units)
+		putBinaryField(record, 3, event.Reference.Units)
+	case EVENT_SELF_TRADE:
+		record[1], isValid = binaryCode(binaryReasons, event.Reason)
+		putBinaryField(record, 2, int64(event.Order.UserID))
+		putBinaryField(record, 3, int64(event.Order.UserOrderID))
+		putBinaryField(record, 4, int64(event.Quantity))
+		putBinaryField(record, 5, int64(event.Order.Quantity))
+	case EVENT_DEPTH:
+		var isActionValid, isScaleValid bool
+		record[1], isValid = binaryLetterCode(binarySides, event.Side)
+		record[2], isActionValid = binaryCode(binaryLevelActions, event.Action)
+		record[3], isScaleValid = binaryScale(event.Level.Price)
+		isValid = isValid && isActionValid && isScaleValid
+		putBinaryField(record, 2, event.Level.Price.Units)
+		putBinaryField(record, 3, int64(event.Level.Quantity))
+		putBinaryField(record, 4, int64(event.Level.OrderCount))
+	}
+	if !isValid {
+		return ErrBinaryValue
+	}
+	return nil
+}
+
+// NewBinaryDecoder: a decoder with no symbols seen yet
+func NewBinaryDecoder() *BinaryDecoder {
+	return &BinaryDecoder{symbols: map[string]string{}}
+}
+
+// DecodeCommand: decodes the record data starts with into command and returns its size. Nothing is
+// allocated apart from the first time a symbol is seen.
+func (d *BinaryDecoder) DecodeCommand(data []byte, command *Order) (int, error) {
+	if len(data) < binaryRecordPrefix {
+		return 0, ErrBinaryTruncated
+	}
+	name, isKnown := binaryLetter(binaryCommandLetters, data[0])
+	if !isKnown || name == "" {
+		return 0, ErrBinaryRecordType
+	}
+	size := binaryRecordSize(binaryCommandFields(name))
+	if len(data) < size {
+		return 0, ErrBinaryTruncated
+	}
+	record := data[:size]
+	*command = Order{Command: name}
+	isValid := true
+	switch name {
+	case NEW_ORDER:
+		command.Side, isValid = binaryLetter(binarySides, record[1])
+		command.Symbol = d.symbol(record[binaryRecordPrefix : binaryRecordPrefix+binarySymbolSize])
+		command.UserID = int(binaryField(record, 1))
+		command.UserOrderID = int(binaryField(record, 2))
+		command.Price = Price{Units: binaryField(record, 3), Scale: int(record[2])}
+		command.Quantity = int(binaryField(record, 4))
+		if expiresAt := binaryField(record, 5); expiresAt != 0 {
+			command.ExpiresAt = time.Unix(0, expiresAt).UTC()
+		}
+	case CANCEL_ORDER:
+		command.UserID = int(binaryField(record, 0))
+		command.UserOrderID = int(binaryField(record, 1))
+	case SESSION_STATE:
+		command.SessionState, isValid = binaryValue(binarySessionStates, record[1])
+	case AMEND_ORDER:
+		command.UserID = int(binaryField(record, 0))
+		command.UserOrderID = int(binaryField(record, 1))
+		command.Price = Price{Units: binaryField(record, 2), Scale: int(record[1])}
+		command.Quantity = int(binaryField(record, 3))
+	case ADVANCE_CLOCK:
+		command.Timestamp = time.Unix(0, binaryField(record, 0)).UTC()
+	}
+	if !isValid {
+		return 0, ErrBinaryValue
+	}
+	return size, nil
+}
+
+// DecodeEvent: decodes the record data starts with into event and returns its size, without allocating
+func (d *BinaryDecoder) DecodeEvent(data []byte, event *Event) (int, error) {
+	if len(data) < binaryRecordPrefix {
+		return 0, ErrBinaryTruncated
+	}
+	eventType, isKnown := binaryLetter(binaryEventLetters, data[0])
+	if !isKnown || eventType == "" {
+		return 0, ErrBinaryRecordType
+	}
+	size := binaryRecordSize(binaryEventFields(eventType))
+	if len(data) < size {
+		return 0, ErrBinaryTruncated
+	}
+	record := data[:size]
+	*event = Event{Type: eventType, Sequence: int(binaryField(record, 0)), Timestamp: time.Unix(0, binaryField(record, 1)).UTC()}
+	isValid := true
+	switch eventType {
+	case EVENT_ACK, EVENT_REJECT, EVENT_EXPIRE:
+		event.Command, isValid = binaryLetter(binaryCommandLetters, record[1])
+		if eventType == EVENT_REJECT {
+			var isReasonValid bool
+			event.Reason, isReasonValid = binaryValue(binaryReasons, record[2])
+			isValid = isValid && isReasonValid
+		}
+		event.Order.UserID = int(binaryField(record, 2))
+		event.Order.UserOrderID = int(binaryField(record, 3))
+	case EVENT_TOP_OF_BOOK:
+		event.Side, isValid = binaryLetter(binarySides, record[1])
+		event.TopBook = TopBook{
+			Price:    Price{Units: binaryField(record, 2), Scale: int(record[3])},
+			Quantity: int(binaryField(record, 3)),
+			IsSet:    record[2] != 0,
+		}
+	case EVENT_TRADE:
+		event.Bid = Order{UserID: int(binaryField(record, 2)), UserOrderID: int(binaryField(record, 3)), Side: BUY}
+		event.Ask = Order{UserID: int(binaryField(record, 4)), UserOrderID: int(binaryField(record, 5)), Side: SELL}
+		event.Price = Price{Units: binaryField(record, 6), Scale: int(record[1])}
+		event.Quantity = int(binaryField(record, 7))
+	case EVENT_SESSION:
+		event.State, isValid = binaryValue(binarySessionStates, record[1])
+	case EVENT_HALT:
+		event.Price = Price{Units: binaryField(record, 2), Scale: int(record[1])}
+		event.Reference = Price{Units: binaryField(record, 3), Scale: int(record[2])}
+	case EVENT_SELF_TRADE:
+		event.Reason, isValid = binaryValue(binaryReasons, record[1])
+		event.Order.UserID = int(binaryField(record, 2))
+		event.Order.UserOrderID = int(binaryField(record, 3))
+		event.Quantity = int(binaryField(record, 4))
+		event.Order.Quantity = int(binaryField(record, 5))
+	case EVENT_DEPTH:
+		var isActionValid bool
+		event.Side, isValid = binaryLetter(binarySides, record[1])
+		event.Action, isActionValid = binaryValue(binaryLevelActions, record[2])
+		isValid = isValid && isActionValid
+		event.Level = DepthLevel{
+			Price:      Price{Units: binaryField(record, 2), Scale: int(record[3])},
+			Quantity:   int(binaryField(record, 3)),
+			OrderCount: int(binaryField(record, 4)),
+		}
+	}
+	if !isValid {
+		return 0, ErrBinaryValue
+	}
+	return size, nil
+}
+
+// symbol: the symbol in a zero padded field, each symbol is only copied out the first time it is seen
+func (d *BinaryDecoder) symbol(field []byte) string {
+	if end := bytes.IndexByte(field, 0); end >= 0 {
+		field = field[:end]
+	}
+	if symbol, found := d.symbols[string(field)]; found {
+		return symbol
+	}
+	symbol := string(field)
+	d.symbols[symbol] = symbol
+	return symbol
+}
+
+// ParseBinaryFile: reads the scenarios of an input file of binary command records. As in the text formats
+// a scenario ends with its flush command.
+func (p *ParserService) ParseBinaryFile(path string) ([][]Order, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %v in ParseBinaryFile()", path)
+	}
+	offset, err := ReadBinaryHeader(data, BINARY_COMMANDS)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading header of %v in ParseBinaryFile()", path)
+	}
+
+	decoder := NewBinaryDecoder()
+	output := [][]Order{}
+	currentBook := []Order{}
+	for offset < len(data) {
+		var command Order
+		size, err := decoder.DecodeCommand(data[offset:], &command)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error decoding record at offset %v in ParseBinaryFile()", offset)
+		}
+		offset += size
+		currentBook = append(currentBook, command)
+		if command.Command == FLUSH_ORDER_BOOK {
+			output = append(output, currentBook)
+			currentBook = []Order{}
+		}
+	}
+	return output, nil
+}
+
+// WriteBinaryEvents: prints the event records of binary output as the service would have printed them in
+// outputFormat, FORMAT_CSV or FORMAT_JSON_LINES, scenario headings included. Each scenario ends with its
+// flush.
+func WriteBinaryEvents(data []byte, outputFormat string, isStamped bool, output io.Writer) error {
+	offset, err := ReadBinaryHeader(data, BINARY_EVENTS)
+	if err != nil {
+		return errors.Wrap(err, "error reading header in WriteBinaryEvents()")
+	}
+	printer := &OrderBookService{Output: output, OutputFormat: outputFormat, IsOutputStamped: isStamped}
+	decoder := NewBinaryDecoder()
+	var event Event
+	for scenario := 0; offset < len(data); scenario++ {
+		printer.writeScenarioStart(scenario)
+		for event.Type != EVENT_FLUSH && offset < len(data) {
+			size, err := decoder.DecodeEvent(data[offset:], &event)
+			if err != nil {
+				return errors.Wrapf(err, "error decoding record at offset %v in WriteBinaryEvents()", offset)
+			}
+			offset += size
+			if line := event.String(); line != "" {
+				if err := printer.writeEventLine(event, line); err != nil {
+					return errors.Wrap(err, "error writing output in WriteBinaryEvents()")
+				}
+			}
+		}
+		printer.writeScenarioEnd()
+		event = Event{}
+	}
+	return nil
+}
+
+// writeBinaryEvent: writes the record of an event to the output, reusing the service's buffer
+func (o *OrderBookService) writeBinaryEvent(event Event) error {
+	record, err := AppendBinaryEvent(o.binaryOutput[:0], event)
+	if err != nil {
+		return errors.Wrapf(err, "error encoding %v event in writeBinaryEvent()", event.Type)
+	}
+	o.binaryOutput = record
+	if _, err := o.output().Write(record); err != nil {
+		return errors.Wrap(err, "error writing event record in writeBinaryEvent()")
+	}
+	return nil
+}
+
+// binaryCommandFields: how many fields follow the prefix of a command record, -1 for unknown commands
+func binaryCommandFields(command string) int {
+	switch command {
+	case NEW_ORDER:
+		return 6
+	case CANCEL_ORDER:
+		return 2
+	case FLUSH_ORDER_BOOK, SESSION_STATE:
+		return 0
+	case AMEND_ORDER:
+		return 4
+	case ADVANCE_CLOCK:
+		return 1
+	}
+	return -1
+}
+
+// binaryEventFields: how many fields follow the prefix of an event record, -1 for unknown event types
+func binaryEventFields(eventType string) int {
+	switch eventType {
+	case EVENT_ACK, EVENT_REJECT, EVENT_HALT, EVENT_EXPIRE, EVENT_TOP_OF_BOOK:
+		return 4
+	case EVENT_TRADE:
+		return 8
+	case EVENT_SESSION, EVENT_FLUSH:
+		return 2
+	case EVENT_SELF_TRADE:
+		return 6
+	case EVENT_DEPTH:
+		return 5
+	}
+	return -1
+}
+
+func binaryRecordSize(fields int) int {
+	return binaryRecordPrefix + fields*binaryFieldSize
+}
+
+// appendBinaryRecord: appends a zeroed record of the given type, growing buffer only when it is too small
+func appendBinaryRecord(buffer []byte, recordType byte, fields int) []byte {
+	start, size := len(buffer), binaryRecordSize(fields)
+	if cap(buffer)-start < size {
+		buffer = append(buffer, make([]byte, size)...)
+	}
+	buffer = buffer[:start+size]
+	record := buffer[start:]
+	for i := range record {
+		record[i] = 0
+	}
+	record[0] = recordType
+	return buffer
+}
+
+func putBinaryField(record []byte, i int, value int64) {
+	binary.LittleEndian.PutUint64(record[binaryRecordPrefix+i*binaryFieldSize:], uint64(value))
+}
+
+func binaryField(record []byte, i int) int64 {
+	return int64(binary.LittleEndian.Uint64(record[binaryRecordPrefix+i*binaryFieldSize:]))
+}
+
+func binaryTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func binaryScale(price Price) (byte, bool) {
+	return byte(price.Scale), price.Scale >= 0 && price.Scale <= 0xFF
+}
+
+// binaryCode: the position of value in table counting from 1, 0 for an empty string
+func binaryCode(table []string, value string) (byte, bool) {
+	if value == "" {
+		return 0, true
+	}
+	for i, entry := range table {
+		if entry == value {
+			return byte(i + 1), true
+		}
+	}
+	return 0, false
+}
+
+func binaryValue(table []string, code byte) (string, bool) {
+	if code == 0 {
+		return "", true
+	}
+	if int(code) > len(table) {
+		return "", false
+	}
+	return table[code-1], true
+}
+
+// binaryLetterCode: the letter of a one letter value found in table, 0 for an empty string
+func binaryLetterCode(table []string, value string) (byte, bool) {
+	if value == "" {
+		return 0, true
+	}
+	for _, entry := range table {
+		if entry == value {
+			return entry[0], true
+		}
+	}
+	return 0, false
+}
+
+func binaryLetter(table []string, letter byte) (string, bool) {
+	if letter == 0 {
+		return "", true
+	}
+	for _, entry := range table {
+		if entry[0] == letter {
+			return entry, true
+		}
+	}
+	return "", false
+}
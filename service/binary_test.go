@@ -0,0 +1,311 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestBinaryCommandRoundTrip(t *testing.T) {
+	tests := map[string]struct {
+		command Order
+		size    int
+	}{
+		"New order": {
+			command: Order{Command: NEW_ORDER, UserID: 1, Symbol: "IBM", Price: NewPrice(1025, 2), Quantity: 100, Side: BUY, UserOrderID: 3},
+			size:    52,
+		},
+		"Good till date order with a full length symbol": {
+			command: Order{Command: NEW_ORDER, UserID: 2, Symbol: "ABCDEFGH", Price: NewPrice(-5, 1), Quantity: 5, Side: SELL, UserOrderID: 4, ExpiresAt: time.Unix(0, 1000).UTC()},
+			size:    52,
+		},
+		"Cancel":        {command: Order{Command: CANCEL_ORDER, UserID: 1, UserOrderID: 3}, size: 20},
+		"Flush":         {command: Order{Command: FLUSH_ORDER_BOOK}, size: 4},
+		"Session state": {command: Order{Command: SESSION_STATE, SessionState: CLOSING_AUCTION}, size: 4},
+		"Amend":         {command: Order{Command: AMEND_ORDER, UserID: 1, UserOrderID: 3, Price: NewPrice(11, 0), Quantity: 50}, size: 36},
+		"Advance clock": {command: Order{Command: ADVANCE_CLOCK, Timestamp: time.Unix(0, 2000).UTC()}, size: 12},
+	}
+
+	decoder := NewBinaryDecoder()
+	for name, test := range tests {
+		record, err := AppendBinaryCommand([]byte{0xFF}, test.command)
+		if err != nil {
+			t.Fatalf("Expected no error, received %s for test %s", err, name)
+		}
+		if len(record) != 1+test.size {
+			t.Errorf("Expected a record of %v bytes, received %v for test %s", test.size, len(record)-1, name)
+		}
+		var command Order
+		size, err := decoder.DecodeCommand(record[1:], &command)
+		if err != nil || size != test.size {
+			t.Errorf("Expected %v bytes decoded, received %v and error %v for test %s", test.size, size, err, name)
+		}
+		if !reflect.DeepEqual(command, test.command) {
+			t.Errorf("Expected %+v, received %+v for test %s", test.command, command, name)
+		}
+	}
+}
+
+func TestBinaryEventRoundTrip(t *testing.T) {
+	bid := Order{UserID: 1, UserOrderID: 2, Quantity: 40}
+	ask := Order{UserID: 3, UserOrderID: 4}
+	tests := map[string]Event{
+		"Ack":            ackEvent(AMEND_ORDER, bid),
+		"Reject":         rejectEvent(NEW_ORDER, bid, OUTSIDE_PRICE_BAND),
+		"Plain reject":   rejectEvent(CANCEL_ORDER, bid, ""),
+		"Top of book":    {Type: EVENT_TOP_OF_BOOK, Side: SELL, TopBook: TopBook{Price: NewPrice(1005, 2), Quantity: 300, IsSet: true}},
+		"Empty side":     {Type: EVENT_TOP_OF_BOOK, Side: BUY},
+		"Trade":          tradeEvent(bid, ask, NewPrice(-3, 0), 60),
+		"Session":        sessionEvent(OPENING_AUCTION),
+		"Halt":           {Type: EVENT_HALT, Price: NewPrice(120, 0), Reference: NewPrice(1005, 1)},
+		"Self trade":     {Type: EVENT_SELF_TRADE, Order: bid, Quantity: 60, Reason: DECREMENT_AND_CANCEL},
+		"Depth":          {Type: EVENT_DEPTH, Side: BUY, Action: LEVEL_CHANGE, Level: DepthLevel{Price: NewPrice(10, 0), Quantity: 500, OrderCount: 3}},
+		"Expire":         {Type: EVENT_EXPIRE, Order: ask},
+		"Flush":          {Type: EVENT_FLUSH},
+		"Big identifier": ackEvent(NEW_ORDER, Order{UserID: 1 << 40, UserOrderID: -1}),
+	}
+
+	decoder := NewBinaryDecoder()
+	for name, test := range tests {
+		test.Sequence = 7
+		test.Timestamp = time.Unix(5, 123)
+		record, err := AppendBinaryEvent(nil, test)
+		if err != nil {
+			t.Fatalf("Expected no error, received %s for test %s", err, name)
+		}
+		var event Event
+		size, err := decoder.DecodeEvent(record, &event)
+		if err != nil || size != len(record) {
+			t.Errorf("Expected %v bytes decoded, received %v and error %v for test %s", len(record), size, err, name)
+		}
+		if event.String() != test.String() || event.Type != test.Type || event.Command != test.Command {
+			t.Errorf("Expected %v event %q, received %v event %q for test %s", test.Type, test.String(), event.Type, event.String(), name)
+		}
+		if event.Sequence != test.Sequence || !event.Timestamp.Equal(test.Timestamp) {
+			t.Errorf("Expected event %v at %v, received event %v at %v for test %s", test.Sequence, test.Timestamp, event.Sequence, event.Timestamp, name)
+		}
+	}
+}
+
+func TestBinaryErrors(t *testing.T) {
+	newOrder := Order{Command: NEW_ORDER, UserID: 1, Symbol: "IBM", Price: NewPrice(10, 0), Quantity: 100, Side: BUY, UserOrderID: 1}
+	longSymbol, unknownSide := newOrder, newOrder
+	longSymbol.Symbol = "TOOLONGSYMBOL"
+	unknownSide.Side = "X"
+	record, _ := AppendBinaryCommand(nil, newOrder)
+	unknownState := []byte{SESSION_STATE[0], 99, 0, 0}
+	unknownAction, _ := AppendBinaryEvent(nil, Event{Type: EVENT_DEPTH, Side: BUY, Action: LEVEL_ADD})
+	unknownAction[2] = 99
+	header := AppendBinaryHeader(nil, BINARY_COMMANDS)
+	newerHeader := append([]byte{}, header...)
+	binary.LittleEndian.PutUint16(newerHeader[4:], BINARY_VERSION+1)
+
+	tests := map[string]struct {
+		run      func() error
+		expected error
+	}{
+		"Unknown command": {run: func() error {
+			_, err := AppendBinaryCommand(nil, Order{Command: "Z"})
+			return err
+		}, expected: ErrBinaryRecordType},
+		"Symbol too long": {run: func() error {
+			_, err := AppendBinaryCommand(nil, longSymbol)
+			return err
+		}, expected: ErrBinaryValue},
+		"Unknown side": {run: func() error {
+			_, err := AppendBinaryCommand(nil, unknownSide)
+			return err
+		}, expected: ErrBinaryValue},
+		"Unknown reason": {run: func() error {
+			_, err := AppendBinaryEvent(nil, rejectEvent(NEW_ORDER, newOrder, "BAD_MOOD"))
+			return err
+		}, expected: ErrBinaryValue},
+		"Price scale out of range": {run: func() error {
+			_, err := AppendBinaryEvent(nil, Event{Type: EVENT_HALT, Price: Price{Units: 1, Scale: 256}})
+			return err
+		}, expected: ErrBinaryValue},
+		"Unknown event": {run: func() error {
+			_, err := AppendBinaryEvent(nil, Event{Type: "AB"})
+			return err
+		}, expected: ErrBinaryRecordType},
+		"Truncated record": {run: func() error {
+			_, err := NewBinaryDecoder().DecodeCommand(record[:len(record)-1], &Order{})
+			return err
+		}, expected: ErrBinaryTruncated},
+		"Truncated prefix": {run: func() error {
+			_, err := NewBinaryDecoder().DecodeEvent(record[:2], &Event{})
+			return err
+		}, expected: ErrBinaryTruncated},
+		"Unknown record type": {run: func() error {
+			_, err := NewBinaryDecoder().DecodeCommand([]byte{'Q', 0, 0, 0}, &Order{})
+			return err
+		}, expected: ErrBinaryRecordType},
+		"Unknown session state code": {run: func() error {
+			_, err := NewBinaryDecoder().DecodeCommand(unknownState, &Order{})
+			return err
+		}, expected: ErrBinaryValue},
+		"Unknown level action code": {run: func() error {
+			_, err := NewBinaryDecoder().DecodeEvent(unknownAction, &Event{})
+			return err
+		}, expected: ErrBinaryValue},
+		"Events read as commands": {run: func() error {
+			_, err := ReadBinaryHeader(AppendBinaryHeader(nil, BINARY_EVENTS), BINARY_COMMANDS)
+			return err
+		}, expected: ErrBinaryHeader},
+		"Not a binary file": {run: func() error {
+			_, err := ReadBinaryHeader([]byte("N, 1, IBM, 10, 100, B, 1"), BINARY_COMMANDS)
+			return err
+		}, expected: ErrBinaryHeader},
+		"Newer version": {run: func() error {
+			_, err := ReadBinaryHeader(newerHeader, BINARY_COMMANDS)
+			return err
+		}, expected: ErrBinaryVersion},
+	}
+
+	for name, test := range tests {
+		if err := test.run(); errors.Cause(err) != test.expected {
+			t.Errorf("Expected error %v, received %v for test %s", test.expected, err, name)
+		}
+	}
+
+	// a command that cannot be encoded leaves what was already in the buffer alone
+	buffer, err := AppendBinaryCommand(record, unknownSide)
+	if err == nil || !bytes.Equal(buffer, record) {
+		t.Errorf("Expected the buffer to be left as it was, received %v bytes and error %v", len(buffer), err)
+	}
+}
+
+func TestBinaryAllocations(t *testing.T) {
+	command := Order{Command: NEW_ORDER, UserID: 1, Symbol: "IBM", Price: NewPrice(1025, 2), Quantity: 100, Side: BUY, UserOrderID: 3}
+	event := tradeEvent(Order{UserID: 1, UserOrderID: 1}, Order{UserID: 2, UserOrderID: 2}, NewPrice(1025, 2), 100)
+	event.Timestamp = time.Unix(0, 1000)
+	decoder := NewBinaryDecoder()
+	buffer := make([]byte, 0, 128)
+	var err error
+
+	tests := map[string]func(){
+		"Encode command": func() { buffer, err = AppendBinaryCommand(buffer[:0], command) },
+		"Encode event":   func() { buffer, err = AppendBinaryEvent(buffer[:0], event) },
+		"Decode command": func() {
+			buffer, _ = AppendBinaryCommand(buffer[:0], command)
+			_, err = decoder.DecodeCommand(buffer, &command)
+		},
+		"Decode event": func() {
+			buffer, _ = AppendBinaryEvent(buffer[:0], event)
+			_, err = decoder.DecodeEvent(buffer, &event)
+		},
+	}
+	for name, run := range tests {
+		run()
+		if allocations := testing.AllocsPerRun(100, run); allocations != 0 || err != nil {
+			t.Errorf("Expected no allocations, received %v and error %v for test %s", allocations, err, name)
+		}
+	}
+}
+
+func TestBinaryInputAndOutput(t *testing.T) {
+	csv := []string{
+		"N, 1, IBM, 10, 100, B, 1",
+		"N, 2, IBM, 10.5, 100, S, 2, 5000",
+		"N, 3, IBM, 10.5, 40, B, 3",
+		"M, 1, 1, 11, 50",
+		"C, 2, 5",
+		"F",
+		"P, HALTED",
+		"K, 7000",
+		"F",
+	}
+	parserService := NewParserService()
+	csvBooks, err := parserService.TransformOrderBookListData([][]string{csv[:6], csv[6:]})
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	data := AppendBinaryHeader(nil, BINARY_COMMANDS)
+	for _, orderBook := range csvBooks {
+		for _, command := range orderBook {
+			if data, err = AppendBinaryCommand(data, command); err != nil {
+				t.Fatalf("Expected no error, received %s", err)
+			}
+		}
+	}
+	file, err := ioutil.TempFile("", "commands*.bin")
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	defer os.Remove(file.Name())
+	file.Write(data)
+	file.Close()
+
+	parserService.InputFormat = FORMAT_BINARY
+	binaryBooks, err := parserService.ParseOrderBooks(file.Name())
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	if !reflect.DeepEqual(binaryBooks, csvBooks) {
+		t.Errorf("Expected the scenarios of the CSV lines %v, received %v", csvBooks, binaryBooks)
+	}
+
+	// binary output printed as text reads exactly like the text output of the same scenarios
+	for _, isStamped := range []bool{false, true} {
+		outputs := map[string]*bytes.Buffer{FORMAT_CSV: {}, FORMAT_BINARY: {}}
+		for format, output := range outputs {
+			testService := NewOrderBookService()
+			testService.IsTradingEnabled = true
+			testService.DepthLevels = 5
+			testService.IsOutputStamped = isStamped
+			testService.OutputFormat = format
+			testService.Output = output
+			testService.Clock = NewSimulatedClock(time.Unix(0, 0))
+			if err := testService.ProcessOrderBooks(binaryBooks); err != nil {
+				t.Fatalf("Expected no error, received %s for %v output", err, format)
+			}
+		}
+		var converted bytes.Buffer
+		if err := WriteBinaryEvents(outputs[FORMAT_BINARY].Bytes(), FORMAT_CSV, isStamped, &converted); err != nil {
+			t.Fatalf("Expected no error, received %s", err)
+		}
+		if converted.String() != outputs[FORMAT_CSV].String() {
+			t.Errorf("Expected %q, received %q for stamped %v", outputs[FORMAT_CSV].String(), converted.String(), isStamped)
+		}
+	}
+}
+
+func BenchmarkParseCommand(b *testing.B) {
+	parserService := NewParserService()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := parserService.ParseCommand("N, 1, IBM, 10.25, 100, B, 3"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeBinaryCommand(b *testing.B) {
+	record, _ := AppendBinaryCommand(nil, Order{Command: NEW_ORDER, UserID: 1, Symbol: "IBM", Price: NewPrice(1025, 2), Quantity: 100, Side: BUY, UserOrderID: 3})
+	decoder := NewBinaryDecoder()
+	var command Order
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := decoder.DecodeCommand(record, &command); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeBinaryEvent(b *testing.B) {
+	event := tradeEvent(Order{UserID: 1, UserOrderID: 1}, Order{UserID: 2, UserOrderID: 2}, NewPrice(1025, 2), 100)
+	buffer := make([]byte, 0, 128)
+	var err error
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if buffer, err = AppendBinaryEvent(buffer[:0], event); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// breachedPriceBand: checks a trade price against the static and dynamic price bands and returns the
+// reference price of the band it falls outside of
+func (o *OrderBookService) breachedPriceBand(price Price) (Price, bool) {
+	breaker := o.CircuitBreaker
+	if !o.orderBook.HasReferencePrice {
+		return Price{}, false
+	}
+	if isOutsideBand(price, o.orderBook.StaticReferencePrice, breaker.StaticBandBps) {
+		return o.orderBook.StaticReferencePrice, true
+	}
+	if isOutsideBand(price, o.orderBook.LastTradePrice, breaker.DynamicBandBps) {
+		return o.orderBook.LastTradePrice, true
+	}
+	return Price{}, false
+}
+
+// isOutsideBand: a band configured at 0 basis points never triggers. The band is a share of the reference's
+// distance from zero, so it works for negative references, and big integers keep it exact for any price.
+func isOutsideBand(price Price, reference Price, bandBps int) bool {
+	if bandBps <= 0 {
+		return false
+	}
+	scale := price.Scale
+	if reference.Scale > scale {
+		scale = reference.Scale
+	}
+	priceUnits := scaledUnits(price, scale)
+	referenceUnits := scaledUnits(reference, scale)
+
+	deviation := new(big.Int).Sub(priceUnits, referenceUnits)
+	deviation.Abs(deviation).Mul(deviation, big.NewInt(10000))
+	band := new(big.Int).Abs(referenceUnits)
+	band.Mul(band, big.NewInt(int64(bandBps)))
+	return deviation.Cmp(band) > 0
+}
+
+func scaledUnits(price Price, scale int) *big.Int {
+	units := big.NewInt(price.Units)
+	return units.Mul(units, big.NewInt(pow10(scale-price.Scale)))
+}
+
+// recordTradePrice: moves the dynamic reference, the first trade of a book without an auction also sets the static one
+func (o *OrderBookService) recordTradePrice(price Price) {
+	if !o.orderBook.HasReferencePrice {
+		o.orderBook.StaticReferencePrice = price
+		o.orderBook.HasReferencePrice = true
+	}
+	o.orderBook.LastTradePrice = price
+}
+
+// tripCircuitBreaker: either rejects the aggressive order that would have traded outside the band,
+// or halts the book and publishes a halt event. The rejected order has already rested in the book, so its
+// reject carries the exchange order id and whatever quantity it had left, and answers the command that
+// entered it, a new order or an amend.
+func (o *OrderBookService) tripCircuitBreaker(command string, order Order, price Price, reference Price) ([]Event, error) {
+	if o.CircuitBreaker.Action == REJECT_ORDER {
+		o.removeOrder(order.Side, order.UserOrderID)
+		return []Event{rejectEvent(command, order, "")}, nil
+	}
+
+	events := []Event{{Type: EVENT_HALT, Price: price, Reference: reference}}
+	sessionChange, err := o.changeSessionState(HALTED)
+	if err != nil {
+		return nil, errors.Wrap(err, "error halting book in tripCircuitBreaker()")
+	}
+	o.orderBook.HaltCountdown = o.CircuitBreaker.HaltLength + 1
+	return append(events, sessionChange...), nil
+}
+
+// breachesOnEntry: whether an order would trade at the best opposite price outside a price band when the
+// breaker rejects orders. It is turned away before it is acknowledged rather than rejected once it rests.
+func (o *OrderBookService) breachesOnEntry(order Order) bool {
+	if o.CircuitBreaker.Action != REJECT_ORDER || !o.IsTradingEnabled || !o.currentSessionRules().MatchOnEntry {
+		return false
+	}
+	level := o.bestLevel(oppositeSide(order.Side))
+	if len(level) == 0 || !crosses(order, level[0].Price) {
+		return false
+	}
+	_, breached := o.breachedPriceBand(level[0].Price)
+	return breached
+}
+
+// advanceHaltCountdown: counts one inbound command against a circuit breaker halt. The countdown is checked
+// before it is taken down, so a halt lasts HaltLength commands and the re-opening auction ReopeningLength,
+// the command that finds the halt over is the first of the auction. From there the book goes back to
+// continuous trading.
+func (o *OrderBookService) advanceHaltCountdown() ([]Event, error) {
+	if o.orderBook.HaltCountdown == 0 {
+		return nil, nil
+	}
+	if o.orderBook.HaltCountdown > 1 {
+		o.orderBook.HaltCountdown--
+		return nil, nil
+	}
+	o.orderBook.HaltCountdown = 0
+
+	switch o.orderBook.SessionState {
+	case HALTED:
+		events, err := o.changeSessionState(OPENING_AUCTION)
+		if err != nil {
+			return nil, errors.Wrap(err, "error starting re-opening auction in advanceHaltCountdown()")
+		}
+		if o.CircuitBreaker.ReopeningLength > 0 {
+			o.orderBook.HaltCountdown = o.CircuitBreaker.ReopeningLength
+			return events, nil
+		}
+		reopen, err := o.changeSessionState(CONTINUOUS)
+		if err != nil {
+			return nil, errors.Wrap(err, "error re-opening book in advanceHaltCountdown()")
+		}
+		return append(events, reopen...), nil
+	case OPENING_AUCTION:
+		events, err := o.changeSessionState(CONTINUOUS)
+		if err != nil {
+			return nil, errors.Wrap(err, "error re-opening book in advanceHaltCountdown()")
+		}
+		return events, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExecuteTradePriceBands(t *testing.T) {
+	testService := NewOrderBookService()
+	testService.IsTradingEnabled = true
+	tests := map[string]struct {
+		action         string
+		askPrice       Price
+		finalBidLength int
+		finalState     string
+		output         []string
+	}{
+		"Trade inside dynamic band": {
+			action:     HALT_BOOK,
+			askPrice:   intPrice(105),
+			finalState: CONTINUOUS,
+			output:     []string{"T, 1, 1, 2, 2, 105, 10"},
+		},
+		"Trade outside dynamic band halts book": {
+			action:         HALT_BOOK,
+			askPrice:       intPrice(115),
+			finalBidLength: 1,
+			finalState:     HALTED,
+			output:         []string{"H, 115, 100", "S, HALTED"},
+		},
+		"Trade outside dynamic band rejects order": {
+			action:     REJECT_ORDER,
+			askPrice:   intPrice(115),
+			finalState: CONTINUOUS,
+			output:     []string{"R, 1, 1"},
+		},
+	}
+
+	for name, test := range tests {
+		testService.CircuitBreaker = CircuitBreaker{DynamicBandBps: 1000, Action: test.action, HaltLength: 1, ReopeningLength: 1}
+		testService.orderBook = NewOrderBook()
+		testService.orderBook.LastTradePrice = intPrice(100)
+		testService.orderBook.HasReferencePrice = true
+
+		order := Order{Side: BUY, UserID: 1, UserOrderID: 1, Price: intPrice(120), Quantity: 10}
+		testService.orderBook.Bids = []Order{order}
+		testService.orderBook.Asks = []Order{{Side: SELL, UserID: 2, UserOrderID: 2, Price: test.askPrice, Quantity: 10}}
+		testService.orderBook.OrderDict[1] = BUY
+		testService.orderBook.OrderDict[2] = SELL
+
+		events, err := testService.executeTrade(&order)
+		output := eventLines(events)
+		if err != nil {
+			t.Errorf("Expected no error, received %s for test %s", err, name)
+		}
+		if !reflect.DeepEqual(output, test.output) {
+			t.Errorf("Expected output %v, received %v for test %s", test.output, output, name)
+		}
+		if test.finalBidLength != len(testService.orderBook.Bids) {
+			t.Errorf("Expected bid length %v, received %v for test %s", test.finalBidLength, len(testService.orderBook.Bids), name)
+		}
+		if test.finalState != testService.orderBook.SessionState {
+			t.Errorf("Expected state %v, received %v for test %s", test.finalState, testService.orderBook.SessionState, name)
+		}
+	}
+}
+
+func TestAdvanceHaltCountdown(t *testing.T) {
+	testService := NewOrderBookService()
+	testService.IsTradingEnabled = true
+	testService.CircuitBreaker = CircuitBreaker{DynamicBandBps: 1000, Action: HALT_BOOK, HaltLength: 2, ReopeningLength: 1}
+	testService.orderBook = NewOrderBook()
+	testService.orderBook.LastTradePrice = intPrice(100)
+	testService.orderBook.HasReferencePrice = true
+
+	order := Order{Side: BUY, UserID: 1, UserOrderID: 1, Price: intPrice(120), Quantity: 10}
+	testService.orderBook.Bids = []Order{order}
+	testService.orderBook.Asks = []Order{{Side: SELL, UserID: 2, UserOrderID: 2, Price: intPrice(115), Quantity: 10}}
+	if _, err := testService.executeTrade(&order); err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+
+	// two halted commands, then one in the re-opening auction
+	expected := [][]string{
+		nil,
+		nil,
+		{"S, OPENING_AUCTION"},
+		{"T, 1, 1, 2, 2, 115, 10", "S, CONTINUOUS"},
+		nil,
+	}
+	for i, expectedOutput := range expected {
+		events, err := testService.advanceHaltCountdown()
+		output := eventLines(events)
+		if err != nil {
+			t.Errorf("Expected no error, received %s for command %v", err, i+1)
+		}
+		if !reflect.DeepEqual(output, expectedOutput) {
+			t.Errorf("Expected output %v, received %v for command %v", expectedOutput, output, i+1)
+		}
+	}
+	if testService.orderBook.LastTradePrice != intPrice(115) || testService.orderBook.StaticReferencePrice != intPrice(115) {
+		t.Errorf("Expected reference prices to move to the re-opening price 115, received %v/%v",
+			testService.orderBook.StaticReferencePrice, testService.orderBook.LastTradePrice)
+	}
+}
+
+func TestPriceBandRejectOnEntry(t *testing.T) {
+	tests := map[string]struct {
+		order  Order
+		output []string
+	}{
+		"Order crossing outside the band is rejected without an ack": {
+			order:  Order{Command: NEW_ORDER, UserID: 3, UserOrderID: 3, Symbol: "IBM", Price: intPrice(120), Quantity: 10, Side: BUY},
+			output: []string{"R, 3, 3"},
+		},
+		"Order resting outside the band is accepted": {
+			order:  Order{Command: NEW_ORDER, UserID: 3, UserOrderID: 3, Symbol: "IBM", Price: intPrice(99), Quantity: 10, Side: BUY},
+			output: []string{"A, 3, 3", "B, B, 99, 10"},
+		},
+		"Amend crossing outside the band is rejected and the order left resting": {
+			order:  Order{Command: AMEND_ORDER, UserID: 1, UserOrderID: 4, Price: intPrice(120), Quantity: 10},
+			output: []string{"R, 1, 4"},
+		},
+	}
+
+	for name, test := range tests {
+		var output bytes.Buffer
+		testService := NewOrderBookService()
+		testService.IsTradingEnabled = true
+		testService.CircuitBreaker = CircuitBreaker{DynamicBandBps: 1000, Action: REJECT_ORDER, HaltLength: 1, ReopeningLength: 1}
+		testService.Output = ioutil.Discard
+		err := testService.ProcessOrderBook([]Order{
+			{Command: NEW_ORDER, UserID: 2, UserOrderID: 1, Symbol: "IBM", Price: intPrice(100), Quantity: 10, Side: SELL},
+			{Command: NEW_ORDER, UserID: 1, UserOrderID: 2, Symbol: "IBM", Price: intPrice(100), Quantity: 10, Side: BUY},
+			{Command: NEW_ORDER, UserID: 2, UserOrderID: 5, Symbol: "IBM", Price: intPrice(115), Quantity: 10, Side: SELL},
+			{Command: NEW_ORDER, UserID: 1, UserOrderID: 4, Symbol: "IBM", Price: intPrice(90), Quantity: 10, Side: BUY},
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, received %s for test %s", err, name)
+		}
+		testService.Output = &output
+		if err := testService.ProcessOrderBook([]Order{test.order}); err != nil {
+			t.Fatalf("Expected no error, received %s for test %s", err, name)
+		}
+		if received := strings.Split(strings.TrimSpace(output.String()), "\n"); !reflect.DeepEqual(received, test.output) {
+			t.Errorf("Expected output %v, received %v for test %s", test.output, received, name)
+		}
+		if book := testService.Book(); len(book.Asks) != 1 || len(book.Bids) < 1 {
+			t.Errorf("Expected the resting orders to be left alone, received %v bids and %v asks for test %s", len(book.Bids), len(book.Asks), name)
+		}
+	}
+}
+
+func TestPriceBandRejectCommand(t *testing.T) {
+	tests := map[string]struct {
+		order  Order
+		output []string
+	}{
+		"New order": {
+			order:  Order{Command: NEW_ORDER, UserID: 1, UserOrderID: 5, Symbol: "IBM", Price: intPrice(120), Quantity: 20, Side: BUY},
+			output: []string{"A, 1, 5", "T, 1, 5, 2, 2, 105, 10", "R, 1, 5", "B, S, 117, 10"},
+		},
+		"Amend": {
+			order:  Order{Command: AMEND_ORDER, UserID: 1, UserOrderID: 4, Price: intPrice(120), Quantity: 20},
+			output: []string{"A, 1, 4", "T, 1, 4, 2, 2, 105, 10", "R, 1, 4", "B, B, -, -", "B, S, 117, 10"},
+		},
+	}
+
+	for name, test := range tests {
+		var output bytes.Buffer
+		var rejects []Event
+		testService := NewOrderBookService()
+		testService.IsTradingEnabled = true
+		testService.CircuitBreaker = CircuitBreaker{DynamicBandBps: 1000, Action: REJECT_ORDER, HaltLength: 1, ReopeningLength: 1}
+		testService.Output = ioutil.Discard
+		err := testService.ProcessOrderBook([]Order{
+			{Command: NEW_ORDER, UserID: 2, UserOrderID: 1, Symbol: "IBM", Price: intPrice(100), Quantity: 10, Side: SELL},
+			{Command: NEW_ORDER, UserID: 1, UserOrderID: 2, Symbol: "IBM", Price: intPrice(100), Quantity: 10, Side: BUY},
+			{Command: NEW_ORDER, UserID: 2, UserOrderID: 2, Symbol: "IBM", Price: intPrice(105), Quantity: 10, Side: SELL},
+			{Command: NEW_ORDER, UserID: 2, UserOrderID: 3, Symbol: "IBM", Price: intPrice(117), Quantity: 10, Side: SELL},
+			{Command: NEW_ORDER, UserID: 1, UserOrderID: 4, Symbol: "IBM", Price: intPrice(90), Quantity: 10, Side: BUY},
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, received %s for test %s", err, name)
+		}
+		testService.Output = &output
+		testService.OnEvent = func(event Event) {
+			if event.Type == EVENT_REJECT {
+				rejects = append(rejects, event)
+			}
+		}
+		if err := testService.ProcessOrderBook([]Order{test.order}); err != nil {
+			t.Fatalf("Expected no error, received %s for test %s", err, name)
+		}
+		if received := strings.Split(strings.TrimSpace(output.String()), "\n"); !reflect.DeepEqual(received, test.output) {
+			t.Errorf("Expected output %v, received %v for test %s", test.output, received, name)
+		}
+		if len(rejects) != 1 || rejects[0].Command != test.order.Command {
+			t.Errorf("Expected a reject of command %v, received %v for test %s", test.order.Command, rejects, name)
+		}
+	}
+}
+
+func TestIsOutsideBand(t *testing.T) {
+	tests := map[string]struct {
+		price     Price
+		reference Price
+		bandBps   int
+		outside   bool
+	}{
+		"Inside band":                  {price: intPrice(105), reference: intPrice(100), bandBps: 1000, outside: false},
+		"Outside band":                 {price: intPrice(111), reference: intPrice(100), bandBps: 1000, outside: true},
+		"Mixed scales":                 {price: NewPrice(10999, 2), reference: intPrice(100), bandBps: 1000, outside: false},
+		"Negative reference":           {price: NewPrice(-25, 1), reference: intPrice(-2), bandBps: 2000, outside: true},
+		"Disabled band":                {price: intPrice(1000), reference: intPrice(100), bandBps: 0, outside: false},
+		"Large prices do not overflow": {price: intPrice(math.MaxInt64), reference: intPrice(math.MaxInt64 / 2), bandBps: 1000, outside: true},
+	}
+
+	for name, test := range tests {
+		if outside := isOutsideBand(test.price, test.reference, test.bandBps); outside != test.outside {
+			t.Errorf("Expected %v, received %v for test %s", test.outside, outside, name)
+		}
+	}
+}
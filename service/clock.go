@@ -0,0 +1,50 @@
+package service
+
+import "time"
+
+// Now: the system time in UTC. The monotonic reading is dropped so times survive being written to the
+// journal or a snapshot and read back unchanged.
+func (RealClock) Now() time.Time {
+	return time.Now().UTC().Round(0)
+}
+
+// NewSimulatedClock: Initializes a simulated clock standing at start
+func NewSimulatedClock(start time.Time) *SimulatedClock {
+	return &SimulatedClock{Time: start.UTC()}
+}
+
+func (c *SimulatedClock) Now() time.Time {
+	return c.Time
+}
+
+// Advance: moves the clock forward by duration
+func (c *SimulatedClock) Advance(duration time.Duration) {
+	c.Time = c.Time.Add(duration)
+}
+
+// newClock: the clock named by CLOCK_SOURCE, anything other than SIMULATED_CLOCK is the system clock
+func newClock(source string) Clock {
+	if source == SIMULATED_CLOCK {
+		return NewSimulatedClock(time.Unix(0, 0))
+	}
+	return RealClock{}
+}
+
+// stampCommand: numbers an inbound command and stamps it with the time it was received. A command that
+// already carries a timestamp, such as one replayed from the journal, keeps it, and the service's time
+// never moves backwards even if the clock does.
+func (o *OrderBookService) stampCommand(order *Order) {
+	o.commandSequence++
+	order.Sequence = o.commandSequence
+	if order.Timestamp.IsZero() {
+		clock := o.Clock
+		if clock == nil {
+			clock = RealClock{}
+		}
+		order.Timestamp = clock.Now()
+	}
+	if order.Timestamp.Before(o.currentTime) {
+		order.Timestamp = o.currentTime
+	}
+	o.currentTime = order.Timestamp
+}
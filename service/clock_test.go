@@ -0,0 +1,84 @@
+package service
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// steppingClock: hands out a fixed list of times, one per call
+type steppingClock struct {
+	times []time.Time
+}
+
+func (c *steppingClock) Now() time.Time {
+	now := c.times[0]
+	c.times = c.times[1:]
+	return now
+}
+
+func TestStampedOutput(t *testing.T) {
+	var buffer bytes.Buffer
+	testService := NewOrderBookService()
+	testService.Output = &buffer
+	testService.IsOutputStamped = true
+	// the clock steps back on the third command, the service holds its time rather than going backwards
+	testService.Clock = &steppingClock{times: []time.Time{time.Unix(0, 100), time.Unix(0, 200), time.Unix(0, 150), time.Unix(0, 300)}}
+
+	orders := []Order{
+		{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: BUY},
+		{Command: NEW_ORDER, UserID: 2, UserOrderID: 2, Price: intPrice(11), Quantity: 100, Side: SELL},
+		{Command: FLUSH_ORDER_BOOK},
+		{Command: NEW_ORDER, UserID: 1, UserOrderID: 3, Price: intPrice(10), Quantity: 50, Side: BUY},
+	}
+	if err := testService.ProcessOrderBook(orders); err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+
+	// the flush prints nothing so it takes no event sequence number
+	expected := []string{
+		"1, 100, A, 1, 1",
+		"2, 100, B, B, 10, 100",
+		"3, 200, A, 2, 2",
+		"4, 200, B, S, 11, 100",
+		"5, 300, A, 1, 3",
+		"6, 300, B, B, 10, 50",
+	}
+	output := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if !reflect.DeepEqual(output, expected) {
+		t.Errorf("Expected output %v, received %v", expected, output)
+	}
+	if testService.commandSequence != 4 || testService.eventSequence != 6 {
+		t.Errorf("Expected 4 commands and 6 events, received %v and %v", testService.commandSequence, testService.eventSequence)
+	}
+	if resting := testService.orderBook.Bids[0]; resting.Sequence != 4 || !resting.Timestamp.Equal(time.Unix(0, 300)) {
+		t.Errorf("Expected the resting order to carry command 4 received at 300, received %v at %v", resting.Sequence, resting.Timestamp)
+	}
+}
+
+func TestStampCommand(t *testing.T) {
+	clock := NewSimulatedClock(time.Unix(10, 0))
+	testService := NewOrderBookService()
+	testService.Clock = clock
+	tests := []struct {
+		advance  time.Duration
+		received time.Time // a timestamp the command already carries, as when replayed from the journal
+		expected time.Time
+	}{
+		{expected: time.Unix(10, 0)},
+		{advance: time.Second, expected: time.Unix(11, 0)},
+		{received: time.Unix(20, 0), expected: time.Unix(20, 0)},
+		{advance: time.Second, expected: time.Unix(20, 0)},
+	}
+
+	for i, test := range tests {
+		clock.Advance(test.advance)
+		order := Order{Command: FLUSH_ORDER_BOOK, Timestamp: test.received}
+		testService.stampCommand(&order)
+		if order.Sequence != i+1 || !order.Timestamp.Equal(test.expected) {
+			t.Errorf("Expected command %v at %v, received %v at %v", i+1, test.expected, order.Sequence, order.Timestamp)
+		}
+	}
+}
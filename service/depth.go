@@ -0,0 +1,82 @@
+package service
+
+// Depth: aggregates the book into price levels, best first, returning at most levels per side.
+// levels <= 0 returns every level.
+func (b *OrderBook) Depth(levels int) ([]DepthLevel, []DepthLevel) {
+	return aggregateLevels(b.Bids, levels), aggregateLevels(b.Asks, levels)
+}
+
+func aggregateLevels(orders []Order, levels int) []DepthLevel {
+	var depth []DepthLevel
+	for _, order := range orders {
+		last := len(depth) - 1
+		if last >= 0 && depth[last].Price.Cmp(order.Price) == 0 {
+			depth[last].Quantity += order.Quantity
+			depth[last].OrderCount++
+			continue
+		}
+		if levels > 0 && len(depth) == levels {
+			break
+		}
+		depth = append(depth, DepthLevel{Price: order.Price, Quantity: order.Quantity, OrderCount: 1})
+	}
+	return depth
+}
+
+// handleDepth: compares the configured number of levels on each side with what was last published and
+// reports every level that was added, changed or deleted
+//
+// D, side, action, price, totalQuantity, orderCount
+func (o *OrderBookService) handleDepth() []Event {
+	if o.DepthLevels <= 0 {
+		return nil
+	}
+	bids, asks := o.orderBook.Depth(o.DepthLevels)
+	events := diffDepth(BUY, o.orderBook.PublishedBidDepth, bids)
+	events = append(events, diffDepth(SELL, o.orderBook.PublishedAskDepth, asks)...)
+	o.orderBook.PublishedBidDepth = bids
+	o.orderBook.PublishedAskDepth = asks
+	return events
+}
+
+// clearDepth: deletes every published level, for a book that is about to be cleared
+func (o *OrderBookService) clearDepth() []Event {
+	events := diffDepth(BUY, o.orderBook.PublishedBidDepth, nil)
+	events = append(events, diffDepth(SELL, o.orderBook.PublishedAskDepth, nil)...)
+	o.orderBook.PublishedBidDepth = nil
+	o.orderBook.PublishedAskDepth = nil
+	return events
+}
+
+// diffDepth: deletes come first so a consumer applying the updates in order never holds more levels than
+// the configured depth
+func diffDepth(side string, previous []DepthLevel, current []DepthLevel) []Event {
+	var events []Event
+	for _, level := range previous {
+		if _, found := findLevel(current, level.Price); !found {
+			events = append(events, depthEvent(side, LEVEL_DELETE, DepthLevel{Price: level.Price}))
+		}
+	}
+	for _, level := range current {
+		previousLevel, found := findLevel(previous, level.Price)
+		if !found {
+			events = append(events, depthEvent(side, LEVEL_ADD, level))
+		} else if previousLevel.Quantity != level.Quantity || previousLevel.OrderCount != level.OrderCount {
+			events = append(events, depthEvent(side, LEVEL_CHANGE, level))
+		}
+	}
+	return events
+}
+
+func findLevel(levels []DepthLevel, price Price) (DepthLevel, bool) {
+	for _, level := range levels {
+		if level.Price.Cmp(price) == 0 {
+			return level, true
+		}
+	}
+	return DepthLevel{}, false
+}
+
+func depthEvent(side string, action string, level DepthLevel) Event {
+	return Event{Type: EVENT_DEPTH, Side: side, Action: action, Level: level}
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDepth(t *testing.T) {
+	book := NewOrderBook()
+	book.Bids = []Order{
+		{UserID: 1, Price: intPrice(10), Quantity: 100},
+		{UserID: 2, Price: intPrice(10), Quantity: 50},
+		{UserID: 1, Price: intPrice(9), Quantity: 100},
+		{UserID: 3, Price: intPrice(8), Quantity: 10},
+	}
+	book.Asks = []Order{
+		{UserID: 2, Price: intPrice(11), Quantity: 200},
+	}
+	tests := map[string]struct {
+		levels int
+		bids   []DepthLevel
+		asks   []DepthLevel
+	}{
+		"Limited depth": {
+			levels: 2,
+			bids:   []DepthLevel{{Price: intPrice(10), Quantity: 150, OrderCount: 2}, {Price: intPrice(9), Quantity: 100, OrderCount: 1}},
+			asks:   []DepthLevel{{Price: intPrice(11), Quantity: 200, OrderCount: 1}},
+		},
+		"Full depth": {
+			levels: 0,
+			bids: []DepthLevel{
+				{Price: intPrice(10), Quantity: 150, OrderCount: 2},
+				{Price: intPrice(9), Quantity: 100, OrderCount: 1},
+				{Price: intPrice(8), Quantity: 10, OrderCount: 1},
+			},
+			asks: []DepthLevel{{Price: intPrice(11), Quantity: 200, OrderCount: 1}},
+		},
+	}
+
+	for name, test := range tests {
+		bids, asks := book.Depth(test.levels)
+		if !reflect.DeepEqual(bids, test.bids) {
+			t.Errorf("Expected bids %v, received %v for test %s", test.bids, bids, name)
+		}
+		if !reflect.DeepEqual(asks, test.asks) {
+			t.Errorf("Expected asks %v, received %v for test %s", test.asks, asks, name)
+		}
+	}
+}
+
+func TestHandleDepth(t *testing.T) {
+	testService := NewOrderBookService()
+	testService.DepthLevels = 2
+	testService.orderBook = NewOrderBook()
+	tests := []struct {
+		order  *Order
+		output []string
+	}{
+		{
+			order:  &Order{UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: BUY},
+			output: []string{"B, B, 10, 100", "D, B, ADD, 10, 100, 1"},
+		},
+		{
+			order:  &Order{UserID: 2, UserOrderID: 2, Price: intPrice(10), Quantity: 50, Side: BUY},
+			output: []string{"D, B, CHANGE, 10, 150, 2"},
+		},
+		{
+			order:  &Order{UserID: 1, UserOrderID: 3, Price: intPrice(9), Quantity: 100, Side: BUY},
+			output: []string{"D, B, ADD, 9, 100, 1"},
+		},
+		{
+			order:  &Order{UserID: 1, UserOrderID: 4, Price: intPrice(11), Quantity: 100, Side: BUY},
+			output: []string{"B, B, 11, 100", "D, B, DELETE, 9, 0, 0", "D, B, ADD, 11, 100, 1"},
+		},
+	}
+
+	for i, test := range tests {
+		if _, err := testService.newOrder(test.order); err != nil {
+			t.Errorf("Expected no error, received %s for order %v", err, i+1)
+		}
+		events, err := testService.handleTopOfBook()
+		output := eventLines(events)
+		if err != nil {
+			t.Errorf("Expected no error, received %s for order %v", err, i+1)
+		}
+		if !reflect.DeepEqual(output, test.output) {
+			t.Errorf("Expected output %v, received %v for order %v", test.output, output, i+1)
+		}
+	}
+}
+
+func TestFlushDepth(t *testing.T) {
+	testService := NewOrderBookService()
+	testService.DepthLevels = 2
+	testService.orderBook = NewOrderBook()
+	orders := []*Order{
+		{UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: BUY},
+		{UserID: 1, UserOrderID: 2, Price: intPrice(9), Quantity: 100, Side: BUY},
+		{UserID: 2, UserOrderID: 3, Price: intPrice(11), Quantity: 50, Side: SELL},
+	}
+	for _, order := range orders {
+		if _, err := testService.newOrder(order); err != nil {
+			t.Fatalf("Expected no error, received %s for order %v", err, order.UserOrderID)
+		}
+		if _, err := testService.handleTopOfBook(); err != nil {
+			t.Fatalf("Expected no error, received %s for order %v", err, order.UserOrderID)
+		}
+	}
+
+	expected := []string{"D, B, DELETE, 10, 0, 0", "D, B, DELETE, 9, 0, 0", "D, S, DELETE, 11, 0, 0"}
+	if output := eventLines(testService.flushBook()); !reflect.DeepEqual(output, expected) {
+		t.Errorf("Expected flush output %v, received %v", expected, output)
+	}
+
+	// the level comes back as an add once the book is filled again
+	if _, err := testService.newOrder(&Order{UserID: 1, UserOrderID: 4, Price: intPrice(10), Quantity: 100, Side: BUY}); err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	events, err := testService.handleTopOfBook()
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	expected = []string{"B, B, 10, 100", "D, B, ADD, 10, 100, 1"}
+	if output := eventLines(events); !reflect.DeepEqual(output, expected) {
+		t.Errorf("Expected output after the flush %v, received %v", expected, output)
+	}
+}
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrQueueFull    = errors.New("shard queue is full")
+	ErrEngineClosed = errors.New("engine is closed")
+)
+
+// NewShardedEngine: Initializes an engine whose books are created by newService as their symbols first
+// appear, each with a queue of queueSize commands. The services must not share a journal or order feed.
+// Events() has to be drained while the engine runs, a shard waits for room on it before it goes on.
+func NewShardedEngine(queueSize int, newService func() *OrderBookService) *ShardedEngine {
+	if queueSize <= 0 {
+		queueSize = SHARD_QUEUE_SIZE
+	}
+	return &ShardedEngine{
+		queueSize:    queueSize,
+		newService:   newService,
+		shards:       make(map[string]*shard),
+		orderSymbols: make(map[orderKey]string),
+		queuedOrders: make(map[orderKey]int),
+		events:       make(chan ShardEvent, queueSize),
+	}
+}
+
+// Submit: queues a command for the books it concerns, waiting while a queue is full. New orders go to
+// their symbol's book and cancels and amends to the book the order was entered in, while flush, session
+// and clock commands go to every book there is. A cancel or amend for an order that is not resting in any
+// book is answered with an UNKNOWN_ORDER reject on Events() that carries no symbol.
+func (e *ShardedEngine) Submit(order Order) error {
+	return e.submit(order, true)
+}
+
+// TrySubmit: queues a command like Submit, but returns ErrQueueFull rather than waiting for room. A command
+// for several books checks they all have room first, which only guarantees all or none of them get it when
+// nobody else is submitting at the same time.
+func (e *ShardedEngine) TrySubmit(order Order) error {
+	return e.submit(order, false)
+}
+
+// Events: the merged stream of every book's events, in order for each symbol. It is closed once Close
+// has drained every queue.
+func (e *ShardedEngine) Events() <-chan ShardEvent {
+	return e.events
+}
+
+// Close: stops taking commands, lets every book work through what is already queued and closes Events()
+func (e *ShardedEngine) Close() {
+	e.closing.Lock()
+	if e.closed {
+		e.closing.Unlock()
+		return
+	}
+	e.closed = true
+	e.mutex.Lock()
+	for _, shard := range e.shards {
+		close(shard.commands)
+	}
+	e.mutex.Unlock()
+	e.closing.Unlock()
+
+	e.wait.Wait()
+	close(e.events)
+}
+
+func (e *ShardedEngine) submit(order Order, wait bool) error {
+	// a queue is only closed once no command is on its way to it
+	e.closing.RLock()
+	defer e.closing.RUnlock()
+	if e.closed {
+		return ErrEngineClosed
+	}
+
+	shards, found := e.route(order)
+	if !found {
+		return e.reject(order, wait)
+	}
+	if !wait {
+		for _, shard := range shards {
+			if len(shard.commands) == cap(shard.commands) {
+				return ErrQueueFull
+			}
+		}
+	}
+	for _, shard := range shards {
+		if wait {
+			shard.commands <- order
+			continue
+		}
+		select {
+		case shard.commands <- order:
+		default:
+			return ErrQueueFull
+		}
+	}
+	return nil
+}
+
+// route: the shards a command goes to, starting the shard of a symbol seen for the first time. A cancel
+// or amend for an order with no route is not found.
+func (e *ShardedEngine) route(order Order) ([]*shard, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	switch order.Command {
+	case NEW_ORDER:
+		e.orderSymbols[userOrderKey(order)] = order.Symbol
+		e.queuedOrders[userOrderKey(order)]++
+		return []*shard{e.shard(order.Symbol)}, true
+	case CANCEL_ORDER, AMEND_ORDER:
+		symbol, found := e.orderSymbols[userOrderKey(order)]
+		if !found {
+			return nil, false
+		}
+		return []*shard{e.shard(symbol)}, true
+	}
+	shards := make([]*shard, 0, len(e.shards))
+	for _, shard := range e.shards {
+		shards = append(shards, shard)
+	}
+	return shards, true
+}
+
+// reject: answers a command for an order with no route, without waiting for room on Events() unless wait
+// is set
+func (e *ShardedEngine) reject(order Order, wait bool) error {
+	event := ShardEvent{Event: rejectEvent(order.Command, order, UNKNOWN_ORDER)}
+	if wait {
+		e.events <- event
+		return nil
+	}
+	select {
+	case e.events <- event:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (e *ShardedEngine) shard(symbol string) *shard {
+	if existing, found := e.shards[symbol]; found {
+		return existing
+	}
+	created := &shard{
+		symbol:   symbol,
+		service:  e.newService(),
+		commands: make(chan Order, e.queueSize),
+		touched:  make(map[orderKey]bool),
+	}
+	created.service.Output = ioutil.Discard
+	created.service.OnEvent = func(event Event) {
+		created.touch(event)
+		e.events <- ShardEvent{Symbol: symbol, Event: event}
+	}
+	e.shards[symbol] = created
+	e.wait.Add(1)
+	go e.run(created)
+	return created
+}
+
+// run: processes a shard's commands one at a time until its queue is closed and empty
+func (e *ShardedEngine) run(s *shard) {
+	defer e.wait.Done()
+	for order := range s.commands {
+		err := s.service.ProcessOrderBook([]Order{order})
+		e.settle(s, order)
+		if err != nil {
+			e.events <- ShardEvent{Symbol: s.symbol, Err: errors.Wrapf(err, "error processing command for %v in run()", s.symbol)}
+		}
+	}
+}
+
+// touch: notes the orders an event concerns, whether they are still resting is looked up once the command
+// is done
+func (s *shard) touch(event Event) {
+	if event.Type == EVENT_FLUSH {
+		s.flushed = true
+	}
+	for _, order := range []Order{event.Order, event.Bid, event.Ask} {
+		s.touched[userOrderKey(order)] = true
+	}
+}
+
+// userOrderKey: the route of an order, user order ids are only unique for one user
+func userOrderKey(order Order) orderKey {
+	return orderKey{userID: order.UserID, userOrderID: order.UserOrderID}
+}
+
+// settle: drops the routes of the orders a command left out of the book, whether it was cancelled, filled,
+// expired, rejected or flushed, so later cancels and amends for them are rejected. A route that now leads
+// to another book, or that a new order still waiting in the queue is about to use, is kept.
+func (e *ShardedEngine) settle(s *shard, command Order) {
+	commandKey := userOrderKey(command)
+	if command.Command == NEW_ORDER || command.Command == CANCEL_ORDER || command.Command == AMEND_ORDER {
+		s.touched[commandKey] = true
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if command.Command == NEW_ORDER {
+		if e.queuedOrders[commandKey]--; e.queuedOrders[commandKey] <= 0 {
+			delete(e.queuedOrders, commandKey)
+		}
+	}
+	if s.flushed {
+		for key, symbol := range e.orderSymbols {
+			if symbol == s.symbol {
+				s.touched[key] = true
+			}
+		}
+		s.flushed = false
+	}
+	for key := range s.touched {
+		delete(s.touched, key)
+		if e.orderSymbols[key] != s.symbol || e.queuedOrders[key] > 0 {
+			continue
+		}
+		if resting, found := s.service.Order(key.userOrderID); !found || resting.UserID != key.userID {
+			delete(e.orderSymbols, key)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package service
+
+import (
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newEngineService() *OrderBookService {
+	testService := NewOrderBookService()
+	testService.IsTradingEnabled = true
+	testService.Clock = NewSimulatedClock(time.Unix(0, 0))
+	return testService
+}
+
+// engineLines: the output lines of every symbol's events, in the order they arrived
+func engineLines(t *testing.T, events <-chan ShardEvent) map[string][]string {
+	lines := make(map[string][]string)
+	for event := range events {
+		if event.Err != nil {
+			t.Errorf("Expected no error, received %s", event.Err)
+			continue
+		}
+		if line := event.Event.String(); line != "" {
+			lines[event.Symbol] = append(lines[event.Symbol], line)
+		}
+	}
+	return lines
+}
+
+func TestShardedEngine(t *testing.T) {
+	symbols := []string{"IBM", "AAPL", "MSFT"}
+	var commands []Order
+	for i := 0; i < 30; i++ {
+		symbol := symbols[i%len(symbols)]
+		side := BUY
+		if i%2 == 1 {
+			side = SELL
+		}
+		price := intPrice(int64(10 + i%3))
+		if i%5 == 2 {
+			// priced away from the book so the cancel below always finds it resting
+			price = intPrice(1)
+			if side == SELL {
+				price = intPrice(99)
+			}
+		}
+		commands = append(commands, Order{Command: NEW_ORDER, UserID: i % 4, UserOrderID: i + 1, Symbol: symbol, Price: price, Quantity: 10 + i, Side: side})
+		if i%5 == 4 {
+			commands = append(commands, Order{Command: CANCEL_ORDER, UserID: (i - 2) % 4, UserOrderID: i - 1})
+		}
+	}
+	commands = append(commands, Order{Command: SESSION_STATE, SessionState: HALTED}, Order{Command: CANCEL_ORDER, UserID: 0, UserOrderID: 100})
+
+	// every symbol's book sees exactly the commands a book of its own would have
+	expected := make(map[string][]string)
+	for _, symbol := range symbols {
+		var output []Order
+		resting := make(map[int]bool)
+		for _, command := range commands {
+			if command.Command == NEW_ORDER && command.Symbol == symbol {
+				resting[command.UserOrderID] = true
+			}
+			if command.Command == SESSION_STATE || command.Symbol == symbol || (command.Command == CANCEL_ORDER && resting[command.UserOrderID]) {
+				output = append(output, command)
+			}
+		}
+		single := newEngineService()
+		var events []Event
+		single.OnEvent = func(event Event) { events = append(events, event) }
+		single.Output = ioutil.Discard
+		if err := single.ProcessOrderBook(output); err != nil {
+			t.Fatalf("Expected no error, received %s", err)
+		}
+		expected[symbol] = eventLines(events)
+	}
+	// the cancel for an order no book has carries no symbol
+	expected[""] = []string{"R, 0, 100, UNKNOWN_ORDER"}
+
+	engine := NewShardedEngine(4, newEngineService)
+	received := make(chan map[string][]string)
+	go func() { received <- engineLines(t, engine.Events()) }()
+	for _, command := range commands {
+		if err := engine.Submit(command); err != nil {
+			t.Fatalf("Expected no error, received %s", err)
+		}
+	}
+	engine.Close()
+	lines := <-received
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected events %v, received %v", expected, lines)
+	}
+	if err := engine.Submit(commands[0]); err != ErrEngineClosed {
+		t.Errorf("Expected %v after closing, received %v", ErrEngineClosed, err)
+	}
+}
+
+func TestShardedEngineBackpressure(t *testing.T) {
+	engine := NewShardedEngine(1, newEngineService)
+
+	// nothing reads the events yet, so the book stalls on them and its queue fills up
+	accepted := 0
+	for i := 1; i <= 100; i++ {
+		err := engine.TrySubmit(Order{Command: NEW_ORDER, UserID: 1, UserOrderID: i, Symbol: "IBM", Price: intPrice(10), Quantity: 1, Side: BUY})
+		if err == ErrQueueFull {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Expected no error, received %s", err)
+		}
+		accepted++
+	}
+	if accepted == 0 || accepted == 100 {
+		t.Fatalf("Expected the queue to fill up part way, accepted %v commands", accepted)
+	}
+
+	// closing drains everything that was accepted
+	var wait sync.WaitGroup
+	acks := 0
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		for event := range engine.Events() {
+			if event.Event.Type == EVENT_ACK {
+				acks++
+			}
+		}
+	}()
+	engine.Close()
+	wait.Wait()
+	if acks != accepted {
+		t.Errorf("Expected %v acknowledgements, received %v", accepted, acks)
+	}
+}
+
+func TestShardedEngineOrderRoutes(t *testing.T) {
+	engine := NewShardedEngine(16, newEngineService)
+	events := engine.Events()
+	// await: the next count output lines, once they have all arrived the books are done with every command
+	// that printed them
+	await := func(count int) []string {
+		var lines []string
+		timeout := time.After(2 * time.Second)
+		for len(lines) < count {
+			select {
+			case event := <-events:
+				if event.Err != nil {
+					t.Fatalf("Expected no error, received %s", event.Err)
+				}
+				if line := event.Event.String(); line != "" {
+					lines = append(lines, line)
+				}
+			case <-timeout:
+				t.Fatalf("Expected %v lines, received %v", count, lines)
+			}
+		}
+		return lines
+	}
+	// each step waits for all of its lines, so the engine's own rejects never mix with a book's lines
+	tests := []struct {
+		name     string
+		commands []Order
+		output   []string
+		resting  map[string][]int // user order ids each book holds afterwards, when set
+	}{
+		{
+			name: "Orders filled on entry",
+			commands: []Order{
+				{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Symbol: "IBM", Price: intPrice(10), Quantity: 10, Side: BUY},
+				{Command: NEW_ORDER, UserID: 2, UserOrderID: 2, Symbol: "IBM", Price: intPrice(10), Quantity: 10, Side: SELL},
+				{Command: NEW_ORDER, UserID: 1, UserOrderID: 3, Symbol: "IBM", Price: intPrice(5), Quantity: 10, Side: BUY},
+			},
+			output: []string{"A, 1, 1", "B, B, 10, 10", "A, 2, 2", "T, 1, 1, 2, 2, 10, 10", "B, B, -, -", "A, 1, 3", "B, B, 5, 10"},
+		},
+		{
+			name:     "Cancel for a filled order",
+			commands: []Order{{Command: CANCEL_ORDER, UserID: 1, UserOrderID: 1}},
+			output:   []string{"R, 1, 1, UNKNOWN_ORDER"},
+		},
+		{
+			name:     "Cancel for a resting order",
+			commands: []Order{{Command: CANCEL_ORDER, UserID: 1, UserOrderID: 3}},
+			output:   []string{"A, 1, 3", "B, B, -, -"},
+		},
+		{
+			name:     "Amend for a cancelled order",
+			commands: []Order{{Command: AMEND_ORDER, UserID: 1, UserOrderID: 3, Price: intPrice(6), Quantity: 10}},
+			output:   []string{"R, 1, 3, UNKNOWN_ORDER"},
+		},
+		{
+			name: "Order entered again right after its cancel",
+			commands: []Order{
+				{Command: NEW_ORDER, UserID: 1, UserOrderID: 4, Symbol: "IBM", Price: intPrice(5), Quantity: 10, Side: BUY},
+				{Command: CANCEL_ORDER, UserID: 1, UserOrderID: 4},
+				{Command: NEW_ORDER, UserID: 1, UserOrderID: 4, Symbol: "IBM", Price: intPrice(6), Quantity: 10, Side: BUY},
+				{Command: CANCEL_ORDER, UserID: 1, UserOrderID: 4},
+			},
+			output: []string{"A, 1, 4", "B, B, 5, 10", "A, 1, 4", "B, B, -, -", "A, 1, 4", "B, B, 6, 10", "A, 1, 4", "B, B, -, -"},
+		},
+		{
+			name: "Flushed book",
+			commands: []Order{
+				{Command: NEW_ORDER, UserID: 1, UserOrderID: 5, Symbol: "MSFT", Price: intPrice(5), Quantity: 10, Side: BUY},
+				{Command: FLUSH_ORDER_BOOK},
+				{Command: NEW_ORDER, UserID: 1, UserOrderID: 6, Symbol: "MSFT", Price: intPrice(4), Quantity: 10, Side: BUY},
+			},
+			output: []string{"A, 1, 5", "B, B, 5, 10", "A, 1, 6", "B, B, 4, 10"},
+		},
+		{
+			name:     "Cancel for a flushed order",
+			commands: []Order{{Command: CANCEL_ORDER, UserID: 1, UserOrderID: 5}},
+			output:   []string{"R, 1, 5, UNKNOWN_ORDER"},
+		},
+		{
+			name:     "Cancel after the flush",
+			commands: []Order{{Command: CANCEL_ORDER, UserID: 1, UserOrderID: 6}},
+			output:   []string{"A, 1, 6", "B, B, -, -"},
+		},
+		{
+			name:     "User order id of one user",
+			commands: []Order{{Command: NEW_ORDER, UserID: 1, UserOrderID: 7, Symbol: "IBM", Price: intPrice(5), Quantity: 10, Side: BUY}},
+			output:   []string{"A, 1, 7", "B, B, 5, 10"},
+		},
+		{
+			name:     "Same user order id of another user in another symbol",
+			commands: []Order{{Command: NEW_ORDER, UserID: 2, UserOrderID: 7, Symbol: "AAPL", Price: intPrice(6), Quantity: 10, Side: BUY}},
+			output:   []string{"A, 2, 7", "B, B, 6, 10"},
+			resting:  map[string][]int{"IBM": {7}, "AAPL": {7}},
+		},
+		{
+			name:     "Cancel goes to the book of its user's order",
+			commands: []Order{{Command: CANCEL_ORDER, UserID: 1, UserOrderID: 7}},
+			output:   []string{"A, 1, 7", "B, B, -, -"},
+			resting:  map[string][]int{"IBM": nil, "AAPL": {7}},
+		},
+		{
+			name:     "Cancel of the other user's order",
+			commands: []Order{{Command: CANCEL_ORDER, UserID: 2, UserOrderID: 7}},
+			output:   []string{"A, 2, 7", "B, B, -, -"},
+			resting:  map[string][]int{"IBM": nil, "AAPL": nil},
+		},
+	}
+
+	for _, test := range tests {
+		for _, command := range test.commands {
+			if err := engine.Submit(command); err != nil {
+				t.Fatalf("Expected no error, received %s for test %s", err, test.name)
+			}
+		}
+		output := await(len(test.output))
+		if !reflect.DeepEqual(output, test.output) {
+			t.Errorf("Expected output %v, received %v for test %s", test.output, output, test.name)
+		}
+		for symbol, expected := range test.resting {
+			engine.mutex.Lock()
+			book := engine.shards[symbol].service.Book()
+			engine.mutex.Unlock()
+			var userOrderIDs []int
+			for _, order := range append(book.Bids, book.Asks...) {
+				userOrderIDs = append(userOrderIDs, order.UserOrderID)
+			}
+			if !reflect.DeepEqual(userOrderIDs, expected) {
+				t.Errorf("Expected %v resting in %v, received %v for test %s", expected, symbol, userOrderIDs, test.name)
+			}
+		}
+	}
+	engine.Close()
+	for range events {
+	}
+	if len(engine.orderSymbols) != 0 || len(engine.queuedOrders) != 0 {
+		t.Errorf("Expected no routes left, received %v and %v", engine.orderSymbols, engine.queuedOrders)
+	}
+}
@@ -0,0 +1,51 @@
+package service
+
+import "fmt"
+
+// String: formats the event as its line of output, events with nothing to print return an empty string
+func (e Event) String() string {
+	switch e.Type {
+	case EVENT_ACK:
+		return fmt.Sprintf("A, %v, %v", e.Order.UserID, e.Order.UserOrderID)
+	case EVENT_REJECT:
+		if e.Reason != "" {
+			return fmt.Sprintf("R, %v, %v, %v", e.Order.UserID, e.Order.UserOrderID, e.Reason)
+		}
+		return fmt.Sprintf("R, %v, %v", e.Order.UserID, e.Order.UserOrderID)
+	case EVENT_TOP_OF_BOOK:
+		if !e.TopBook.IsSet {
+			return fmt.Sprintf("B, %v, -, -", e.Side)
+		}
+		return fmt.Sprintf("B, %v, %v, %v", e.Side, e.TopBook.Price, e.TopBook.Quantity)
+	case EVENT_TRADE:
+		return fmt.Sprintf("T, %v, %v, %v, %v, %v, %v", e.Bid.UserID, e.Bid.UserOrderID, e.Ask.UserID, e.Ask.UserOrderID, e.Price, e.Quantity)
+	case EVENT_SESSION:
+		return fmt.Sprintf("S, %v", e.State)
+	case EVENT_HALT:
+		return fmt.Sprintf("H, %v, %v", e.Price, e.Reference)
+	case EVENT_SELF_TRADE:
+		return fmt.Sprintf("X, %v, %v, %v, %v, %v", e.Order.UserID, e.Order.UserOrderID, e.Quantity, e.Order.Quantity, e.Reason)
+	case EVENT_DEPTH:
+		return fmt.Sprintf("D, %v, %v, %v, %v, %v", e.Side, e.Action, e.Level.Price, e.Level.Quantity, e.Level.OrderCount)
+	case EVENT_EXPIRE:
+		return fmt.Sprintf("E, %v, %v", e.Order.UserID, e.Order.UserOrderID)
+	}
+	return ""
+}
+
+func ackEvent(command string, order Order) Event {
+	return Event{Type: EVENT_ACK, Command: command, Order: order}
+}
+
+func rejectEvent(command string, order Order, reason string) Event {
+	return Event{Type: EVENT_REJECT, Command: command, Order: order, Reason: reason}
+}
+
+// tradeEvent: bid and ask hold what is left of each order after the trade
+func tradeEvent(bid Order, ask Order, price Price, quantity int) Event {
+	return Event{Type: EVENT_TRADE, Bid: bid, Ask: ask, Price: price, Quantity: quantity}
+}
+
+func sessionEvent(state string) Event {
+	return Event{Type: EVENT_SESSION, State: state}
+}
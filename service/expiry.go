@@ -0,0 +1,68 @@
+package service
+
+import (
+	"container/heap"
+
+	"github.com/pkg/errors"
+)
+
+func (q ExpiryQueue) Len() int { return len(q) }
+
+func (q ExpiryQueue) Less(i, j int) bool {
+	if q[i].At.Equal(q[j].At) {
+		return q[i].OrderID < q[j].OrderID
+	}
+	return q[i].At.Before(q[j].At)
+}
+
+func (q ExpiryQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *ExpiryQueue) Push(x interface{}) {
+	*q = append(*q, x.(Expiry))
+}
+
+func (q *ExpiryQueue) Pop() interface{} {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return last
+}
+
+// scheduleExpiry: starts the timer of a good-till-date order that has just rested in the book
+func (o *OrderBookService) scheduleExpiry(order Order) {
+	if order.ExpiresAt.IsZero() {
+		return
+	}
+	heap.Push(&o.orderBook.Expiries, Expiry{
+		At:          order.ExpiresAt,
+		OrderID:     order.OrderID,
+		UserOrderID: order.UserOrderID,
+		Side:        order.Side,
+	})
+}
+
+// expireOrders: cancels every resting order whose expiry time the service's time has reached, soonest
+// first, whatever the session state. Timers whose order already left the book are dropped.
+//
+// E, userId, userOrderId
+func (o *OrderBookService) expireOrders() ([]Event, error) {
+	var events []Event
+	for len(o.orderBook.Expiries) > 0 && !o.orderBook.Expiries[0].At.After(o.currentTime) {
+		expiry := heap.Pop(&o.orderBook.Expiries).(Expiry)
+		resting := o.findOrder(expiry.Side, expiry.UserOrderID)
+		if resting == nil || resting.OrderID != expiry.OrderID {
+			continue
+		}
+		expired := *resting
+		o.removeOrder(expired.Side, expired.UserOrderID)
+		events = append(events, Event{Type: EVENT_EXPIRE, Order: expired})
+	}
+	if len(events) == 0 {
+		return nil, nil
+	}
+	topOfBook, err := o.handleTopOfBook()
+	if err != nil {
+		return nil, errors.Wrap(err, "error handling top of book in expireOrders()")
+	}
+	return append(events, topOfBook...), nil
+}
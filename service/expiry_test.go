@@ -0,0 +1,106 @@
+package service
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpireOrders(t *testing.T) {
+	tests := map[string]struct {
+		orders []Order
+		output []string
+	}{
+		"Clock advance expires the order": {
+			orders: []Order{
+				{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: BUY, ExpiresAt: time.Unix(0, 50)},
+				{Command: ADVANCE_CLOCK, Timestamp: time.Unix(0, 50)},
+			},
+			output: []string{"A, 1, 1", "B, B, 10, 100", "E, 1, 1", "B, B, -, -"},
+		},
+		"Clock advance short of the expiry": {
+			orders: []Order{
+				{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: BUY, ExpiresAt: time.Unix(0, 50)},
+				{Command: ADVANCE_CLOCK, Timestamp: time.Unix(0, 49)},
+			},
+			output: []string{"A, 1, 1", "B, B, 10, 100"},
+		},
+		"Expiries run soonest first before the next command": {
+			orders: []Order{
+				{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: BUY, ExpiresAt: time.Unix(0, 80)},
+				{Command: NEW_ORDER, UserID: 2, UserOrderID: 2, Price: intPrice(12), Quantity: 100, Side: SELL, ExpiresAt: time.Unix(0, 30)},
+				{Command: NEW_ORDER, UserID: 1, UserOrderID: 3, Price: intPrice(9), Quantity: 100, Side: BUY},
+				{Command: NEW_ORDER, UserID: 2, UserOrderID: 4, Price: intPrice(11), Quantity: 100, Side: SELL, Timestamp: time.Unix(0, 100)},
+			},
+			output: []string{
+				"A, 1, 1", "B, B, 10, 100",
+				"A, 2, 2", "B, S, 12, 100",
+				"A, 1, 3",
+				"E, 2, 2", "E, 1, 1", "B, B, 9, 100", "B, S, -, -",
+				"A, 2, 4", "B, S, 11, 100",
+			},
+		},
+		"Timer of a filled order is dropped": {
+			orders: []Order{
+				{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: BUY, ExpiresAt: time.Unix(0, 50)},
+				{Command: NEW_ORDER, UserID: 2, UserOrderID: 2, Price: intPrice(10), Quantity: 100, Side: SELL},
+				{Command: ADVANCE_CLOCK, Timestamp: time.Unix(0, 60)},
+			},
+			output: []string{"A, 1, 1", "B, B, 10, 100", "A, 2, 2", "T, 1, 1, 2, 2, 10, 100", "B, B, -, -"},
+		},
+		"Expiry already passed": {
+			orders: []Order{
+				{Command: ADVANCE_CLOCK, Timestamp: time.Unix(0, 50)},
+				{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: BUY, ExpiresAt: time.Unix(0, 50)},
+			},
+			output: []string{"R, 1, 1, ALREADY_EXPIRED"},
+		},
+	}
+
+	for name, test := range tests {
+		var buffer bytes.Buffer
+		testService := NewOrderBookService()
+		testService.IsTradingEnabled = true
+		testService.Output = &buffer
+		testService.Clock = NewSimulatedClock(time.Unix(0, 0))
+		if err := testService.ProcessOrderBook(test.orders); err != nil {
+			t.Fatalf("Expected no error, received %s for test %s", err, name)
+		}
+		output := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+		if !reflect.DeepEqual(output, test.output) {
+			t.Errorf("Expected output %v, received %v for test %s", test.output, output, name)
+		}
+	}
+}
+
+func TestExpiryCommandFormat(t *testing.T) {
+	parser := NewParserService()
+	tests := map[string]struct {
+		line     string
+		expected Order
+	}{
+		"Good till date order": {
+			line:     "N, 1, IBM, 10, 100, B, 1, 50",
+			expected: Order{Command: NEW_ORDER, UserID: 1, Symbol: "IBM", Price: intPrice(10), Quantity: 100, Side: BUY, UserOrderID: 1, ExpiresAt: time.Unix(0, 50).UTC()},
+		},
+		"Clock advance": {
+			line:     "K, 1500",
+			expected: Order{Command: ADVANCE_CLOCK, Timestamp: time.Unix(0, 1500).UTC()},
+		},
+	}
+
+	for name, test := range tests {
+		order, err := parser.ParseCommand(test.line)
+		if err != nil {
+			t.Fatalf("Expected no error, received %s for test %s", err, name)
+		}
+		if !reflect.DeepEqual(order, test.expected) {
+			t.Errorf("Expected command %v, received %v for test %s", test.expected, order, name)
+		}
+		if line := parser.FormatCommand(order); line != test.line {
+			t.Errorf("Expected line %q, received %q for test %s", test.line, line, name)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A FIX message is a run of tag=value fields, each followed by the SOH character. It starts with
+// BeginString and BodyLength, the number of bytes from the field after it up to the CheckSum, and ends
+// with CheckSum, the sum of every byte before it modulo 256 written as three digits.
+const (
+	fixBeginString   = "FIX.4.4"
+	fixSOH           = '\x01'
+	fixTimeFormat    = "20060102-15:04:05.000"
+	fixMaxBodyLength = 1 << 16
+
+	// header and trailer
+	fixTagBeginString     = 8
+	fixTagBodyLength      = 9
+	fixTagCheckSum        = 10
+	fixTagMsgType         = 35
+	fixTagSenderCompID    = 49
+	fixTagTargetCompID    = 56
+	fixTagMsgSeqNum       = 34
+	fixTagSendingTime     = 52
+	fixTagPossDupFlag     = 43
+	fixTagOrigSendingTime = 122
+
+	// session messages
+	fixTagEncryptMethod       = 98
+	fixTagTestReqID           = 112
+	fixTagHeartBtInt          = 108
+	fixTagResetSeqNumFlag     = 141
+	fixTagBeginSeqNo          = 7
+	fixTagEndSeqNo            = 16
+	fixTagNewSeqNo            = 36
+	fixTagGapFillFlag         = 123
+	fixTagText                = 58
+	fixTagRefSeqNum           = 45
+	fixTagRefTagID            = 371
+	fixTagRefMsgType          = 372
+	fixTagSessionRejectReason = 373
+
+	// orders and execution reports
+	fixTagClOrdID          = 11
+	fixTagOrigClOrdID      = 41
+	fixTagOrderID          = 37
+	fixTagExecID           = 17
+	fixTagExecType         = 150
+	fixTagOrdStatus        = 39
+	fixTagSymbol           = 55
+	fixTagSide             = 54
+	fixTagOrderQty         = 38
+	fixTagOrdType          = 40
+	fixTagPrice            = 44
+	fixTagTimeInForce      = 59
+	fixTagExpireTime       = 126
+	fixTagLastQty          = 32
+	fixTagLastPx           = 31
+	fixTagLeavesQty        = 151
+	fixTagCumQty           = 14
+	fixTagAvgPx            = 6
+	fixTagTransactTime     = 60
+	fixTagOrdRejReason     = 103
+	fixTagCxlRejReason     = 102
+	fixTagCxlRejResponseTo = 434
+
+	// message types
+	fixMsgHeartbeat                 = "0"
+	fixMsgTestRequest               = "1"
+	fixMsgResendRequest             = "2"
+	fixMsgReject                    = "3"
+	fixMsgSequenceReset             = "4"
+	fixMsgLogout                    = "5"
+	fixMsgExecutionReport           = "8"
+	fixMsgOrderCancelReject         = "9"
+	fixMsgLogon                     = "A"
+	fixMsgNewOrderSingle            = "D"
+	fixMsgOrderCancelRequest        = "F"
+	fixMsgOrderCancelReplaceRequest = "G"
+
+	// field values
+	fixYes               = "Y"
+	fixSideBuy           = "1"
+	fixSideSell          = "2"
+	fixOrdTypeLimit      = "2"
+	fixTimeInForceDay    = "0"
+	fixTimeInForceGTC    = "1"
+	fixTimeInForceGTD    = "6"
+	fixExecTypeNew       = "0"
+	fixExecTypeCanceled  = "4"
+	fixExecTypeReplaced  = "5"
+	fixExecTypeRejected  = "8"
+	fixExecTypeExpired   = "C"
+	fixExecTypeRestated  = "D"
+	fixExecTypeTrade     = "F"
+	fixStatusNew         = "0"
+	fixStatusPartial     = "1"
+	fixStatusFilled      = "2"
+	fixStatusCanceled    = "4"
+	fixStatusRejected    = "8"
+	fixStatusExpired     = "C"
+	fixCxlRejTooLate     = "0"
+	fixCxlRejUnknown     = "1"
+	fixCxlRejOther       = "2"
+	fixCxlRejDuplicate   = "6"
+	fixCxlRejForCancel   = "1"
+	fixCxlRejForReplace  = "2"
+	fixOrdRejUnknownSym  = "1"
+	fixOrdRejDuplicate   = "6"
+	fixOrdRejQuantity    = "13"
+	fixOrdRejOther       = "99"
+	fixRejectRequiredTag = "1"
+	fixRejectBadValue    = "5"
+	fixRejectBadMsgType  = "11"
+)
+
+// ErrFIXChecksum: a message arrived whole but failed its checksum, it is dropped and the stream carries on
+var ErrFIXChecksum = errors.New("FIX message failed its checksum")
+
+// NewFIXMessage: a message of msgType with the given body fields, the header is filled in when it is sent
+func NewFIXMessage(msgType string, fields ...FIXField) FIXMessage {
+	return FIXMessage{Fields: append([]FIXField{{Tag: fixTagMsgType, Value: msgType}}, fields...)}
+}
+
+// Get: the value of the first field with tag
+func (m FIXMessage) Get(tag int) (string, bool) {
+	for _, field := range m.Fields {
+		if field.Tag == tag {
+			return field.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set: replaces the value of the field with tag, or adds the field at the end
+func (m *FIXMessage) Set(tag int, value string) {
+	for i := range m.Fields {
+		if m.Fields[i].Tag == tag {
+			m.Fields[i].Value = value
+			return
+		}
+	}
+	m.Fields = append(m.Fields, FIXField{Tag: tag, Value: value})
+}
+
+// MsgType: the message type, empty when the message has none
+func (m FIXMessage) MsgType() string {
+	msgType, _ := m.Get(fixTagMsgType)
+	return msgType
+}
+
+// SeqNum: the message's sequence number, 0 when it has none that parses
+func (m FIXMessage) SeqNum() int {
+	value, _ := m.Get(fixTagMsgSeqNum)
+	seqNum, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+	return seqNum
+}
+
+// withHeader: a copy of the message with its standard header in front of the body fields. PossDupFlag and
+// OrigSendingTime carry over from the message when it has them.
+func (m FIXMessage) withHeader(senderCompID string, targetCompID string, seqNum int, sendingTime time.Time) FIXMessage {
+	header := FIXMessage{}
+	header.Set(fixTagMsgType, m.MsgType())
+	header.Set(fixTagSenderCompID, senderCompID)
+	header.Set(fixTagTargetCompID, targetCompID)
+	header.Set(fixTagMsgSeqNum, strconv.Itoa(seqNum))
+	header.Set(fixTagSendingTime, sendingTime.UTC().Format(fixTimeFormat))
+	for _, tag := range []int{fixTagPossDupFlag, fixTagOrigSendingTime} {
+		if value, found := m.Get(tag); found {
+			header.Set(tag, value)
+		}
+	}
+	for _, field := range m.Fields {
+		if !isFIXHeaderTag(field.Tag) {
+			header.Fields = append(header.Fields, field)
+		}
+	}
+	return header
+}
+
+func isFIXHeaderTag(tag int) bool {
+	switch tag {
+	case fixTagMsgType, fixTagSenderCompID, fixTagTargetCompID, fixTagMsgSeqNum, fixTagSendingTime, fixTagPossDupFlag, fixTagOrigSendingTime:
+		return true
+	}
+	return false
+}
+
+// Bytes: the message as it goes on the wire, with BeginString, BodyLength and CheckSum worked out
+func (m FIXMessage) Bytes() []byte {
+	var body bytes.Buffer
+	for _, field := range m.Fields {
+		fmt.Fprintf(&body, "%v=%v%c", field.Tag, field.Value, fixSOH)
+	}
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "%v=%v%c%v=%v%c", fixTagBeginString, fixBeginString, fixSOH, fixTagBodyLength, body.Len(), fixSOH)
+	message.Write(body.Bytes())
+	fmt.Fprintf(&message, "%v=%03d%c", fixTagCheckSum, fixChecksum(message.Bytes()), fixSOH)
+	return message.Bytes()
+}
+
+// String: the message with SOH shown as |, for logs and test failures
+func (m FIXMessage) String() string {
+	return strings.Replace(string(m.Bytes()), string(fixSOH), "|", -1)
+}
+
+func fixChecksum(data []byte) int {
+	sum := 0
+	for _, b := range data {
+		sum += int(b)
+	}
+	return sum % 256
+}
+
+// ReadFIXMessage: reads the next message from a stream. A message that fails its checksum returns
+// ErrFIXChecksum and leaves the stream at the start of the next one, any other malformed message leaves
+// no way to find the next one and the stream has to be dropped.
+func ReadFIXMessage(reader *bufio.Reader) (FIXMessage, error) {
+	var raw bytes.Buffer
+	beginString, err := readFIXField(reader, &raw)
+	if err != nil {
+		return FIXMessage{}, err
+	}
+	if beginString.Tag != fixTagBeginString || beginString.Value != fixBeginString {
+		return FIXMessage{}, errors.Errorf("expected BeginString %v, received %v=%v in ReadFIXMessage()", fixBeginString, beginString.Tag, beginString.Value)
+	}
+	bodyLength, err := readFIXField(reader, &raw)
+	if err != nil {
+		return FIXMessage{}, err
+	}
+	length, err := strconv.Atoi(bodyLength.Value)
+	if bodyLength.Tag != fixTagBodyLength || err != nil || length <= 0 || length > fixMaxBodyLength {
+		return FIXMessage{}, errors.Errorf("invalid BodyLength %v=%v in ReadFIXMessage()", bodyLength.Tag, bodyLength.Value)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return FIXMessage{}, errors.Wrap(err, "error reading message body in ReadFIXMessage()")
+	}
+	raw.Write(body)
+	checksum, err := readFIXField(reader, nil)
+	if err != nil {
+		return FIXMessage{}, err
+	}
+	if checksum.Tag != fixTagCheckSum {
+		return FIXMessage{}, errors.Errorf("expected CheckSum after the body, received %v=%v in ReadFIXMessage()", checksum.Tag, checksum.Value)
+	}
+	if value, err := strconv.Atoi(checksum.Value); err != nil || value != fixChecksum(raw.Bytes()) {
+		return FIXMessage{}, ErrFIXChecksum
+	}
+
+	var message FIXMessage
+	for len(body) > 0 {
+		end := bytes.IndexByte(body, fixSOH)
+		if end < 0 {
+			return FIXMessage{}, errors.New("body does not end with SOH in ReadFIXMessage()")
+		}
+		field, err := parseFIXField(string(body[:end]))
+		if err != nil {
+			return FIXMessage{}, err
+		}
+		message.Fields = append(message.Fields, field)
+		body = body[end+1:]
+	}
+	if message.MsgType() == "" || message.Fields[0].Tag != fixTagMsgType {
+		return FIXMessage{}, errors.New("MsgType is not the first body field in ReadFIXMessage()")
+	}
+	return message, nil
+}
+
+// ParseFIXMessage: a message from its wire format
+func ParseFIXMessage(data []byte) (FIXMessage, error) {
+	return ReadFIXMessage(bufio.NewReader(bytes.NewReader(data)))
+}
+
+// readFIXField: reads one field up to and including its SOH, copying the raw bytes to raw when it is set
+func readFIXField(reader *bufio.Reader, raw *bytes.Buffer) (FIXField, error) {
+	data, err := reader.ReadSlice(fixSOH)
+	if err == bufio.ErrBufferFull {
+		return FIXField{}, errors.New("field too long in readFIXField()")
+	}
+	if err != nil {
+		return FIXField{}, err
+	}
+	if raw != nil {
+		raw.Write(data)
+	}
+	return parseFIXField(string(data[:len(data)-1]))
+}
+
+func parseFIXField(data string) (FIXField, error) {
+	separator := strings.IndexByte(data, '=')
+	if separator <= 0 {
+		return FIXField{}, errors.Errorf("malformed field %q in parseFIXField()", data)
+	}
+	tag, err := strconv.Atoi(data[:separator])
+	if err != nil || tag <= 0 {
+		return FIXField{}, errors.Errorf("malformed tag %q in parseFIXField()", data[:separator])
+	}
+	return FIXField{Tag: tag, Value: data[separator+1:]}, nil
+}
+
+// fixTime: a UTCTimestamp, with or without milliseconds
+func fixTime(value string) (time.Time, error) {
+	for _, layout := range []string{fixTimeFormat, "20060102-15:04:05"} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("invalid UTCTimestamp %v in fixTime()", value)
+}
+
+// fixAverage: the volume weighted average of fills with a total notional value over quantity
+func fixAverage(notional float64, quantity int) string {
+	if quantity == 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(notional/float64(quantity), 'f', -1, 64)
+}
+
+// priceValue: a price as a float, for averages only, matching never leaves exact decimals
+func priceValue(price Price) float64 {
+	return float64(price.Units) / float64(pow10(price.Scale))
+}
+
+// resendMessages: what to send for a ResendRequest covering begin to end of the messages in store.
+// Application messages go out again flagged as possible duplicates, and every run of session messages or
+// of messages the store does not have is replaced by a single SequenceReset that fills the gap. Each
+// message keeps its sequence number, the header is filled in again when it is sent.
+func resendMessages(store *FIXStore, begin int, end int) []FIXMessage {
+	if end == 0 || end >= store.NextSenderSeqNum {
+		end = store.NextSenderSeqNum - 1
+	}
+	var messages []FIXMessage
+	gapStart := 0
+	fillGap := func(next int) {
+		if gapStart == 0 {
+			return
+		}
+		gapFill := NewFIXMessage(fixMsgSequenceReset, FIXField{Tag: fixTagGapFillFlag, Value: fixYes}, FIXField{Tag: fixTagNewSeqNo, Value: strconv.Itoa(next)})
+		gapFill.Set(fixTagPossDupFlag, fixYes)
+		gapFill.Set(fixTagMsgSeqNum, strconv.Itoa(gapStart))
+		messages = append(messages, gapFill)
+		gapStart = 0
+	}
+	for seqNum := begin; seqNum <= end; seqNum++ {
+		message, found := store.Message(seqNum)
+		if !found || isFIXSessionMessage(message.MsgType()) {
+			if gapStart == 0 {
+				gapStart = seqNum
+			}
+			continue
+		}
+		fillGap(seqNum)
+		if sendingTime, found := message.Get(fixTagSendingTime); found {
+			message.Set(fixTagOrigSendingTime, sendingTime)
+		}
+		message.Set(fixTagPossDupFlag, fixYes)
+		messages = append(messages, message)
+	}
+	fillGap(end + 1)
+	return messages
+}
+
+func isFIXSessionMessage(msgType string) bool {
+	switch msgType {
+	case fixMsgHeartbeat, fixMsgTestRequest, fixMsgResendRequest, fixMsgReject, fixMsgSequenceReset, fixMsgLogout, fixMsgLogon:
+		return true
+	}
+	return false
+}
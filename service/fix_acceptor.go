@@ -0,0 +1,836 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// NewFIXAcceptor: an acceptor named senderCompID for the counterparties in users, each mapped to the user
+// its orders are entered for, whose books are created by newService as their symbols first appear. Every session's sequence numbers and messages are stored
+// in storeDirectory, or in memory when it is empty, and the orders a session still had open are picked
+// up again from the ExecutionReports it was sent.
+func NewFIXAcceptor(newService func() *OrderBookService, senderCompID string, users map[string]int, storeDirectory string) (*FIXAcceptor, error) {
+	acceptor := &FIXAcceptor{
+		SenderCompID: senderCompID,
+		newService:   newService,
+		books:        make(map[string]*OrderBookService),
+		sessions:     make(map[string]*fixSession),
+		orders:       make(map[int]*fixOrder),
+		connections:  make(map[*fixConnection]bool),
+	}
+	for targetCompID, userID := range users {
+		path := ""
+		if storeDirectory != "" {
+			path = filepath.Join(storeDirectory, fmt.Sprintf("%v-%v.store", senderCompID, targetCompID))
+		}
+		store, err := OpenFIXStore(path)
+		if err != nil {
+			acceptor.closeStores()
+			return nil, errors.Wrapf(err, "error opening message store of %v in NewFIXAcceptor()", targetCompID)
+		}
+		session := &fixSession{targetCompID: targetCompID, userID: userID, store: store, clOrdIDs: make(map[string]int)}
+		acceptor.sessions[targetCompID] = session
+		for _, message := range store.Messages() {
+			if message.MsgType() == fixMsgExecutionReport {
+				acceptor.restoreOrder(session, message)
+			}
+		}
+	}
+	return acceptor, nil
+}
+
+// ListenAndServe: serves FIX connections on a TCP address until Close is called
+func (a *FIXAcceptor) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return errors.Wrapf(err, "error listening on %v in ListenAndServe()", address)
+	}
+	return a.Serve(listener)
+}
+
+// Serve: accepts FIX connections on listener until Close is called, each served on its own goroutines
+func (a *FIXAcceptor) Serve(listener net.Listener) error {
+	a.mutex.Lock()
+	if a.closed {
+		a.mutex.Unlock()
+		listener.Close()
+		return nil
+	}
+	a.listener = listener
+	a.mutex.Unlock()
+
+	for {
+		connection, err := listener.Accept()
+		if err != nil {
+			a.mutex.Lock()
+			closed := a.closed
+			a.mutex.Unlock()
+			if closed {
+				return nil
+			}
+			return errors.Wrap(err, "error accepting connection in Serve()")
+		}
+		fixConnection := &fixConnection{
+			connection:   connection,
+			outbound:     make(chan []byte, SESSION_OUTBOUND_SIZE),
+			lastReceived: time.Now().UnixNano(),
+			loggedOn:     make(chan struct{}),
+			finished:     make(chan struct{}),
+			done:         make(chan struct{}),
+		}
+		a.mutex.Lock()
+		if a.closed {
+			a.mutex.Unlock()
+			connection.Close()
+			return nil
+		}
+		a.connections[fixConnection] = true
+		a.wait.Add(2)
+		a.mutex.Unlock()
+		go a.read(fixConnection)
+		go a.write(fixConnection)
+	}
+}
+
+// Close: logs every session out, waits for the connections to end and closes the message stores
+func (a *FIXAcceptor) Close() error {
+	a.mutex.Lock()
+	a.closed = true
+	listener := a.listener
+	for connection := range a.connections {
+		if connection.session != nil {
+			a.logout(connection, "acceptor shutting down")
+		} else {
+			connection.close()
+		}
+	}
+	a.mutex.Unlock()
+
+	var err error
+	if listener != nil {
+		err = listener.Close()
+	}
+	a.wait.Wait()
+	a.closeStores()
+	return err
+}
+
+func (a *FIXAcceptor) closeStores() {
+	for _, session := range a.sessions {
+		session.store.Close()
+	}
+}
+
+// read: handles the connection's messages in the order they arrive until either side ends the session.
+// The first message has to be a Logon and arrive within FIX_LOGON_TIMEOUT.
+func (a *FIXAcceptor) read(connection *fixConnection) {
+	defer a.wait.Done()
+	defer a.disconnect(connection)
+
+	reader := bufio.NewReader(connection.connection)
+	connection.connection.SetReadDeadline(time.Now().Add(FIX_LOGON_TIMEOUT))
+	for {
+		message, err := ReadFIXMessage(reader)
+		if err == ErrFIXChecksum {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		atomic.StoreInt64(&connection.lastReceived, time.Now().UnixNano())
+		if !a.handle(connection, message) {
+			return
+		}
+	}
+}
+
+// write: writes the connection's outbound messages. Once logged on it sends a Heartbeat whenever nothing
+// went out for a whole heartbeat interval, a TestRequest when nothing came in for a little longer than
+// one, and disconnects when the TestRequest goes unanswered for another interval.
+func (a *FIXAcceptor) write(connection *fixConnection) {
+	defer a.wait.Done()
+	writer := bufio.NewWriter(connection.connection)
+	var ticks <-chan time.Time
+	var testRequestSent time.Time
+	lastSent := time.Now()
+	loggedOn := connection.loggedOn
+	for {
+		select {
+		case <-connection.done:
+			return
+		case <-connection.finished:
+			for len(connection.outbound) > 0 {
+				writer.Write(<-connection.outbound)
+			}
+			writer.Flush()
+			connection.close()
+			return
+		case <-loggedOn:
+			loggedOn = nil
+			if connection.heartbeat > 0 {
+				ticker := time.NewTicker(connection.heartbeat / 5)
+				defer ticker.Stop()
+				ticks = ticker.C
+			}
+			continue
+		case now := <-ticks:
+			lastReceived := time.Unix(0, atomic.LoadInt64(&connection.lastReceived))
+			silence := now.Sub(lastReceived)
+			switch {
+			case silence >= connection.heartbeat*12/5:
+				connection.close()
+				return
+			case silence >= connection.heartbeat*6/5 && !testRequestSent.After(lastReceived):
+				testRequestSent = now
+				a.sendSessionMessage(connection, NewFIXMessage(fixMsgTestRequest, FIXField{Tag: fixTagTestReqID, Value: strconv.FormatInt(now.UnixNano(), 10)}))
+			case now.Sub(lastSent) >= connection.heartbeat:
+				a.sendSessionMessage(connection, NewFIXMessage(fixMsgHeartbeat))
+			}
+			continue
+		case data := <-connection.outbound:
+			writer.Write(data)
+			lastSent = time.Now()
+		}
+		// messages that queued up together go out in one write
+		if len(connection.outbound) > 0 {
+			continue
+		}
+		if err := writer.Flush(); err != nil {
+			connection.close()
+			return
+		}
+	}
+}
+
+// sendSessionMessage: sends a message of the writer's own, such as a Heartbeat
+func (a *FIXAcceptor) sendSessionMessage(connection *fixConnection, message FIXMessage) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if connection.session != nil && connection.session.connection == connection {
+		if err := a.send(connection.session, message); err != nil {
+			connection.close()
+		}
+	}
+}
+
+// disconnect: ends the connection and logs its session out
+func (a *FIXAcceptor) disconnect(connection *fixConnection) {
+	connection.finish()
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.connections, connection)
+	if connection.session != nil && connection.session.connection == connection {
+		connection.session.connection = nil
+	}
+}
+
+// send: numbers a message, stores it for resending and queues it for the session's connection, if it is
+// logged on. ExecutionReports are given the sequence number they go out under as their ExecID.
+func (a *FIXAcceptor) send(session *fixSession, message FIXMessage) error {
+	seqNum := session.store.NextSenderSeqNum
+	if message.MsgType() == fixMsgExecutionReport {
+		message.Set(fixTagExecID, strconv.Itoa(seqNum))
+	}
+	message = message.withHeader(a.SenderCompID, session.targetCompID, seqNum, time.Now())
+	if err := session.store.SaveMessage(message); err != nil {
+		return errors.Wrap(err, "error storing message in send()")
+	}
+	if session.connection != nil {
+		session.connection.queue(message.Bytes())
+	}
+	return nil
+}
+
+// handle: processes one inbound message, false ends the connection
+func (a *FIXAcceptor) handle(connection *fixConnection, message FIXMessage) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if connection.session == nil {
+		return a.logon(connection, message)
+	}
+	session := connection.session
+	sender, _ := message.Get(fixTagSenderCompID)
+	target, _ := message.Get(fixTagTargetCompID)
+	if sender != session.targetCompID || target != a.SenderCompID {
+		a.logout(connection, "CompID problem")
+		return false
+	}
+	seqNum := message.SeqNum()
+	if seqNum <= 0 {
+		a.logout(connection, "MsgSeqNum missing")
+		return false
+	}
+	msgType := message.MsgType()
+	if gapFill, _ := message.Get(fixTagGapFillFlag); msgType == fixMsgSequenceReset && gapFill != fixYes {
+		return a.sequenceReset(connection, message)
+	}
+
+	expected := session.store.NextTargetSeqNum
+	if seqNum > expected {
+		// messages past a gap are dropped and sent again once the gap is filled, except that a resend is
+		// answered straight away so both sides can fill their gaps at once
+		if msgType == fixMsgResendRequest {
+			a.resend(connection, message)
+		}
+		if expected > session.resendUntil {
+			if err := a.requestResend(session, expected, seqNum); err != nil {
+				return false
+			}
+		}
+		return true
+	}
+	if seqNum < expected {
+		if possDup, _ := message.Get(fixTagPossDupFlag); possDup == fixYes {
+			return true
+		}
+		a.logout(connection, fmt.Sprintf("MsgSeqNum too low, expecting %v but received %v", expected, seqNum))
+		return false
+	}
+
+	next := seqNum + 1
+	if msgType == fixMsgSequenceReset {
+		newSeqNo, err := strconv.Atoi(getFIX(message, fixTagNewSeqNo))
+		if err != nil || newSeqNo <= seqNum {
+			return a.rejectMessage(session, message, fixTagNewSeqNo, fixRejectBadValue, "NewSeqNo must move the sequence forward") == nil
+		}
+		next = newSeqNo
+	}
+	if err := session.store.SetNextTargetSeqNum(next); err != nil {
+		return false
+	}
+
+	var err error
+	switch msgType {
+	case fixMsgHeartbeat, fixMsgReject, fixMsgSequenceReset:
+	case fixMsgTestRequest:
+		err = a.send(session, NewFIXMessage(fixMsgHeartbeat, FIXField{Tag: fixTagTestReqID, Value: getFIX(message, fixTagTestReqID)}))
+	case fixMsgResendRequest:
+		a.resend(connection, message)
+	case fixMsgLogout:
+		if !connection.isLoggingOut {
+			a.logout(connection, "")
+		}
+		return false
+	case fixMsgLogon:
+		err = a.rejectMessage(session, message, fixTagMsgType, fixRejectBadValue, "already logged on")
+	case fixMsgNewOrderSingle:
+		err = a.newOrderSingle(session, message)
+	case fixMsgOrderCancelRequest, fixMsgOrderCancelReplaceRequest:
+		err = a.cancelOrReplace(session, message)
+	default:
+		err = a.rejectMessage(session, message, fixTagMsgType, fixRejectBadMsgType, "unsupported MsgType")
+	}
+	return err == nil
+}
+
+// logon: accepts the Logon that opens a connection. A connection from an unknown counterparty, or for a
+// session that is already logged on, is dropped without a word, one whose sequence number is lower than
+// expected is logged out.
+func (a *FIXAcceptor) logon(connection *fixConnection, message FIXMessage) bool {
+	sender, _ := message.Get(fixTagSenderCompID)
+	target, _ := message.Get(fixTagTargetCompID)
+	session, found := a.sessions[sender]
+	if message.MsgType() != fixMsgLogon || !found || target != a.SenderCompID || session.connection != nil {
+		return false
+	}
+	heartBtInt, err := strconv.Atoi(getFIX(message, fixTagHeartBtInt))
+	if err != nil || heartBtInt < 0 {
+		return false
+	}
+	reset := getFIX(message, fixTagResetSeqNumFlag) == fixYes
+	if reset {
+		if err := session.store.Reset(); err != nil {
+			return false
+		}
+		session.resendUntil = 0
+	}
+
+	session.connection = connection
+	connection.session = session
+	connection.heartbeat = time.Duration(heartBtInt) * time.Second
+	seqNum := message.SeqNum()
+	expected := session.store.NextTargetSeqNum
+	if seqNum < expected {
+		a.logout(connection, fmt.Sprintf("MsgSeqNum too low, expecting %v but received %v", expected, seqNum))
+		return false
+	}
+	response := NewFIXMessage(fixMsgLogon, FIXField{Tag: fixTagEncryptMethod, Value: "0"}, FIXField{Tag: fixTagHeartBtInt, Value: strconv.Itoa(heartBtInt)})
+	if reset {
+		response.Set(fixTagResetSeqNumFlag, fixYes)
+	}
+	if err := a.send(session, response); err != nil {
+		return false
+	}
+	if seqNum > expected {
+		err = a.requestResend(session, expected, seqNum)
+	} else {
+		err = session.store.SetNextTargetSeqNum(seqNum + 1)
+	}
+	if err != nil {
+		return false
+	}
+	connection.connection.SetReadDeadline(time.Time{})
+	close(connection.loggedOn)
+	return true
+}
+
+// logout: sends a Logout and disconnects once everything queued has been written
+func (a *FIXAcceptor) logout(connection *fixConnection, text string) {
+	logout := NewFIXMessage(fixMsgLogout)
+	if text != "" {
+		logout.Set(fixTagText, text)
+	}
+	connection.isLoggingOut = true
+	a.send(connection.session, logout)
+	connection.finish()
+}
+
+// requestResend: asks the counterparty for everything from begin, seqNum is the message that showed the gap
+func (a *FIXAcceptor) requestResend(session *fixSession, begin int, seqNum int) error {
+	session.resendUntil = seqNum
+	return a.send(session, NewFIXMessage(fixMsgResendRequest, FIXField{Tag: fixTagBeginSeqNo, Value: strconv.Itoa(begin)}, FIXField{Tag: fixTagEndSeqNo, Value: "0"}))
+}
+
+// resend: answers a ResendRequest from the store, the messages keep their sequence numbers and are not
+// stored again
+func (a *FIXAcceptor) resend(connection *fixConnection, request FIXMessage) {
+	begin, err := strconv.Atoi(getFIX(request, fixTagBeginSeqNo))
+	if err != nil || begin <= 0 {
+		begin = 1
+	}
+	end, err := strconv.Atoi(getFIX(request, fixTagEndSeqNo))
+	if err != nil {
+		end = 0
+	}
+	session := connection.session
+	for _, message := range resendMessages(session.store, begin, end) {
+		connection.queue(message.withHeader(a.SenderCompID, session.targetCompID, message.SeqNum(), time.Now()).Bytes())
+	}
+}
+
+// sequenceReset: a SequenceReset without GapFillFlag moves the expected sequence number whatever the
+// message's own, but never backwards
+func (a *FIXAcceptor) sequenceReset(connection *fixConnection, message FIXMessage) bool {
+	session := connection.session
+	newSeqNo, err := strconv.Atoi(getFIX(message, fixTagNewSeqNo))
+	if err != nil || newSeqNo < session.store.NextTargetSeqNum {
+		return a.rejectMessage(session, message, fixTagNewSeqNo, fixRejectBadValue, "NewSeqNo must not move the sequence backwards") == nil
+	}
+	return session.store.SetNextTargetSeqNum(newSeqNo) == nil
+}
+
+// rejectMessage: a session level Reject of a message that cannot be processed as it is
+func (a *FIXAcceptor) rejectMessage(session *fixSession, message FIXMessage, tag int, reason string, text string) error {
+	reject := NewFIXMessage(fixMsgReject,
+		FIXField{Tag: fixTagRefSeqNum, Value: strconv.Itoa(message.SeqNum())},
+		FIXField{Tag: fixTagRefTagID, Value: strconv.Itoa(tag)},
+		FIXField{Tag: fixTagRefMsgType, Value: message.MsgType()},
+		FIXField{Tag: fixTagSessionRejectReason, Value: reason},
+		FIXField{Tag: fixTagText, Value: text},
+	)
+	return a.send(session, reject)
+}
+
+// missingField: the first of tags the message does not have, 0 when it has them all
+func missingField(message FIXMessage, tags ...int) int {
+	for _, tag := range tags {
+		if value, found := message.Get(tag); !found || value == "" {
+			return tag
+		}
+	}
+	return 0
+}
+
+func getFIX(message FIXMessage, tag int) string {
+	value, _ := message.Get(tag)
+	return value
+}
+
+// newOrderSingle: enters a limit order for the session's user. Day orders rest until they are cancelled
+// like good-till-cancel ones, there is no end of day to take them out.
+func (a *FIXAcceptor) newOrderSingle(session *fixSession, message FIXMessage) error {
+	if tag := missingField(message, fixTagClOrdID, fixTagSymbol, fixTagSide, fixTagOrderQty, fixTagOrdType); tag != 0 {
+		return a.rejectMessage(session, message, tag, fixRejectRequiredTag, "required tag missing")
+	}
+	order := &fixOrder{clOrdID: getFIX(message, fixTagClOrdID), targetCompID: session.targetCompID, symbol: getFIX(message, fixTagSymbol)}
+	if tag, reason := parseFIXOrder(message, order); tag != 0 {
+		return a.rejectMessage(session, message, tag, fixRejectBadValue, reason)
+	}
+
+	reason, ordRejReason := "", fixOrdRejOther
+	var expiresAt time.Time
+	switch timeInForce := getFIX(message, fixTagTimeInForce); timeInForce {
+	case "", fixTimeInForceDay, fixTimeInForceGTC:
+	case fixTimeInForceGTD:
+		value, found := message.Get(fixTagExpireTime)
+		if !found {
+			return a.rejectMessage(session, message, fixTagExpireTime, fixRejectRequiredTag, "ExpireTime is required for GTD")
+		}
+		parsed, err := fixTime(value)
+		if err != nil {
+			return a.rejectMessage(session, message, fixTagExpireTime, fixRejectBadValue, "ExpireTime is not a UTCTimestamp")
+		}
+		expiresAt = parsed
+	default:
+		reason = "unsupported TimeInForce " + timeInForce
+	}
+	// turned away before the order takes a user order id or its ClOrdID, so the ClOrdID can be used again.
+	// The price is left to the engine, 0 is a limit price like any other.
+	if order.orderQuantity <= 0 {
+		reason, ordRejReason = "OrderQty must be positive", fixOrdRejQuantity
+	}
+	if ordType := getFIX(message, fixTagOrdType); ordType != fixOrdTypeLimit {
+		reason = "unsupported OrdType " + ordType
+	}
+	if _, found := session.clOrdIDs[order.clOrdID]; found {
+		reason, ordRejReason = "duplicate ClOrdID", fixOrdRejDuplicate
+	}
+	if reason != "" {
+		return a.send(session, a.rejectReport(order, ordRejReason, reason, time.Now()))
+	}
+
+	order.userOrderID = a.allocateUserOrderID()
+	session.clOrdIDs[order.clOrdID] = order.userOrderID
+	a.orders[order.userOrderID] = order
+	events, err := a.book(order.symbol).Submit(Order{
+		UserID:      session.userID,
+		UserOrderID: order.userOrderID,
+		Symbol:      order.symbol,
+		Price:       order.price,
+		Quantity:    order.orderQuantity,
+		Side:        order.side,
+		ExpiresAt:   expiresAt,
+	})
+	if err != nil {
+		return errors.Wrap(err, "error submitting order in newOrderSingle()")
+	}
+	return a.dispatchEvents(events)
+}
+
+// parseFIXOrder: fills in the side, quantity and price of an order, or names the field that is invalid
+func parseFIXOrder(message FIXMessage, order *fixOrder) (int, string) {
+	switch getFIX(message, fixTagSide) {
+	case fixSideBuy:
+		order.side = BUY
+	case fixSideSell:
+		order.side = SELL
+	default:
+		return fixTagSide, "Side must be buy or sell"
+	}
+	quantity, err := strconv.Atoi(getFIX(message, fixTagOrderQty))
+	if err != nil {
+		return fixTagOrderQty, "OrderQty is not a whole number"
+	}
+	order.orderQuantity = quantity
+	if value, found := message.Get(fixTagPrice); found {
+		price, err := ParsePrice(value)
+		if err != nil {
+			return fixTagPrice, "Price is not a decimal"
+		}
+		order.price = price
+	} else if getFIX(message, fixTagOrdType) == fixOrdTypeLimit {
+		return fixTagPrice, "Price is required for limit orders"
+	}
+	return 0, ""
+}
+
+// cancelOrReplace: cancels or amends the order a session knows by OrigClOrdID. The order keeps its time
+// priority when a replace only takes quantity off.
+func (a *FIXAcceptor) cancelOrReplace(session *fixSession, message FIXMessage) error {
+	isReplace := message.MsgType() == fixMsgOrderCancelReplaceRequest
+	required := []int{fixTagOrigClOrdID, fixTagClOrdID, fixTagSymbol, fixTagSide}
+	if isReplace {
+		required = append(required, fixTagOrderQty, fixTagOrdType)
+	}
+	if tag := missingField(message, required...); tag != 0 {
+		return a.rejectMessage(session, message, tag, fixRejectRequiredTag, "required tag missing")
+	}
+	request := fixOrder{clOrdID: getFIX(message, fixTagClOrdID), origClOrdID: getFIX(message, fixTagOrigClOrdID)}
+	if isReplace {
+		if tag, reason := parseFIXOrder(message, &request); tag != 0 {
+			return a.rejectMessage(session, message, tag, fixRejectBadValue, reason)
+		}
+	}
+
+	userOrderID, known := session.clOrdIDs[request.origClOrdID]
+	order, isOpen := a.orders[userOrderID]
+	reason, cxlRejReason := "", fixCxlRejOther
+	switch {
+	case !known:
+		reason, cxlRejReason = "unknown order", fixCxlRejUnknown
+	case !isOpen:
+		reason, cxlRejReason = "order is no longer open", fixCxlRejTooLate
+	case isReplace && getFIX(message, fixTagOrdType) != fixOrdTypeLimit:
+		reason = "unsupported OrdType " + getFIX(message, fixTagOrdType)
+	case isReplace && request.orderQuantity <= order.cumulativeQuantity:
+		reason = "OrderQty must be more than CumQty"
+	}
+	if _, found := session.clOrdIDs[request.clOrdID]; found {
+		reason, cxlRejReason = "duplicate ClOrdID", fixCxlRejDuplicate
+	}
+	if reason != "" {
+		if !isOpen {
+			order = &fixOrder{userOrderID: userOrderID, clOrdID: request.origClOrdID}
+		}
+		return a.send(session, a.cancelRejectReport(order, request.clOrdID, isReplace, cxlRejReason, reason))
+	}
+
+	session.clOrdIDs[request.clOrdID] = userOrderID
+	order.pending = &request
+	defer func() { order.pending = nil }()
+	var events []Event
+	var err error
+	if isReplace {
+		events, err = a.book(order.symbol).Amend(session.userID, userOrderID, request.price, request.orderQuantity-order.cumulativeQuantity)
+	} else {
+		events, err = a.book(order.symbol).Cancel(session.userID, userOrderID)
+	}
+	if err != nil {
+		return errors.Wrap(err, "error applying request in cancelOrReplace()")
+	}
+	return a.dispatchEvents(events)
+}
+
+// allocateUserOrderID: the engine knows orders by a single number, the acceptor hands them out itself so
+// every session can pick its ClOrdIDs freely. An id is not used while it rests in any book.
+func (a *FIXAcceptor) allocateUserOrderID() int {
+	for {
+		a.nextUserOrderID++
+		isTaken := false
+		for _, book := range a.books {
+			if _, found := book.Order(a.nextUserOrderID); found {
+				isTaken = true
+				break
+			}
+		}
+		if !isTaken {
+			return a.nextUserOrderID
+		}
+	}
+}
+
+// book: the symbol's book, created when it is first needed. The caller holds the mutex.
+func (a *FIXAcceptor) book(symbol string) *OrderBookService {
+	service, found := a.books[symbol]
+	if !found {
+		service = a.newService()
+		a.books[symbol] = service
+	}
+	return service
+}
+
+// dispatchEvents: sends the ExecutionReports for the events about orders entered through the acceptor to
+// the sessions that entered them, whoever caused the event
+func (a *FIXAcceptor) dispatchEvents(events []Event) error {
+	for _, event := range events {
+		switch event.Type {
+		case EVENT_ACK, EVENT_REJECT, EVENT_SELF_TRADE, EVENT_EXPIRE:
+			if err := a.report(event, event.Order); err != nil {
+				return err
+			}
+		case EVENT_TRADE:
+			if err := a.report(event, event.Bid); err != nil {
+				return err
+			}
+			if err := a.report(event, event.Ask); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// report: the ExecutionReport, or OrderCancelReject, an event makes for one order
+func (a *FIXAcceptor) report(event Event, engineOrder Order) error {
+	order, found := a.orders[engineOrder.UserOrderID]
+	if !found {
+		return nil
+	}
+	session := a.sessions[order.targetCompID]
+	leaves := engineOrder.Quantity
+	var report FIXMessage
+	switch {
+	case event.Type == EVENT_ACK && event.Command == CANCEL_ORDER:
+		order.replaceClOrdID()
+		report = a.executionReport(order, fixExecTypeCanceled, fixStatusCanceled, 0, event.Timestamp)
+		delete(a.orders, order.userOrderID)
+	case event.Type == EVENT_ACK && event.Command == AMEND_ORDER:
+		order.replaceClOrdID()
+		order.price = order.pending.price
+		order.orderQuantity = order.pending.orderQuantity
+		report = a.executionReport(order, fixExecTypeReplaced, order.status(leaves), leaves, event.Timestamp)
+		order.origClOrdID = ""
+		// the replace is done, anything that happens to the order from here on is reported as for any other
+		order.pending = nil
+	case event.Type == EVENT_ACK:
+		report = a.executionReport(order, fixExecTypeNew, fixStatusNew, leaves, event.Timestamp)
+	case event.Type == EVENT_REJECT && order.pending != nil:
+		isReplace := event.Command == AMEND_ORDER
+		report = a.cancelRejectReport(order, order.pending.clOrdID, isReplace, fixCxlRejOther, event.Reason)
+	case event.Type == EVENT_REJECT && order.cumulativeQuantity > 0:
+		// the circuit breaker turned the order away after it had traded, what was left of it is cancelled
+		report = a.executionReport(order, fixExecTypeCanceled, fixStatusCanceled, 0, event.Timestamp)
+		delete(a.orders, order.userOrderID)
+	case event.Type == EVENT_REJECT:
+		ordRejReason := fixOrdRejOther
+		if event.Reason == UNKNOWN_SYMBOL {
+			ordRejReason = fixOrdRejUnknownSym
+		}
+		report = a.rejectReport(order, ordRejReason, event.Reason, event.Timestamp)
+		delete(a.orders, order.userOrderID)
+	case event.Type == EVENT_TRADE:
+		order.cumulativeQuantity += event.Quantity
+		order.notional += priceValue(event.Price) * float64(event.Quantity)
+		report = a.executionReport(order, fixExecTypeTrade, order.status(leaves), leaves, event.Timestamp)
+		report.Set(fixTagLastPx, event.Price.String())
+		report.Set(fixTagLastQty, strconv.Itoa(event.Quantity))
+		if leaves == 0 {
+			delete(a.orders, order.userOrderID)
+		}
+	case event.Type == EVENT_SELF_TRADE && leaves > 0:
+		// self trade prevention took some quantity off, the order carries on smaller
+		order.orderQuantity = order.cumulativeQuantity + leaves
+		report = a.executionReport(order, fixExecTypeRestated, order.status(leaves), leaves, event.Timestamp)
+		report.Set(fixTagText, event.Reason)
+	case event.Type == EVENT_SELF_TRADE:
+		report = a.executionReport(order, fixExecTypeCanceled, fixStatusCanceled, 0, event.Timestamp)
+		report.Set(fixTagText, event.Reason)
+		delete(a.orders, order.userOrderID)
+	case event.Type == EVENT_EXPIRE:
+		report = a.executionReport(order, fixExecTypeExpired, fixStatusExpired, 0, event.Timestamp)
+		delete(a.orders, order.userOrderID)
+	}
+	return a.send(session, report)
+}
+
+// replaceClOrdID: the order is known by the ClOrdID of the request that was just accepted from now on
+func (o *fixOrder) replaceClOrdID() {
+	o.origClOrdID = o.clOrdID
+	o.clOrdID = o.pending.clOrdID
+}
+
+// status: OrdStatus of a working order with leaves left
+func (o *fixOrder) status(leaves int) string {
+	if leaves == 0 {
+		return fixStatusFilled
+	}
+	if o.cumulativeQuantity > 0 {
+		return fixStatusPartial
+	}
+	return fixStatusNew
+}
+
+func (a *FIXAcceptor) executionReport(order *fixOrder, execType string, status string, leaves int, transactTime time.Time) FIXMessage {
+	report := NewFIXMessage(fixMsgExecutionReport,
+		FIXField{Tag: fixTagOrderID, Value: order.orderID()},
+		FIXField{Tag: fixTagClOrdID, Value: order.clOrdID},
+	)
+	if order.origClOrdID != "" && (execType == fixExecTypeCanceled || execType == fixExecTypeReplaced) {
+		report.Set(fixTagOrigClOrdID, order.origClOrdID)
+	}
+	side := fixSideBuy
+	if order.side == SELL {
+		side = fixSideSell
+	}
+	report.Fields = append(report.Fields,
+		FIXField{Tag: fixTagExecID, Value: ""},
+		FIXField{Tag: fixTagExecType, Value: execType},
+		FIXField{Tag: fixTagOrdStatus, Value: status},
+		FIXField{Tag: fixTagSymbol, Value: order.symbol},
+		FIXField{Tag: fixTagSide, Value: side},
+		FIXField{Tag: fixTagOrderQty, Value: strconv.Itoa(order.orderQuantity)},
+		FIXField{Tag: fixTagPrice, Value: order.price.String()},
+		FIXField{Tag: fixTagLeavesQty, Value: strconv.Itoa(leaves)},
+		FIXField{Tag: fixTagCumQty, Value: strconv.Itoa(order.cumulativeQuantity)},
+		FIXField{Tag: fixTagAvgPx, Value: fixAverage(order.notional, order.cumulativeQuantity)},
+		FIXField{Tag: fixTagTransactTime, Value: transactTime.UTC().Format(fixTimeFormat)},
+	)
+	return report
+}
+
+func (a *FIXAcceptor) rejectReport(order *fixOrder, ordRejReason string, text string, transactTime time.Time) FIXMessage {
+	report := a.executionReport(order, fixExecTypeRejected, fixStatusRejected, 0, transactTime)
+	report.Set(fixTagOrdRejReason, ordRejReason)
+	report.Set(fixTagText, text)
+	return report
+}
+
+func (a *FIXAcceptor) cancelRejectReport(order *fixOrder, clOrdID string, isReplace bool, cxlRejReason string, text string) FIXMessage {
+	status := fixStatusRejected
+	if _, isOpen := a.orders[order.userOrderID]; isOpen {
+		status = order.status(order.orderQuantity - order.cumulativeQuantity)
+	}
+	responseTo := fixCxlRejForCancel
+	if isReplace {
+		responseTo = fixCxlRejForReplace
+	}
+	return NewFIXMessage(fixMsgOrderCancelReject,
+		FIXField{Tag: fixTagOrderID, Value: order.orderID()},
+		FIXField{Tag: fixTagClOrdID, Value: clOrdID},
+		FIXField{Tag: fixTagOrigClOrdID, Value: order.clOrdID},
+		FIXField{Tag: fixTagOrdStatus, Value: status},
+		FIXField{Tag: fixTagCxlRejResponseTo, Value: responseTo},
+		FIXField{Tag: fixTagCxlRejReason, Value: cxlRejReason},
+		FIXField{Tag: fixTagText, Value: text},
+	)
+}
+
+// orderID: the engine's user order id, NONE for orders that never reached the engine
+func (o *fixOrder) orderID() string {
+	if o.userOrderID == 0 {
+		return "NONE"
+	}
+	return strconv.Itoa(o.userOrderID)
+}
+
+// restoreOrder: picks up what a stored ExecutionReport says about an order when the acceptor starts
+func (a *FIXAcceptor) restoreOrder(session *fixSession, report FIXMessage) {
+	userOrderID, err := strconv.Atoi(getFIX(report, fixTagOrderID))
+	if err != nil {
+		return
+	}
+	session.clOrdIDs[getFIX(report, fixTagClOrdID)] = userOrderID
+	if userOrderID > a.nextUserOrderID {
+		a.nextUserOrderID = userOrderID
+	}
+	switch getFIX(report, fixTagOrdStatus) {
+	case fixStatusFilled, fixStatusCanceled, fixStatusRejected, fixStatusExpired:
+		delete(a.orders, userOrderID)
+		return
+	}
+	order := &fixOrder{userOrderID: userOrderID, targetCompID: session.targetCompID, clOrdID: getFIX(report, fixTagClOrdID), symbol: getFIX(report, fixTagSymbol)}
+	parseFIXOrder(report, order)
+	order.cumulativeQuantity, _ = strconv.Atoi(getFIX(report, fixTagCumQty))
+	averagePrice, _ := strconv.ParseFloat(getFIX(report, fixTagAvgPx), 64)
+	order.notional = averagePrice * float64(order.cumulativeQuantity)
+	a.orders[userOrderID] = order
+}
+
+// queue: hands a message to the writer, a counterparty too slow to keep up is disconnected rather than
+// holding everyone else up, what it missed is in the store for when it logs on again
+func (c *fixConnection) queue(data []byte) {
+	select {
+	case <-c.done:
+	case c.outbound <- data:
+	default:
+		c.close()
+	}
+}
+
+func (c *fixConnection) finish() {
+	c.finishOnce.Do(func() { close(c.finished) })
+}
+
+func (c *fixConnection) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.connection.Close()
+	})
+}
@@ -0,0 +1,310 @@
+package service
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+const fixTestTimeout = 2 * time.Second
+
+func startFIXAcceptor(t *testing.T, storeDirectory string) (*FIXAcceptor, string) {
+	return startFIXAcceptorWith(t, storeDirectory, CircuitBreaker{})
+}
+
+func startFIXAcceptorWith(t *testing.T, storeDirectory string, breaker CircuitBreaker) (*FIXAcceptor, string) {
+	acceptor, err := NewFIXAcceptor(func() *OrderBookService {
+		testService := NewOrderBookService()
+		testService.IsTradingEnabled = true
+		testService.CircuitBreaker = breaker
+		testService.Output = ioutil.Discard
+		return testService
+	}, "ORDERBOOK", map[string]int{"BUYER": 1, "SELLER": 2}, storeDirectory)
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	go acceptor.Serve(listener)
+	return acceptor, listener.Addr().String()
+}
+
+func logOnFIXClient(t *testing.T, address string, senderCompID string, store *FIXStore, reset bool) *FIXClient {
+	client, err := DialFIXClient(address, senderCompID, "ORDERBOOK", store)
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	if _, err := client.Logon(30, reset, fixTestTimeout); err != nil {
+		t.Fatalf("Expected no error logging on %v, received %s", senderCompID, err)
+	}
+	return client
+}
+
+// expectFIX: receives the next message and checks the fields given, name says what is being tested
+func expectFIX(t *testing.T, client *FIXClient, name string, fields map[int]string) FIXMessage {
+	message, err := client.Receive(fixTestTimeout)
+	if err != nil {
+		t.Fatalf("Expected a message, received %s for test %s", err, name)
+	}
+	for tag, value := range fields {
+		if received, _ := message.Get(tag); received != value {
+			t.Fatalf("Expected %v=%v, received %v for test %s", tag, value, message, name)
+		}
+	}
+	return message
+}
+
+func newOrderSingle(clOrdID string, side string, quantity string, price string) FIXMessage {
+	return NewFIXMessage(fixMsgNewOrderSingle,
+		FIXField{Tag: fixTagClOrdID, Value: clOrdID},
+		FIXField{Tag: fixTagSymbol, Value: "IBM"},
+		FIXField{Tag: fixTagSide, Value: side},
+		FIXField{Tag: fixTagOrderQty, Value: quantity},
+		FIXField{Tag: fixTagOrdType, Value: fixOrdTypeLimit},
+		FIXField{Tag: fixTagPrice, Value: price},
+	)
+}
+
+func cancelRequest(msgType string, origClOrdID string, clOrdID string, side string, fields ...FIXField) FIXMessage {
+	return NewFIXMessage(msgType, append([]FIXField{
+		{Tag: fixTagOrigClOrdID, Value: origClOrdID},
+		{Tag: fixTagClOrdID, Value: clOrdID},
+		{Tag: fixTagSymbol, Value: "IBM"},
+		{Tag: fixTagSide, Value: side},
+	}, fields...)...)
+}
+
+func TestFIXOrderEntry(t *testing.T) {
+	acceptor, address := startFIXAcceptor(t, "")
+	defer acceptor.Close()
+	buyer := logOnFIXClient(t, address, "BUYER", nil, false)
+	seller := logOnFIXClient(t, address, "SELLER", nil, false)
+
+	buyer.Send(newOrderSingle("b1", fixSideBuy, "100", "10"))
+	expectFIX(t, buyer, "New order", map[int]string{fixTagClOrdID: "b1", fixTagOrderID: "1", fixTagExecType: fixExecTypeNew, fixTagOrdStatus: fixStatusNew, fixTagLeavesQty: "100"})
+
+	seller.Send(newOrderSingle("s1", fixSideSell, "40", "10"))
+	expectFIX(t, seller, "Aggressor acknowledged", map[int]string{fixTagClOrdID: "s1", fixTagExecType: fixExecTypeNew})
+	expectFIX(t, seller, "Aggressor filled", map[int]string{fixTagClOrdID: "s1", fixTagExecType: fixExecTypeTrade, fixTagOrdStatus: fixStatusFilled, fixTagLastQty: "40", fixTagLastPx: "10", fixTagCumQty: "40", fixTagLeavesQty: "0", fixTagAvgPx: "10"})
+	expectFIX(t, buyer, "Resting order partly filled", map[int]string{fixTagClOrdID: "b1", fixTagExecType: fixExecTypeTrade, fixTagOrdStatus: fixStatusPartial, fixTagCumQty: "40", fixTagLeavesQty: "60"})
+
+	buyer.Send(cancelRequest(fixMsgOrderCancelReplaceRequest, "b1", "b2", fixSideBuy,
+		FIXField{Tag: fixTagOrderQty, Value: "70"}, FIXField{Tag: fixTagOrdType, Value: fixOrdTypeLimit}, FIXField{Tag: fixTagPrice, Value: "10"}))
+	expectFIX(t, buyer, "Replace takes quantity off", map[int]string{fixTagClOrdID: "b2", fixTagOrigClOrdID: "b1", fixTagExecType: fixExecTypeReplaced, fixTagOrdStatus: fixStatusPartial, fixTagOrderQty: "70", fixTagCumQty: "40", fixTagLeavesQty: "30"})
+
+	buyer.Send(cancelRequest(fixMsgOrderCancelRequest, "b2", "b1", fixSideBuy))
+	expectFIX(t, buyer, "ClOrdID used before", map[int]string{fixTagMsgType: fixMsgOrderCancelReject, fixTagCxlRejReason: fixCxlRejDuplicate})
+	buyer.Send(cancelRequest(fixMsgOrderCancelRequest, "b2", "b4", fixSideBuy))
+	expectFIX(t, buyer, "Cancel", map[int]string{fixTagClOrdID: "b4", fixTagOrigClOrdID: "b2", fixTagExecType: fixExecTypeCanceled, fixTagOrdStatus: fixStatusCanceled, fixTagLeavesQty: "0"})
+	buyer.Send(cancelRequest(fixMsgOrderCancelRequest, "b4", "b5", fixSideBuy))
+	expectFIX(t, buyer, "Cancel of a cancelled order", map[int]string{fixTagMsgType: fixMsgOrderCancelReject, fixTagCxlRejReason: fixCxlRejTooLate, fixTagCxlRejResponseTo: fixCxlRejForCancel})
+	seller.Send(cancelRequest(fixMsgOrderCancelRequest, "b2", "s2", fixSideBuy))
+	expectFIX(t, seller, "Another session's order", map[int]string{fixTagMsgType: fixMsgOrderCancelReject, fixTagCxlRejReason: fixCxlRejUnknown})
+
+	seller.Send(newOrderSingle("s1", fixSideSell, "10", "11"))
+	expectFIX(t, seller, "Duplicate ClOrdID", map[int]string{fixTagExecType: fixExecTypeRejected, fixTagOrdRejReason: fixOrdRejDuplicate, fixTagOrderID: "NONE"})
+	market := newOrderSingle("s3", fixSideSell, "10", "11")
+	market.Set(fixTagOrdType, "1")
+	seller.Send(market)
+	expectFIX(t, seller, "Market orders are not supported", map[int]string{fixTagExecType: fixExecTypeRejected, fixTagText: "unsupported OrdType 1"})
+	seller.Send(newOrderSingle("s4", "7", "10", "11"))
+	expectFIX(t, seller, "Invalid side", map[int]string{fixTagMsgType: fixMsgReject, fixTagRefTagID: "54", fixTagSessionRejectReason: fixRejectBadValue})
+	seller.Send(NewFIXMessage(fixMsgNewOrderSingle, FIXField{Tag: fixTagClOrdID, Value: "s5"}))
+	expectFIX(t, seller, "Missing symbol", map[int]string{fixTagMsgType: fixMsgReject, fixTagRefTagID: "55", fixTagSessionRejectReason: fixRejectRequiredTag})
+	seller.Send(NewFIXMessage("AE"))
+	expectFIX(t, seller, "Unsupported message", map[int]string{fixTagMsgType: fixMsgReject, fixTagSessionRejectReason: fixRejectBadMsgType})
+	seller.Send(newOrderSingle("s6", fixSideSell, "0", "11"))
+	expectFIX(t, seller, "No quantity", map[int]string{fixTagExecType: fixExecTypeRejected, fixTagOrdStatus: fixStatusRejected, fixTagOrdRejReason: fixOrdRejQuantity, fixTagOrderID: "NONE"})
+	seller.Send(newOrderSingle("s6", fixSideSell, "-5", "11"))
+	expectFIX(t, seller, "Negative quantity", map[int]string{fixTagExecType: fixExecTypeRejected, fixTagOrdRejReason: fixOrdRejQuantity, fixTagOrderID: "NONE"})
+	seller.Send(newOrderSingle("s6", fixSideSell, "10", "0"))
+	expectFIX(t, seller, "Rejected orders took no id or ClOrdID, zero price", map[int]string{fixTagClOrdID: "s6", fixTagOrderID: "3", fixTagExecType: fixExecTypeNew})
+	seller.Send(cancelRequest(fixMsgOrderCancelReplaceRequest, "s6", "s7", fixSideSell,
+		FIXField{Tag: fixTagOrderQty, Value: "10"}, FIXField{Tag: fixTagOrdType, Value: fixOrdTypeLimit}, FIXField{Tag: fixTagPrice, Value: "12"}))
+	expectFIX(t, seller, "Replace from a zero price", map[int]string{fixTagClOrdID: "s7", fixTagExecType: fixExecTypeReplaced})
+	seller.Send(cancelRequest(fixMsgOrderCancelReplaceRequest, "s7", "s8", fixSideSell,
+		FIXField{Tag: fixTagOrderQty, Value: "10"}, FIXField{Tag: fixTagOrdType, Value: fixOrdTypeLimit}, FIXField{Tag: fixTagPrice, Value: "0"}))
+	expectFIX(t, seller, "Replace to a zero price", map[int]string{fixTagClOrdID: "s8", fixTagExecType: fixExecTypeReplaced})
+	seller.Send(NewFIXMessage(fixMsgTestRequest, FIXField{Tag: fixTagTestReqID, Value: "ping"}))
+	expectFIX(t, seller, "Test request", map[int]string{fixTagMsgType: fixMsgHeartbeat, fixTagTestReqID: "ping"})
+
+	if err := buyer.Logout(fixTestTimeout); err != nil {
+		t.Errorf("Expected no error logging out, received %s", err)
+	}
+	seller.Close()
+}
+
+func TestFIXSymbols(t *testing.T) {
+	acceptor, address := startFIXAcceptor(t, "")
+	defer acceptor.Close()
+	buyer := logOnFIXClient(t, address, "BUYER", nil, false)
+	seller := logOnFIXClient(t, address, "SELLER", nil, false)
+	defer buyer.Close()
+	defer seller.Close()
+
+	buyer.Send(newOrderSingle("b1", fixSideBuy, "100", "10"))
+	expectFIX(t, buyer, "Buy order", map[int]string{fixTagClOrdID: "b1", fixTagSymbol: "IBM", fixTagExecType: fixExecTypeNew})
+	other := newOrderSingle("s1", fixSideSell, "100", "10")
+	other.Set(fixTagSymbol, "MSFT")
+	seller.Send(other)
+	expectFIX(t, seller, "Same price in another symbol rests", map[int]string{fixTagClOrdID: "s1", fixTagSymbol: "MSFT", fixTagExecType: fixExecTypeNew, fixTagLeavesQty: "100"})
+	seller.Send(newOrderSingle("s2", fixSideSell, "40", "10"))
+	expectFIX(t, seller, "Same symbol acknowledged", map[int]string{fixTagClOrdID: "s2", fixTagExecType: fixExecTypeNew})
+	expectFIX(t, seller, "Same symbol trades", map[int]string{fixTagClOrdID: "s2", fixTagExecType: fixExecTypeTrade, fixTagCumQty: "40"})
+	expectFIX(t, buyer, "Buy order filled in its own symbol", map[int]string{fixTagClOrdID: "b1", fixTagExecType: fixExecTypeTrade, fixTagCumQty: "40"})
+
+	replace := cancelRequest(fixMsgOrderCancelReplaceRequest, "s1", "s3", fixSideSell,
+		FIXField{Tag: fixTagOrderQty, Value: "50"}, FIXField{Tag: fixTagOrdType, Value: fixOrdTypeLimit}, FIXField{Tag: fixTagPrice, Value: "11"})
+	replace.Set(fixTagSymbol, "MSFT")
+	seller.Send(replace)
+	expectFIX(t, seller, "Replace goes to the order's symbol", map[int]string{fixTagClOrdID: "s3", fixTagExecType: fixExecTypeReplaced, fixTagLeavesQty: "50"})
+	cancel := cancelRequest(fixMsgOrderCancelRequest, "s3", "s4", fixSideSell)
+	cancel.Set(fixTagSymbol, "MSFT")
+	seller.Send(cancel)
+	expectFIX(t, seller, "Cancel goes to the order's symbol", map[int]string{fixTagClOrdID: "s4", fixTagExecType: fixExecTypeCanceled})
+}
+
+func TestFIXPriceBandCancel(t *testing.T) {
+	acceptor, address := startFIXAcceptorWith(t, "", CircuitBreaker{DynamicBandBps: 1000, Action: REJECT_ORDER, HaltLength: 1, ReopeningLength: 1})
+	defer acceptor.Close()
+	buyer := logOnFIXClient(t, address, "BUYER", nil, false)
+	seller := logOnFIXClient(t, address, "SELLER", nil, false)
+	defer buyer.Close()
+	defer seller.Close()
+
+	// a trade at 100 sets the reference, 117 is outside a 10% band around the 105 the buyer trades at first
+	seller.Send(newOrderSingle("s1", fixSideSell, "10", "100"))
+	expectFIX(t, seller, "Reference order acknowledged", map[int]string{fixTagExecType: fixExecTypeNew})
+	buyer.Send(newOrderSingle("b1", fixSideBuy, "10", "100"))
+	expectFIX(t, buyer, "Reference trade acknowledged", map[int]string{fixTagExecType: fixExecTypeNew})
+	expectFIX(t, buyer, "Reference trade", map[int]string{fixTagExecType: fixExecTypeTrade})
+	expectFIX(t, seller, "Reference trade filled", map[int]string{fixTagExecType: fixExecTypeTrade})
+	seller.Send(newOrderSingle("s2", fixSideSell, "10", "105"))
+	expectFIX(t, seller, "Inside the band", map[int]string{fixTagExecType: fixExecTypeNew})
+	seller.Send(newOrderSingle("s3", fixSideSell, "10", "117"))
+	expectFIX(t, seller, "Outside the band", map[int]string{fixTagExecType: fixExecTypeNew})
+
+	buyer.Send(newOrderSingle("b2", fixSideBuy, "20", "120"))
+	expectFIX(t, buyer, "Order acknowledged", map[int]string{fixTagClOrdID: "b2", fixTagExecType: fixExecTypeNew})
+	expectFIX(t, buyer, "Trade inside the band", map[int]string{fixTagClOrdID: "b2", fixTagExecType: fixExecTypeTrade, fixTagCumQty: "10"})
+	expectFIX(t, buyer, "Rest cancelled by the breaker", map[int]string{fixTagClOrdID: "b2", fixTagExecType: fixExecTypeCanceled, fixTagOrdStatus: fixStatusCanceled, fixTagCumQty: "10", fixTagLeavesQty: "0"})
+	expectFIX(t, seller, "Order inside the band filled", map[int]string{fixTagClOrdID: "s2", fixTagExecType: fixExecTypeTrade})
+
+	// a replace that trips the breaker has been accepted by then, the rest is cancelled rather than the replace rejected
+	seller.Send(newOrderSingle("s4", fixSideSell, "10", "105"))
+	expectFIX(t, seller, "Inside the band again", map[int]string{fixTagExecType: fixExecTypeNew})
+	buyer.Send(newOrderSingle("b3", fixSideBuy, "10", "90"))
+	expectFIX(t, buyer, "Order to replace", map[int]string{fixTagClOrdID: "b3", fixTagExecType: fixExecTypeNew})
+	buyer.Send(cancelRequest(fixMsgOrderCancelReplaceRequest, "b3", "b4", fixSideBuy,
+		FIXField{Tag: fixTagOrderQty, Value: "20"}, FIXField{Tag: fixTagOrdType, Value: fixOrdTypeLimit}, FIXField{Tag: fixTagPrice, Value: "120"}))
+	expectFIX(t, buyer, "Replace accepted", map[int]string{fixTagClOrdID: "b4", fixTagExecType: fixExecTypeReplaced})
+	expectFIX(t, buyer, "Replaced order trades", map[int]string{fixTagClOrdID: "b4", fixTagExecType: fixExecTypeTrade, fixTagCumQty: "10"})
+	expectFIX(t, buyer, "Replaced order cancelled by the breaker", map[int]string{fixTagMsgType: fixMsgExecutionReport, fixTagClOrdID: "b4", fixTagExecType: fixExecTypeCanceled, fixTagLeavesQty: "0"})
+}
+
+func TestFIXResend(t *testing.T) {
+	directory, err := ioutil.TempDir("", "fix_acceptor")
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	defer os.RemoveAll(directory)
+	acceptor, address := startFIXAcceptor(t, directory)
+	buyerStore, _ := OpenFIXStore("")
+
+	// an order lost on its way is sent again when the acceptor sees the gap behind the next one
+	buyer := logOnFIXClient(t, address, "BUYER", buyerStore, true)
+	lost := newOrderSingle("b1", fixSideBuy, "100", "10").withHeader("BUYER", "ORDERBOOK", buyerStore.NextSenderSeqNum, time.Now())
+	buyerStore.SaveMessage(lost)
+	buyer.Send(newOrderSingle("b2", fixSideBuy, "100", "9"))
+	expectFIX(t, buyer, "Lost order resent", map[int]string{fixTagClOrdID: "b1", fixTagExecType: fixExecTypeNew})
+	expectFIX(t, buyer, "Order behind the gap", map[int]string{fixTagClOrdID: "b2", fixTagExecType: fixExecTypeNew})
+	if err := buyer.Logout(fixTestTimeout); err != nil {
+		t.Fatalf("Expected no error logging out, received %s", err)
+	}
+
+	// fills while the buyer is away are sent when it asks for the messages it missed
+	seller := logOnFIXClient(t, address, "SELLER", nil, true)
+	seller.Send(newOrderSingle("s1", fixSideSell, "30", "10"))
+	expectFIX(t, seller, "Seller acknowledged", map[int]string{fixTagExecType: fixExecTypeNew})
+	expectFIX(t, seller, "Seller filled", map[int]string{fixTagExecType: fixExecTypeTrade})
+	seller.Close()
+
+	// the acceptor restarts with the same stores, sequence numbers carry on
+	acceptor.Close()
+	acceptor, address = startFIXAcceptor(t, directory)
+	defer acceptor.Close()
+	buyer = logOnFIXClient(t, address, "BUYER", buyerStore, false)
+	fill := expectFIX(t, buyer, "Fill while logged out", map[int]string{fixTagClOrdID: "b1", fixTagExecType: fixExecTypeTrade, fixTagPossDupFlag: fixYes, fixTagCumQty: "30"})
+	if _, found := fill.Get(fixTagOrigSendingTime); !found {
+		t.Errorf("Expected a resent message to carry OrigSendingTime, received %v", fill)
+	}
+
+	// a message sequenced lower than expected ends the session
+	buyer.SendWithSeqNum(NewFIXMessage(fixMsgHeartbeat), 1)
+	expectFIX(t, buyer, "MsgSeqNum too low", map[int]string{fixTagMsgType: fixMsgLogout, fixTagText: "MsgSeqNum too low, expecting 7 but received 1"})
+	if _, err := buyer.Receive(fixTestTimeout); err == nil {
+		t.Errorf("Expected the acceptor to disconnect")
+	}
+}
+
+func TestFIXLogon(t *testing.T) {
+	acceptor, address := startFIXAcceptor(t, "")
+	defer acceptor.Close()
+	tests := map[string]struct {
+		senderCompID string
+		targetCompID string
+	}{
+		"Unknown counterparty": {senderCompID: "STRANGER", targetCompID: "ORDERBOOK"},
+		"Wrong TargetCompID":   {senderCompID: "BUYER", targetCompID: "ELSEWHERE"},
+	}
+
+	for name, test := range tests {
+		client, err := DialFIXClient(address, test.senderCompID, test.targetCompID, nil)
+		if err != nil {
+			t.Fatalf("Expected no error, received %s for test %s", err, name)
+		}
+		if _, err := client.Logon(30, true, fixTestTimeout); err == nil {
+			t.Errorf("Expected the logon to be refused for test %s", name)
+		}
+		client.Close()
+	}
+
+	buyer := logOnFIXClient(t, address, "BUYER", nil, true)
+	defer buyer.Close()
+	second, _ := DialFIXClient(address, "BUYER", "ORDERBOOK", nil)
+	if _, err := second.Logon(30, true, fixTestTimeout); err == nil {
+		t.Errorf("Expected a second logon to a live session to be refused")
+	}
+	second.Close()
+}
+
+func TestFIXHeartbeat(t *testing.T) {
+	acceptor, address := startFIXAcceptor(t, "")
+	defer acceptor.Close()
+	client, err := DialFIXClient(address, "BUYER", "ORDERBOOK", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	defer client.Close()
+	if _, err := client.Logon(1, true, fixTestTimeout); err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+
+	// a counterparty that never answers is sent a TestRequest and then disconnected
+	var received []string
+	client.connection.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		message, err := ReadFIXMessage(client.reader)
+		if err != nil {
+			break
+		}
+		received = append(received, message.MsgType())
+	}
+	if len(received) < 2 || received[0] != fixMsgHeartbeat || received[len(received)-1] != fixMsgTestRequest {
+		t.Errorf("Expected heartbeats and then a TestRequest, received %v", received)
+	}
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DialFIXClient: connects to an acceptor at address as senderCompID. The session's sequence numbers and
+// sent messages are kept in store, which a later connection can carry on with, a nil store starts afresh
+// in memory.
+func DialFIXClient(address string, senderCompID string, targetCompID string, store *FIXStore) (*FIXClient, error) {
+	if store == nil {
+		store, _ = OpenFIXStore("")
+	}
+	connection, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error connecting to %v in DialFIXClient()", address)
+	}
+	return &FIXClient{
+		SenderCompID: senderCompID,
+		TargetCompID: targetCompID,
+		Store:        store,
+		connection:   connection,
+		reader:       bufio.NewReader(connection),
+	}, nil
+}
+
+// Logon: logs on with a heartbeat interval in seconds, resetting both sides' sequence numbers to 1 when
+// reset is set, and returns the acceptor's Logon
+func (c *FIXClient) Logon(heartBtInt int, reset bool, timeout time.Duration) (FIXMessage, error) {
+	logon := NewFIXMessage(fixMsgLogon, FIXField{Tag: fixTagEncryptMethod, Value: "0"}, FIXField{Tag: fixTagHeartBtInt, Value: strconv.Itoa(heartBtInt)})
+	if reset {
+		if err := c.Store.Reset(); err != nil {
+			return FIXMessage{}, errors.Wrap(err, "error resetting message store in Logon()")
+		}
+		logon.Set(fixTagResetSeqNumFlag, fixYes)
+	}
+	if err := c.Send(logon); err != nil {
+		return FIXMessage{}, err
+	}
+	response, err := c.Receive(timeout)
+	if err != nil {
+		return FIXMessage{}, errors.Wrap(err, "error waiting for Logon in Logon()")
+	}
+	if response.MsgType() != fixMsgLogon {
+		return response, errors.Errorf("logon refused: %v in Logon()", response)
+	}
+	return response, nil
+}
+
+// Send: numbers a message, stores it and writes it to the acceptor
+func (c *FIXClient) Send(message FIXMessage) error {
+	message = message.withHeader(c.SenderCompID, c.TargetCompID, c.Store.NextSenderSeqNum, time.Now())
+	if err := c.Store.SaveMessage(message); err != nil {
+		return errors.Wrap(err, "error storing message in Send()")
+	}
+	if _, err := c.connection.Write(message.Bytes()); err != nil {
+		return errors.Wrap(err, "error writing message in Send()")
+	}
+	return nil
+}
+
+// SendWithSeqNum: writes a message under a sequence number of the caller's choosing without storing it,
+// for trying out how the acceptor deals with gaps and duplicates
+func (c *FIXClient) SendWithSeqNum(message FIXMessage, seqNum int) error {
+	message = message.withHeader(c.SenderCompID, c.TargetCompID, seqNum, time.Now())
+	if _, err := c.connection.Write(message.Bytes()); err != nil {
+		return errors.Wrap(err, "error writing message in SendWithSeqNum()")
+	}
+	return nil
+}
+
+// Receive: the next message from the acceptor other than a session message the client deals with itself.
+// Heartbeats are skipped unless they answer a TestRequest, TestRequests and ResendRequests are answered
+// and SequenceResets applied. Any other
+// message past a gap but a Logon or Logout is dropped and the gap asked for again, as the acceptor does.
+func (c *FIXClient) Receive(timeout time.Duration) (FIXMessage, error) {
+	c.connection.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		message, err := ReadFIXMessage(c.reader)
+		if err != nil {
+			return FIXMessage{}, errors.Wrap(err, "error reading message in Receive()")
+		}
+		seqNum := message.SeqNum()
+		expected := c.Store.NextTargetSeqNum
+		msgType := message.MsgType()
+		if gapFill, _ := message.Get(fixTagGapFillFlag); msgType == fixMsgSequenceReset && gapFill != fixYes {
+			newSeqNo, _ := strconv.Atoi(getFIX(message, fixTagNewSeqNo))
+			if err := c.Store.SetNextTargetSeqNum(newSeqNo); err != nil {
+				return FIXMessage{}, err
+			}
+			continue
+		}
+		if seqNum > expected {
+			if msgType == fixMsgResendRequest {
+				c.resend(message)
+			}
+			if expected > c.resendUntil {
+				c.resendUntil = seqNum
+				resendRequest := NewFIXMessage(fixMsgResendRequest, FIXField{Tag: fixTagBeginSeqNo, Value: strconv.Itoa(expected)}, FIXField{Tag: fixTagEndSeqNo, Value: "0"})
+				if err := c.Send(resendRequest); err != nil {
+					return FIXMessage{}, err
+				}
+			}
+			// a Logon or Logout counts even when it shows a gap
+			if msgType == fixMsgLogon || msgType == fixMsgLogout {
+				return message, nil
+			}
+			continue
+		}
+		if seqNum < expected {
+			if possDup, _ := message.Get(fixTagPossDupFlag); possDup == fixYes {
+				continue
+			}
+			return message, errors.Errorf("MsgSeqNum too low, expecting %v but received %v in Receive()", expected, seqNum)
+		}
+
+		next := seqNum + 1
+		if msgType == fixMsgSequenceReset {
+			if newSeqNo, err := strconv.Atoi(getFIX(message, fixTagNewSeqNo)); err == nil && newSeqNo > next {
+				next = newSeqNo
+			}
+		}
+		if err := c.Store.SetNextTargetSeqNum(next); err != nil {
+			return FIXMessage{}, err
+		}
+		switch msgType {
+		case fixMsgSequenceReset:
+		case fixMsgHeartbeat:
+			if _, found := message.Get(fixTagTestReqID); found {
+				return message, nil
+			}
+		case fixMsgTestRequest:
+			if err := c.Send(NewFIXMessage(fixMsgHeartbeat, FIXField{Tag: fixTagTestReqID, Value: getFIX(message, fixTagTestReqID)})); err != nil {
+				return FIXMessage{}, err
+			}
+		case fixMsgResendRequest:
+			if err := c.resend(message); err != nil {
+				return FIXMessage{}, err
+			}
+		default:
+			return message, nil
+		}
+	}
+}
+
+func (c *FIXClient) resend(request FIXMessage) error {
+	begin, err := strconv.Atoi(getFIX(request, fixTagBeginSeqNo))
+	if err != nil || begin <= 0 {
+		begin = 1
+	}
+	end, _ := strconv.Atoi(getFIX(request, fixTagEndSeqNo))
+	for _, message := range resendMessages(c.Store, begin, end) {
+		message = message.withHeader(c.SenderCompID, c.TargetCompID, message.SeqNum(), time.Now())
+		if _, err := c.connection.Write(message.Bytes()); err != nil {
+			return errors.Wrap(err, "error resending message in resend()")
+		}
+	}
+	return nil
+}
+
+// Logout: sends a Logout and waits for the acceptor's, then closes the connection
+func (c *FIXClient) Logout(timeout time.Duration) error {
+	defer c.connection.Close()
+	if err := c.Send(NewFIXMessage(fixMsgLogout)); err != nil {
+		return err
+	}
+	for {
+		message, err := c.Receive(timeout)
+		if err != nil {
+			return errors.Wrap(err, "error waiting for Logout in Logout()")
+		}
+		if message.MsgType() == fixMsgLogout {
+			return nil
+		}
+	}
+}
+
+// Close: drops the connection without logging out
+func (c *FIXClient) Close() error {
+	return c.connection.Close()
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// The store file is a run of records laid out little-endian as
+//
+// kind (byte) | sequence number (uint32) | length (uint32) | message
+//
+// A message record holds an outbound message under its sequence number, a target record carries the
+// next sequence number expected from the counterparty and no message. A record torn by a crash while it
+// was written is cut off when the store is opened.
+const (
+	fixStoreMessage      = 'M'
+	fixStoreTarget       = 'T'
+	fixStoreRecordHeader = 9
+)
+
+// OpenFIXStore: opens the store at path, creating it if needed, with the sequence numbers and messages it
+// holds. An empty path keeps the store in memory.
+func OpenFIXStore(path string) (*FIXStore, error) {
+	store := &FIXStore{NextSenderSeqNum: 1, NextTargetSeqNum: 1, messages: make(map[int][]byte)}
+	if path == "" {
+		return store, nil
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening message store in OpenFIXStore()")
+	}
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "error reading message store in OpenFIXStore()")
+	}
+
+	offset := 0
+	for len(data)-offset >= fixStoreRecordHeader {
+		kind := data[offset]
+		seqNum := int(binary.LittleEndian.Uint32(data[offset+1:]))
+		length := int(binary.LittleEndian.Uint32(data[offset+5:]))
+		if len(data)-offset-fixStoreRecordHeader < length {
+			break
+		}
+		switch kind {
+		case fixStoreMessage:
+			store.messages[seqNum] = data[offset+fixStoreRecordHeader : offset+fixStoreRecordHeader+length]
+			store.NextSenderSeqNum = seqNum + 1
+		case fixStoreTarget:
+			store.NextTargetSeqNum = seqNum
+		default:
+			file.Close()
+			return nil, errors.Errorf("unknown record kind %q in OpenFIXStore()", kind)
+		}
+		offset += fixStoreRecordHeader + length
+	}
+	if err := file.Truncate(int64(offset)); err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "error truncating torn record in OpenFIXStore()")
+	}
+	if _, err := file.Seek(int64(offset), io.SeekStart); err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "error seeking to message store end in OpenFIXStore()")
+	}
+	store.file = file
+	return store, nil
+}
+
+// SaveMessage: keeps an outbound message for resending, under the sequence number in its header
+func (s *FIXStore) SaveMessage(message FIXMessage) error {
+	seqNum := message.SeqNum()
+	data := message.Bytes()
+	if err := s.write(fixStoreMessage, seqNum, data); err != nil {
+		return errors.Wrap(err, "error saving message in SaveMessage()")
+	}
+	s.messages[seqNum] = data
+	s.NextSenderSeqNum = seqNum + 1
+	return nil
+}
+
+// SetNextTargetSeqNum: records the sequence number expected next from the counterparty
+func (s *FIXStore) SetNextTargetSeqNum(seqNum int) error {
+	if err := s.write(fixStoreTarget, seqNum, nil); err != nil {
+		return errors.Wrap(err, "error saving target sequence number in SetNextTargetSeqNum()")
+	}
+	s.NextTargetSeqNum = seqNum
+	return nil
+}
+
+// Message: the outbound message sent under seqNum
+func (s *FIXStore) Message(seqNum int) (FIXMessage, bool) {
+	data, found := s.messages[seqNum]
+	if !found {
+		return FIXMessage{}, false
+	}
+	message, err := ParseFIXMessage(data)
+	if err != nil {
+		return FIXMessage{}, false
+	}
+	return message, true
+}
+
+// Messages: every stored outbound message in sequence
+func (s *FIXStore) Messages() []FIXMessage {
+	var messages []FIXMessage
+	for seqNum := 1; seqNum < s.NextSenderSeqNum; seqNum++ {
+		if message, found := s.Message(seqNum); found {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// Reset: forgets every message and starts both sequence numbers again from 1
+func (s *FIXStore) Reset() error {
+	if s.file != nil {
+		if err := s.file.Truncate(0); err != nil {
+			return errors.Wrap(err, "error truncating message store in Reset()")
+		}
+		if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+			return errors.Wrap(err, "error seeking to message store start in Reset()")
+		}
+	}
+	s.messages = make(map[int][]byte)
+	s.NextSenderSeqNum = 1
+	s.NextTargetSeqNum = 1
+	return nil
+}
+
+// Close: closes the store's file, the store cannot be used afterwards
+func (s *FIXStore) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	if err := s.file.Close(); err != nil {
+		return errors.Wrap(err, "error closing message store in Close()")
+	}
+	return nil
+}
+
+func (s *FIXStore) write(kind byte, seqNum int, data []byte) error {
+	if s.file == nil {
+		return nil
+	}
+	record := make([]byte, fixStoreRecordHeader+len(data))
+	record[0] = kind
+	binary.LittleEndian.PutUint32(record[1:], uint32(seqNum))
+	binary.LittleEndian.PutUint32(record[5:], uint32(len(data)))
+	copy(record[fixStoreRecordHeader:], data)
+	_, err := s.file.Write(record)
+	return err
+}
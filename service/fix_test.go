@@ -0,0 +1,154 @@
+package service
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFIXMessageRoundTrip(t *testing.T) {
+	message := NewFIXMessage(fixMsgNewOrderSingle,
+		FIXField{Tag: fixTagClOrdID, Value: "order-1"},
+		FIXField{Tag: fixTagSymbol, Value: "IBM"},
+		FIXField{Tag: fixTagPrice, Value: "10.25"},
+	).withHeader("CLIENT", "ORDERBOOK", 7, time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.UTC))
+	expected := "8=FIX.4.4|9=85|35=D|49=CLIENT|56=ORDERBOOK|34=7|52=20200102-03:04:05.006|11=order-1|55=IBM|44=10.25|10=120|"
+	if message.String() != expected {
+		t.Fatalf("Expected %v, received %v", expected, message)
+	}
+
+	parsed, err := ParseFIXMessage(message.Bytes())
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	if !reflect.DeepEqual(parsed, message) {
+		t.Errorf("Expected %v, received %v", message, parsed)
+	}
+	if parsed.SeqNum() != 7 || parsed.MsgType() != fixMsgNewOrderSingle {
+		t.Errorf("Expected sequence number 7 of a NewOrderSingle, received %v of %v", parsed.SeqNum(), parsed.MsgType())
+	}
+}
+
+func TestReadFIXMessage(t *testing.T) {
+	valid := string(NewFIXMessage(fixMsgHeartbeat).withHeader("A", "B", 1, time.Unix(0, 0)).Bytes())
+	tests := map[string]struct {
+		input      string
+		messages   int  // messages read before the first error
+		isChecksum bool // the error is ErrFIXChecksum and the stream carries on after it
+	}{
+		"Two messages":        {input: valid + valid, messages: 2},
+		"Bad checksum":        {input: strings.Replace(valid, "10=", "10=9", 1) + valid, isChecksum: true},
+		"Wrong BeginString":   {input: strings.Replace(valid, "FIX.4.4", "FIX.4.2", 1)},
+		"BodyLength too long": {input: strings.Replace(valid, "9=", "9=9", 1)},
+		"Torn message":        {input: valid + valid[:len(valid)-5], messages: 1},
+	}
+
+	for name, test := range tests {
+		reader := bufio.NewReader(strings.NewReader(test.input))
+		messages := 0
+		var err error
+		for {
+			if _, err = ReadFIXMessage(reader); err != nil {
+				break
+			}
+			messages++
+		}
+		if messages != test.messages {
+			t.Errorf("Expected %v messages, received %v for test %s", test.messages, messages, name)
+		}
+		if (err == ErrFIXChecksum) != test.isChecksum {
+			t.Errorf("Expected a checksum error %v, received %v for test %s", test.isChecksum, err, name)
+		}
+		if test.isChecksum {
+			if _, err := ReadFIXMessage(reader); err != nil {
+				t.Errorf("Expected the next message to read, received %s for test %s", err, name)
+			}
+		}
+	}
+}
+
+func TestResendMessages(t *testing.T) {
+	store, _ := OpenFIXStore("")
+	for seqNum, msgType := range []string{fixMsgLogon, fixMsgExecutionReport, fixMsgHeartbeat, fixMsgHeartbeat, fixMsgExecutionReport, fixMsgTestRequest} {
+		store.SaveMessage(NewFIXMessage(msgType).withHeader("A", "B", seqNum+1, time.Unix(0, 0)))
+	}
+	tests := map[string]struct {
+		begin    int
+		end      int
+		expected []string // MsgType and MsgSeqNum, with NewSeqNo for gap fills
+	}{
+		"Everything":                   {begin: 1, end: 0, expected: []string{"4 1 2", "8 2", "4 3 5", "8 5", "4 6 7"}},
+		"Up to an application message": {begin: 3, end: 5, expected: []string{"4 3 5", "8 5"}},
+		"End past what was sent":       {begin: 6, end: 10, expected: []string{"4 6 7"}},
+	}
+
+	for name, test := range tests {
+		var received []string
+		for _, message := range resendMessages(store, test.begin, test.end) {
+			line := message.MsgType() + " " + getFIX(message, fixTagMsgSeqNum)
+			if newSeqNo, found := message.Get(fixTagNewSeqNo); found {
+				line += " " + newSeqNo
+			}
+			if possDup, _ := message.Get(fixTagPossDupFlag); possDup != fixYes {
+				t.Errorf("Expected every resent message to be a possible duplicate, received %v for test %s", message, name)
+			}
+			received = append(received, line)
+		}
+		if !reflect.DeepEqual(received, test.expected) {
+			t.Errorf("Expected %v, received %v for test %s", test.expected, received, name)
+		}
+	}
+}
+
+func TestFIXStoreReopen(t *testing.T) {
+	directory, err := ioutil.TempDir("", "fix_store")
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "session.store")
+
+	store, err := OpenFIXStore(path)
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	for seqNum := 1; seqNum <= 3; seqNum++ {
+		store.SaveMessage(NewFIXMessage(fixMsgExecutionReport, FIXField{Tag: fixTagClOrdID, Value: "order"}).withHeader("A", "B", seqNum, time.Unix(0, 0)))
+	}
+	store.SetNextTargetSeqNum(5)
+	store.Close()
+	// a crash part way through the next record
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	file.Write([]byte{fixStoreMessage, 4, 0, 0, 0, 200, 0})
+	file.Close()
+
+	store, err = OpenFIXStore(path)
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	if store.NextSenderSeqNum != 4 || store.NextTargetSeqNum != 5 {
+		t.Errorf("Expected sequence numbers 4 and 5, received %v and %v", store.NextSenderSeqNum, store.NextTargetSeqNum)
+	}
+	if message, found := store.Message(2); !found || message.SeqNum() != 2 {
+		t.Errorf("Expected message 2 to be kept, received %v", message)
+	}
+	store.SaveMessage(NewFIXMessage(fixMsgHeartbeat).withHeader("A", "B", 4, time.Unix(0, 0)))
+	store.Close()
+
+	store, _ = OpenFIXStore(path)
+	if len(store.Messages()) != 4 {
+		t.Errorf("Expected 4 messages after the torn record was cut off, received %v", len(store.Messages()))
+	}
+	store.Reset()
+	store.Close()
+	store, _ = OpenFIXStore(path)
+	defer store.Close()
+	if store.NextSenderSeqNum != 1 || store.NextTargetSeqNum != 1 || len(store.Messages()) != 0 {
+		t.Errorf("Expected an empty store after a reset, received %v messages", len(store.Messages()))
+	}
+}
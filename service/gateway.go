@@ -0,0 +1,348 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var ErrMarketCommand = errors.New("flush, session and clock commands are not accepted on the gateway")
+
+// NewGateway: Initializes a gateway whose books are created by newService as their symbols first appear,
+// with the configured heartbeat interval and cancel-on-disconnect
+func NewGateway(newService func() *OrderBookService) *Gateway {
+	return &Gateway{
+		HeartbeatInterval:  HEARTBEAT_INTERVAL,
+		CancelOnDisconnect: CANCEL_ON_DISCONNECT,
+		newService:         newService,
+		books:              make(map[string]*OrderBookService),
+		parser:             NewParserService(),
+		sessions:           make(map[*gatewaySession]bool),
+		users:              make(map[int]*gatewaySession),
+	}
+}
+
+// ListenAndServe: serves client sessions on a TCP address until Close is called
+func (g *Gateway) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return errors.Wrapf(err, "error listening on %v in ListenAndServe()", address)
+	}
+	return g.Serve(listener)
+}
+
+// Serve: accepts client sessions on listener until Close is called, each served on its own goroutine
+func (g *Gateway) Serve(listener net.Listener) error {
+	g.mutex.Lock()
+	if g.closed {
+		g.mutex.Unlock()
+		listener.Close()
+		return nil
+	}
+	g.listener = listener
+	g.mutex.Unlock()
+
+	for {
+		connection, err := listener.Accept()
+		if err != nil {
+			g.mutex.Lock()
+			closed := g.closed
+			g.mutex.Unlock()
+			if closed {
+				return nil
+			}
+			return errors.Wrap(err, "error accepting connection in Serve()")
+		}
+		session := &gatewaySession{
+			connection: connection,
+			outbound:   make(chan string, SESSION_OUTBOUND_SIZE),
+			finished:   make(chan struct{}),
+			done:       make(chan struct{}),
+		}
+		g.mutex.Lock()
+		if g.closed {
+			g.mutex.Unlock()
+			connection.Close()
+			return nil
+		}
+		g.sessions[session] = true
+		g.wait.Add(2)
+		g.mutex.Unlock()
+		go g.read(session)
+		go g.write(session)
+	}
+}
+
+// Close: stops accepting sessions, disconnects every client and waits for their sessions to end
+func (g *Gateway) Close() error {
+	g.mutex.Lock()
+	g.closed = true
+	listener := g.listener
+	sessions := make([]*gatewaySession, 0, len(g.sessions))
+	for session := range g.sessions {
+		sessions = append(sessions, session)
+	}
+	g.mutex.Unlock()
+
+	var err error
+	if listener != nil {
+		err = listener.Close()
+	}
+	for _, session := range sessions {
+		session.close()
+	}
+	g.wait.Wait()
+	return err
+}
+
+// read: applies the session's commands in the order they arrive. A client that stays silent for two
+// heartbeat intervals is disconnected, and when the session ends its user is unbound and, with
+// CancelOnDisconnect, their resting orders are cancelled.
+func (g *Gateway) read(session *gatewaySession) {
+	defer g.wait.Done()
+	defer g.endSession(session)
+
+	scanner := bufio.NewScanner(session.connection)
+	for {
+		if g.HeartbeatInterval > 0 {
+			session.connection.SetReadDeadline(time.Now().Add(2 * g.HeartbeatInterval))
+		}
+		if !scanner.Scan() {
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line == HEARTBEAT {
+			continue
+		}
+		command, err := g.parser.ParseCommand(line)
+		if err != nil {
+			session.send(fmt.Sprintf("%v, %v", PROTOCOL_ERROR, errors.Cause(err)))
+			continue
+		}
+		if err := g.apply(session, command); err != nil {
+			session.send(fmt.Sprintf("%v, %v", PROTOCOL_ERROR, err))
+			return
+		}
+	}
+}
+
+// write: writes the session's outbound lines, with a heartbeat whenever nothing was written for a whole
+// interval
+func (g *Gateway) write(session *gatewaySession) {
+	defer g.wait.Done()
+	var heartbeat <-chan time.Time
+	if g.HeartbeatInterval > 0 {
+		ticker := time.NewTicker(g.HeartbeatInterval)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	writer := bufio.NewWriter(session.connection)
+	isIdle := true
+	for {
+		select {
+		case <-session.done:
+			return
+		case <-session.finished:
+			for len(session.outbound) > 0 {
+				fmt.Fprintln(writer, <-session.outbound)
+			}
+			writer.Flush()
+			session.close()
+			return
+		case <-heartbeat:
+			if isIdle {
+				fmt.Fprintln(writer, HEARTBEAT)
+			}
+			isIdle = true
+		case line := <-session.outbound:
+			fmt.Fprintln(writer, line)
+			isIdle = false
+		}
+		// lines that queued up together go out in one write
+		if len(session.outbound) > 0 {
+			continue
+		}
+		if err := writer.Flush(); err != nil {
+			session.close()
+			return
+		}
+	}
+}
+
+// apply: binds the session to the user of its first order, applies the command to the book of its symbol
+// and hands out its events. Commands for another user, or for another user's order, are rejected as
+// WRONG_USER, and a new order under the id of one of the user's resting orders as DUPLICATE_ORDER. Flush,
+// session and clock commands would change the books under every other session, so they are answered with
+// an error.
+func (g *Gateway) apply(session *gatewaySession, command Order) error {
+	g.dispatch.Lock()
+	defer g.dispatch.Unlock()
+
+	switch command.Command {
+	case FLUSH_ORDER_BOOK, SESSION_STATE, ADVANCE_CLOCK:
+		session.send(fmt.Sprintf("%v, %v", PROTOCOL_ERROR, ErrMarketCommand))
+		return nil
+	}
+	reason := g.bind(session, command.UserID)
+	// the books know orders by their user order id alone, so an id that rests in one book is taken in all of
+	// them. A session may only touch its own user's orders and may not enter an order under a taken id.
+	resting, book, found := g.restingOrder(command.UserOrderID)
+	switch {
+	case reason != "":
+	case found && resting.UserID != command.UserID:
+		reason = WRONG_USER
+	case found && command.Command == NEW_ORDER:
+		reason = DUPLICATE_ORDER
+	case !found && command.Command != NEW_ORDER:
+		reason = UNKNOWN_ORDER
+	}
+	if reason != "" {
+		session.send(rejectEvent(command.Command, command, reason).String())
+		return nil
+	}
+	if command.Command == NEW_ORDER {
+		book = g.book(command.Symbol)
+	}
+	events, err := book.Apply(command)
+	g.dispatchEvents(session, events)
+	if err != nil {
+		return errors.Wrap(err, "error applying command in apply()")
+	}
+	return nil
+}
+
+// book: the symbol's book, created when it is first needed. The caller holds dispatch.
+func (g *Gateway) book(symbol string) *OrderBookService {
+	service, found := g.books[symbol]
+	if !found {
+		service = g.newService()
+		g.books[symbol] = service
+	}
+	return service
+}
+
+// restingOrder: the order resting under a user order id and the book it rests in. The caller holds dispatch.
+func (g *Gateway) restingOrder(userOrderID int) (Order, *OrderBookService, bool) {
+	for _, book := range g.books {
+		if order, found := book.Order(userOrderID); found {
+			return order, book, true
+		}
+	}
+	return Order{}, nil, false
+}
+
+// bind: the reason the session may not act for userID, binding it when it has no user yet
+func (g *Gateway) bind(session *gatewaySession, userID int) string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if session.isBound {
+		if session.userID != userID {
+			return WRONG_USER
+		}
+		return ""
+	}
+	if _, found := g.users[userID]; found {
+		return USER_IN_USE
+	}
+	session.userID = userID
+	session.isBound = true
+	g.users[userID] = session
+	return ""
+}
+
+// dispatchEvents: the session that sent the command gets every event it caused, and other sessions get the
+// events about their own user's orders, such as fills of resting orders, self trade cancels and expiries
+func (g *Gateway) dispatchEvents(origin *gatewaySession, events []Event) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for _, event := range events {
+		line := event.String()
+		if line == "" {
+			continue
+		}
+		if origin != nil {
+			origin.send(line)
+		}
+		for _, userID := range eventUsers(event) {
+			if session, found := g.users[userID]; found && session != origin {
+				session.send(line)
+			}
+		}
+	}
+}
+
+// eventUsers: the users whose orders an event is about, market wide events concern nobody in particular
+func eventUsers(event Event) []int {
+	switch event.Type {
+	case EVENT_ACK, EVENT_REJECT, EVENT_SELF_TRADE, EVENT_EXPIRE:
+		return []int{event.Order.UserID}
+	case EVENT_TRADE:
+		if event.Bid.UserID == event.Ask.UserID {
+			return []int{event.Bid.UserID}
+		}
+		return []int{event.Bid.UserID, event.Ask.UserID}
+	}
+	return nil
+}
+
+// endSession: unbinds the session's user and cancels their resting orders in every book when the gateway
+// is set to
+func (g *Gateway) endSession(session *gatewaySession) {
+	session.finishOnce.Do(func() { close(session.finished) })
+	g.mutex.Lock()
+	delete(g.sessions, session)
+	isBound, userID := session.isBound, session.userID
+	if isBound && g.users[userID] == session {
+		delete(g.users, userID)
+	}
+	g.mutex.Unlock()
+	if !isBound || !g.CancelOnDisconnect {
+		return
+	}
+
+	g.dispatch.Lock()
+	defer g.dispatch.Unlock()
+	symbols := make([]string, 0, len(g.books))
+	for symbol := range g.books {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		book := g.books[symbol].Book()
+		for _, orders := range [][]Order{book.Bids, book.Asks} {
+			for _, order := range orders {
+				if order.UserID != userID {
+					continue
+				}
+				events, err := g.books[symbol].Cancel(userID, order.UserOrderID)
+				if err != nil {
+					return
+				}
+				g.dispatchEvents(nil, events)
+			}
+		}
+	}
+}
+
+// send: queues a line for the client, a client too slow to keep up is disconnected rather than holding
+// everyone else up
+func (s *gatewaySession) send(line string) {
+	select {
+	case <-s.done:
+	case s.outbound <- line:
+	default:
+		s.close()
+	}
+}
+
+func (s *gatewaySession) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.connection.Close()
+	})
+}
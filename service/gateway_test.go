@@ -0,0 +1,191 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// gatewayClient: a test connection that reads the gateway's lines with a deadline
+type gatewayClient struct {
+	connection net.Conn
+	scanner    *bufio.Scanner
+}
+
+func startGateway(t *testing.T, heartbeat time.Duration, cancelOnDisconnect bool) (*Gateway, string) {
+	gateway := NewGateway(func() *OrderBookService {
+		testService := NewOrderBookService()
+		testService.IsTradingEnabled = true
+		testService.Output = ioutil.Discard
+		return testService
+	})
+	gateway.HeartbeatInterval = heartbeat
+	gateway.CancelOnDisconnect = cancelOnDisconnect
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	go gateway.Serve(listener)
+	return gateway, listener.Addr().String()
+}
+
+func dialGateway(t *testing.T, address string) *gatewayClient {
+	connection, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	return &gatewayClient{connection: connection, scanner: bufio.NewScanner(connection)}
+}
+
+func (c *gatewayClient) send(t *testing.T, line string) {
+	if _, err := fmt.Fprintln(c.connection, line); err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+}
+
+// expect: reads the next lines, skipping heartbeats, and compares them with the expected ones
+func (c *gatewayClient) expect(t *testing.T, name string, expected ...string) {
+	for _, line := range expected {
+		c.connection.SetReadDeadline(time.Now().Add(2 * time.Second))
+		received := ""
+		for received == "" || received == HEARTBEAT {
+			if !c.scanner.Scan() {
+				t.Fatalf("Expected line %q, received end of stream for test %s", line, name)
+			}
+			received = c.scanner.Text()
+		}
+		if received != line {
+			t.Fatalf("Expected line %q, received %q for test %s", line, received, name)
+		}
+	}
+}
+
+func TestGatewaySessions(t *testing.T) {
+	gateway, address := startGateway(t, 0, true)
+	defer gateway.Close()
+	buyer := dialGateway(t, address)
+	defer buyer.connection.Close()
+	seller := dialGateway(t, address)
+	defer seller.connection.Close()
+
+	buyer.send(t, "N, 1, IBM, 10, 100, B, 1")
+	buyer.expect(t, "Buyer enters an order", "A, 1, 1", "B, B, 10, 100")
+
+	seller.send(t, "N, 2, IBM, 10, 40, S, 2")
+	seller.expect(t, "Seller trades against it", "A, 2, 2", "T, 1, 1, 2, 2, 10, 40", "B, B, 10, 60")
+	buyer.expect(t, "Buyer hears about the fill", "T, 1, 1, 2, 2, 10, 40")
+
+	seller.send(t, "N, 1, IBM, 11, 10, S, 3")
+	seller.expect(t, "Session is bound to its first user", "R, 1, 3, WRONG_USER")
+	seller.send(t, "C, 2, 1")
+	seller.expect(t, "Another user's order is out of reach", "R, 2, 1, WRONG_USER")
+	seller.send(t, "N, 2, IBM, 11, 10, S, 1")
+	seller.expect(t, "Another user's order id is taken", "R, 2, 1, WRONG_USER")
+	for _, line := range []string{"F", "P, HALTED", "K, 100"} {
+		seller.send(t, line)
+		seller.expect(t, "Market wide command "+line, "ERROR, "+ErrMarketCommand.Error())
+	}
+
+	third := dialGateway(t, address)
+	defer third.connection.Close()
+	third.send(t, "N, 1, IBM, 9, 10, B, 4")
+	third.expect(t, "User is bound to a live session", "R, 1, 4, USER_IN_USE")
+	third.send(t, "N, 3, IBM")
+	third.expect(t, "Malformed line", "ERROR, NEW_ORDER invalid input in ParseCommand()")
+
+	buyer.send(t, "C, 1, 1")
+	buyer.expect(t, "Buyer cancels", "A, 1, 1", "B, B, -, -")
+}
+
+func TestGatewaySymbols(t *testing.T) {
+	gateway, address := startGateway(t, 0, true)
+	defer gateway.Close()
+	buyer := dialGateway(t, address)
+	defer buyer.connection.Close()
+	seller := dialGateway(t, address)
+	defer seller.connection.Close()
+
+	buyer.send(t, "N, 1, IBM, 10, 100, B, 1")
+	buyer.expect(t, "Buyer enters an order", "A, 1, 1", "B, B, 10, 100")
+	seller.send(t, "N, 2, MSFT, 10, 100, S, 2")
+	seller.expect(t, "Same price in another symbol rests", "A, 2, 2", "B, S, 10, 100")
+	seller.send(t, "N, 2, IBM, 10, 40, S, 3")
+	seller.expect(t, "Same symbol trades", "A, 2, 3", "T, 1, 1, 2, 3, 10, 40", "B, B, 10, 60")
+	buyer.expect(t, "Buyer hears about the fill", "T, 1, 1, 2, 3, 10, 40")
+
+	buyer.send(t, "N, 1, IBM, 9, 10, B, 1")
+	buyer.expect(t, "Resting order id is taken", "R, 1, 1, DUPLICATE_ORDER")
+	buyer.send(t, "N, 1, MSFT, 9, 10, B, 1")
+	buyer.expect(t, "Resting order id is taken in every symbol", "R, 1, 1, DUPLICATE_ORDER")
+	seller.send(t, "M, 2, 2, 11, 50")
+	seller.expect(t, "Amend goes to the order's symbol", "A, 2, 2", "B, S, 11, 50")
+	seller.send(t, "C, 2, 2")
+	seller.expect(t, "Cancel goes to the order's symbol", "A, 2, 2", "B, S, -, -")
+	seller.send(t, "C, 2, 2")
+	seller.expect(t, "Cancelled order is unknown", "R, 2, 2, UNKNOWN_ORDER")
+	seller.send(t, "N, 2, MSFT, 10, 100, S, 2")
+	seller.expect(t, "Id of a cancelled order can be used again", "A, 2, 2", "B, S, 10, 100")
+}
+
+func TestGatewayCancelOnDisconnect(t *testing.T) {
+	tests := map[string]struct {
+		cancelOnDisconnect bool
+		resting            int
+	}{
+		"Orders are cancelled": {cancelOnDisconnect: true},
+		"Orders are left":      {cancelOnDisconnect: false, resting: 2},
+	}
+
+	for name, test := range tests {
+		gateway, address := startGateway(t, 0, test.cancelOnDisconnect)
+		client := dialGateway(t, address)
+		client.send(t, "N, 1, IBM, 10, 100, B, 1")
+		client.send(t, "N, 1, MSFT, 12, 100, S, 2")
+		client.expect(t, name, "A, 1, 1", "B, B, 10, 100", "A, 1, 2", "B, S, 12, 100")
+		client.connection.Close()
+
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			resting := 0
+			gateway.dispatch.Lock()
+			for _, testService := range gateway.books {
+				book := testService.Book()
+				resting += len(book.Bids) + len(book.Asks)
+			}
+			gateway.dispatch.Unlock()
+			gateway.mutex.Lock()
+			users := len(gateway.users)
+			gateway.mutex.Unlock()
+			if resting == test.resting && users == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %v resting orders after disconnecting, received %v for test %s", test.resting, resting, name)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		gateway.Close()
+	}
+}
+
+func TestGatewayHeartbeat(t *testing.T) {
+	gateway, address := startGateway(t, 50*time.Millisecond, true)
+	defer gateway.Close()
+	client := dialGateway(t, address)
+	defer client.connection.Close()
+
+	// an idle session is sent heartbeats
+	client.connection.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if !client.scanner.Scan() || client.scanner.Text() != HEARTBEAT {
+		t.Fatalf("Expected a heartbeat, received %q", client.scanner.Text())
+	}
+	// a client that never answers is disconnected after two intervals
+	for client.scanner.Scan() {
+	}
+	if err := client.scanner.Err(); err != nil {
+		t.Errorf("Expected the gateway to end the session, received %s", err)
+	}
+}
@@ -0,0 +1,391 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// NewHTTPServer: Initializes a JSON API whose books are created by newService as their symbols first appear
+//
+//	POST   /users/{userId}/orders                submits an order
+//	GET    /users/{userId}/orders                the user's resting orders
+//	PATCH  /users/{userId}/orders/{userOrderId}  amends price and quantity
+//	DELETE /users/{userId}/orders/{userOrderId}  cancels
+//	GET    /users/{userId}/fills                 the user's fills, oldest first
+//	GET    /books/{symbol}?depth=N               price levels, every level without depth
+//	GET    /books/{symbol}/top                   best bid and ask
+//	GET    /marketdata                           WebSocket of top of book, depth and trades by symbol
+func NewHTTPServer(newService func() *OrderBookService) *HTTPServer {
+	return &HTTPServer{
+		SlowConsumerPolicy:  SLOW_CONSUMER_POLICY,
+		MarketDataQueueSize: MARKET_DATA_QUEUE_SIZE,
+		newService:          newService,
+		books:               make(map[string]*OrderBookService),
+		bookOrderIDs:        make(map[httpOrderKey]int),
+		userOrders:          make(map[int]httpOrderKey),
+		orderSymbols:        make(map[int]string),
+		fills:               make(map[int][]HTTPFill),
+	}
+}
+
+// ServeHTTP: routes a request to its handler, the path decides the resource and the method what is done
+func (h *HTTPServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	path := strings.Split(strings.Trim(request.URL.Path, "/"), "/")
+	switch {
+	case len(path) >= 3 && path[0] == "users":
+		userID, err := strconv.Atoi(path[1])
+		if err != nil {
+			writeHTTPError(writer, http.StatusNotFound, "unknown user "+path[1])
+			return
+		}
+		switch {
+		case len(path) == 3 && path[2] == "orders" && request.Method == http.MethodPost:
+			h.submit(writer, request, userID)
+		case len(path) == 3 && path[2] == "orders" && request.Method == http.MethodGet:
+			h.openOrders(writer, userID)
+		case len(path) == 3 && path[2] == "orders":
+			writeMethodNotAllowed(writer, http.MethodGet, http.MethodPost)
+		case len(path) == 3 && path[2] == "fills" && request.Method == http.MethodGet:
+			h.userFills(writer, userID)
+		case len(path) == 3 && path[2] == "fills":
+			writeMethodNotAllowed(writer, http.MethodGet)
+		case len(path) == 4 && path[2] == "orders":
+			userOrderID, err := strconv.Atoi(path[3])
+			if err != nil {
+				writeHTTPError(writer, http.StatusNotFound, "unknown order "+path[3])
+				return
+			}
+			switch request.Method {
+			case http.MethodPatch:
+				h.amend(writer, request, userID, userOrderID)
+			case http.MethodDelete:
+				h.cancel(writer, userID, userOrderID)
+			default:
+				writeMethodNotAllowed(writer, http.MethodPatch, http.MethodDelete)
+			}
+		default:
+			writeHTTPError(writer, http.StatusNotFound, "no such resource")
+		}
+	case (len(path) == 2 || (len(path) == 3 && path[2] == "top")) && path[0] == "books":
+		if request.Method != http.MethodGet {
+			writeMethodNotAllowed(writer, http.MethodGet)
+			return
+		}
+		if len(path) == 3 {
+			h.topOfBook(writer, path[1])
+		} else {
+			h.bookDepth(writer, request, path[1])
+		}
+	case len(path) == 1 && path[0] == "marketdata":
+		if request.Method != http.MethodGet {
+			writeMethodNotAllowed(writer, http.MethodGet)
+			return
+		}
+		h.streamMarketData(writer, request)
+	default:
+		writeHTTPError(writer, http.StatusNotFound, "no such resource")
+	}
+}
+
+// submit: enters an order, 201 when it is accepted, 422 when the book rejects it and 409 when the user
+// order id is taken by one of the user's orders still resting. The books know the order by an id of the
+// server's own, so users do not have to keep clear of each other's ids.
+func (h *HTTPServer) submit(writer http.ResponseWriter, request *http.Request, userID int) {
+	var body HTTPOrder
+	decoder := json.NewDecoder(request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		writeHTTPError(writer, http.StatusBadRequest, "invalid order: "+err.Error())
+		return
+	}
+	// the price is left to the book, 0 is a limit price like any other
+	price, err := ParsePrice(string(body.Price))
+	switch {
+	case body.UserOrderID <= 0:
+		writeHTTPError(writer, http.StatusBadRequest, "userOrderId must be a positive whole number")
+		return
+	case err != nil:
+		writeHTTPError(writer, http.StatusBadRequest, "price must be a decimal")
+		return
+	case body.Side != BUY && body.Side != SELL:
+		writeHTTPError(writer, http.StatusBadRequest, fmt.Sprintf("side must be %v or %v", BUY, SELL))
+		return
+	case body.Quantity <= 0:
+		writeHTTPError(writer, http.StatusBadRequest, "quantity must be a positive whole number")
+		return
+	case body.Symbol == "":
+		writeHTTPError(writer, http.StatusBadRequest, "symbol is required")
+		return
+	}
+	order := Order{UserID: userID, Symbol: body.Symbol, Price: price, Quantity: body.Quantity, Side: body.Side}
+	if body.ExpiresAt != nil {
+		order.ExpiresAt = *body.ExpiresAt
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	key := httpOrderKey{userID: userID, userOrderID: body.UserOrderID}
+	if _, found := h.restingOrder(key); found {
+		writeHTTPError(writer, http.StatusConflict, fmt.Sprintf("order %v is already resting", body.UserOrderID))
+		return
+	}
+	// the user's earlier order under this id is done with, its fills already carry the user's id
+	if previous, found := h.bookOrderIDs[key]; found {
+		delete(h.userOrders, previous)
+		delete(h.orderSymbols, previous)
+	}
+	h.nextBookOrderID++
+	order.UserOrderID = h.nextBookOrderID
+	h.bookOrderIDs[key] = order.UserOrderID
+	h.userOrders[order.UserOrderID] = key
+	h.orderSymbols[order.UserOrderID] = order.Symbol
+	book := h.book(order.Symbol)
+	events, err := book.Submit(order)
+	if err != nil {
+		writeHTTPError(writer, http.StatusInternalServerError, errors.Wrap(err, "error submitting order in submit()").Error())
+		return
+	}
+	h.respond(writer, http.StatusCreated, order.Symbol, order.UserOrderID, events)
+}
+
+// amend: changes the price and quantity of one of the user's resting orders
+func (h *HTTPServer) amend(writer http.ResponseWriter, request *http.Request, userID int, userOrderID int) {
+	var body HTTPAmend
+	decoder := json.NewDecoder(request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		writeHTTPError(writer, http.StatusBadRequest, "invalid amend: "+err.Error())
+		return
+	}
+	price, err := ParsePrice(string(body.Price))
+	switch {
+	case err != nil:
+		writeHTTPError(writer, http.StatusBadRequest, "price must be a decimal")
+		return
+	case body.Quantity <= 0:
+		writeHTTPError(writer, http.StatusBadRequest, "quantity must be a positive whole number")
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	resting, found := h.restingOrder(httpOrderKey{userID: userID, userOrderID: userOrderID})
+	if !found {
+		writeHTTPError(writer, http.StatusNotFound, fmt.Sprintf("user %v has no resting order %v", userID, userOrderID))
+		return
+	}
+	events, err := h.books[resting.Symbol].Amend(userID, resting.UserOrderID, price, body.Quantity)
+	if err != nil {
+		writeHTTPError(writer, http.StatusInternalServerError, errors.Wrap(err, "error amending order in amend()").Error())
+		return
+	}
+	h.respond(writer, http.StatusOK, resting.Symbol, resting.UserOrderID, events)
+}
+
+// cancel: takes one of the user's resting orders out of its book
+func (h *HTTPServer) cancel(writer http.ResponseWriter, userID int, userOrderID int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	resting, found := h.restingOrder(httpOrderKey{userID: userID, userOrderID: userOrderID})
+	if !found {
+		writeHTTPError(writer, http.StatusNotFound, fmt.Sprintf("user %v has no resting order %v", userID, userOrderID))
+		return
+	}
+	events, err := h.books[resting.Symbol].Cancel(userID, resting.UserOrderID)
+	if err != nil {
+		writeHTTPError(writer, http.StatusInternalServerError, errors.Wrap(err, "error cancelling order in cancel()").Error())
+		return
+	}
+	h.respond(writer, http.StatusOK, resting.Symbol, resting.UserOrderID, events)
+}
+
+// respond: records the fills among the events and answers with what became of the order, 422 when it was
+// rejected. userOrderID is the order's id in the book. The caller holds the mutex.
+func (h *HTTPServer) respond(writer http.ResponseWriter, status int, symbol string, userOrderID int, events []Event) {
+	result := HTTPResult{Status: HTTP_ACCEPTED}
+	for _, event := range events {
+		switch event.Type {
+		case EVENT_REJECT:
+			if event.Order.UserOrderID == userOrderID {
+				status = http.StatusUnprocessableEntity
+				result.Status = HTTP_REJECTED
+				result.Reason = event.Reason
+			}
+		case EVENT_TRADE:
+			for _, order := range []Order{event.Bid, event.Ask} {
+				fill := HTTPFill{
+					Sequence:    event.Sequence,
+					Timestamp:   event.Timestamp,
+					UserOrderID: h.userOrders[order.UserOrderID].userOrderID,
+					Symbol:      symbol,
+					Side:        order.Side,
+					Price:       json.Number(event.Price.String()),
+					Quantity:    event.Quantity,
+				}
+				h.fills[order.UserID] = append(h.fills[order.UserID], fill)
+				if order.UserOrderID == userOrderID {
+					result.Fills = append(result.Fills, fill)
+				}
+			}
+		}
+	}
+	if resting, found := h.books[symbol].Order(userOrderID); found {
+		order := h.httpOrder(resting)
+		result.Order = &order
+	}
+	writeJSON(writer, status, result)
+}
+
+// openOrders: the user's resting orders in every book, by symbol and then in priority order
+func (h *HTTPServer) openOrders(writer http.ResponseWriter, userID int) {
+	symbols := h.symbols()
+	orders := []HTTPOrder{}
+	h.mutex.Lock()
+	for _, symbol := range symbols {
+		book := h.books[symbol].Book()
+		for _, side := range [][]Order{book.Bids, book.Asks} {
+			for _, order := range side {
+				if order.UserID == userID {
+					orders = append(orders, h.httpOrder(order))
+				}
+			}
+		}
+	}
+	h.mutex.Unlock()
+	writeJSON(writer, http.StatusOK, orders)
+}
+
+func (h *HTTPServer) userFills(writer http.ResponseWriter, userID int) {
+	h.mutex.Lock()
+	fills := append([]HTTPFill{}, h.fills[userID]...)
+	h.mutex.Unlock()
+	writeJSON(writer, http.StatusOK, fills)
+}
+
+// bookDepth: the book's price levels, best first, at most depth of them on each side when it is given
+func (h *HTTPServer) bookDepth(writer http.ResponseWriter, request *http.Request, symbol string) {
+	depth := 0
+	if value := request.URL.Query().Get("depth"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			writeHTTPError(writer, http.StatusBadRequest, "depth must be a positive whole number")
+			return
+		}
+		depth = parsed
+	}
+	service := h.existingBook(symbol)
+	if service == nil {
+		writeHTTPError(writer, http.StatusNotFound, "no book for "+symbol)
+		return
+	}
+	book := service.Book()
+	bids, asks := book.Depth(depth)
+	writeJSON(writer, http.StatusOK, HTTPBook{Symbol: symbol, Bids: httpLevels(bids), Asks: httpLevels(asks)})
+}
+
+// topOfBook: the best level on each side, null while a side is empty
+func (h *HTTPServer) topOfBook(writer http.ResponseWriter, symbol string) {
+	service := h.existingBook(symbol)
+	if service == nil {
+		writeHTTPError(writer, http.StatusNotFound, "no book for "+symbol)
+		return
+	}
+	book := service.Book()
+	bids, asks := book.Depth(1)
+	top := HTTPTopOfBook{Symbol: symbol}
+	if levels := httpLevels(bids); len(levels) > 0 {
+		top.Bid = &levels[0]
+	}
+	if levels := httpLevels(asks); len(levels) > 0 {
+		top.Ask = &levels[0]
+	}
+	writeJSON(writer, http.StatusOK, top)
+}
+
+// book: the symbol's book, created when it is first needed. The caller holds the mutex.
+func (h *HTTPServer) book(symbol string) *OrderBookService {
+	service, found := h.books[symbol]
+	if !found {
+		service = h.newService()
+		h.books[symbol] = service
+	}
+	return service
+}
+
+func (h *HTTPServer) existingBook(symbol string) *OrderBookService {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.books[symbol]
+}
+
+func (h *HTTPServer) symbols() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	symbols := make([]string, 0, len(h.books))
+	for symbol := range h.books {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// restingOrder: the user's order as it rests in whichever book it was entered in, under its id in the book.
+// The caller holds the mutex.
+func (h *HTTPServer) restingOrder(key httpOrderKey) (Order, bool) {
+	bookOrderID, found := h.bookOrderIDs[key]
+	if !found {
+		return Order{}, false
+	}
+	return h.books[h.orderSymbols[bookOrderID]].Order(bookOrderID)
+}
+
+// httpOrder: a resting order as its user knows it. The caller holds the mutex.
+func (h *HTTPServer) httpOrder(order Order) HTTPOrder {
+	converted := HTTPOrder{
+		UserID:      order.UserID,
+		UserOrderID: h.userOrders[order.UserOrderID].userOrderID,
+		OrderID:     order.OrderID,
+		Symbol:      order.Symbol,
+		Side:        order.Side,
+		Price:       json.Number(order.Price.String()),
+		Quantity:    order.Quantity,
+	}
+	if !order.ExpiresAt.IsZero() {
+		expiresAt := order.ExpiresAt
+		converted.ExpiresAt = &expiresAt
+	}
+	return converted
+}
+
+func httpLevels(levels []DepthLevel) []HTTPLevel {
+	converted := []HTTPLevel{}
+	for _, level := range levels {
+		converted = append(converted, httpLevel(level))
+	}
+	return converted
+}
+
+func httpLevel(level DepthLevel) HTTPLevel {
+	return HTTPLevel{Price: json.Number(level.Price.String()), Quantity: level.Quantity, Orders: level.OrderCount}
+}
+
+func writeJSON(writer http.ResponseWriter, status int, body interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(body)
+}
+
+func writeHTTPError(writer http.ResponseWriter, status int, message string) {
+	writeJSON(writer, status, HTTPError{Error: message})
+}
+
+func writeMethodNotAllowed(writer http.ResponseWriter, methods ...string) {
+	writer.Header().Set("Allow", strings.Join(methods, ", "))
+	writeHTTPError(writer, http.StatusMethodNotAllowed, "method not allowed")
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestHTTPServer() *HTTPServer {
+	return NewHTTPServer(func() *OrderBookService {
+		testService := NewOrderBookService()
+		testService.IsTradingEnabled = true
+		testService.Output = ioutil.Discard
+		testService.Clock = NewSimulatedClock(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC))
+		return testService
+	})
+}
+
+func TestHTTPServer(t *testing.T) {
+	server := newTestHTTPServer()
+	// each step runs against the book the steps before it left
+	steps := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		status   int
+		expected string // the response body
+	}{
+		{"Empty book", "GET", "/books/IBM", "", 404, `{"error":"no book for IBM"}`},
+		{"Buy order", "POST", "/users/1/orders", `{"userOrderId":1,"symbol":"IBM","side":"B","price":"10","quantity":100}`, 201,
+			`{"status":"ACCEPTED","order":{"userId":1,"userOrderId":1,"orderId":1,"symbol":"IBM","side":"B","price":10,"quantity":100}}`},
+		{"Second buy order", "POST", "/users/1/orders", `{"userOrderId":2,"symbol":"IBM","side":"B","price":9.5,"quantity":50}`, 201,
+			`{"status":"ACCEPTED","order":{"userId":1,"userOrderId":2,"orderId":2,"symbol":"IBM","side":"B","price":9.5,"quantity":50}}`},
+		{"Order in another symbol", "POST", "/users/1/orders", `{"userOrderId":3,"symbol":"VAL","side":"S","price":"20","quantity":10}`, 201,
+			`{"status":"ACCEPTED","order":{"userId":1,"userOrderId":3,"orderId":1,"symbol":"VAL","side":"S","price":20,"quantity":10}}`},
+		{"User order id in use", "POST", "/users/1/orders", `{"userOrderId":1,"symbol":"IBM","side":"S","price":"10","quantity":10}`, 409,
+			`{"error":"order 1 is already resting"}`},
+		{"Invalid side", "POST", "/users/2/orders", `{"userOrderId":101,"symbol":"IBM","side":"X","price":"10","quantity":10}`, 400,
+			`{"error":"side must be B or S"}`},
+		{"Invalid price", "POST", "/users/2/orders", `{"userOrderId":101,"symbol":"IBM","side":"S","price":"1e3","quantity":10}`, 400,
+			`{"error":"price must be a decimal"}`},
+		{"Missing user order id", "POST", "/users/2/orders", `{"symbol":"IBM","side":"S","price":"10","quantity":10}`, 400,
+			`{"error":"userOrderId must be a positive whole number"}`},
+		{"Unknown field", "POST", "/users/2/orders", `{"userOrderId":101,"symbol":"IBM","side":"S","price":"10","quantity":10,"type":"market"}`, 400,
+			`{"error":"invalid order: json: unknown field \"type\""}`},
+		{"Zero quantity", "POST", "/users/2/orders", `{"userOrderId":101,"symbol":"IBM","side":"S","price":"10","quantity":0}`, 400,
+			`{"error":"quantity must be a positive whole number"}`},
+		{"Negative quantity", "POST", "/users/2/orders", `{"userOrderId":101,"symbol":"IBM","side":"S","price":"10","quantity":-5}`, 400,
+			`{"error":"quantity must be a positive whole number"}`},
+		{"Malformed body", "POST", "/users/2/orders", `{"userOrderId":`, 400, `{"error":"invalid order: unexpected EOF"}`},
+		{"Already expired", "POST", "/users/2/orders", `{"userOrderId":102,"symbol":"IBM","side":"S","price":"11","quantity":10,"expiresAt":"2000-01-01T00:00:00Z"}`, 422,
+			`{"status":"REJECTED","reason":"ALREADY_EXPIRED"}`},
+		{"Partial fill", "POST", "/users/2/orders", `{"userOrderId":101,"symbol":"IBM","side":"S","price":"10","quantity":30}`, 201,
+			`{"status":"ACCEPTED","fills":[{"sequence":6,"timestamp":"2020-01-02T00:00:00Z","userOrderId":101,"symbol":"IBM","side":"S","price":10,"quantity":30}]}`},
+		{"Top of book", "GET", "/books/IBM/top", "", 200,
+			`{"symbol":"IBM","bid":{"price":10,"quantity":70,"orders":1},"ask":null}`},
+		{"Depth", "GET", "/books/IBM?depth=5", "", 200,
+			`{"symbol":"IBM","bids":[{"price":10,"quantity":70,"orders":1},{"price":9.5,"quantity":50,"orders":1}],"asks":[]}`},
+		{"Invalid depth", "GET", "/books/IBM?depth=-1", "", 400, `{"error":"depth must be a positive whole number"}`},
+		{"Amend", "PATCH", "/users/1/orders/2", `{"price":"9.75","quantity":40}`, 200,
+			`{"status":"ACCEPTED","order":{"userId":1,"userOrderId":2,"orderId":4,"symbol":"IBM","side":"B","price":9.75,"quantity":40}}`},
+		{"Amend to no quantity", "PATCH", "/users/1/orders/2", `{"price":"9.75","quantity":0}`, 400,
+			`{"error":"quantity must be a positive whole number"}`},
+		{"Amend with an unknown field", "PATCH", "/users/1/orders/2", `{"price":"9.75","quantity":40,"side":"S"}`, 400,
+			`{"error":"invalid amend: json: unknown field \"side\""}`},
+		{"Amend another user's order", "PATCH", "/users/2/orders/2", `{"price":"9.75","quantity":40}`, 404,
+			`{"error":"user 2 has no resting order 2"}`},
+		{"Cancel", "DELETE", "/users/1/orders/1", "", 200, `{"status":"ACCEPTED"}`},
+		{"Cancel again", "DELETE", "/users/1/orders/1", "", 404, `{"error":"user 1 has no resting order 1"}`},
+		{"Open orders", "GET", "/users/1/orders", "", 200,
+			`[{"userId":1,"userOrderId":2,"orderId":4,"symbol":"IBM","side":"B","price":9.75,"quantity":40},` +
+				`{"userId":1,"userOrderId":3,"orderId":1,"symbol":"VAL","side":"S","price":20,"quantity":10}]`},
+		{"Fills", "GET", "/users/1/fills", "", 200,
+			`[{"sequence":6,"timestamp":"2020-01-02T00:00:00Z","userOrderId":1,"symbol":"IBM","side":"B","price":10,"quantity":30}]`},
+		{"No fills", "GET", "/users/3/fills", "", 200, `[]`},
+		{"User order id another user has resting", "POST", "/users/2/orders", `{"userOrderId":2,"symbol":"IBM","side":"S","price":"12","quantity":5}`, 201,
+			`{"status":"ACCEPTED","order":{"userId":2,"userOrderId":2,"orderId":5,"symbol":"IBM","side":"S","price":12,"quantity":5}}`},
+		{"Cancel of the other user's order with the same id", "DELETE", "/users/2/orders/2", "", 200, `{"status":"ACCEPTED"}`},
+		{"Open orders after the other user's cancel", "GET", "/users/1/orders", "", 200,
+			`[{"userId":1,"userOrderId":2,"orderId":4,"symbol":"IBM","side":"B","price":9.75,"quantity":40},` +
+				`{"userId":1,"userOrderId":3,"orderId":1,"symbol":"VAL","side":"S","price":20,"quantity":10}]`},
+		{"Zero price", "POST", "/users/3/orders", `{"userOrderId":1,"symbol":"ZRO","side":"S","price":"0","quantity":10}`, 201,
+			`{"status":"ACCEPTED","order":{"userId":3,"userOrderId":1,"orderId":1,"symbol":"ZRO","side":"S","price":0,"quantity":10}}`},
+		{"Wrong method", "PUT", "/users/1/orders", "", 405, `{"error":"method not allowed"}`},
+		{"Unknown resource", "GET", "/orders", "", 404, `{"error":"no such resource"}`},
+	}
+
+	for _, step := range steps {
+		request := httptest.NewRequest(step.method, step.path, strings.NewReader(step.body))
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, request)
+		body := strings.TrimSpace(recorder.Body.String())
+		if recorder.Code != step.status {
+			t.Errorf("Expected status %v, received %v for test %s", step.status, recorder.Code, step.name)
+		}
+		if step.status == http.StatusMethodNotAllowed && recorder.Header().Get("Allow") == "" {
+			t.Errorf("Expected an Allow header for test %s", step.name)
+		}
+		if body != step.expected {
+			t.Errorf("Expected %s, received %s for test %s", step.expected, body, step.name)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package service
+
+import "github.com/pkg/errors"
+
+// validateOrder: checks a new order against the definition of its instrument and returns the price it
+// would enter the book at with the reason it has to be rejected, an empty string when it can go on to the
+// book. The order itself is left alone. The price is brought to the instrument's scale, so every price in
+// a book shares one scale. A service without instrument definitions accepts every symbol and drops the
+// trailing zeros of the price instead, so 10 and 10.0 are one price that always prints the same way.
+func (o *OrderBookService) validateOrder(order Order) (Price, string) {
+	if len(o.Instruments) == 0 {
+		return order.Price.Normalize(), ""
+	}
+	instrument, ok := o.Instruments[order.Symbol]
+	if !ok {
+		return order.Price, UNKNOWN_SYMBOL
+	}
+	price, err := order.Price.Rescale(instrument.PriceScale)
+	if errors.Cause(err) == ErrPriceOverflow {
+		return order.Price, PRICE_OVERFLOW
+	} else if err != nil {
+		return order.Price, INVALID_TICK
+	}
+	if !instrument.TickSize.IsZero() && price.Units%instrument.TickSize.Units != 0 {
+		return price, INVALID_TICK
+	}
+	if instrument.LotSize > 0 && order.Quantity%instrument.LotSize != 0 {
+		return price, INVALID_LOT
+	}
+	if order.Quantity < instrument.MinimumQuantity || order.Quantity <= 0 {
+		return price, BELOW_MIN_QTY
+	}
+	if instrument.MaximumQuantity > 0 && order.Quantity > instrument.MaximumQuantity {
+		return price, ABOVE_MAX_QTY
+	}
+	if (!instrument.MinimumPrice.IsZero() && price.Cmp(instrument.MinimumPrice) < 0) ||
+		(!instrument.MaximumPrice.IsZero() && price.Cmp(instrument.MaximumPrice) > 0) {
+		return price, OUTSIDE_PRICE_BAND
+	}
+	return price, ""
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNewOrderInstrumentValidation(t *testing.T) {
+	testService := NewOrderBookService()
+	testService.Instruments = map[string]Instrument{
+		"IBM": {Symbol: "IBM", TickSize: intPrice(5), LotSize: 100, MinimumQuantity: 100, MaximumQuantity: 1000, MinimumPrice: intPrice(50), MaximumPrice: intPrice(200)},
+		"EUR": {Symbol: "EUR", PriceScale: 2, TickSize: NewPrice(5, 2), LotSize: 1},
+	}
+	tests := map[string]struct {
+		order  *Order
+		output string
+	}{
+		"Valid order": {
+			order:  &Order{UserID: 1, UserOrderID: 1, Symbol: "IBM", Price: intPrice(100), Quantity: 200, Side: BUY},
+			output: "A, 1, 1",
+		},
+		"Unknown symbol": {
+			order:  &Order{UserID: 1, UserOrderID: 2, Symbol: "AAPL", Price: intPrice(100), Quantity: 200, Side: BUY},
+			output: "R, 1, 2, UNKNOWN_SYMBOL",
+		},
+		"Price off tick": {
+			order:  &Order{UserID: 1, UserOrderID: 3, Symbol: "IBM", Price: intPrice(101), Quantity: 200, Side: BUY},
+			output: "R, 1, 3, INVALID_TICK",
+		},
+		"Quantity off lot": {
+			order:  &Order{UserID: 1, UserOrderID: 4, Symbol: "IBM", Price: intPrice(100), Quantity: 250, Side: SELL},
+			output: "R, 1, 4, INVALID_LOT",
+		},
+		"Quantity below minimum": {
+			order:  &Order{UserID: 1, UserOrderID: 5, Symbol: "IBM", Price: intPrice(100), Quantity: 0, Side: SELL},
+			output: "R, 1, 5, BELOW_MIN_QTY",
+		},
+		"Quantity above maximum": {
+			order:  &Order{UserID: 1, UserOrderID: 6, Symbol: "IBM", Price: intPrice(100), Quantity: 1100, Side: SELL},
+			output: "R, 1, 6, ABOVE_MAX_QTY",
+		},
+		"Price outside band": {
+			order:  &Order{UserID: 1, UserOrderID: 7, Symbol: "IBM", Price: intPrice(205), Quantity: 100, Side: SELL},
+			output: "R, 1, 7, OUTSIDE_PRICE_BAND",
+		},
+		"Decimal price on tick": {
+			order:  &Order{UserID: 1, UserOrderID: 8, Symbol: "EUR", Price: NewPrice(103, 1), Quantity: 10, Side: BUY},
+			output: "A, 1, 8",
+		},
+		"Decimal price off tick": {
+			order:  &Order{UserID: 1, UserOrderID: 9, Symbol: "EUR", Price: NewPrice(1027, 2), Quantity: 10, Side: BUY},
+			output: "R, 1, 9, INVALID_TICK",
+		},
+		"Decimal price finer than the instrument scale": {
+			order:  &Order{UserID: 1, UserOrderID: 10, Symbol: "EUR", Price: NewPrice(10255, 3), Quantity: 10, Side: BUY},
+			output: "R, 1, 10, INVALID_TICK",
+		},
+		"Price overflows at the instrument scale": {
+			order:  &Order{UserID: 1, UserOrderID: 11, Symbol: "EUR", Price: intPrice(math.MaxInt64), Quantity: 10, Side: BUY},
+			output: "R, 1, 11, PRICE_OVERFLOW",
+		},
+	}
+
+	for name, test := range tests {
+		testService.orderBook = NewOrderBook()
+		events, err := testService.newOrder(test.order)
+		output := eventOutput(events)
+		if err != nil {
+			t.Errorf("Expected no error, received %s for test %s", err, name)
+		}
+		if output != test.output {
+			t.Errorf("Expected output %s, received %s for test %s", test.output, output, name)
+		}
+	}
+}
+
+func TestValidateOrderPrice(t *testing.T) {
+	tests := map[string]struct {
+		instruments map[string]Instrument
+		price       Price
+		expected    Price
+		reason      string
+	}{
+		"Trailing zeros are dropped without instruments": {price: NewPrice(1000, 2), expected: intPrice(10)},
+		"Decimals are kept without instruments":          {price: NewPrice(-1050, 3), expected: NewPrice(-105, 2)},
+		"Zero without instruments":                       {price: NewPrice(0, 4), expected: intPrice(0)},
+		"Price brought to the instrument scale": {
+			instruments: map[string]Instrument{"EUR": {Symbol: "EUR", PriceScale: 2}},
+			price:       NewPrice(103, 1),
+			expected:    NewPrice(1030, 2),
+		},
+		"Rejected price is not rescaled": {
+			instruments: map[string]Instrument{"EUR": {Symbol: "EUR", PriceScale: 2, MaximumPrice: intPrice(5)}},
+			price:       NewPrice(103, 1),
+			expected:    NewPrice(103, 1),
+			reason:      OUTSIDE_PRICE_BAND,
+		},
+	}
+
+	for name, test := range tests {
+		testService := NewOrderBookService()
+		testService.Instruments = test.instruments
+		order := Order{UserID: 1, UserOrderID: 1, Symbol: "EUR", Price: test.price, Quantity: 10, Side: BUY}
+		if _, reason := testService.validateOrder(order); reason != test.reason {
+			t.Errorf("Expected reason %q, received %q for test %s", test.reason, reason, name)
+		}
+		if _, reason := testService.checkNewOrder(&order); reason != test.reason {
+			t.Errorf("Expected reason %q from checkNewOrder, received %q for test %s", test.reason, reason, name)
+		}
+		if order.Price != test.expected {
+			t.Errorf("Expected price %#v, received %#v for test %s", test.expected, order.Price, name)
+		}
+	}
+}
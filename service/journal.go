@@ -0,0 +1,237 @@
+package service
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The journal starts with an 8 byte header, the magic bytes and the format version. Every record after
+// it is laid out little-endian as
+//
+// length (uint32) | checksum (uint32) | sequence (uint64) | timestamp (int64) | command
+//
+// length covers everything after the checksum and the CRC-32 checksum covers the same bytes. The
+// timestamp is when the command was received in Unix nanoseconds, so a replay sees the same times, and
+// the command is the line of input it was parsed from.
+const (
+	journalMagic        = "OBJL"
+	journalHeaderSize   = 8
+	journalRecordHeader = 8
+	journalRecordFixed  = 16 // sequence and timestamp
+	journalMaxRecord    = 1 << 20
+)
+
+// OpenJournal: opens the journal at path for appending, creating it if needed. A damaged tail left by a
+// crash is cut off so new records follow straight on from the last intact one.
+func OpenJournal(path string, syncPolicy string, batchSize int) (*Journal, error) {
+	if syncPolicy != FSYNC_ALWAYS && syncPolicy != FSYNC_BATCH && syncPolicy != FSYNC_NEVER {
+		return nil, errors.Errorf("unknown fsync policy %v in OpenJournal()", syncPolicy)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening journal in OpenJournal()")
+	}
+	scan, err := ReadJournal(file)
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrapf(err, "error reading journal %v in OpenJournal()", path)
+	}
+
+	validSize := scan.ValidSize
+	if validSize == 0 {
+		// a new journal, or one whose header never made it to disk
+		if err := writeJournalHeader(file); err != nil {
+			file.Close()
+			return nil, errors.Wrap(err, "error writing journal header in OpenJournal()")
+		}
+		validSize = journalHeaderSize
+	}
+	if err := file.Truncate(validSize); err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "error truncating damaged journal tail in OpenJournal()")
+	}
+	if _, err := file.Seek(validSize, io.SeekStart); err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "error seeking to journal end in OpenJournal()")
+	}
+
+	journal := &Journal{SyncPolicy: syncPolicy, BatchSize: batchSize, file: file}
+	if len(scan.Records) > 0 {
+		journal.Sequence = scan.Records[len(scan.Records)-1].Sequence
+	}
+	return journal, nil
+}
+
+// Append: writes a command to the journal and syncs it according to the fsync policy
+func (j *Journal) Append(command Order) error {
+	payload := []byte(NewParserService().FormatCommand(command))
+	record := make([]byte, journalRecordHeader+journalRecordFixed+len(payload))
+	binary.LittleEndian.PutUint64(record[journalRecordHeader:], uint64(j.Sequence+1))
+	binary.LittleEndian.PutUint64(record[journalRecordHeader+8:], uint64(command.Timestamp.UnixNano()))
+	copy(record[journalRecordHeader+journalRecordFixed:], payload)
+	body := record[journalRecordHeader:]
+	binary.LittleEndian.PutUint32(record[0:], uint32(len(body)))
+	binary.LittleEndian.PutUint32(record[4:], crc32.ChecksumIEEE(body))
+
+	if _, err := j.file.Write(record); err != nil {
+		return errors.Wrap(err, "error writing journal record in Append()")
+	}
+	j.Sequence++
+	j.unsynced++
+	if j.SyncPolicy == FSYNC_ALWAYS || (j.SyncPolicy == FSYNC_BATCH && j.unsynced >= j.BatchSize) {
+		return j.Sync()
+	}
+	return nil
+}
+
+// Sync: flushes every record written so far to stable storage
+func (j *Journal) Sync() error {
+	if j.unsynced == 0 {
+		return nil
+	}
+	if err := j.file.Sync(); err != nil {
+		return errors.Wrap(err, "error syncing journal in Sync()")
+	}
+	j.unsynced = 0
+	return nil
+}
+
+// Close: syncs any outstanding records whatever the policy and closes the journal
+func (j *Journal) Close() error {
+	if err := j.Sync(); err != nil {
+		j.file.Close()
+		return errors.Wrap(err, "error syncing journal in Close()")
+	}
+	if err := j.file.Close(); err != nil {
+		return errors.Wrap(err, "error closing journal in Close()")
+	}
+	return nil
+}
+
+// ReadJournal: reads every intact record from the start of a journal. Reading stops at the first record
+// that was only partly written or fails its checksum, which is reported in the scan rather than as an
+// error, a crash while appending leaves exactly that behind.
+func ReadJournal(reader io.Reader) (JournalScan, error) {
+	var scan JournalScan
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return scan, errors.Wrap(err, "error reading journal in ReadJournal()")
+	}
+	if len(data) < journalHeaderSize {
+		if len(data) > 0 {
+			scan.Damage = TORN_RECORD
+		}
+		return scan, nil
+	}
+	if string(data[:4]) != journalMagic {
+		return scan, errors.New("not a journal file in ReadJournal()")
+	}
+	if version := binary.LittleEndian.Uint32(data[4:]); version != JOURNAL_VERSION {
+		return scan, errors.Errorf("unsupported journal version %v in ReadJournal()", version)
+	}
+
+	parser := NewParserService()
+	offset := journalHeaderSize
+	scan.ValidSize = int64(offset)
+	for offset < len(data) {
+		if len(data)-offset < journalRecordHeader {
+			scan.Damage = TORN_RECORD
+			break
+		}
+		length := int(binary.LittleEndian.Uint32(data[offset:]))
+		checksum := binary.LittleEndian.Uint32(data[offset+4:])
+		if length < journalRecordFixed || length > journalMaxRecord {
+			scan.Damage = CORRUPT_RECORD
+			break
+		}
+		if len(data)-offset-journalRecordHeader < length {
+			scan.Damage = TORN_RECORD
+			break
+		}
+		body := data[offset+journalRecordHeader : offset+journalRecordHeader+length]
+		if crc32.ChecksumIEEE(body) != checksum {
+			scan.Damage = CORRUPT_RECORD
+			break
+		}
+		sequence := int(binary.LittleEndian.Uint64(body))
+		command, err := parser.ParseCommand(string(body[journalRecordFixed:]))
+		command.Timestamp = time.Unix(0, int64(binary.LittleEndian.Uint64(body[8:]))).UTC()
+		if err != nil || sequence != len(scan.Records)+1 {
+			scan.Damage = CORRUPT_RECORD
+			break
+		}
+		scan.Records = append(scan.Records, JournalRecord{Sequence: sequence, Command: command})
+		offset += journalRecordHeader + length
+		scan.ValidSize = int64(offset)
+	}
+	return scan, nil
+}
+
+// Recover: rebuilds the book by replaying the journal at journalPath, starting from the snapshot at
+// snapshotPath when one is given. Replayed commands publish nothing, the order feed only catches up its
+// sequence numbers, and nothing is journaled again. The scan reports any damaged tail that was skipped.
+func (o *OrderBookService) Recover(journalPath string, snapshotPath string) (JournalScan, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	journalSequence := 0
+	if snapshotPath != "" {
+		snapshot, err := readSnapshotFile(snapshotPath)
+		if err != nil {
+			return JournalScan{}, errors.Wrap(err, "error reading snapshot in Recover()")
+		}
+		if err := o.restoreSnapshot(snapshot); err != nil {
+			return JournalScan{}, errors.Wrap(err, "error restoring snapshot in Recover()")
+		}
+		journalSequence = snapshot.JournalSequence
+	}
+
+	file, err := os.Open(journalPath)
+	if err != nil {
+		return JournalScan{}, errors.Wrap(err, "error opening journal in Recover()")
+	}
+	defer file.Close()
+	scan, err := ReadJournal(file)
+	if err != nil {
+		return scan, errors.Wrap(err, "error reading journal in Recover()")
+	}
+	if journalSequence > len(scan.Records) {
+		return scan, errors.Errorf("snapshot is at journal record %v but the journal ends at %v in Recover()", journalSequence, len(scan.Records))
+	}
+
+	output, journal := o.Output, o.Journal
+	o.Output, o.Journal = ioutil.Discard, nil
+	var feedWriter io.Writer
+	if o.OrderFeed != nil {
+		feedWriter = o.OrderFeed.Writer
+		o.OrderFeed.Writer = ioutil.Discard
+	}
+	defer func() {
+		o.Output, o.Journal = output, journal
+		if o.OrderFeed != nil {
+			o.OrderFeed.Writer = feedWriter
+		}
+	}()
+
+	for _, record := range scan.Records[journalSequence:] {
+		if _, err := o.processCommand(record.Command); err != nil {
+			return scan, errors.Wrapf(err, "error replaying journal record %v in Recover()", record.Sequence)
+		}
+	}
+	return scan, nil
+}
+
+func writeJournalHeader(file *os.File) error {
+	header := make([]byte, journalHeaderSize)
+	copy(header, journalMagic)
+	binary.LittleEndian.PutUint32(header[4:], JOURNAL_VERSION)
+	if _, err := file.WriteAt(header, 0); err != nil {
+		return err
+	}
+	return file.Sync()
+}
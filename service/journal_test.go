@@ -0,0 +1,172 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var journalCommands = []Order{
+	{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Symbol: "IBM", Price: intPrice(10), Quantity: 100, Side: BUY},
+	{Command: NEW_ORDER, UserID: 2, UserOrderID: 2, Symbol: "IBM", Price: NewPrice(1150, 2), Quantity: 100, Side: SELL},
+	{Command: NEW_ORDER, UserID: 2, UserOrderID: 3, Symbol: "IBM", Price: intPrice(10), Quantity: 40, Side: SELL},
+	{Command: SESSION_STATE, SessionState: HALTED},
+	{Command: CANCEL_ORDER, UserID: 2, UserOrderID: 2},
+	{Command: SESSION_STATE, SessionState: CONTINUOUS},
+	{Command: NEW_ORDER, UserID: 3, UserOrderID: 4, Symbol: "IBM", Price: intPrice(9), Quantity: 10, Side: SELL},
+}
+
+func newJournaledService(t *testing.T, path string) *OrderBookService {
+	journal, err := OpenJournal(path, FSYNC_ALWAYS, 1)
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	testService := NewOrderBookService()
+	testService.IsTradingEnabled = true
+	testService.Output = ioutil.Discard
+	testService.Clock = NewSimulatedClock(time.Unix(100, 0))
+	testService.Journal = journal
+	return testService
+}
+
+func TestJournalRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	defer os.RemoveAll(dir)
+	tests := map[string]struct {
+		snapshotAfter int // commands processed before a snapshot is taken, 0 for none
+	}{
+		"Replay the whole journal":       {},
+		"Replay on top of a snapshot":    {snapshotAfter: 4},
+		"Snapshot at the end of journal": {snapshotAfter: len(journalCommands)},
+	}
+
+	for name, test := range tests {
+		journalPath := filepath.Join(dir, name+".journal")
+		snapshotPath := ""
+		original := newJournaledService(t, journalPath)
+		if test.snapshotAfter > 0 {
+			snapshotPath = filepath.Join(dir, name+".snapshot")
+			if err := original.ProcessOrderBook(journalCommands[:test.snapshotAfter]); err != nil {
+				t.Fatalf("Expected no error, received %s for test %s", err, name)
+			}
+			if err := original.SaveSnapshot(snapshotPath); err != nil {
+				t.Fatalf("Expected no error, received %s for test %s", err, name)
+			}
+		}
+		if err := original.ProcessOrderBook(journalCommands[test.snapshotAfter:]); err != nil {
+			t.Fatalf("Expected no error, received %s for test %s", err, name)
+		}
+		if err := original.Journal.Close(); err != nil {
+			t.Fatalf("Expected no error, received %s for test %s", err, name)
+		}
+
+		recovered := NewOrderBookService()
+		recovered.IsTradingEnabled = true
+		scan, err := recovered.Recover(journalPath, snapshotPath)
+		if err != nil {
+			t.Errorf("Expected no error, received %s for test %s", err, name)
+		}
+		if len(scan.Records) != len(journalCommands) || scan.Damage != "" {
+			t.Errorf("Expected %v intact records, received %v with damage %q for test %s", len(journalCommands), len(scan.Records), scan.Damage, name)
+		}
+		if !reflect.DeepEqual(recovered.orderBook, original.orderBook) {
+			t.Errorf("Expected book %v, received %v for test %s", original.orderBook, recovered.orderBook, name)
+		}
+	}
+}
+
+func TestJournalDamagedTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	defer os.RemoveAll(dir)
+	tests := map[string]struct {
+		damage  func(data []byte) []byte
+		records int
+		result  string
+	}{
+		"Intact journal": {
+			damage:  func(data []byte) []byte { return data },
+			records: 3,
+		},
+		"Last record cut short": {
+			damage:  func(data []byte) []byte { return data[:len(data)-3] },
+			records: 2,
+			result:  TORN_RECORD,
+		},
+		"Partial record header": {
+			damage:  func(data []byte) []byte { return append(data, 1, 2, 3) },
+			records: 3,
+			result:  TORN_RECORD,
+		},
+		"Last record fails its checksum": {
+			damage: func(data []byte) []byte {
+				data[len(data)-1] ^= 0xFF
+				return data
+			},
+			records: 2,
+			result:  CORRUPT_RECORD,
+		},
+	}
+
+	for name, test := range tests {
+		path := filepath.Join(dir, name+".journal")
+		testService := newJournaledService(t, path)
+		if err := testService.ProcessOrderBook(journalCommands[:3]); err != nil {
+			t.Fatalf("Expected no error, received %s for test %s", err, name)
+		}
+		testService.Journal.Close()
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Expected no error, received %s for test %s", err, name)
+		}
+		if err := ioutil.WriteFile(path, test.damage(data), 0644); err != nil {
+			t.Fatalf("Expected no error, received %s for test %s", err, name)
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("Expected no error, received %s for test %s", err, name)
+		}
+		scan, err := ReadJournal(file)
+		file.Close()
+		if err != nil {
+			t.Errorf("Expected no error, received %s for test %s", err, name)
+		}
+		if len(scan.Records) != test.records || scan.Damage != test.result {
+			t.Errorf("Expected %v records and %q, received %v and %q for test %s", test.records, test.result, len(scan.Records), scan.Damage, name)
+		}
+		// the command comes back with the time it was received
+		expected := journalCommands[0]
+		expected.Timestamp = time.Unix(100, 0).UTC()
+		if !reflect.DeepEqual(scan.Records[0].Command, expected) {
+			t.Errorf("Expected command %v, received %v for test %s", expected, scan.Records[0].Command, name)
+		}
+
+		// reopening cuts the damaged tail off and appends after the last intact record
+		journal, err := OpenJournal(path, FSYNC_NEVER, 0)
+		if err != nil {
+			t.Fatalf("Expected no error, received %s for test %s", err, name)
+		}
+		if err := journal.Append(journalCommands[5]); err != nil {
+			t.Errorf("Expected no error, received %s for test %s", err, name)
+		}
+		journal.Close()
+		file, err = os.Open(path)
+		if err != nil {
+			t.Fatalf("Expected no error, received %s for test %s", err, name)
+		}
+		scan, err = ReadJournal(file)
+		file.Close()
+		if err != nil || scan.Damage != "" || len(scan.Records) != test.records+1 {
+			t.Errorf("Expected %v intact records after reopening, received %v with damage %q for test %s", test.records+1, len(scan.Records), scan.Damage, name)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// jsonCommandFields: the fields each command must name in JSON Lines input, others it names are ignored
+var jsonCommandFields = map[string][]string{
+	NEW_ORDER:        {"userId", "symbol", "price", "quantity", "side", "userOrderId"},
+	CANCEL_ORDER:     {"userId", "userOrderId"},
+	FLUSH_ORDER_BOOK: {},
+	SESSION_STATE:    {"state"},
+	AMEND_ORDER:      {"userId", "userOrderId", "price", "quantity"},
+	ADVANCE_CLOCK:    {"timestamp"},
+}
+
+// ParseInputFile: reads the scenarios of an input file in the parser's input format. Binary input has no
+// lines, ParseOrderBooks reads every format.
+func (p *ParserService) ParseInputFile(path string) ([][]string, error) {
+	if p.InputFormat == FORMAT_BINARY {
+		return nil, errors.Errorf("binary input %v has no lines in ParseInputFile()", path)
+	}
+	if p.InputFormat == FORMAT_JSON_LINES {
+		return p.ParseJSONLinesFile(path)
+	}
+	return p.ParseCSVFile(path)
+}
+
+// ParseJSONLinesFile: reads the scenarios of an input file with one JSON command per line. As in the CSV
+// format a scenario ends with its flush command and blank lines are skipped.
+func (p *ParserService) ParseJSONLinesFile(path string) ([][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error opening %v in ParseJSONLinesFile()", path)
+	}
+	defer file.Close()
+
+	output := [][]string{}
+	currentBookInput := []string{}
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var command struct {
+			Command string `json:"command"`
+		}
+		if err := json.Unmarshal([]byte(line), &command); err != nil {
+			return nil, errors.Wrapf(err, "error decoding line %v in ParseJSONLinesFile()", number)
+		}
+		currentBookInput = append(currentBookInput, line)
+		if command.Command == FLUSH_ORDER_BOOK {
+			output = append(output, currentBookInput)
+			currentBookInput = []string{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "error reading %v in ParseJSONLinesFile()", path)
+	}
+	return output, nil
+}
+
+// ParseJSONCommand: converts a line of JSON Lines input to the command it describes. Unknown fields are
+// rejected so a misspelt one does not go unnoticed.
+func (p *ParserService) ParseJSONCommand(line string) (Order, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return Order{}, errors.Wrap(err, "error decoding command in ParseJSONCommand()")
+	}
+	var command JSONCommand
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&command); err != nil {
+		return Order{}, errors.Wrap(err, "error decoding command in ParseJSONCommand()")
+	}
+	required, found := jsonCommandFields[command.Command]
+	if !found {
+		return Order{}, errors.Errorf("unknown command %v in ParseJSONCommand()", command.Command)
+	}
+	for _, name := range required {
+		if _, found := fields[name]; !found {
+			return Order{}, errors.Errorf("%v command is missing %v in ParseJSONCommand()", command.Command, name)
+		}
+	}
+
+	order := Order{Command: command.Command}
+	switch command.Command {
+	case NEW_ORDER, AMEND_ORDER:
+		price, err := ParsePrice(string(command.Price))
+		if err != nil {
+			return Order{}, errors.Wrapf(err, "error converting price in %v for ParseJSONCommand()", command.Command)
+		}
+		order.UserID = command.UserID
+		order.UserOrderID = command.UserOrderID
+		order.Price = price
+		order.Quantity = command.Quantity
+		if command.Command == NEW_ORDER {
+			order.Symbol = command.Symbol
+			order.Side = command.Side
+			if command.ExpiresAt != 0 {
+				order.ExpiresAt = time.Unix(0, command.ExpiresAt).UTC()
+			}
+		}
+	case CANCEL_ORDER:
+		order.UserID = command.UserID
+		order.UserOrderID = command.UserOrderID
+	case SESSION_STATE:
+		order.SessionState = command.SessionState
+	case ADVANCE_CLOCK:
+		// the command is received at the time it names, which moves the service's time forward to it
+		order.Timestamp = time.Unix(0, command.Timestamp).UTC()
+	}
+	return order, nil
+}
+
+// FormatJSONCommand: writes a command out as a line of JSON Lines input
+func (p *ParserService) FormatJSONCommand(order Order) string {
+	fields := []jsonField{{"command", order.Command}}
+	switch order.Command {
+	case NEW_ORDER:
+		fields = append(fields, jsonField{"userId", order.UserID}, jsonField{"symbol", order.Symbol}, jsonField{"price", jsonPrice(order.Price)},
+			jsonField{"quantity", order.Quantity}, jsonField{"side", order.Side}, jsonField{"userOrderId", order.UserOrderID})
+		if !order.ExpiresAt.IsZero() {
+			fields = append(fields, jsonField{"expiresAt", order.ExpiresAt.UnixNano()})
+		}
+	case CANCEL_ORDER:
+		fields = append(fields, jsonField{"userId", order.UserID}, jsonField{"userOrderId", order.UserOrderID})
+	case SESSION_STATE:
+		fields = append(fields, jsonField{"state", order.SessionState})
+	case AMEND_ORDER:
+		fields = append(fields, jsonField{"userId", order.UserID}, jsonField{"userOrderId", order.UserOrderID},
+			jsonField{"price", jsonPrice(order.Price)}, jsonField{"quantity", order.Quantity})
+	case ADVANCE_CLOCK:
+		fields = append(fields, jsonField{"timestamp", order.Timestamp.UnixNano()})
+	}
+	return formatJSONObject(fields)
+}
+
+// JSONLine: formats the event as a JSON object with the fields of its line of output named, led by its
+// sequence number and timestamp when isStamped. Events with nothing to print return an empty string.
+func (e Event) JSONLine(isStamped bool) string {
+	fields := []jsonField{{"type", e.Type}}
+	if isStamped {
+		fields = append(fields, jsonField{"sequence", e.Sequence}, jsonField{"timestamp", e.Timestamp.UnixNano()})
+	}
+	switch e.Type {
+	case EVENT_ACK, EVENT_EXPIRE:
+		fields = append(fields, jsonField{"userId", e.Order.UserID}, jsonField{"userOrderId", e.Order.UserOrderID})
+	case EVENT_REJECT:
+		fields = append(fields, jsonField{"userId", e.Order.UserID}, jsonField{"userOrderId", e.Order.UserOrderID})
+		if e.Reason != "" {
+			fields = append(fields, jsonField{"reason", e.Reason})
+		}
+	case EVENT_TOP_OF_BOOK:
+		// an empty side has no price or quantity
+		var price, quantity interface{}
+		if e.TopBook.IsSet {
+			price, quantity = jsonPrice(e.TopBook.Price), e.TopBook.Quantity
+		}
+		fields = append(fields, jsonField{"side", e.Side}, jsonField{"price", price}, jsonField{"quantity", quantity})
+	case EVENT_TRADE:
+		fields = append(fields, jsonField{"bidUserId", e.Bid.UserID}, jsonField{"bidUserOrderId", e.Bid.UserOrderID},
+			jsonField{"askUserId", e.Ask.UserID}, jsonField{"askUserOrderId", e.Ask.UserOrderID},
+			jsonField{"price", jsonPrice(e.Price)}, jsonField{"quantity", e.Quantity})
+	case EVENT_SESSION:
+		fields = append(fields, jsonField{"state", e.State})
+	case EVENT_HALT:
+		fields = append(fields, jsonField{"price", jsonPrice(e.Price)}, jsonField{"reference", jsonPrice(e.Reference)})
+	case EVENT_SELF_TRADE:
+		fields = append(fields, jsonField{"userId", e.Order.UserID}, jsonField{"userOrderId", e.Order.UserOrderID},
+			jsonField{"quantity", e.Quantity}, jsonField{"remaining", e.Order.Quantity}, jsonField{"mode", e.Reason})
+	case EVENT_DEPTH:
+		fields = append(fields, jsonField{"side", e.Side}, jsonField{"action", e.Action}, jsonField{"price", jsonPrice(e.Level.Price)},
+			jsonField{"quantity", e.Level.Quantity}, jsonField{"orders", e.Level.OrderCount})
+	default:
+		return ""
+	}
+	return formatJSONObject(fields)
+}
+
+// formatJSONObject: a JSON object with its fields in the order given, which encoding a map would lose
+func formatJSONObject(fields []jsonField) string {
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for i, field := range fields {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		name, _ := json.Marshal(field.name)
+		value, _ := json.Marshal(field.value)
+		buffer.Write(name)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+	buffer.WriteByte('}')
+	return buffer.String()
+}
+
+// jsonPrice: a price as a JSON number with all of its decimal places
+func jsonPrice(price Price) json.Number {
+	return json.Number(price.String())
+}
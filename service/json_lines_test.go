@@ -0,0 +1,139 @@
+package service
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseJSONCommand(t *testing.T) {
+	tests := map[string]struct {
+		line     string
+		expected Order
+		isError  bool
+	}{
+		"New order": {
+			line:     `{"command":"N","userId":1,"symbol":"IBM","price":10.25,"quantity":100,"side":"B","userOrderId":3}`,
+			expected: Order{Command: NEW_ORDER, UserID: 1, Symbol: "IBM", Price: NewPrice(1025, 2), Quantity: 100, Side: BUY, UserOrderID: 3},
+		},
+		"Fields in any order and a quoted price": {
+			line:     `{"side":"S","userOrderId":4,"quantity":5,"price":"-0.5","symbol":"IBM","userId":0,"command":"N","expiresAt":1000}`,
+			expected: Order{Command: NEW_ORDER, Symbol: "IBM", Price: NewPrice(-5, 1), Quantity: 5, Side: SELL, UserOrderID: 4, ExpiresAt: time.Unix(0, 1000).UTC()},
+		},
+		"Cancel":          {line: `{"command":"C","userId":1,"userOrderId":3}`, expected: Order{Command: CANCEL_ORDER, UserID: 1, UserOrderID: 3}},
+		"Flush":           {line: `{"command":"F"}`, expected: Order{Command: FLUSH_ORDER_BOOK}},
+		"Session state":   {line: `{"command":"P","state":"HALTED"}`, expected: Order{Command: SESSION_STATE, SessionState: HALTED}},
+		"Amend":           {line: `{"command":"M","userId":1,"userOrderId":3,"price":11,"quantity":50}`, expected: Order{Command: AMEND_ORDER, UserID: 1, UserOrderID: 3, Price: NewPrice(11, 0), Quantity: 50}},
+		"Advance clock":   {line: `{"command":"K","timestamp":2000}`, expected: Order{Command: ADVANCE_CLOCK, Timestamp: time.Unix(0, 2000).UTC()}},
+		"Missing field":   {line: `{"command":"N","userId":1,"symbol":"IBM","price":10,"side":"B","userOrderId":3}`, isError: true},
+		"Unknown field":   {line: `{"command":"C","userId":1,"userOrderID":3}`, isError: true},
+		"Unknown command": {line: `{"command":"X"}`, isError: true},
+		"Invalid price":   {line: `{"command":"M","userId":1,"userOrderId":3,"price":"ten","quantity":50}`, isError: true},
+		"Not an object":   {line: `["N", 1]`, isError: true},
+	}
+
+	parserService := NewParserService()
+	for name, test := range tests {
+		order, err := parserService.ParseJSONCommand(test.line)
+		if (err != nil) != test.isError {
+			t.Errorf("Expected error %v, received %v for test %s", test.isError, err, name)
+		}
+		if !test.isError && !reflect.DeepEqual(order, test.expected) {
+			t.Errorf("Expected %+v, received %+v for test %s", test.expected, order, name)
+		}
+	}
+}
+
+func TestJSONLinesInput(t *testing.T) {
+	csv := []string{
+		"N, 1, IBM, 10, 100, B, 1",
+		"N, 2, IBM, 10.5, 100, S, 2, 5000",
+		"M, 1, 1, 11, 50",
+		"C, 2, 2",
+		"F",
+		"P, HALTED",
+		"K, 7000",
+		"F",
+	}
+	parserService := NewParserService()
+	var jsonLines []string
+	for _, line := range csv {
+		order, err := parserService.ParseCommand(line)
+		if err != nil {
+			t.Fatalf("Expected no error, received %s", err)
+		}
+		jsonLines = append(jsonLines, parserService.FormatJSONCommand(order))
+	}
+	file, err := ioutil.TempFile("", "commands*.jsonl")
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(strings.Join(jsonLines, "\n\n"))
+	file.Close()
+
+	csvBooks, _ := parserService.TransformOrderBookListData([][]string{csv[:5], csv[5:]})
+	parserService.InputFormat = FORMAT_JSON_LINES
+	listData, err := parserService.ParseInputFile(file.Name())
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	jsonBooks, err := parserService.TransformOrderBookListData(listData)
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	if !reflect.DeepEqual(jsonBooks, csvBooks) {
+		t.Errorf("Expected the scenarios of the CSV lines %v, received %v", csvBooks, jsonBooks)
+	}
+}
+
+func TestJSONLinesOutput(t *testing.T) {
+	tests := map[string]struct {
+		isStamped bool
+		expected  []string
+	}{
+		"Unstamped": {expected: []string{
+			`{"type":"ORDER_BOOK","orderBook":1}`,
+			`{"type":"A","userId":1,"userOrderId":1}`,
+			`{"type":"B","side":"B","price":10,"quantity":100}`,
+			`{"type":"A","userId":2,"userOrderId":2}`,
+			`{"type":"T","bidUserId":1,"bidUserOrderId":1,"askUserId":2,"askUserOrderId":2,"price":10,"quantity":100}`,
+			`{"type":"B","side":"B","price":null,"quantity":null}`,
+			`{"type":"R","userId":3,"userOrderId":3,"reason":"UNKNOWN_ORDER"}`,
+		}},
+		"Stamped": {isStamped: true, expected: []string{
+			`{"type":"ORDER_BOOK","orderBook":1}`,
+			`{"type":"A","sequence":1,"timestamp":0,"userId":1,"userOrderId":1}`,
+			`{"type":"B","sequence":2,"timestamp":0,"side":"B","price":10,"quantity":100}`,
+			`{"type":"A","sequence":3,"timestamp":0,"userId":2,"userOrderId":2}`,
+			`{"type":"T","sequence":4,"timestamp":0,"bidUserId":1,"bidUserOrderId":1,"askUserId":2,"askUserOrderId":2,"price":10,"quantity":100}`,
+			`{"type":"B","sequence":5,"timestamp":0,"side":"B","price":null,"quantity":null}`,
+			`{"type":"R","sequence":6,"timestamp":0,"userId":3,"userOrderId":3,"reason":"UNKNOWN_ORDER"}`,
+		}},
+	}
+
+	for name, test := range tests {
+		var output bytes.Buffer
+		testService := NewOrderBookService()
+		testService.IsTradingEnabled = true
+		testService.IsOutputStamped = test.isStamped
+		testService.OutputFormat = FORMAT_JSON_LINES
+		testService.Output = &output
+		testService.Clock = NewSimulatedClock(time.Unix(0, 0))
+		err := testService.ProcessOrderBooks([][]Order{{
+			{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Symbol: "IBM", Price: NewPrice(10, 0), Quantity: 100, Side: BUY},
+			{Command: NEW_ORDER, UserID: 2, UserOrderID: 2, Symbol: "IBM", Price: NewPrice(10, 0), Quantity: 100, Side: SELL},
+			{Command: AMEND_ORDER, UserID: 3, UserOrderID: 3, Price: NewPrice(10, 0), Quantity: 1},
+		}})
+		if err != nil {
+			t.Fatalf("Expected no error, received %s for test %s", err, name)
+		}
+		if received := strings.Split(strings.TrimSpace(output.String()), "\n"); !reflect.DeepEqual(received, test.expected) {
+			t.Errorf("Expected %v, received %v for test %s", strings.Join(test.expected, "\n"), output.String(), name)
+		}
+	}
+}
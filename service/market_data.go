@@ -0,0 +1,323 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var marketDataUpgrader = websocket.Upgrader{
+	// market data is public, dashboards can be served from anywhere
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// streamMarketData: upgrades the request to a WebSocket and serves market data on it until the client
+// goes away. The client sends MarketDataRequests, each subscribe is answered with a snapshot of the
+// channel and followed by its updates.
+func (h *HTTPServer) streamMarketData(writer http.ResponseWriter, request *http.Request) {
+	connection, err := marketDataUpgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		// Upgrade has already answered the request
+		return
+	}
+	session := &marketDataSession{
+		server:        h,
+		connection:    connection,
+		subscriptions: make(map[string]*marketDataSubscription),
+		ready:         make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+	go session.write()
+	defer session.close()
+
+	for {
+		_, data, err := connection.ReadMessage()
+		if err != nil {
+			return
+		}
+		var request MarketDataRequest
+		if err := json.Unmarshal(data, &request); err != nil {
+			session.sendError("invalid request: " + err.Error())
+			continue
+		}
+		switch request.Action {
+		case ACTION_SUBSCRIBE:
+			session.subscribe(request.Symbol, request.Channel)
+		case ACTION_UNSUBSCRIBE:
+			session.unsubscribe(request.Symbol, request.Channel)
+		default:
+			session.sendError(fmt.Sprintf("action must be %v or %v", ACTION_SUBSCRIBE, ACTION_UNSUBSCRIBE))
+		}
+	}
+}
+
+// subscribe: starts a channel of a symbol with a snapshot. The book is subscribed to and copied while no
+// command can run, so the updates carry on exactly where the snapshot leaves off.
+func (s *marketDataSession) subscribe(symbol string, channel string) {
+	if channel != CHANNEL_TOP && channel != CHANNEL_DEPTH && channel != CHANNEL_TRADES {
+		s.sendError(fmt.Sprintf("channel must be %v, %v or %v", CHANNEL_TOP, CHANNEL_DEPTH, CHANNEL_TRADES))
+		return
+	}
+	if symbol == "" {
+		s.sendError("symbol is required")
+		return
+	}
+
+	s.server.mutex.Lock()
+	defer s.server.mutex.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return
+	}
+	key := symbol + " " + channel
+	if _, found := s.subscriptions[key]; found {
+		s.push(marketDataQueued{message: MarketDataMessage{Type: MESSAGE_ERROR, Symbol: symbol, Channel: channel, Error: "already subscribed"}})
+		return
+	}
+	service := s.server.book(symbol)
+	if channel == CHANNEL_DEPTH && service.DepthLevels == 0 {
+		s.push(marketDataQueued{message: MarketDataMessage{Type: MESSAGE_ERROR, Symbol: symbol, Channel: channel, Error: "no depth is published for " + symbol}})
+		return
+	}
+	events, unsubscribe := service.Subscribe(s.server.MarketDataQueueSize)
+	subscription := &marketDataSubscription{symbol: symbol, channel: channel, isActive: true, unsubscribe: unsubscribe}
+	s.subscriptions[key] = subscription
+	s.push(marketDataQueued{subscription: subscription, message: marketDataSnapshot(symbol, channel, service.Book())})
+	go s.pump(subscription, events)
+}
+
+func (s *marketDataSession) unsubscribe(symbol string, channel string) {
+	s.mutex.Lock()
+	key := symbol + " " + channel
+	subscription, found := s.subscriptions[key]
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	if !found {
+		s.push(marketDataQueued{message: MarketDataMessage{Type: MESSAGE_ERROR, Symbol: symbol, Channel: channel, Error: "not subscribed"}})
+		s.mutex.Unlock()
+		return
+	}
+	delete(s.subscriptions, key)
+	subscription.isActive = false
+	s.push(marketDataQueued{message: MarketDataMessage{Type: MESSAGE_UNSUBSCRIBED, Symbol: symbol, Channel: channel}})
+	s.mutex.Unlock()
+	subscription.unsubscribe()
+}
+
+// pump: turns the book's events into the subscription's updates. The book closes the events channel when
+// the subscription ends, or when the session fell so far behind that it dropped it, which ends the session.
+func (s *marketDataSession) pump(subscription *marketDataSubscription, events <-chan Event) {
+	for event := range events {
+		message, key, found := marketDataUpdate(subscription, event)
+		if !found {
+			continue
+		}
+		if !s.enqueue(subscription, message, key) {
+			return
+		}
+	}
+	s.mutex.Lock()
+	isDropped := subscription.isActive && !s.closed
+	s.mutex.Unlock()
+	if isDropped {
+		s.close()
+	}
+}
+
+// enqueue: queues an update for the writer, applying the slow consumer policy once the queue is full. It
+// returns false when the session is over.
+func (s *marketDataSession) enqueue(subscription *marketDataSubscription, message MarketDataMessage, key string) bool {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return false
+	}
+	if !subscription.isActive {
+		s.mutex.Unlock()
+		return true
+	}
+	policy := s.server.SlowConsumerPolicy
+	if policy == SLOW_CONSUMER_CONFLATE && key != "" && s.conflate(subscription, message, key) {
+		s.mutex.Unlock()
+		return true
+	}
+	if len(s.queue) >= s.server.MarketDataQueueSize {
+		if policy == SLOW_CONSUMER_DISCONNECT {
+			s.mutex.Unlock()
+			s.close()
+			return false
+		}
+		subscription.dropped++
+		s.mutex.Unlock()
+		return true
+	}
+	s.push(marketDataQueued{subscription: subscription, message: message, key: key})
+	s.mutex.Unlock()
+	return true
+}
+
+// conflate: merges an update into one for the same side and price still waiting to be written, so the
+// client sees the level as it ends up. A level added and deleted before the client heard of it is taken
+// out of the queue altogether. The caller holds the mutex.
+func (s *marketDataSession) conflate(subscription *marketDataSubscription, message MarketDataMessage, key string) bool {
+	for i := range s.queue {
+		queued := &s.queue[i]
+		if queued.subscription != subscription || queued.key != key {
+			continue
+		}
+		switch {
+		case queued.message.Action == LEVEL_ADD && message.Action == LEVEL_DELETE:
+			dropped := queued.dropped
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			s.carryDropped(subscription, i, dropped)
+		case queued.message.Action == LEVEL_DELETE && message.Action == LEVEL_ADD:
+			message.Action = LEVEL_CHANGE
+			queued.message = message
+		case queued.message.Action == LEVEL_ADD:
+			message.Action = LEVEL_ADD
+			queued.message = message
+		default:
+			queued.message = message
+		}
+		return true
+	}
+	return false
+}
+
+// carryDropped: hands the count of dropped messages of an update taken out of the queue to the next one
+// of its subscription, so the gap still shows. The caller holds the mutex.
+func (s *marketDataSession) carryDropped(subscription *marketDataSubscription, from int, dropped int) {
+	for i := from; i < len(s.queue); i++ {
+		if s.queue[i].subscription == subscription {
+			s.queue[i].dropped += dropped
+			return
+		}
+	}
+	subscription.dropped += dropped
+}
+
+// push: queues a message and wakes the writer, the caller holds the mutex
+func (s *marketDataSession) push(queued marketDataQueued) {
+	if queued.subscription != nil {
+		queued.dropped = queued.subscription.dropped
+		queued.subscription.dropped = 0
+	}
+	s.queue = append(s.queue, queued)
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (s *marketDataSession) sendError(message string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.push(marketDataQueued{message: MarketDataMessage{Type: MESSAGE_ERROR, Error: message}})
+}
+
+// write: writes what is queued, numbering each subscription's messages as they go out. It is the only
+// writer of the connection.
+func (s *marketDataSession) write() {
+	defer s.close()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.ready:
+		}
+		s.mutex.Lock()
+		messages := make([]MarketDataMessage, 0, len(s.queue))
+		for _, queued := range s.queue {
+			if subscription := queued.subscription; subscription != nil {
+				if !subscription.isActive {
+					continue
+				}
+				subscription.sequence += queued.dropped + 1
+				queued.message.Sequence = subscription.sequence
+			}
+			messages = append(messages, queued.message)
+		}
+		s.queue = nil
+		s.mutex.Unlock()
+
+		for _, message := range messages {
+			s.connection.SetWriteDeadline(time.Now().Add(MARKET_DATA_WRITE_TIMEOUT))
+			if err := s.connection.WriteJSON(message); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// close: ends the session and its subscriptions, it can be called more than once
+func (s *marketDataSession) close() {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.done)
+	subscriptions := s.subscriptions
+	s.subscriptions = nil
+	s.mutex.Unlock()
+	for _, subscription := range subscriptions {
+		subscription.unsubscribe()
+	}
+	s.connection.Close()
+}
+
+// marketDataSnapshot: the channel's view of the book, trades have nothing to show but the sequence they start
+func marketDataSnapshot(symbol string, channel string, book OrderBook) MarketDataMessage {
+	snapshot := MarketDataMessage{Type: MESSAGE_SNAPSHOT, Symbol: symbol, Channel: channel}
+	switch channel {
+	case CHANNEL_TOP:
+		snapshot.Bid = topLevel(book.TopBookBid)
+		snapshot.Ask = topLevel(book.TopBookAsk)
+	case CHANNEL_DEPTH:
+		snapshot.Bids = httpLevels(book.PublishedBidDepth)
+		snapshot.Asks = httpLevels(book.PublishedAskDepth)
+	}
+	return snapshot
+}
+
+// marketDataUpdate: the subscription's update for an event and the key it is conflated by, found is false
+// for events the channel does not carry
+func marketDataUpdate(subscription *marketDataSubscription, event Event) (message MarketDataMessage, key string, found bool) {
+	message = MarketDataMessage{Symbol: subscription.symbol, Channel: subscription.channel}
+	switch {
+	case subscription.channel == CHANNEL_TOP && event.Type == EVENT_TOP_OF_BOOK:
+		message.Type = MESSAGE_UPDATE
+		message.Side = event.Side
+		message.Level = topLevel(event.TopBook)
+		return message, event.Side, true
+	case subscription.channel == CHANNEL_DEPTH && event.Type == EVENT_DEPTH:
+		level := httpLevel(event.Level)
+		message.Type = MESSAGE_UPDATE
+		message.Side = event.Side
+		message.Action = event.Action
+		message.Level = &level
+		return message, event.Side + " " + event.Level.Price.String(), true
+	case subscription.channel == CHANNEL_TRADES && event.Type == EVENT_TRADE:
+		timestamp := event.Timestamp
+		message.Type = MESSAGE_TRADE
+		message.Price = json.Number(event.Price.String())
+		message.Quantity = event.Quantity
+		message.Timestamp = &timestamp
+		return message, "", true
+	}
+	return message, "", false
+}
+
+func topLevel(top TopBook) *HTTPLevel {
+	if !top.IsSet {
+		return nil
+	}
+	return &HTTPLevel{Price: json.Number(top.Price.String()), Quantity: top.Quantity}
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func startMarketData(t *testing.T) (*httptest.Server, *websocket.Conn) {
+	server := httptest.NewServer(NewHTTPServer(func() *OrderBookService {
+		testService := NewOrderBookService()
+		testService.IsTradingEnabled = true
+		testService.DepthLevels = 5
+		testService.Output = ioutil.Discard
+		testService.Clock = NewSimulatedClock(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC))
+		return testService
+	}))
+	connection, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/marketdata", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	return server, connection
+}
+
+// receiveMarketData: reads count messages and sorts them by channel, as each channel is fed on its own
+func receiveMarketData(t *testing.T, connection *websocket.Conn, count int) map[string][]string {
+	received := make(map[string][]string)
+	for i := 0; i < count; i++ {
+		connection.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, data, err := connection.ReadMessage()
+		if err != nil {
+			t.Fatalf("Expected %v messages, received %s after %v", count, err, i)
+		}
+		var message MarketDataMessage
+		json.Unmarshal(data, &message)
+		received[message.Channel] = append(received[message.Channel], strings.TrimSpace(string(data)))
+	}
+	return received
+}
+
+func TestMarketDataStream(t *testing.T) {
+	server, connection := startMarketData(t)
+	defer server.Close()
+	defer connection.Close()
+
+	post := func(userID string, body string) {
+		response, err := http.Post(server.URL+"/users/"+userID+"/orders", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Expected no error, received %s", err)
+		}
+		response.Body.Close()
+	}
+	// the book has an order before anyone subscribes, the snapshots show it
+	post("1", `{"userOrderId":1,"symbol":"IBM","side":"B","price":"10","quantity":100}`)
+	for _, channel := range []string{CHANNEL_TOP, CHANNEL_DEPTH, CHANNEL_TRADES} {
+		connection.WriteJSON(MarketDataRequest{Action: ACTION_SUBSCRIBE, Symbol: "IBM", Channel: channel})
+	}
+	receiveMarketData(t, connection, 3)
+	post("2", `{"userOrderId":2,"symbol":"IBM","side":"S","price":"10","quantity":30}`)
+	post("2", `{"userOrderId":3,"symbol":"IBM","side":"S","price":"11","quantity":20}`)
+	received := receiveMarketData(t, connection, 5)
+	connection.WriteJSON(MarketDataRequest{Action: ACTION_UNSUBSCRIBE, Symbol: "IBM", Channel: CHANNEL_TRADES})
+	connection.WriteJSON(MarketDataRequest{Action: ACTION_SUBSCRIBE, Symbol: "IBM", Channel: "quotes"})
+	for channel, messages := range receiveMarketData(t, connection, 2) {
+		received[channel] = append(received[channel], messages...)
+	}
+
+	expected := map[string][]string{
+		CHANNEL_TOP: {
+			`{"type":"update","symbol":"IBM","channel":"top","sequence":2,"side":"B","level":{"price":10,"quantity":70}}`,
+			`{"type":"update","symbol":"IBM","channel":"top","sequence":3,"side":"S","level":{"price":11,"quantity":20}}`,
+		},
+		CHANNEL_DEPTH: {
+			`{"type":"update","symbol":"IBM","channel":"depth","sequence":2,"side":"B","action":"CHANGE","level":{"price":10,"quantity":70,"orders":1}}`,
+			`{"type":"update","symbol":"IBM","channel":"depth","sequence":3,"side":"S","action":"ADD","level":{"price":11,"quantity":20,"orders":1}}`,
+		},
+		CHANNEL_TRADES: {
+			`{"type":"trade","symbol":"IBM","channel":"trades","sequence":2,"price":10,"quantity":30,"timestamp":"2020-01-02T00:00:00Z"}`,
+			`{"type":"unsubscribed","symbol":"IBM","channel":"trades"}`,
+		},
+		"": {`{"type":"error","error":"channel must be top, depth or trades"}`},
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("Expected %v, received %v", expected, received)
+	}
+}
+
+func TestMarketDataSnapshot(t *testing.T) {
+	server, connection := startMarketData(t)
+	defer server.Close()
+	defer connection.Close()
+
+	for _, body := range []string{
+		`{"userOrderId":1,"symbol":"IBM","side":"B","price":"10","quantity":100}`,
+		`{"userOrderId":2,"symbol":"IBM","side":"B","price":"9","quantity":50}`,
+		`{"userOrderId":3,"symbol":"IBM","side":"S","price":"12","quantity":10}`,
+	} {
+		response, err := http.Post(server.URL+"/users/1/orders", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Expected no error, received %s", err)
+		}
+		response.Body.Close()
+	}
+	tests := map[string]struct {
+		request  MarketDataRequest
+		expected string
+	}{
+		"Top":            {request: MarketDataRequest{ACTION_SUBSCRIBE, "IBM", CHANNEL_TOP}, expected: `{"type":"snapshot","symbol":"IBM","channel":"top","sequence":1,"bid":{"price":10,"quantity":100},"ask":{"price":12,"quantity":10}}`},
+		"Depth":          {request: MarketDataRequest{ACTION_SUBSCRIBE, "IBM", CHANNEL_DEPTH}, expected: `{"type":"snapshot","symbol":"IBM","channel":"depth","sequence":1,"bids":[{"price":10,"quantity":100,"orders":1},{"price":9,"quantity":50,"orders":1}],"asks":[{"price":12,"quantity":10,"orders":1}]}`},
+		"Empty book":     {request: MarketDataRequest{ACTION_SUBSCRIBE, "VAL", CHANNEL_TOP}, expected: `{"type":"snapshot","symbol":"VAL","channel":"top","sequence":1}`},
+		"Not subscribed": {request: MarketDataRequest{ACTION_UNSUBSCRIBE, "IBM", CHANNEL_TRADES}, expected: `{"type":"error","symbol":"IBM","channel":"trades","error":"not subscribed"}`},
+		"Unknown action": {request: MarketDataRequest{"list", "IBM", CHANNEL_TOP}, expected: `{"type":"error","error":"action must be subscribe or unsubscribe"}`},
+		"Missing symbol": {request: MarketDataRequest{ACTION_SUBSCRIBE, "", CHANNEL_TOP}, expected: `{"type":"error","error":"symbol is required"}`},
+	}
+
+	for name, test := range tests {
+		connection.WriteJSON(test.request)
+		connection.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, data, err := connection.ReadMessage()
+		if err != nil {
+			t.Fatalf("Expected a message, received %s for test %s", err, name)
+		}
+		if received := strings.TrimSpace(string(data)); received != test.expected {
+			t.Errorf("Expected %s, received %s for test %s", test.expected, received, name)
+		}
+	}
+}
+
+func TestMarketDataSlowConsumer(t *testing.T) {
+	level := func(side string, action string, price string, quantity int) MarketDataMessage {
+		return MarketDataMessage{Type: MESSAGE_UPDATE, Side: side, Action: action, Level: &HTTPLevel{Price: json.Number(price), Quantity: quantity}}
+	}
+	updates := []MarketDataMessage{
+		level(BUY, LEVEL_ADD, "10", 100),
+		level(BUY, LEVEL_ADD, "9", 50),
+		level(BUY, LEVEL_CHANGE, "10", 70),
+		level(SELL, LEVEL_ADD, "11", 20),
+		level(BUY, LEVEL_DELETE, "9", 0),
+		level(SELL, LEVEL_CHANGE, "11", 10),
+		level(BUY, LEVEL_ADD, "8", 5),
+	}
+	tests := map[string]struct {
+		policy   string
+		expected []string // what is queued: side, action, price, quantity and messages dropped before it
+		dropped  int      // dropped since the last message queued
+		isClosed bool
+	}{
+		"Drop":       {policy: SLOW_CONSUMER_DROP, expected: []string{"B ADD 10 100 0", "B ADD 9 50 0"}, dropped: 5},
+		"Conflate":   {policy: SLOW_CONSUMER_CONFLATE, expected: []string{"B ADD 10 70 0", "S CHANGE 11 10 1"}, dropped: 1},
+		"Disconnect": {policy: SLOW_CONSUMER_DISCONNECT, expected: []string{"B ADD 10 100 0", "B ADD 9 50 0"}, isClosed: true},
+	}
+
+	for name, test := range tests {
+		server, connection := startMarketData(t)
+		session := &marketDataSession{
+			server:     &HTTPServer{SlowConsumerPolicy: test.policy, MarketDataQueueSize: 2},
+			connection: connection,
+			ready:      make(chan struct{}, 1),
+			done:       make(chan struct{}),
+		}
+		subscription := &marketDataSubscription{symbol: "IBM", channel: CHANNEL_DEPTH, isActive: true, unsubscribe: func() {}}
+		for _, update := range updates {
+			if !session.enqueue(subscription, update, update.Side+" "+string(update.Level.Price)) {
+				break
+			}
+		}
+
+		var queued []string
+		for _, message := range session.queue {
+			update := message.message
+			queued = append(queued, fmt.Sprintf("%v %v %v %v %v", update.Side, update.Action, update.Level.Price, update.Level.Quantity, message.dropped))
+		}
+		if !reflect.DeepEqual(queued, test.expected) {
+			t.Errorf("Expected %v, received %v for test %s", test.expected, queued, name)
+		}
+		if subscription.dropped != test.dropped {
+			t.Errorf("Expected %v dropped, received %v for test %s", test.dropped, subscription.dropped, name)
+		}
+		if session.closed != test.isClosed {
+			t.Errorf("Expected closed %v, received %v for test %s", test.isClosed, session.closed, name)
+		}
+		session.close()
+		server.Close()
+	}
+}
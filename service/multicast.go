@@ -0,0 +1,323 @@
+package service
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Every market data packet is one UDP datagram laid out little-endian as
+//
+// version (uint8) | type (uint8) | length (uint16) | sequence (uint64) | timestamp (int64) | symbol [8]byte
+//
+// followed for top of book and trades by
+//
+// side (uint8) | isSet (uint8) | scale (uint8) | reserved (uint8) | price units (int64) | quantity (int64)
+//
+// The type is B for top of book, T for a trade and H for a heartbeat, which is the header alone and
+// carries the last sequence number published. A trade has no side and is always set. A retransmission
+// request is the first and last sequence number wanted as two uint64s, a last of 0 asks for everything
+// after the first, and is answered with the packets still kept back to back.
+const (
+	multicastHeaderSize  = 28
+	multicastPacketSize  = 48
+	multicastSymbolSize  = 8
+	multicastRequestSize = 16
+	multicastTopOfBook   = 'B'
+	multicastTrade       = 'T'
+	multicastHeartbeat   = 'H'
+)
+
+// NewMulticastPublisher: publishes the service's top of book and trades for symbol to a UDP group, or
+// any UDP address, from now on. Serve answers retransmission requests.
+func NewMulticastPublisher(service *OrderBookService, symbol string, groupAddress string) (*MulticastPublisher, error) {
+	if len(symbol) > multicastSymbolSize {
+		return nil, errors.Errorf("symbol %v is longer than %v bytes in NewMulticastPublisher()", symbol, multicastSymbolSize)
+	}
+	address, err := net.ResolveUDPAddr("udp", groupAddress)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error resolving %v in NewMulticastPublisher()", groupAddress)
+	}
+	connection, err := net.DialUDP("udp", nil, address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error connecting to %v in NewMulticastPublisher()", groupAddress)
+	}
+	publisher := &MulticastPublisher{
+		Symbol:            symbol,
+		HeartbeatInterval: MULTICAST_HEARTBEAT_INTERVAL,
+		service:           service,
+		writer:            connection,
+		connection:        connection,
+		packets:           make([][]byte, RETRANSMIT_BUFFER_SIZE),
+		done:              make(chan struct{}),
+	}
+	events, unsubscribe := service.Subscribe(MARKET_DATA_QUEUE_SIZE)
+	publisher.unsubscribe = unsubscribe
+	publisher.wait.Add(1)
+	go publisher.pump(events)
+	return publisher, nil
+}
+
+// ListenAndServe: answers retransmission requests on a TCP address until Close is called
+func (p *MulticastPublisher) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return errors.Wrapf(err, "error listening on %v in ListenAndServe()", address)
+	}
+	return p.Serve(listener)
+}
+
+// Serve: sends heartbeats and answers retransmission requests on listener until Close is called
+func (p *MulticastPublisher) Serve(listener net.Listener) error {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		listener.Close()
+		return nil
+	}
+	p.listener = listener
+	if p.HeartbeatInterval > 0 {
+		p.wait.Add(1)
+		go p.heartbeat()
+	}
+	p.mutex.Unlock()
+
+	for {
+		connection, err := listener.Accept()
+		if err != nil {
+			p.mutex.Lock()
+			closed := p.closed
+			p.mutex.Unlock()
+			if closed {
+				return nil
+			}
+			return errors.Wrap(err, "error accepting connection in Serve()")
+		}
+		p.wait.Add(1)
+		go p.retransmit(connection)
+	}
+}
+
+// Close: stops publishing and serving retransmissions
+func (p *MulticastPublisher) Close() error {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	listener, unsubscribe := p.listener, p.unsubscribe
+	p.mutex.Unlock()
+
+	unsubscribe()
+	if listener != nil {
+		listener.Close()
+	}
+	p.wait.Wait()
+	return p.connection.Close()
+}
+
+// pump: publishes the events of the book. When the book drops the subscription for falling behind it is
+// taken out again and the top of book as it stands is published, so receivers end up with the right one.
+func (p *MulticastPublisher) pump(events <-chan Event) {
+	defer p.wait.Done()
+	for {
+		for event := range events {
+			switch event.Type {
+			case EVENT_TOP_OF_BOOK:
+				p.publish(MulticastPacket{Type: EVENT_TOP_OF_BOOK, Timestamp: event.Timestamp, Side: event.Side, TopBook: event.TopBook})
+			case EVENT_TRADE:
+				p.publish(MulticastPacket{Type: EVENT_TRADE, Timestamp: event.Timestamp, Price: event.Price, Quantity: event.Quantity})
+			case EVENT_FLUSH:
+				// the book was cleared without top of book updates
+				p.publish(MulticastPacket{Type: EVENT_TOP_OF_BOOK, Timestamp: event.Timestamp, Side: BUY})
+				p.publish(MulticastPacket{Type: EVENT_TOP_OF_BOOK, Timestamp: event.Timestamp, Side: SELL})
+			}
+		}
+
+		p.mutex.Lock()
+		if p.closed {
+			p.mutex.Unlock()
+			return
+		}
+		events, p.unsubscribe = p.service.Subscribe(MARKET_DATA_QUEUE_SIZE)
+		p.mutex.Unlock()
+		book := p.service.Book()
+		now := time.Now()
+		p.publish(MulticastPacket{Type: EVENT_TOP_OF_BOOK, Timestamp: now, Side: BUY, TopBook: book.TopBookBid})
+		p.publish(MulticastPacket{Type: EVENT_TOP_OF_BOOK, Timestamp: now, Side: SELL, TopBook: book.TopBookAsk})
+	}
+}
+
+// publish: numbers a packet, keeps it for retransmission and sends it. A packet lost on the way can be
+// fetched again, so a failed write is not an error.
+func (p *MulticastPublisher) publish(packet MulticastPacket) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return
+	}
+	p.sequence++
+	packet.Sequence = p.sequence
+	packet.Symbol = p.Symbol
+	data := encodeMulticastPacket(packet)
+	p.packets[p.sequence%len(p.packets)] = data
+	p.lastPublished = time.Now()
+	p.writer.Write(data)
+}
+
+// heartbeat: publishes the last sequence number when nothing else has been for an interval, so a receiver
+// finds out about packets it missed at the end of a burst
+func (p *MulticastPublisher) heartbeat() {
+	defer p.wait.Done()
+	ticker := time.NewTicker(p.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+		p.mutex.Lock()
+		if time.Since(p.lastPublished) >= p.HeartbeatInterval {
+			p.lastPublished = time.Now()
+			p.writer.Write(encodeMulticastPacket(MulticastPacket{Type: HEARTBEAT, Sequence: p.sequence, Timestamp: p.lastPublished, Symbol: p.Symbol}))
+		}
+		p.mutex.Unlock()
+	}
+}
+
+// retransmit: answers one retransmission request with the packets asked for that are still kept
+func (p *MulticastPublisher) retransmit(connection net.Conn) {
+	defer p.wait.Done()
+	defer connection.Close()
+	connection.SetDeadline(time.Now().Add(RETRANSMIT_TIMEOUT))
+	request := make([]byte, multicastRequestSize)
+	if _, err := io.ReadFull(connection, request); err != nil {
+		return
+	}
+	begin := int(binary.LittleEndian.Uint64(request))
+	end := int(binary.LittleEndian.Uint64(request[8:]))
+
+	p.mutex.Lock()
+	if end == 0 || end > p.sequence {
+		end = p.sequence
+	}
+	if oldest := p.sequence - len(p.packets) + 1; begin < oldest {
+		begin = oldest
+	}
+	if begin < 1 {
+		begin = 1
+	}
+	var data []byte
+	for sequence := begin; sequence <= end; sequence++ {
+		data = append(data, p.packets[sequence%len(p.packets)]...)
+	}
+	p.mutex.Unlock()
+	connection.Write(data)
+}
+
+func encodeMulticastPacket(packet MulticastPacket) []byte {
+	size := multicastPacketSize
+	if packet.Type == HEARTBEAT {
+		size = multicastHeaderSize
+	}
+	data := make([]byte, size)
+	data[0] = MULTICAST_VERSION
+	binary.LittleEndian.PutUint16(data[2:], uint16(size))
+	binary.LittleEndian.PutUint64(data[4:], uint64(packet.Sequence))
+	binary.LittleEndian.PutUint64(data[12:], uint64(packet.Timestamp.UnixNano()))
+	copy(data[20:multicastHeaderSize], packet.Symbol)
+	switch packet.Type {
+	case EVENT_TOP_OF_BOOK:
+		data[1] = multicastTopOfBook
+		data[28] = packet.Side[0]
+		if packet.TopBook.IsSet {
+			data[29] = 1
+		}
+		data[30] = byte(packet.TopBook.Price.Scale)
+		binary.LittleEndian.PutUint64(data[32:], uint64(packet.TopBook.Price.Units))
+		binary.LittleEndian.PutUint64(data[40:], uint64(packet.TopBook.Quantity))
+	case EVENT_TRADE:
+		data[1] = multicastTrade
+		data[29] = 1
+		data[30] = byte(packet.Price.Scale)
+		binary.LittleEndian.PutUint64(data[32:], uint64(packet.Price.Units))
+		binary.LittleEndian.PutUint64(data[40:], uint64(packet.Quantity))
+	default:
+		data[1] = multicastHeartbeat
+	}
+	return data
+}
+
+// decodeMulticastPacket: the packet in a datagram, which must hold exactly one
+func decodeMulticastPacket(data []byte) (MulticastPacket, error) {
+	if len(data) < multicastHeaderSize {
+		return MulticastPacket{}, errors.Errorf("packet of %v bytes is shorter than its header in decodeMulticastPacket()", len(data))
+	}
+	if data[0] != MULTICAST_VERSION {
+		return MulticastPacket{}, errors.Errorf("unknown packet version %v in decodeMulticastPacket()", data[0])
+	}
+	if length := int(binary.LittleEndian.Uint16(data[2:])); length != len(data) {
+		return MulticastPacket{}, errors.Errorf("packet length %v does not match its %v bytes in decodeMulticastPacket()", length, len(data))
+	}
+	packet := MulticastPacket{
+		Sequence:  int(binary.LittleEndian.Uint64(data[4:])),
+		Timestamp: time.Unix(0, int64(binary.LittleEndian.Uint64(data[12:]))).UTC(),
+		Symbol:    string(trimSymbol(data[20:multicastHeaderSize])),
+	}
+	if data[1] == multicastHeartbeat {
+		packet.Type = HEARTBEAT
+		return packet, nil
+	}
+	if data[1] != multicastTopOfBook && data[1] != multicastTrade {
+		return MulticastPacket{}, errors.Errorf("unknown packet type %q in decodeMulticastPacket()", data[1])
+	}
+	if len(data) != multicastPacketSize {
+		return MulticastPacket{}, errors.Errorf("packet of %v bytes is the wrong size in decodeMulticastPacket()", len(data))
+	}
+	price := NewPrice(int64(binary.LittleEndian.Uint64(data[32:])), int(data[30]))
+	quantity := int(binary.LittleEndian.Uint64(data[40:]))
+	if data[1] == multicastTrade {
+		packet.Type = EVENT_TRADE
+		packet.Price = price
+		packet.Quantity = quantity
+		return packet, nil
+	}
+	packet.Type = EVENT_TOP_OF_BOOK
+	packet.Side = string(data[28])
+	if data[29] == 1 {
+		packet.TopBook = TopBook{Price: price, Quantity: quantity, IsSet: true}
+	}
+	return packet, nil
+}
+
+// readMulticastPacket: reads the next packet of a retransmission
+func readMulticastPacket(reader io.Reader) (MulticastPacket, error) {
+	header := make([]byte, multicastHeaderSize, multicastPacketSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return MulticastPacket{}, err
+	}
+	length := int(binary.LittleEndian.Uint16(header[2:]))
+	if length < multicastHeaderSize || length > multicastPacketSize {
+		return MulticastPacket{}, errors.Errorf("packet length %v out of range in readMulticastPacket()", length)
+	}
+	data := header[:length]
+	if _, err := io.ReadFull(reader, data[multicastHeaderSize:]); err != nil {
+		return MulticastPacket{}, errors.Wrap(err, "error reading packet body in readMulticastPacket()")
+	}
+	return decodeMulticastPacket(data)
+}
+
+func trimSymbol(symbol []byte) []byte {
+	for i, b := range symbol {
+		if b == 0 {
+			return symbol[:i]
+		}
+	}
+	return symbol
+}
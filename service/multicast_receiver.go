@@ -0,0 +1,159 @@
+package service
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// NewMulticastReceiver: joins the group at groupAddress, or listens on it when it is not a multicast
+// address, and fetches missed packets from the publisher at retransmitAddress
+func NewMulticastReceiver(groupAddress string, retransmitAddress string) (*MulticastReceiver, error) {
+	address, err := net.ResolveUDPAddr("udp", groupAddress)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error resolving %v in NewMulticastReceiver()", groupAddress)
+	}
+	var connection *net.UDPConn
+	if address.IP.IsMulticast() {
+		connection, err = net.ListenMulticastUDP("udp", nil, address)
+	} else {
+		connection, err = net.ListenUDP("udp", address)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error joining %v in NewMulticastReceiver()", groupAddress)
+	}
+	return &MulticastReceiver{
+		connection:        connection,
+		retransmitAddress: retransmitAddress,
+		buffer:            make([]byte, multicastPacketSize+1),
+		pending:           make(map[int]MulticastPacket),
+		lost:              make(map[int]bool),
+		books:             make(map[string]MulticastBook),
+	}, nil
+}
+
+// Address: where the receiver listens, for publishing to a receiver on an address picked by the system
+func (r *MulticastReceiver) Address() string {
+	return r.connection.LocalAddr().String()
+}
+
+// Receive: the next packet in sequence, after it has been applied to its book. A packet past a gap is
+// held back while the gap is fetched from the retransmission service, and packets it no longer has are
+// counted as lost and skipped. Heartbeats are not handed out.
+func (r *MulticastReceiver) Receive(timeout time.Duration) (MulticastPacket, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		next := r.sequence + 1
+		if packet, found := r.pending[next]; found {
+			delete(r.pending, next)
+			r.apply(packet)
+			return packet, nil
+		}
+		if r.lost[next] {
+			delete(r.lost, next)
+			r.sequence = next
+			continue
+		}
+
+		r.connection.SetReadDeadline(deadline)
+		size, err := r.connection.Read(r.buffer)
+		if err != nil {
+			return MulticastPacket{}, errors.Wrap(err, "error reading packet in Receive()")
+		}
+		packet, err := decodeMulticastPacket(r.buffer[:size])
+		if err != nil {
+			continue
+		}
+		switch {
+		case packet.Type == HEARTBEAT:
+			if packet.Sequence > r.sequence {
+				if err := r.recover(next, packet.Sequence); err != nil {
+					return MulticastPacket{}, err
+				}
+			}
+		case packet.Sequence < next:
+			// a duplicate
+		case packet.Sequence == next:
+			r.apply(packet)
+			return packet, nil
+		default:
+			r.pending[packet.Sequence] = packet
+			if err := r.recover(next, packet.Sequence-1); err != nil {
+				return MulticastPacket{}, err
+			}
+		}
+	}
+}
+
+// recover: fetches the packets from begin to end. Those the publisher no longer has are marked lost.
+func (r *MulticastReceiver) recover(begin int, end int) error {
+	r.Gaps++
+	connection, err := net.DialTimeout("tcp", r.retransmitAddress, RETRANSMIT_TIMEOUT)
+	if err != nil {
+		return errors.Wrapf(err, "error connecting to %v in recover()", r.retransmitAddress)
+	}
+	defer connection.Close()
+	connection.SetDeadline(time.Now().Add(RETRANSMIT_TIMEOUT))
+	request := make([]byte, multicastRequestSize)
+	binary.LittleEndian.PutUint64(request, uint64(begin))
+	binary.LittleEndian.PutUint64(request[8:], uint64(end))
+	if _, err := connection.Write(request); err != nil {
+		return errors.Wrap(err, "error requesting retransmission in recover()")
+	}
+
+	reader := bufio.NewReader(connection)
+	for {
+		packet, err := readMulticastPacket(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "error reading retransmission in recover()")
+		}
+		if packet.Sequence > r.sequence {
+			r.pending[packet.Sequence] = packet
+		}
+	}
+	for sequence := begin; sequence <= end; sequence++ {
+		if _, found := r.pending[sequence]; !found && !r.lost[sequence] {
+			r.lost[sequence] = true
+			r.Lost++
+		}
+	}
+	return nil
+}
+
+func (r *MulticastReceiver) apply(packet MulticastPacket) {
+	r.sequence = packet.Sequence
+	book := r.books[packet.Symbol]
+	switch {
+	case packet.Type == EVENT_TOP_OF_BOOK && packet.Side == BUY:
+		book.Bid = packet.TopBook
+	case packet.Type == EVENT_TOP_OF_BOOK:
+		book.Ask = packet.TopBook
+	case packet.Type == EVENT_TRADE:
+		book.LastTradePrice = packet.Price
+		book.LastTradeQuantity = packet.Quantity
+		book.Volume += packet.Quantity
+	}
+	r.books[packet.Symbol] = book
+}
+
+// Book: the symbol's book as of the last packet handed out
+func (r *MulticastReceiver) Book(symbol string) (MulticastBook, bool) {
+	book, found := r.books[symbol]
+	return book, found
+}
+
+// Sequence: the last packet handed out or skipped
+func (r *MulticastReceiver) Sequence() int {
+	return r.sequence
+}
+
+func (r *MulticastReceiver) Close() error {
+	return r.connection.Close()
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// lossyWriter: loses the packets with the given sequence numbers on their first publication
+type lossyWriter struct {
+	writer  io.Writer
+	dropped map[int]bool
+}
+
+func (l *lossyWriter) Write(data []byte) (int, error) {
+	if sequence := int(binary.LittleEndian.Uint64(data[4:])); data[1] != multicastHeartbeat && l.dropped[sequence] {
+		return len(data), nil
+	}
+	return l.writer.Write(data)
+}
+
+func TestMulticastPacketRoundTrip(t *testing.T) {
+	timestamp := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	tests := map[string]MulticastPacket{
+		"Top of book": {Type: EVENT_TOP_OF_BOOK, Sequence: 1, Timestamp: timestamp, Symbol: "IBM", Side: BUY, TopBook: TopBook{Price: NewPrice(1025, 2), Quantity: 100, IsSet: true}},
+		"Empty side":  {Type: EVENT_TOP_OF_BOOK, Sequence: 2, Timestamp: timestamp, Symbol: "IBM", Side: SELL},
+		"Trade":       {Type: EVENT_TRADE, Sequence: 3, Timestamp: timestamp, Symbol: "VERYLONG", Price: NewPrice(-5, 1), Quantity: 7},
+		"Heartbeat":   {Type: HEARTBEAT, Sequence: 3, Timestamp: timestamp, Symbol: "IBM"},
+	}
+
+	for name, packet := range tests {
+		data := encodeMulticastPacket(packet)
+		decoded, err := decodeMulticastPacket(data)
+		if err != nil {
+			t.Errorf("Expected no error, received %s for test %s", err, name)
+		}
+		if !reflect.DeepEqual(decoded, packet) {
+			t.Errorf("Expected %+v, received %+v for test %s", packet, decoded, name)
+		}
+	}
+
+	valid := encodeMulticastPacket(tests["Trade"])
+	wrongVersion := append([]byte{}, valid...)
+	wrongVersion[0] = MULTICAST_VERSION + 1
+	for name, data := range map[string][]byte{"Truncated": valid[:multicastPacketSize-1], "Wrong version": wrongVersion, "Header only": valid[:multicastHeaderSize]} {
+		if _, err := decodeMulticastPacket(data); err == nil {
+			t.Errorf("Expected an error for test %s", name)
+		}
+	}
+}
+
+func TestMulticastGapRecovery(t *testing.T) {
+	tests := map[string]struct {
+		dropped    map[int]bool
+		bufferSize int // packets the publisher keeps
+		received   []int
+		lost       int
+		ask        TopBook
+	}{
+		"Nothing lost":              {dropped: map[int]bool{}, bufferSize: 16, received: []int{1, 2, 3, 4, 5, 6}, ask: TopBook{Price: NewPrice(11, 0), Quantity: 30, IsSet: true}},
+		"Gap and lost last packet":  {dropped: map[int]bool{2: true, 6: true}, bufferSize: 16, received: []int{1, 2, 3, 4, 5, 6}, ask: TopBook{Price: NewPrice(11, 0), Quantity: 30, IsSet: true}},
+		"Late join past the buffer": {dropped: map[int]bool{1: true, 2: true, 3: true, 4: true, 5: true}, bufferSize: 2, received: []int{5, 6}, lost: 4},
+	}
+
+	for name, test := range tests {
+		receiver, err := NewMulticastReceiver("127.0.0.1:0", "")
+		if err != nil {
+			t.Fatalf("Expected no error, received %s", err)
+		}
+		testService := NewOrderBookService()
+		testService.IsTradingEnabled = true
+		testService.Output = ioutil.Discard
+		publisher, err := NewMulticastPublisher(testService, "IBM", receiver.Address())
+		if err != nil {
+			t.Fatalf("Expected no error, received %s", err)
+		}
+		publisher.mutex.Lock()
+		publisher.writer = &lossyWriter{writer: publisher.connection, dropped: test.dropped}
+		publisher.packets = make([][]byte, test.bufferSize)
+		publisher.mutex.Unlock()
+		publisher.HeartbeatInterval = 50 * time.Millisecond
+		listener, _ := net.Listen("tcp", "127.0.0.1:0")
+		receiver.retransmitAddress = listener.Addr().String()
+		go publisher.Serve(listener)
+
+		// top of bid, top of ask, a trade and what is left of the ask, a trade that empties the bid
+		testService.Submit(Order{UserID: 1, UserOrderID: 1, Symbol: "IBM", Price: NewPrice(10, 0), Quantity: 100, Side: BUY})
+		testService.Submit(Order{UserID: 2, UserOrderID: 2, Symbol: "IBM", Price: NewPrice(11, 0), Quantity: 50, Side: SELL})
+		testService.Submit(Order{UserID: 3, UserOrderID: 3, Symbol: "IBM", Price: NewPrice(11, 0), Quantity: 20, Side: BUY})
+		testService.Submit(Order{UserID: 4, UserOrderID: 4, Symbol: "IBM", Price: NewPrice(10, 0), Quantity: 100, Side: SELL})
+
+		var received []int
+		for receiver.Sequence() < 6 {
+			packet, err := receiver.Receive(2 * time.Second)
+			if err != nil {
+				t.Fatalf("Expected no error, received %s after %v for test %s", err, received, name)
+			}
+			received = append(received, packet.Sequence)
+		}
+		if !reflect.DeepEqual(received, test.received) {
+			t.Errorf("Expected packets %v, received %v for test %s", test.received, received, name)
+		}
+		if receiver.Lost != test.lost {
+			t.Errorf("Expected %v lost, received %v for test %s", test.lost, receiver.Lost, name)
+		}
+		if len(test.dropped) > 0 && receiver.Gaps == 0 {
+			t.Errorf("Expected the gap to be detected for test %s", name)
+		}
+		book, _ := receiver.Book("IBM")
+		expected := MulticastBook{Ask: test.ask, LastTradePrice: NewPrice(10, 0), LastTradeQuantity: 100, Volume: 120}
+		if test.lost > 0 {
+			expected.Volume = 100
+		}
+		if !reflect.DeepEqual(book, expected) {
+			t.Errorf("Expected book %+v, received %+v for test %s", expected, book, name)
+		}
+		publisher.Close()
+		receiver.Close()
+	}
+}
+
+func TestMulticastGroup(t *testing.T) {
+	group := "239.255.0.1:30101"
+	receiver, err := NewMulticastReceiver(group, "127.0.0.1:1")
+	if err != nil {
+		t.Skipf("Multicast is not available: %s", err)
+	}
+	defer receiver.Close()
+	testService := NewOrderBookService()
+	testService.Output = ioutil.Discard
+	publisher, err := NewMulticastPublisher(testService, "IBM", group)
+	if err != nil {
+		t.Skipf("Multicast is not available: %s", err)
+	}
+	defer publisher.Close()
+	if _, err := publisher.connection.Write(encodeMulticastPacket(MulticastPacket{Type: HEARTBEAT, Timestamp: time.Now()})); err != nil {
+		t.Skipf("Multicast is not available: %s", err)
+	}
+
+	testService.Submit(Order{UserID: 1, UserOrderID: 1, Symbol: "IBM", Price: NewPrice(10, 0), Quantity: 100, Side: BUY})
+	packet, err := receiver.Receive(2 * time.Second)
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	expected := TopBook{Price: NewPrice(10, 0), Quantity: 100, IsSet: true}
+	if packet.Sequence != 1 || packet.Side != BUY || packet.TopBook != expected {
+		t.Errorf("Expected the top bid as packet 1, received %+v", packet)
+	}
+}
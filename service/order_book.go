@@ -2,6 +2,8 @@ package service
 
 import (
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/pkg/errors"
 )
@@ -11,23 +13,36 @@ import (
 /////////////////////////
 
 func NewOrderBookService() *OrderBookService {
+	// ALLOCATION_ALGORITHM is a constant, an unknown name falls back to price-time
+	allocation, err := NewAllocationStrategy(ALLOCATION_ALGORITHM)
+	if err != nil {
+		allocation = PriceTimeAllocation{}
+	}
 	return &OrderBookService{
-		IsTradingEnabled: IS_TRADING_ENABLED,
+		IsTradingEnabled:    IS_TRADING_ENABLED,
+		DepthLevels:         DEPTH_LEVELS,
+		SelfTradePrevention: SELF_TRADE_PREVENTION,
+		Allocation:          allocation,
+		Output:              os.Stdout,
+		IsOutputStamped:     IS_OUTPUT_STAMPED,
+		OutputFormat:        OUTPUT_FORMAT,
+		Clock:               newClock(CLOCK_SOURCE),
+		orderBook:           NewOrderBook(),
+		CircuitBreaker: CircuitBreaker{
+			StaticBandBps:   STATIC_PRICE_BAND_BPS,
+			DynamicBandBps:  DYNAMIC_PRICE_BAND_BPS,
+			Action:          PRICE_BAND_ACTION,
+			HaltLength:      HALT_LENGTH,
+			ReopeningLength: REOPENING_AUCTION_LENGTH,
+		},
 	}
 }
 
 // NewOrderBook: Initializes Order Book within OrderBookService
 func NewOrderBook() OrderBook {
 	return OrderBook{
-		TopBookBid: TopBook{
-			UserID: 0,
-			Price:  MIN_INT,
-		},
-		TopBookAsk: TopBook{
-			UserID: 0,
-			Price:  MAX_INT,
-		},
-		OrderDict: make(map[int]string),
+		OrderDict:    make(map[int]string),
+		SessionState: CONTINUOUS,
 	}
 }
 
@@ -35,62 +50,242 @@ func NewOrderBook() OrderBook {
 ///       MAIN       ////
 /////////////////////////
 
+// ProcessOrderBooks: processes each scenario of an input file against a fresh book, printing a heading
+// before it and a blank line after. The first scenario carries on from the book the service already holds,
+// which may have been recovered from the journal. The order feed runs on across scenarios, deleting
+// whatever a scenario left resting before the next one starts.
+func (o *OrderBookService) ProcessOrderBooks(orderBooks [][]Order) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.processOrderBooks(orderBooks)
+}
+
+// processOrderBooks: processes the scenarios in turn, the caller holds the mutex
+func (o *OrderBookService) processOrderBooks(orderBooks [][]Order) error {
+	for i, orderBook := range orderBooks {
+		if i > 0 {
+			// exchange order ids and order feed sequence numbers carry on so no two of one run are the same
+			nextOrderID := o.orderBook.NextOrderID
+			o.orderBook = NewOrderBook()
+			o.orderBook.NextOrderID = nextOrderID
+			if o.OrderFeed != nil {
+				if err := o.OrderFeed.Publish(Event{Type: EVENT_FLUSH, Timestamp: o.currentTime}); err != nil {
+					return errors.Wrapf(err, "error clearing order feed before order book %v in ProcessOrderBooks()", i+1)
+				}
+			}
+		}
+		o.writeScenarioStart(i)
+		if err := o.processCommands(orderBook); err != nil {
+			return errors.Wrapf(err, "error Processing order book %v in ProcessOrderBooks()", i+1)
+		}
+		o.writeScenarioEnd()
+	}
+	return nil
+}
+
 // ProcessOrderBook: Main function processes order book limit bids/asks by price and time
 func (o *OrderBookService) ProcessOrderBook(orderBook []Order) error {
-	var output string
-	var err error
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.processCommands(orderBook)
+}
+
+// processCommands: applies the commands in turn, the caller holds the mutex
+func (o *OrderBookService) processCommands(orderBook []Order) error {
 	for _, order := range orderBook {
-		switch order.Command {
-		case NEW_ORDER:
-			output, err = o.newOrder(&order)
-			if err != nil {
-				return errors.Wrapf(err, "error creating new order in ProcessOrderBook for order: %v", order.UserOrderID)
-			}
-			if output != "" {
-				fmt.Println(output)
-			}
+		if _, err := o.processCommand(order); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			// Based on configuration.
-			if o.IsTradingEnabled {
-				output, err = o.executeTrade()
-				if err != nil {
-					return errors.Wrapf(err, "error attempting to execute a trade in ProcessOrderBook for order: %v", order.UserOrderID)
-				}
-				if output != "" {
-					fmt.Println(output)
-				}
-			}
+// processCommand: stamps, journals and applies a single command, returning every event it published
+func (o *OrderBookService) processCommand(order Order) ([]Event, error) {
+	o.stampCommand(&order)
+
+	// the command is durable before anything about it is applied or published
+	if o.Journal != nil {
+		if err := o.Journal.Append(order); err != nil {
+			return nil, errors.Wrapf(err, "error journaling command in processCommand for order: %v", order.UserOrderID)
+		}
+	}
+
+	// good-till-date orders whose time has come leave the book before the command is applied
+	events, err := o.expireOrders()
+	if err != nil {
+		return nil, errors.Wrap(err, "error expiring orders in processCommand")
+	}
+	published, err := o.publish(events)
+	if err != nil {
+		return published, errors.Wrap(err, "error publishing expiry events in processCommand")
+	}
+
+	// a circuit breaker halt runs its course in inbound commands before this one is applied
+	events, err = o.advanceHaltCountdown()
+	if err != nil {
+		return published, errors.Wrap(err, "error advancing circuit breaker halt in processCommand")
+	}
+	halt, err := o.publish(events)
+	published = append(published, halt...)
+	if err != nil {
+		return published, errors.Wrap(err, "error publishing circuit breaker events in processCommand")
+	}
+
+	switch order.Command {
+	case NEW_ORDER:
+		events, err = o.newOrder(&order)
+		if err != nil {
+			return published, errors.Wrapf(err, "error creating new order in processCommand for order: %v", order.UserOrderID)
+		}
 
-			output, err = o.handleTopOfBook()
+		// Based on configuration. Orders entered outside continuous trading wait for the auction uncross.
+		if o.IsTradingEnabled && o.currentSessionRules().MatchOnEntry {
+			trades, err := o.executeTrade(&order)
 			if err != nil {
-				return errors.Wrapf(err, "error handling top of book for new order in ProcessOrderBook for order: %v", order.UserOrderID)
+				return published, errors.Wrapf(err, "error attempting to execute a trade in processCommand for order: %v", order.UserOrderID)
 			}
-			if output != "" {
-				fmt.Println(output)
-			}
-		case CANCEL_ORDER:
-			// Instead of searching through both asks and bids list to find which order to cancel, we keep track of
-			// what side an order is on via an in memory hashmap o.OrderBook.OrderDict
-			order.Side = o.OrderBook.OrderDict[order.UserOrderID]
-			output, err := o.cancelOrder(&order)
+			events = append(events, trades...)
+		}
+
+		topOfBook, err := o.handleTopOfBook()
+		if err != nil {
+			return published, errors.Wrapf(err, "error handling top of book for new order in processCommand for order: %v", order.UserOrderID)
+		}
+		events = append(events, topOfBook...)
+	case CANCEL_ORDER:
+		// Instead of searching through both asks and bids list to find which order to cancel, we keep track of
+		// what side an order is on via an in memory hashmap o.orderBook.OrderDict
+		order.Side = o.orderBook.OrderDict[order.UserOrderID]
+		events, err = o.cancelOrder(&order)
+		if err != nil {
+			return published, errors.Wrapf(err, "error cancelling order in processCommand for order: %v", order.UserOrderID)
+		}
+		topOfBook, err := o.handleTopOfBook()
+		if err != nil {
+			return published, errors.Wrapf(err, "error handling top of book for cancel order in processCommand for order: %v", order.UserOrderID)
+		}
+		events = append(events, topOfBook...)
+	case FLUSH_ORDER_BOOK:
+		events = o.flushBook()
+	case SESSION_STATE:
+		// a state the book cannot move to is rejected and the book carries on as it was
+		if reason := o.checkSessionState(order.SessionState); reason != "" {
+			events = []Event{rejectEvent(SESSION_STATE, order, reason)}
+			break
+		}
+		// an explicit session command takes over from any circuit breaker halt in progress
+		o.orderBook.HaltCountdown = 0
+		events, err = o.changeSessionState(order.SessionState)
+		if err != nil {
+			return published, errors.Wrapf(err, "error changing session state in processCommand to: %v", order.SessionState)
+		}
+	case AMEND_ORDER:
+		events, err = o.amendOrder(&order)
+		if err != nil {
+			return published, errors.Wrapf(err, "error amending order in processCommand for order: %v", order.UserOrderID)
+		}
+		if o.IsTradingEnabled && o.currentSessionRules().MatchOnEntry {
+			trades, err := o.executeTrade(&order)
 			if err != nil {
-				return errors.Wrapf(err, "error cancelling order in ProcessOrderBook for order: %v", order.UserOrderID)
+				return published, errors.Wrapf(err, "error attempting to execute a trade in processCommand for order: %v", order.UserOrderID)
 			}
-			if output != "" {
-				fmt.Println(output)
+			events = append(events, trades...)
+		}
+		topOfBook, err := o.handleTopOfBook()
+		if err != nil {
+			return published, errors.Wrapf(err, "error handling top of book for amend order in processCommand for order: %v", order.UserOrderID)
+		}
+		events = append(events, topOfBook...)
+	case ADVANCE_CLOCK:
+		// nothing to apply, stamping the command moved the service's time and the expiries ran above
+		events = nil
+	}
+	applied, err := o.publish(events)
+	published = append(published, applied...)
+	if err != nil {
+		return published, errors.Wrapf(err, "error publishing events in processCommand for order: %v", order.UserOrderID)
+	}
+	return published, nil
+}
+
+// publish: numbers and stamps each event, prints it as a line of output, or writes its record in binary
+// output, and passes it on to the
+// order-by-order feed and OnEvent when they are set. Events that print nothing take no sequence number,
+// so a gap in the numbers always means a missing line.
+func (o *OrderBookService) publish(events []Event) ([]Event, error) {
+	published := make([]Event, 0, len(events))
+	for _, event := range events {
+		event.Timestamp = o.currentTime
+		line := event.String()
+		if line != "" {
+			o.eventSequence++
+			event.Sequence = o.eventSequence
+		}
+		// binary output keeps the flushes too, they mark where each scenario ends
+		if o.OutputFormat == FORMAT_BINARY {
+			if err := o.writeBinaryEvent(event); err != nil {
+				return published, errors.Wrap(err, "error writing output in publish()")
 			}
-			output, err = o.handleTopOfBook()
-			if err != nil {
-				return errors.Wrapf(err, "error handling top of book for cancel order in ProcessOrderBook for order: %v", order.UserOrderID)
+		} else if line != "" {
+			if err := o.writeEventLine(event, line); err != nil {
+				return published, errors.Wrap(err, "error writing output in publish()")
 			}
-			if output != "" {
-				fmt.Println(output)
+		}
+		if o.OrderFeed != nil {
+			if err := o.OrderFeed.Publish(event); err != nil {
+				return published, errors.Wrap(err, "error writing order feed in publish()")
 			}
-		case FLUSH_ORDER_BOOK:
-			o.flushBook()
 		}
+		if o.OnEvent != nil {
+			o.OnEvent(event)
+		}
+		o.notifySubscribers(event)
+		published = append(published, event)
+	}
+	return published, nil
+}
+
+// writeEventLine: prints the line of an event in the output format, led by its sequence number and
+// timestamp when the output is stamped
+func (o *OrderBookService) writeEventLine(event Event, line string) error {
+	if o.OutputFormat == FORMAT_JSON_LINES {
+		line = event.JSONLine(o.IsOutputStamped)
+	} else if o.IsOutputStamped {
+		line = fmt.Sprintf("%v, %v, %v", event.Sequence, event.Timestamp.UnixNano(), line)
+	}
+	_, err := fmt.Fprintln(o.output(), line)
+	return err
+}
+
+func (o *OrderBookService) output() io.Writer {
+	if o.Output == nil {
+		return os.Stdout
+	}
+	return o.Output
+}
+
+// writeScenarioStart: heads the output of scenario i, as a JSON object of its own in JSON Lines output so
+// every line stays JSON. Binary output has no scenario headings, only the file header before the first.
+func (o *OrderBookService) writeScenarioStart(i int) {
+	if o.OutputFormat == FORMAT_BINARY {
+		if i == 0 {
+			o.output().Write(AppendBinaryHeader(nil, BINARY_EVENTS))
+		}
+		return
+	}
+	if o.OutputFormat == FORMAT_JSON_LINES {
+		fmt.Fprintln(o.output(), formatJSONObject([]jsonField{{"type", "ORDER_BOOK"}, {"orderBook", i + 1}}))
+		return
+	}
+	fmt.Fprintf(o.output(), "Processing Order book %v\n", i+1)
+}
+
+// writeScenarioEnd: the blank line after a scenario, which JSON Lines and binary output leave out
+func (o *OrderBookService) writeScenarioEnd() {
+	if o.OutputFormat != FORMAT_JSON_LINES && o.OutputFormat != FORMAT_BINARY {
+		fmt.Fprintln(o.output())
 	}
-	return nil
 }
 
 /////////////////////////
@@ -98,202 +293,420 @@ func (o *OrderBookService) ProcessOrderBook(orderBook []Order) error {
 /////////////////////////
 
 // if TRADING_IS_ENABLED == True, the program executes cross book orders as trades
-// TODO: Support trading multiple orders instead of just lowestAsk and highest Bid.
-func (o *OrderBookService) executeTrade() (string, error) {
-	var output string
-	if len(o.OrderBook.Asks) != 0 && len(o.OrderBook.Bids) != 0 {
-		lowestAsk := o.OrderBook.Asks[0]
-		highestBid := o.OrderBook.Bids[0]
-		if highestBid.Price >= lowestAsk.Price && highestBid.Quantity == lowestAsk.Quantity {
-			orderList, err := remove(o.OrderBook.Asks, 0)
+// the incoming order works through the opposite side one price level at a time, each level shared out by
+// the book's allocation strategy. A level outside the circuit breaker price bands trips the breaker
+// instead of trading. Fills go out in level order, and one the allocation hands to an order from the same
+// owner is resolved by self trade prevention instead, after which the level is shared out again.
+func (o *OrderBookService) executeTrade(order *Order) ([]Event, error) {
+	var events []Event
+	for {
+		aggressor := o.findOrder(order.Side, order.UserOrderID)
+		level := o.bestLevel(oppositeSide(order.Side))
+		if aggressor == nil || aggressor.Quantity <= 0 || len(level) == 0 || !crosses(*aggressor, level[0].Price) {
+			break
+		}
+
+		price := level[0].Price
+		if reference, breached := o.breachedPriceBand(price); breached {
+			tripEvents, err := o.tripCircuitBreaker(order.Command, *aggressor, price, reference)
 			if err != nil {
-				errors.Wrap(err, "error removing ask in trade call for newOrder()")
+				return nil, errors.Wrap(err, "error tripping circuit breaker in executeTrade()")
 			}
-			o.OrderBook.Asks = orderList
+			return append(events, tripEvents...), nil
+		}
 
-			orderList, err = remove(o.OrderBook.Bids, 0)
-			if err != nil {
-				errors.Wrap(err, "error removing bid in trade call for newOrder()")
+		allocation := o.Allocation
+		if allocation == nil {
+			allocation = PriceTimeAllocation{}
+		}
+		fills := allocation.Allocate(aggressor.Quantity, level)
+		incoming := *aggressor
+		filled := 0
+		isSelfTrade := false
+		for i, resting := range level {
+			if fills[i] == 0 {
+				continue
+			}
+			if o.SelfTradePrevention != STP_NONE && selfTradeKey(resting) == selfTradeKey(incoming) {
+				bid, ask := incoming, resting
+				if incoming.Side == SELL {
+					bid, ask = resting, incoming
+				}
+				events = append(events, o.preventSelfTrade(bid, ask)...)
+				isSelfTrade = true
+				break
 			}
-			o.OrderBook.Bids = orderList
+			filled += fills[i]
+			o.reduceOrder(resting.Side, resting.UserOrderID, fills[i])
+			o.reduceOrder(incoming.Side, incoming.UserOrderID, fills[i])
+			resting.Quantity -= fills[i]
+			incoming.Quantity -= fills[i]
 
-			output = fmt.Sprintf("T, %v, %v, %v %v, %v, %v", highestBid.UserID, highestBid.UserOrderID, lowestAsk.UserID, lowestAsk.UserOrderID, lowestAsk.Price, lowestAsk.Quantity)
-			return output, nil
+			bid, ask := incoming, resting
+			if incoming.Side == SELL {
+				bid, ask = resting, incoming
+			}
+			events = append(events, tradeEvent(bid, ask, price, fills[i]))
+		}
+		if filled > 0 {
+			o.recordTradePrice(price)
+		}
+		// a pass that fills nothing would be repeated forever
+		if filled <= 0 && !isSelfTrade {
+			break
 		}
 	}
-	return "", nil
+	return events, nil
 }
 
 // newOrder: function that creates a brand new order within the order book
 // also evaluates orders that attempt to cross the book
-func (o *OrderBookService) newOrder(order *Order) (string, error) {
-	var output string
-	if order.Price == 0 {
-		return "", errors.Errorf("Error creating new order for order %v: invalid price %v", order.UserOrderID, order.Price)
+func (o *OrderBookService) newOrder(order *Order) ([]Event, error) {
+	if rejected, reason := o.checkNewOrder(order); rejected {
+		return []Event{rejectEvent(NEW_ORDER, *order, reason)}, nil
 	}
 	if order.Side == BUY {
-		if !o.IsTradingEnabled && order.Price >= o.OrderBook.TopBookAsk.Price {
-			output = fmt.Sprintf("R, %v, %v", order.UserID, order.UserOrderID)
-			return output, nil
-		}
-		insertionIndex := len(o.OrderBook.Bids)
-		for i, bidOrder := range o.OrderBook.Bids {
-			if order.Price > bidOrder.Price {
+		insertionIndex := len(o.orderBook.Bids)
+		for i, bidOrder := range o.orderBook.Bids {
+			if order.Price.Cmp(bidOrder.Price) > 0 {
 				insertionIndex = i
 				break
 			}
 		}
-		bids, err := insertOrder(o.OrderBook.Bids, insertionIndex, *order)
+		o.orderBook.NextOrderID++
+		order.OrderID = o.orderBook.NextOrderID
+		bids, err := insertOrder(o.orderBook.Bids, insertionIndex, *order)
 		if err != nil {
-			return "", errors.Wrap(err, "error inserting order to bids in newOrder()")
+			return nil, errors.Wrap(err, "error inserting order to bids in newOrder()")
 		}
-		o.OrderBook.Bids = bids
-		o.OrderBook.OrderDict[order.UserOrderID] = BUY
-		output = fmt.Sprintf("A, %v, %v", order.UserID, order.UserOrderID)
-		return output, nil
+		o.orderBook.Bids = bids
+		o.orderBook.OrderDict[order.UserOrderID] = BUY
+		o.scheduleExpiry(*order)
+		return []Event{ackEvent(NEW_ORDER, *order)}, nil
 	} else if order.Side == SELL {
-		if !o.IsTradingEnabled && order.Price <= o.OrderBook.TopBookBid.Price {
-			output = fmt.Sprintf("R, %v, %v", order.UserID, order.UserOrderID)
-			return output, nil
-		}
-		insertionIndex := len(o.OrderBook.Asks)
-		for i, askOrder := range o.OrderBook.Asks {
-			if order.Price < askOrder.Price {
+		insertionIndex := len(o.orderBook.Asks)
+		for i, askOrder := range o.orderBook.Asks {
+			if order.Price.Cmp(askOrder.Price) < 0 {
 				insertionIndex = i
 				break
 			}
 		}
-		asks, err := insertOrder(o.OrderBook.Asks, insertionIndex, *order)
+		o.orderBook.NextOrderID++
+		order.OrderID = o.orderBook.NextOrderID
+		asks, err := insertOrder(o.orderBook.Asks, insertionIndex, *order)
 		if err != nil {
-			return "", errors.Wrap(err, "error inserting order to asks in newOrder()")
+			return nil, errors.Wrap(err, "error inserting order to asks in newOrder()")
+		}
+		o.orderBook.Asks = asks
+		o.orderBook.OrderDict[order.UserOrderID] = SELL
+		o.scheduleExpiry(*order)
+		return []Event{ackEvent(NEW_ORDER, *order)}, nil
+	}
+	return nil, nil
+}
+
+// checkNewOrder: whether an order may enter the book, and the reason it may not. An order that passes
+// validation has its price brought to the form it takes in the book. Without trading enabled an order that would cross the book is turned away.
+func (o *OrderBookService) checkNewOrder(order *Order) (bool, string) {
+	if !o.currentSessionRules().AcceptNewOrders {
+		return true, ""
+	}
+	price, reason := o.validateOrder(*order)
+	if reason != "" {
+		return true, reason
+	}
+	order.Price = price
+	// nothing could ever fill an order without quantity, instruments or not
+	if order.Quantity <= 0 {
+		return true, BELOW_MIN_QTY
+	}
+	if !order.ExpiresAt.IsZero() && !order.ExpiresAt.After(o.currentTime) {
+		return true, ALREADY_EXPIRED
+	}
+	if o.breachesOnEntry(*order) {
+		return true, ""
+	}
+	if !o.IsTradingEnabled {
+		if order.Side == BUY && o.orderBook.TopBookAsk.IsSet && order.Price.Cmp(o.orderBook.TopBookAsk.Price) >= 0 {
+			return true, ""
+		}
+		if order.Side == SELL && o.orderBook.TopBookBid.IsSet && order.Price.Cmp(o.orderBook.TopBookBid.Price) <= 0 {
+			return true, ""
 		}
-		o.OrderBook.Asks = asks
-		o.OrderBook.OrderDict[order.UserOrderID] = SELL
-		output = fmt.Sprintf("A, %v, %v", order.UserID, order.UserOrderID)
-		return output, nil
 	}
-	return "", nil
+	return false, ""
+}
+
+// amendOrder: changes the price and quantity of a resting order. Taking quantity off at the same price
+// changes the order in place and keeps its time priority. Any other change re-enters it under a new
+// exchange order id behind the orders already at its price, as long as the changed order would be
+// accepted as a new one; otherwise it is rejected and the resting order is left as it was. The
+// acknowledgement carries the order as it now rests, and Previous the order it replaced.
+func (o *OrderBookService) amendOrder(order *Order) ([]Event, error) {
+	side, found := o.orderBook.OrderDict[order.UserOrderID]
+	if !found {
+		return []Event{rejectEvent(AMEND_ORDER, *order, UNKNOWN_ORDER)}, nil
+	}
+	resting := o.findOrder(side, order.UserOrderID)
+	if resting == nil {
+		return nil, errors.Errorf("order %v is indexed but not resting in amendOrder()", order.UserOrderID)
+	}
+	previous := *resting
+	order.Side = previous.Side
+	if order.Quantity <= 0 || !o.currentSessionRules().AcceptCancels {
+		return []Event{rejectEvent(AMEND_ORDER, *order, "")}, nil
+	}
+
+	if order.Price.Cmp(previous.Price) == 0 && order.Quantity <= previous.Quantity {
+		resting.Quantity = order.Quantity
+		return []Event{{Type: EVENT_ACK, Command: AMEND_ORDER, Order: *resting, Previous: previous}}, nil
+	}
+
+	replacement := previous
+	replacement.Sequence = order.Sequence
+	replacement.Timestamp = order.Timestamp
+	replacement.Price = order.Price
+	replacement.Quantity = order.Quantity
+	o.removeOrder(previous.Side, previous.UserOrderID)
+	if rejected, reason := o.checkNewOrder(&replacement); rejected {
+		o.restoreOrder(previous)
+		return []Event{rejectEvent(AMEND_ORDER, *order, reason)}, nil
+	}
+	events, err := o.newOrder(&replacement)
+	if err != nil {
+		return nil, errors.Wrap(err, "error entering amended order in amendOrder()")
+	}
+	for i := range events {
+		events[i].Command = AMEND_ORDER
+		events[i].Previous = previous
+	}
+	return events, nil
 }
 
-// cancelOrder: cancels orders within the orderbook by ID
-func (o *OrderBookService) cancelOrder(order *Order) (string, error) {
-	var output string
+// cancelOrder: cancels orders within the orderbook by ID, the acknowledgement carries the exchange order id,
+// price and quantity of the order that was taken out of the book
+func (o *OrderBookService) cancelOrder(order *Order) ([]Event, error) {
+	if !o.currentSessionRules().AcceptCancels {
+		return []Event{rejectEvent(CANCEL_ORDER, *order, "")}, nil
+	}
 	if order.Side == BUY {
-		bids := o.OrderBook.Bids
+		bids := o.orderBook.Bids
 		for i := range bids {
 			if bids[i].UserOrderID == order.UserOrderID {
-				orderList, err := remove(o.OrderBook.Bids, i)
+				cancelled := cancelledOrder(*order, bids[i])
+				orderList, err := remove(o.orderBook.Bids, i)
 				if err != nil {
-					return "", errors.Wrap(err, "error removing bid in cancelOrder()")
+					return nil, errors.Wrap(err, "error removing bid in cancelOrder()")
 				}
-				o.OrderBook.Bids = orderList
-				output = fmt.Sprintf("A, %v, %v", order.UserID, order.UserOrderID)
-				return output, nil
+				o.orderBook.Bids = orderList
+				delete(o.orderBook.OrderDict, order.UserOrderID)
+				return []Event{ackEvent(CANCEL_ORDER, cancelled)}, nil
 			}
 		}
 	} else if order.Side == SELL {
-		asks := o.OrderBook.Asks
+		asks := o.orderBook.Asks
 		for i := range asks {
 			if asks[i].UserOrderID == order.UserOrderID {
-				orderList, err := remove(o.OrderBook.Asks, i)
+				cancelled := cancelledOrder(*order, asks[i])
+				orderList, err := remove(o.orderBook.Asks, i)
 				if err != nil {
-					return "", errors.Wrap(err, "error removing ask in cancelOrder()")
+					return nil, errors.Wrap(err, "error removing ask in cancelOrder()")
 				}
-				o.OrderBook.Asks = orderList
-				output = fmt.Sprintf("A, %v, %v", order.UserID, order.UserOrderID)
-				return output, nil
+				o.orderBook.Asks = orderList
+				delete(o.orderBook.OrderDict, order.UserOrderID)
+				return []Event{ackEvent(CANCEL_ORDER, cancelled)}, nil
 			}
 		}
 	}
-	return "", nil
+	return nil, nil
 }
 
-// flushBook: clears the orderbook
-func (o *OrderBookService) flushBook() {
-	o.OrderBook = OrderBook{}
+// flushBook: clears the orderbook, the session state, any halt in progress and the exchange order ids carry
+// over to the fresh book. Every published depth level is deleted first so depth consumers clear their books too.
+func (o *OrderBookService) flushBook() []Event {
+	events := append(o.clearDepth(), Event{Type: EVENT_FLUSH})
+	previous := o.orderBook
+	o.orderBook = NewOrderBook()
+	o.orderBook.SessionState = previous.SessionState
+	o.orderBook.HaltCountdown = previous.HaltCountdown
+	o.orderBook.NextOrderID = previous.NextOrderID
+	return events
 }
 
 /////////////////////////
 ///   TOP OF BOOK   ////
 ////////////////////////
 
-// handleTopOfBook: Determines if we need to handle the top of book for asks or bids
-func (o *OrderBookService) handleTopOfBook() (string, error) {
-	output, err := o.evaluateBook(BUY, o.OrderBook.TopBookBid, o.OrderBook.Bids)
+// handleTopOfBook: Determines if we need to handle the top of book for asks or bids, both sides are
+// reported when a single command moves both of them. Depth updates are published right after.
+func (o *OrderBookService) handleTopOfBook() ([]Event, error) {
+	bidEvents, err := o.evaluateBook(BUY, o.orderBook.TopBookBid, o.orderBook.Bids)
 	if err != nil {
-		return "", errors.Wrap(err, "error for bid order in assessTopOfBook()")
+		return nil, errors.Wrap(err, "error for bid order in assessTopOfBook()")
 	}
-	if output != "" {
-		return output, nil
-	}
-	output, err = o.evaluateBook(SELL, o.OrderBook.TopBookAsk, o.OrderBook.Asks)
+	askEvents, err := o.evaluateBook(SELL, o.orderBook.TopBookAsk, o.orderBook.Asks)
 	if err != nil {
-		return "", errors.Wrap(err, "error for ask order in assessTopOfBook()")
-	}
-	if output != "" {
-		return output, nil
+		return nil, errors.Wrap(err, "error for ask order in assessTopOfBook()")
 	}
-	return "", nil
+	events := append(bidEvents, askEvents...)
+	return append(events, o.handleDepth()...), nil
 }
 
 // evaluateBook: groups top orders that share UserID's and Price, thenwe add the quantities together and store to handler
-func (o *OrderBookService) evaluateBook(side string, currentTopOfBook TopBook, orders []Order) (string, error) {
-	var output string
+func (o *OrderBookService) evaluateBook(side string, currentTopOfBook TopBook, orders []Order) ([]Event, error) {
 	if side != BUY && side != SELL {
-		return "", errors.New("invalid side input in evaluatebook")
+		return nil, errors.New("invalid side input in evaluatebook")
 	}
+	// an empty side leaves newTopOfBook unset
 	var newTopOfBook TopBook
-	if side == BUY {
-		newTopOfBook = TopBook{
-			UserID:   0,
-			Price:    MIN_INT,
-			Quantity: 0,
-		}
-	} else if side == SELL {
-		newTopOfBook = TopBook{
-			UserID:   0,
-			Price:    MAX_INT,
-			Quantity: 0,
-		}
-	}
-
 	if len(orders) != 0 {
 		newTopOfBook.UserID = orders[0].UserID
 		newTopOfBook.Price = orders[0].Price
 		newTopOfBook.Quantity = orders[0].Quantity
+		newTopOfBook.IsSet = true
 
 		for i := 1; i < len(orders); i++ {
-			if orders[i].UserID == newTopOfBook.UserID && orders[i].Price == newTopOfBook.Price {
+			if orders[i].UserID == newTopOfBook.UserID && orders[i].Price.Cmp(newTopOfBook.Price) == 0 {
 				newTopOfBook.Quantity += orders[i].Quantity
 			}
 		}
 	}
-	if newTopOfBook.UserID != currentTopOfBook.UserID ||
-		newTopOfBook.Quantity != currentTopOfBook.Quantity ||
-		newTopOfBook.Price != currentTopOfBook.Price {
-
-		if side == BUY {
-			o.OrderBook.TopBookBid = newTopOfBook
-			if newTopOfBook.Price == MIN_INT {
-				output = "B, B, -, -"
-			} else {
-				output = fmt.Sprintf("B, B, %v, %v", newTopOfBook.Price, newTopOfBook.Quantity)
-			}
-		} else if side == SELL {
-			o.OrderBook.TopBookAsk = newTopOfBook
-			if newTopOfBook.Price == MAX_INT {
-				output = "B, S, -, -"
-			} else {
-				output = fmt.Sprintf("B, S, %v, %v", newTopOfBook.Price, newTopOfBook.Quantity)
-			}
-		}
+	if newTopOfBook.equals(currentTopOfBook) {
+		return nil, nil
 	}
-	return output, nil
+	if side == BUY {
+		o.orderBook.TopBookBid = newTopOfBook
+	} else {
+		o.orderBook.TopBookAsk = newTopOfBook
+	}
+	return []Event{{Type: EVENT_TOP_OF_BOOK, Side: side, TopBook: newTopOfBook}}, nil
 }
 
 /////////////////////////
 ///    HELPERS      ////
 ////////////////////////
+
+// findOrder: returns the resting order so it can be changed in place, nil when it is not in the book
+func (o *OrderBookService) findOrder(side string, userOrderID int) *Order {
+	orders := o.orderBook.Bids
+	if side == SELL {
+		orders = o.orderBook.Asks
+	}
+	for i := range orders {
+		if orders[i].UserOrderID == userOrderID {
+			return &orders[i]
+		}
+	}
+	return nil
+}
+
+// bestLevel: the resting orders at the best price of a side, in time priority
+func (o *OrderBookService) bestLevel(side string) []Order {
+	orders := o.orderBook.Bids
+	if side == SELL {
+		orders = o.orderBook.Asks
+	}
+	end := 0
+	for end < len(orders) && orders[end].Price.Cmp(orders[0].Price) == 0 {
+		end++
+	}
+	level := make([]Order, end)
+	copy(level, orders[:end])
+	return level
+}
+
+// reduceOrder: takes quantity off a resting order and removes it from the book once nothing is left
+func (o *OrderBookService) reduceOrder(side string, userOrderID int, quantity int) {
+	order := o.findOrder(side, userOrderID)
+	if order == nil {
+		return
+	}
+	order.Quantity -= quantity
+	if order.Quantity <= 0 {
+		o.removeOrder(side, userOrderID)
+	}
+}
+
+// removeOrder: takes an order off its side of the book without publishing anything
+func (o *OrderBookService) removeOrder(side string, userOrderID int) {
+	orders := o.orderBook.Bids
+	if side == SELL {
+		orders = o.orderBook.Asks
+	}
+	for i := range orders {
+		if orders[i].UserOrderID == userOrderID {
+			orders = append(orders[:i], orders[i+1:]...)
+			break
+		}
+	}
+	if side == SELL {
+		o.orderBook.Asks = orders
+	} else {
+		o.orderBook.Bids = orders
+	}
+	delete(o.orderBook.OrderDict, userOrderID)
+}
+
+// restoreOrder: puts an order taken out of the book back where its price and exchange order id place it
+func (o *OrderBookService) restoreOrder(order Order) {
+	orders := o.orderBook.Bids
+	if order.Side == SELL {
+		orders = o.orderBook.Asks
+	}
+	index := len(orders)
+	for i, resting := range orders {
+		better := order.Price.Cmp(resting.Price)
+		if order.Side == SELL {
+			better = -better
+		}
+		if better > 0 || (better == 0 && order.OrderID < resting.OrderID) {
+			index = i
+			break
+		}
+	}
+	orders = append(orders, Order{})
+	copy(orders[index+1:], orders[index:])
+	orders[index] = order
+	if order.Side == SELL {
+		o.orderBook.Asks = orders
+	} else {
+		o.orderBook.Bids = orders
+	}
+	o.orderBook.OrderDict[order.UserOrderID] = order.Side
+}
+
+// equals: two empty sides are the same whatever their other fields hold
+func (t TopBook) equals(other TopBook) bool {
+	if !t.IsSet || !other.IsSet {
+		return t.IsSet == other.IsSet
+	}
+	return t.UserID == other.UserID && t.Quantity == other.Quantity && t.Price.Cmp(other.Price) == 0
+}
+
+func oppositeSide(side string) string {
+	if side == BUY {
+		return SELL
+	}
+	return BUY
+}
+
+// crosses: whether an order is willing to trade at price on the opposite side
+func crosses(order Order, price Price) bool {
+	if order.Side == BUY {
+		return order.Price.Cmp(price) >= 0
+	}
+	return order.Price.Cmp(price) <= 0
+}
+
+// cancelledOrder: the cancel command filled in with the details of the resting order it removed
+func cancelledOrder(command Order, resting Order) Order {
+	command.OrderID = resting.OrderID
+	command.Symbol = resting.Symbol
+	command.Price = resting.Price
+	command.Quantity = resting.Quantity
+	return command
+}
+
 func insertOrder(orderList []Order, index int, newOrder Order) ([]Order, error) {
 	if index < 0 || index > len(orderList) {
 		return nil, errors.New("index out of bounds in insertOrder()")
@@ -2,9 +2,9 @@ package service
 
 import (
 	"reflect"
+	"strings"
 	"testing"
-
-	"github.com/pkg/errors"
+	"time"
 )
 
 func compareErrors(expected error, returned error) bool {
@@ -14,6 +14,25 @@ func compareErrors(expected error, returned error) bool {
 	return reflect.TypeOf(expected) == reflect.TypeOf(returned) && expected.Error() == returned.Error()
 }
 
+func intPrice(value int64) Price {
+	return NewPrice(value, 0)
+}
+
+// eventLines: the lines of output the events print as
+func eventLines(events []Event) []string {
+	var lines []string
+	for _, event := range events {
+		if line := event.String(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func eventOutput(events []Event) string {
+	return strings.Join(eventLines(events), "\n")
+}
+
 func TestNewOrder(t *testing.T) {
 	testService := NewOrderBookService()
 	tests := map[string]struct {
@@ -28,12 +47,13 @@ func TestNewOrder(t *testing.T) {
 				UserOrderID: 1,
 				Command:     NEW_ORDER,
 				Symbol:      "IBM",
-				Price:       10,
+				Price:       intPrice(10),
 				Quantity:    100,
 				Side:        BUY,
 			},
 			topBook: TopBook{
-				Price: 20,
+				Price: intPrice(20),
+				IsSet: true,
 			},
 			err:    nil,
 			output: "A, 1, 1",
@@ -44,28 +64,33 @@ func TestNewOrder(t *testing.T) {
 				UserOrderID: 2,
 				Command:     NEW_ORDER,
 				Symbol:      "IBM",
-				Price:       10,
+				Price:       intPrice(10),
 				Quantity:    100,
 				Side:        SELL,
 			},
 			topBook: TopBook{
-				Price: 5,
+				Price: intPrice(5),
+				IsSet: true,
 			},
 			err:    nil,
 			output: "A, 1, 2",
 		},
-		"Invalid Order Price": {
+		"Zero Price Sell Order": {
 			order: &Order{
 				UserID:      1,
 				UserOrderID: 3,
 				Command:     NEW_ORDER,
 				Symbol:      "IBM",
-				Price:       0,
+				Price:       intPrice(0),
 				Quantity:    100,
 				Side:        SELL,
 			},
-			err:    errors.New("Error creating new order for order 3: invalid price 0"),
-			output: "",
+			topBook: TopBook{
+				Price: intPrice(-5),
+				IsSet: true,
+			},
+			err:    nil,
+			output: "A, 1, 3",
 		},
 		"Rejected Buy Order": {
 			order: &Order{
@@ -73,28 +98,47 @@ func TestNewOrder(t *testing.T) {
 				UserOrderID: 4,
 				Command:     NEW_ORDER,
 				Symbol:      "IBM",
-				Price:       10,
+				Price:       intPrice(10),
 				Quantity:    100,
 				Side:        BUY,
 			},
 			topBook: TopBook{
-				Price: 5,
+				Price: intPrice(5),
+				IsSet: true,
 			},
 			err:    nil,
 			output: "R, 2, 4",
 		},
+		"Zero Quantity Order Crossing The Book": {
+			order: &Order{
+				UserID:      4,
+				UserOrderID: 6,
+				Command:     NEW_ORDER,
+				Symbol:      "IBM",
+				Price:       intPrice(10),
+				Quantity:    0,
+				Side:        BUY,
+			},
+			topBook: TopBook{
+				Price: intPrice(5),
+				IsSet: true,
+			},
+			err:    nil,
+			output: "R, 4, 6, BELOW_MIN_QTY",
+		},
 		"Rejected Sell Order": {
 			order: &Order{
 				UserID:      3,
 				UserOrderID: 5,
 				Command:     NEW_ORDER,
 				Symbol:      "IBM",
-				Price:       10,
+				Price:       intPrice(10),
 				Quantity:    100,
 				Side:        SELL,
 			},
 			topBook: TopBook{
-				Price: 20,
+				Price: intPrice(20),
+				IsSet: true,
 			},
 			err:    nil,
 			output: "R, 3, 5",
@@ -102,14 +146,15 @@ func TestNewOrder(t *testing.T) {
 	}
 
 	for name, test := range tests {
-		testService.OrderBook = NewOrderBook()
+		testService.orderBook = NewOrderBook()
 
 		if test.order.Side == BUY {
-			testService.OrderBook.TopBookAsk = test.topBook
+			testService.orderBook.TopBookAsk = test.topBook
 		} else if test.order.Side == SELL {
-			testService.OrderBook.TopBookBid = test.topBook
+			testService.orderBook.TopBookBid = test.topBook
 		}
-		output, err := testService.newOrder(test.order)
+		events, err := testService.newOrder(test.order)
+		output := eventOutput(events)
 		if !compareErrors(test.err, err) {
 			t.Errorf("Expected error %s, received %s for test %s", test.err, err, name)
 		}
@@ -119,6 +164,39 @@ func TestNewOrder(t *testing.T) {
 	}
 }
 
+func TestExecuteTradeWithoutQuantity(t *testing.T) {
+	tests := map[string]struct {
+		quantity int
+	}{
+		"Zero quantity":     {quantity: 0},
+		"Negative quantity": {quantity: -5},
+	}
+
+	for name, test := range tests {
+		testService := NewOrderBookService()
+		testService.IsTradingEnabled = true
+		order := Order{Side: BUY, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: test.quantity}
+		testService.orderBook.Bids = []Order{order}
+		testService.orderBook.Asks = []Order{{Side: SELL, UserID: 2, UserOrderID: 2, Price: intPrice(10), Quantity: 100}}
+		testService.orderBook.OrderDict[1] = BUY
+		testService.orderBook.OrderDict[2] = SELL
+
+		done := make(chan []Event)
+		go func() {
+			events, _ := testService.executeTrade(&order)
+			done <- events
+		}()
+		select {
+		case events := <-done:
+			if len(events) != 0 {
+				t.Errorf("Expected no trades, received %v for test %s", eventLines(events), name)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected matching to stop, it is still running for test %s", name)
+		}
+	}
+}
+
 func TestCancelOrder(t *testing.T) {
 	testService := NewOrderBookService()
 	tests := map[string]struct {
@@ -225,27 +303,104 @@ func TestCancelOrder(t *testing.T) {
 	}
 
 	for name, test := range tests {
-		testService.OrderBook = NewOrderBook()
+		testService.orderBook = NewOrderBook()
 
 		for _, existingOrder := range test.existingOrders {
 			if existingOrder.Side == BUY {
-				testService.OrderBook.Bids = append(testService.OrderBook.Bids, existingOrder)
+				testService.orderBook.Bids = append(testService.orderBook.Bids, existingOrder)
 			} else if existingOrder.Side == SELL {
-				testService.OrderBook.Asks = append(testService.OrderBook.Asks, existingOrder)
+				testService.orderBook.Asks = append(testService.orderBook.Asks, existingOrder)
 			}
 		}
-		output, err := testService.cancelOrder(test.order)
+		events, err := testService.cancelOrder(test.order)
+		output := eventOutput(events)
 		if !compareErrors(test.err, err) {
 			t.Errorf("Expected error %s, received %s for test %s", test.err, err, name)
 		}
 		if output != test.output {
 			t.Errorf("Expected output %s, received %s for test %s", test.output, output, name)
 		}
-		if test.finalAskLength != len(testService.OrderBook.Asks) {
-			t.Errorf("Expected ask length %v, received %v for test %s", test.finalAskLength, len(testService.OrderBook.Asks), name)
+		if test.finalAskLength != len(testService.orderBook.Asks) {
+			t.Errorf("Expected ask length %v, received %v for test %s", test.finalAskLength, len(testService.orderBook.Asks), name)
+		}
+		if test.finalBidLength != len(testService.orderBook.Bids) {
+			t.Errorf("Expected bid length %v, received %v for test %s", test.finalBidLength, len(testService.orderBook.Bids), name)
+		}
+	}
+}
+
+func TestEvaluateBook(t *testing.T) {
+	testService := NewOrderBookService()
+	tests := map[string]struct {
+		side       string
+		currentTop TopBook
+		orders     []Order
+		output     string
+	}{
+		"First order on an empty side": {
+			side:   BUY,
+			orders: []Order{{UserID: 1, Price: intPrice(10), Quantity: 100}},
+			output: "B, B, 10, 100",
+		},
+		"Negative spread price": {
+			side:   SELL,
+			orders: []Order{{UserID: 1, Price: NewPrice(-15, 1), Quantity: 100}},
+			output: "B, S, -1.5, 100",
+		},
+		"Side becomes empty": {
+			side:       SELL,
+			currentTop: TopBook{UserID: 1, Price: intPrice(11), Quantity: 100, IsSet: true},
+			output:     "B, S, -, -",
+		},
+		"Empty side stays empty": {
+			side:   BUY,
+			output: "",
+		},
+		"Unchanged top of book": {
+			side:       BUY,
+			currentTop: TopBook{UserID: 1, Price: intPrice(10), Quantity: 100, IsSet: true},
+			orders:     []Order{{UserID: 1, Price: intPrice(10), Quantity: 100}},
+			output:     "",
+		},
+	}
+
+	for name, test := range tests {
+		testService.orderBook = NewOrderBook()
+		events, err := testService.evaluateBook(test.side, test.currentTop, test.orders)
+		output := eventOutput(events)
+		if err != nil {
+			t.Errorf("Expected no error, received %s for test %s", err, name)
+		}
+		if output != test.output {
+			t.Errorf("Expected output %s, received %s for test %s", test.output, output, name)
+		}
+	}
+}
+
+func TestNewOrderNegativePrices(t *testing.T) {
+	testService := NewOrderBookService()
+	testService.orderBook = NewOrderBook()
+	tests := []struct {
+		order  *Order
+		output string
+	}{
+		{order: &Order{UserID: 1, UserOrderID: 1, Price: NewPrice(-20, 1), Quantity: 100, Side: BUY}, output: "A, 1, 1"},
+		{order: &Order{UserID: 2, UserOrderID: 2, Price: NewPrice(-25, 1), Quantity: 100, Side: SELL}, output: "R, 2, 2"},
+		{order: &Order{UserID: 2, UserOrderID: 3, Price: NewPrice(-15, 1), Quantity: 100, Side: SELL}, output: "A, 2, 3"},
+	}
+
+	// orders are entered in sequence so the crossing checks see the top of book the earlier ones built
+	for i, test := range tests {
+		events, err := testService.newOrder(test.order)
+		output := eventOutput(events)
+		if err != nil {
+			t.Errorf("Expected no error, received %s for order %v", err, i+1)
+		}
+		if output != test.output {
+			t.Errorf("Expected output %s, received %s for order %v", test.output, output, i+1)
 		}
-		if test.finalBidLength != len(testService.OrderBook.Bids) {
-			t.Errorf("Expected bid length %v, received %v for test %s", test.finalBidLength, len(testService.OrderBook.Bids), name)
+		if _, err := testService.handleTopOfBook(); err != nil {
+			t.Errorf("Expected no error, received %s for order %v", err, i+1)
 		}
 	}
 }
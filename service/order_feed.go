@@ -0,0 +1,134 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// NewOrderFeed: Initializes an order-by-order feed that writes its messages to writer
+func NewOrderFeed(writer io.Writer) *OrderFeed {
+	return &OrderFeed{
+		Writer: writer,
+		orders: make(map[int]Order),
+	}
+}
+
+// Publish: writes the messages an event implies, one line each
+//
+// sequence, timestamp, action, orderId, side, price, quantity, remaining
+func (f *OrderFeed) Publish(event Event) error {
+	for _, message := range f.Messages(event) {
+		if _, err := fmt.Fprintln(f.Writer, message); err != nil {
+			return errors.Wrapf(err, "error writing message %v in Publish()", message.Sequence)
+		}
+	}
+	return nil
+}
+
+// Messages: translates an event into the changes it made to individual orders, numbering each one.
+// Every order enters the feed with an add, so aggressive orders are added before they execute and a
+// consumer applying the messages in sequence holds exactly the orders resting in the book.
+func (f *OrderFeed) Messages(event Event) []OrderFeedMessage {
+	var messages []OrderFeedMessage
+	if f.orders == nil {
+		f.orders = make(map[int]Order)
+	}
+	switch event.Type {
+	case EVENT_ACK:
+		if event.Command == NEW_ORDER {
+			f.orders[event.Order.OrderID] = event.Order
+			messages = append(messages, f.message(event, ORDER_ADD, event.Order, event.Order.Quantity))
+		} else if event.Command == CANCEL_ORDER {
+			messages = append(messages, f.remove(event, event.Order)...)
+		} else if event.Command == AMEND_ORDER && event.Order.OrderID == event.Previous.OrderID {
+			if _, found := f.orders[event.Order.OrderID]; found {
+				f.orders[event.Order.OrderID] = event.Order
+				messages = append(messages, f.message(event, ORDER_MODIFY, event.Order, event.Previous.Quantity-event.Order.Quantity))
+			}
+		} else if event.Command == AMEND_ORDER {
+			// an amend that loses priority replaces the order with a new one
+			messages = append(messages, f.remove(event, event.Previous)...)
+			f.orders[event.Order.OrderID] = event.Order
+			messages = append(messages, f.message(event, ORDER_ADD, event.Order, event.Order.Quantity))
+		}
+	case EVENT_EXPIRE:
+		messages = append(messages, f.remove(event, event.Order)...)
+	case EVENT_REJECT:
+		// only an order that already rested, such as one rejected by the circuit breaker, has anything to delete
+		messages = append(messages, f.remove(event, event.Order)...)
+	case EVENT_TRADE:
+		// the older order is the one that was resting, it executes first
+		first, second := event.Bid, event.Ask
+		if second.OrderID < first.OrderID {
+			first, second = second, first
+		}
+		messages = append(messages, f.execute(event, first)...)
+		messages = append(messages, f.execute(event, second)...)
+	case EVENT_SELF_TRADE:
+		if event.Order.Quantity <= 0 {
+			messages = append(messages, f.remove(event, event.Order)...)
+		} else if resting, found := f.orders[event.Order.OrderID]; found {
+			resting.Quantity = event.Order.Quantity
+			f.orders[resting.OrderID] = resting
+			messages = append(messages, f.message(event, ORDER_MODIFY, resting, event.Quantity))
+		}
+	case EVENT_FLUSH:
+		orderIDs := make([]int, 0, len(f.orders))
+		for orderID := range f.orders {
+			orderIDs = append(orderIDs, orderID)
+		}
+		sort.Ints(orderIDs)
+		for _, orderID := range orderIDs {
+			messages = append(messages, f.remove(event, f.orders[orderID])...)
+		}
+	}
+	return messages
+}
+
+// execute: order holds what is left after the trade
+func (f *OrderFeed) execute(trade Event, order Order) []OrderFeedMessage {
+	resting, found := f.orders[order.OrderID]
+	if !found {
+		return nil
+	}
+	resting.Quantity = order.Quantity
+	if resting.Quantity <= 0 {
+		delete(f.orders, resting.OrderID)
+	} else {
+		f.orders[resting.OrderID] = resting
+	}
+	return []OrderFeedMessage{f.message(trade, ORDER_EXECUTE, resting, trade.Quantity)}
+}
+
+// remove: deletes whatever is left of an order the feed knows about
+func (f *OrderFeed) remove(event Event, order Order) []OrderFeedMessage {
+	resting, found := f.orders[order.OrderID]
+	if !found {
+		return nil
+	}
+	delete(f.orders, resting.OrderID)
+	quantity := resting.Quantity
+	resting.Quantity = 0
+	return []OrderFeedMessage{f.message(event, ORDER_DELETE, resting, quantity)}
+}
+
+func (f *OrderFeed) message(event Event, action string, order Order, quantity int) OrderFeedMessage {
+	f.Sequence++
+	return OrderFeedMessage{
+		Sequence:  f.Sequence,
+		Timestamp: event.Timestamp,
+		Action:    action,
+		OrderID:   order.OrderID,
+		Side:      order.Side,
+		Price:     order.Price,
+		Quantity:  quantity,
+		Remaining: order.Quantity,
+	}
+}
+
+func (m OrderFeedMessage) String() string {
+	return fmt.Sprintf("%v, %v, %v, %v, %v, %v, %v, %v", m.Sequence, m.Timestamp.UnixNano(), m.Action, m.OrderID, m.Side, m.Price, m.Quantity, m.Remaining)
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOrderFeedProcessOrderBook(t *testing.T) {
+	var buffer bytes.Buffer
+	testService := NewOrderBookService()
+	testService.IsTradingEnabled = true
+	testService.OrderFeed = NewOrderFeed(&buffer)
+	testService.Clock = NewSimulatedClock(time.Unix(0, 5))
+	testService.Output = ioutil.Discard
+	testService.orderBook = NewOrderBook()
+
+	orders := []Order{
+		{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: BUY},
+		{Command: NEW_ORDER, UserID: 2, UserOrderID: 2, Price: intPrice(11), Quantity: 50, Side: SELL},
+		{Command: NEW_ORDER, UserID: 2, UserOrderID: 3, Price: intPrice(10), Quantity: 60, Side: SELL},
+		{Command: CANCEL_ORDER, UserID: 1, UserOrderID: 1},
+		{Command: FLUSH_ORDER_BOOK},
+		{Command: NEW_ORDER, UserID: 1, UserOrderID: 4, Price: intPrice(9), Quantity: 10, Side: BUY},
+	}
+	if err := testService.ProcessOrderBook(orders); err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+
+	expected := []string{
+		"1, 5, ADD, 1, B, 10, 100, 100",
+		"2, 5, ADD, 2, S, 11, 50, 50",
+		"3, 5, ADD, 3, S, 10, 60, 60",
+		"4, 5, EXECUTE, 1, B, 10, 60, 40",
+		"5, 5, EXECUTE, 3, S, 10, 60, 0",
+		"6, 5, DELETE, 1, B, 10, 40, 0",
+		"7, 5, DELETE, 2, S, 11, 50, 0",
+		"8, 5, ADD, 4, B, 9, 10, 10",
+	}
+	output := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if !reflect.DeepEqual(output, expected) {
+		t.Errorf("Expected feed %v, received %v", expected, output)
+	}
+}
+
+func TestOrderFeedMessages(t *testing.T) {
+	resting := Order{OrderID: 1, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: SELL}
+	tests := map[string]struct {
+		event  Event
+		output []OrderFeedMessage
+	}{
+		"Self trade reduces the order": {
+			event: Event{Type: EVENT_SELF_TRADE, Order: Order{OrderID: 1, Quantity: 40}, Quantity: 60},
+			output: []OrderFeedMessage{
+				{Sequence: 2, Action: ORDER_MODIFY, OrderID: 1, Side: SELL, Price: intPrice(10), Quantity: 60, Remaining: 40},
+			},
+		},
+		"Self trade cancels the order": {
+			event: Event{Type: EVENT_SELF_TRADE, Order: Order{OrderID: 1}, Quantity: 100},
+			output: []OrderFeedMessage{
+				{Sequence: 2, Action: ORDER_DELETE, OrderID: 1, Side: SELL, Price: intPrice(10), Quantity: 100},
+			},
+		},
+		"Circuit breaker rejects a resting order": {
+			event: Event{Type: EVENT_REJECT, Command: NEW_ORDER, Order: Order{OrderID: 1}},
+			output: []OrderFeedMessage{
+				{Sequence: 2, Action: ORDER_DELETE, OrderID: 1, Side: SELL, Price: intPrice(10), Quantity: 100},
+			},
+		},
+		"Amend in place": {
+			event: Event{Type: EVENT_ACK, Command: AMEND_ORDER, Order: Order{OrderID: 1, Price: intPrice(10), Quantity: 70, Side: SELL}, Previous: resting},
+			output: []OrderFeedMessage{
+				{Sequence: 2, Action: ORDER_MODIFY, OrderID: 1, Side: SELL, Price: intPrice(10), Quantity: 30, Remaining: 70},
+			},
+		},
+		"Amend that loses priority": {
+			event: Event{Type: EVENT_ACK, Command: AMEND_ORDER, Order: Order{OrderID: 2, Price: intPrice(11), Quantity: 100, Side: SELL}, Previous: resting},
+			output: []OrderFeedMessage{
+				{Sequence: 2, Action: ORDER_DELETE, OrderID: 1, Side: SELL, Price: intPrice(10), Quantity: 100},
+				{Sequence: 3, Action: ORDER_ADD, OrderID: 2, Side: SELL, Price: intPrice(11), Quantity: 100, Remaining: 100},
+			},
+		},
+		"Reject before entering the book": {
+			event: Event{Type: EVENT_REJECT, Command: NEW_ORDER, Order: Order{UserID: 2, UserOrderID: 2}},
+		},
+		"Cancel of an unknown order": {
+			event: Event{Type: EVENT_ACK, Command: CANCEL_ORDER, Order: Order{OrderID: 5}},
+		},
+		"Top of book is not an order change": {
+			event: Event{Type: EVENT_TOP_OF_BOOK, Side: SELL, TopBook: TopBook{Price: intPrice(10), Quantity: 100, IsSet: true}},
+		},
+	}
+
+	for name, test := range tests {
+		feed := NewOrderFeed(nil)
+		feed.Messages(ackEvent(NEW_ORDER, resting))
+		output := feed.Messages(test.event)
+		if !reflect.DeepEqual(output, test.output) {
+			t.Errorf("Expected messages %v, received %v for test %s", test.output, output, name)
+		}
+	}
+}
+
+func TestOrderFeedScenarios(t *testing.T) {
+	var buffer bytes.Buffer
+	testService := NewOrderBookService()
+	testService.OrderFeed = NewOrderFeed(&buffer)
+	testService.Clock = NewSimulatedClock(time.Unix(0, 5))
+	testService.Output = ioutil.Discard
+
+	err := testService.ProcessOrderBooks([][]Order{
+		{{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: BUY}},
+		{{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Price: intPrice(9), Quantity: 10, Side: BUY}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+
+	expected := []string{
+		"1, 5, ADD, 1, B, 10, 100, 100",
+		"2, 5, DELETE, 1, B, 10, 100, 0",
+		"3, 5, ADD, 2, B, 9, 10, 10",
+	}
+	output := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if !reflect.DeepEqual(output, expected) {
+		t.Errorf("Expected feed %v, received %v", expected, output)
+	}
+}
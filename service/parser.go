@@ -6,16 +6,22 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
 func NewParserService() *ParserService {
-	return &ParserService{}
+	return &ParserService{InputFormat: INPUT_FORMAT}
 }
 
 func (p *ParserService) ParseCSV() ([][]string, error) {
-	file, err := os.Open(INPUT_PATH)
+	return p.ParseCSVFile(INPUT_PATH)
+}
+
+// ParseCSVFile: reads the scenarios of an input file other than the configured INPUT_PATH
+func (p *ParserService) ParseCSVFile(path string) ([][]string, error) {
+	file, err := os.Open(path)
 	if err != nil {
 		fmt.Println("file open error")
 		return nil, err
@@ -44,7 +50,7 @@ func (p *ParserService) parseOrderBookList(scanner *bufio.Scanner) [][]string {
 			currentBookInput = append(currentBookInput, line)
 			output = append(output, currentBookInput)
 			currentBookInput = []string{}
-		} else if line[0:1] == CANCEL_ORDER || line[0:1] == NEW_ORDER {
+		} else if line[0:1] == CANCEL_ORDER || line[0:1] == NEW_ORDER || line[0:1] == SESSION_STATE || line[0:1] == ADVANCE_CLOCK || line[0:1] == AMEND_ORDER {
 			currentBookInput = append(currentBookInput, line)
 		}
 	}
@@ -56,77 +62,17 @@ func (p *ParserService) TransformOrderBookListData(orderBookList [][]string) ([]
 	var (
 		orderBookInputs [][]Order
 		orderBook       []Order
-		order           Order
 	)
 
 	for _, orderBookInput := range orderBookList {
 		for _, orderline := range orderBookInput {
-			orderSplit := strings.Split(orderline, ",")
-			command := strings.TrimSpace(orderSplit[0])
-			if command == FLUSH_ORDER_BOOK {
-				if len(orderSplit) != 1 {
-					return nil, errors.New("FLUSH_ORDER_BOOK invalid input in TransformOrderBookListData()")
-				}
-				order = Order{
-					Command: FLUSH_ORDER_BOOK,
-				}
-			} else if command == NEW_ORDER {
-				if len(orderSplit) != 7 {
-					return nil, errors.New("NEW_ORDER invalid input in TransformOrderBookListData")
-				}
-				userID, err := strconv.Atoi(strings.TrimSpace(orderSplit[1]))
-				if err != nil {
-					err = errors.Wrap(err, "error converting UserID in NEW_ORDER for TransformOrderBookListData()")
-					return nil, err
-				}
-
-				price, err := strconv.Atoi(strings.TrimSpace(orderSplit[3]))
-				if err != nil {
-					err = errors.Wrap(err, "error converting price in NEW_ORDER for TransformOrderBookListData()")
-					return nil, err
-				}
-
-				quantity, err := strconv.Atoi(strings.TrimSpace(orderSplit[4]))
-				if err != nil {
-					err = errors.Wrap(err, "error converting quantity in NEW_ORDER for TransformOrderBookListData()")
-					return nil, err
-				}
-				userOrderID, err := strconv.Atoi(strings.TrimSpace(orderSplit[6]))
-				if err != nil {
-					err = errors.Wrap(err, "error converting userOrderID in NEW_ORDER for TransformOrderBookListData()")
-					return nil, err
-				}
-
-				order = Order{
-					Command:     NEW_ORDER,
-					UserID:      userID,
-					Symbol:      strings.TrimSpace(orderSplit[2]),
-					Price:       price,
-					Quantity:    quantity,
-					Side:        strings.TrimSpace(orderSplit[5]),
-					UserOrderID: userOrderID,
-				}
-			} else if command == CANCEL_ORDER {
-				if len(orderSplit) != 3 {
-					return nil, errors.New("CANCEL_ORDER invalid input in TransformOrderBookListData()")
-				}
-				userID, err := strconv.Atoi(strings.TrimSpace(orderSplit[1]))
-				if err != nil {
-					err = errors.Wrap(err, "error converting userID in CANCEL_ORDER for TransformOrderBookListData()")
-					return nil, err
-				}
-
-				userOrderID, err := strconv.Atoi(strings.TrimSpace(orderSplit[2]))
-				if err != nil {
-					err = errors.Wrap(err, "error converting userOrderID in CANCEL_ORDER for TransformOrderBookListData()")
-					return nil, err
-				}
-
-				order = Order{
-					Command:     CANCEL_ORDER,
-					UserID:      userID,
-					UserOrderID: userOrderID,
-				}
+			parse := p.ParseCommand
+			if p.InputFormat == FORMAT_JSON_LINES {
+				parse = p.ParseJSONCommand
+			}
+			order, err := parse(orderline)
+			if err != nil {
+				return nil, errors.Wrap(err, "error parsing command in TransformOrderBookListData()")
 			}
 			orderBook = append(orderBook, order)
 		}
@@ -135,3 +81,248 @@ func (p *ParserService) TransformOrderBookListData(orderBookList [][]string) ([]
 	}
 	return orderBookInputs, nil
 }
+
+// ParseOrderBooks: reads and converts the scenarios of an input file in the parser's input format
+func (p *ParserService) ParseOrderBooks(path string) ([][]Order, error) {
+	if p.InputFormat == FORMAT_BINARY {
+		return p.ParseBinaryFile(path)
+	}
+	orderBookListData, err := p.ParseInputFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing input in ParseOrderBooks()")
+	}
+	orderBooks, err := p.TransformOrderBookListData(orderBookListData)
+	if err != nil {
+		return nil, errors.Wrap(err, "error transforming raw string data in ParseOrderBooks()")
+	}
+	return orderBooks, nil
+}
+
+// ParseCommand: converts a single line of input to the command it describes
+func (p *ParserService) ParseCommand(orderline string) (Order, error) {
+	orderSplit := strings.Split(orderline, ",")
+	command := strings.TrimSpace(orderSplit[0])
+	if command == FLUSH_ORDER_BOOK {
+		if len(orderSplit) != 1 {
+			return Order{}, errors.New("FLUSH_ORDER_BOOK invalid input in ParseCommand()")
+		}
+		return Order{
+			Command: FLUSH_ORDER_BOOK,
+		}, nil
+	} else if command == NEW_ORDER {
+		// the optional eighth field makes the order good till date
+		if len(orderSplit) != 7 && len(orderSplit) != 8 {
+			return Order{}, errors.New("NEW_ORDER invalid input in ParseCommand()")
+		}
+		userID, err := strconv.Atoi(strings.TrimSpace(orderSplit[1]))
+		if err != nil {
+			err = errors.Wrap(err, "error converting UserID in NEW_ORDER for ParseCommand()")
+			return Order{}, err
+		}
+
+		price, err := ParsePrice(orderSplit[3])
+		if err != nil {
+			err = errors.Wrap(err, "error converting price in NEW_ORDER for ParseCommand()")
+			return Order{}, err
+		}
+
+		quantity, err := strconv.Atoi(strings.TrimSpace(orderSplit[4]))
+		if err != nil {
+			err = errors.Wrap(err, "error converting quantity in NEW_ORDER for ParseCommand()")
+			return Order{}, err
+		}
+		userOrderID, err := strconv.Atoi(strings.TrimSpace(orderSplit[6]))
+		if err != nil {
+			err = errors.Wrap(err, "error converting userOrderID in NEW_ORDER for ParseCommand()")
+			return Order{}, err
+		}
+		var expiresAt time.Time
+		if len(orderSplit) == 8 {
+			expiresAt, err = parseTimestamp(orderSplit[7])
+			if err != nil {
+				err = errors.Wrap(err, "error converting expiry in NEW_ORDER for ParseCommand()")
+				return Order{}, err
+			}
+		}
+
+		return Order{
+			Command:     NEW_ORDER,
+			UserID:      userID,
+			Symbol:      strings.TrimSpace(orderSplit[2]),
+			Price:       price,
+			Quantity:    quantity,
+			Side:        strings.TrimSpace(orderSplit[5]),
+			UserOrderID: userOrderID,
+			ExpiresAt:   expiresAt,
+		}, nil
+	} else if command == CANCEL_ORDER {
+		if len(orderSplit) != 3 {
+			return Order{}, errors.New("CANCEL_ORDER invalid input in ParseCommand()")
+		}
+		userID, err := strconv.Atoi(strings.TrimSpace(orderSplit[1]))
+		if err != nil {
+			err = errors.Wrap(err, "error converting userID in CANCEL_ORDER for ParseCommand()")
+			return Order{}, err
+		}
+
+		userOrderID, err := strconv.Atoi(strings.TrimSpace(orderSplit[2]))
+		if err != nil {
+			err = errors.Wrap(err, "error converting userOrderID in CANCEL_ORDER for ParseCommand()")
+			return Order{}, err
+		}
+
+		return Order{
+			Command:     CANCEL_ORDER,
+			UserID:      userID,
+			UserOrderID: userOrderID,
+		}, nil
+	} else if command == SESSION_STATE {
+		if len(orderSplit) != 2 {
+			return Order{}, errors.New("SESSION_STATE invalid input in ParseCommand()")
+		}
+		return Order{
+			Command:      SESSION_STATE,
+			SessionState: strings.TrimSpace(orderSplit[1]),
+		}, nil
+	} else if command == AMEND_ORDER {
+		if len(orderSplit) != 5 {
+			return Order{}, errors.New("AMEND_ORDER invalid input in ParseCommand()")
+		}
+		userID, err := strconv.Atoi(strings.TrimSpace(orderSplit[1]))
+		if err != nil {
+			err = errors.Wrap(err, "error converting userID in AMEND_ORDER for ParseCommand()")
+			return Order{}, err
+		}
+		userOrderID, err := strconv.Atoi(strings.TrimSpace(orderSplit[2]))
+		if err != nil {
+			err = errors.Wrap(err, "error converting userOrderID in AMEND_ORDER for ParseCommand()")
+			return Order{}, err
+		}
+		price, err := ParsePrice(orderSplit[3])
+		if err != nil {
+			err = errors.Wrap(err, "error converting price in AMEND_ORDER for ParseCommand()")
+			return Order{}, err
+		}
+		quantity, err := strconv.Atoi(strings.TrimSpace(orderSplit[4]))
+		if err != nil {
+			err = errors.Wrap(err, "error converting quantity in AMEND_ORDER for ParseCommand()")
+			return Order{}, err
+		}
+
+		return Order{
+			Command:     AMEND_ORDER,
+			UserID:      userID,
+			UserOrderID: userOrderID,
+			Price:       price,
+			Quantity:    quantity,
+		}, nil
+	} else if command == ADVANCE_CLOCK {
+		if len(orderSplit) != 2 {
+			return Order{}, errors.New("ADVANCE_CLOCK invalid input in ParseCommand()")
+		}
+		timestamp, err := parseTimestamp(orderSplit[1])
+		if err != nil {
+			err = errors.Wrap(err, "error converting timestamp in ADVANCE_CLOCK for ParseCommand()")
+			return Order{}, err
+		}
+		// the command is received at the time it names, which moves the service's time forward to it
+		return Order{
+			Command:   ADVANCE_CLOCK,
+			Timestamp: timestamp,
+		}, nil
+	}
+	return Order{}, errors.Errorf("unknown command %v in ParseCommand()", command)
+}
+
+// parseTimestamp: times in the input are nanoseconds since the Unix epoch
+func parseTimestamp(field string) (time.Time, error) {
+	nanos, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, nanos).UTC(), nil
+}
+
+// FormatCommand: writes a command back out as the line of input it was parsed from
+func (p *ParserService) FormatCommand(order Order) string {
+	switch order.Command {
+	case NEW_ORDER:
+		line := fmt.Sprintf("N, %v, %v, %v, %v, %v, %v", order.UserID, order.Symbol, order.Price, order.Quantity, order.Side, order.UserOrderID)
+		if !order.ExpiresAt.IsZero() {
+			line += fmt.Sprintf(", %v", order.ExpiresAt.UnixNano())
+		}
+		return line
+	case CANCEL_ORDER:
+		return fmt.Sprintf("C, %v, %v", order.UserID, order.UserOrderID)
+	case SESSION_STATE:
+		return fmt.Sprintf("P, %v", order.SessionState)
+	case AMEND_ORDER:
+		return fmt.Sprintf("M, %v, %v, %v, %v", order.UserID, order.UserOrderID, order.Price, order.Quantity)
+	case ADVANCE_CLOCK:
+		return fmt.Sprintf("K, %v", order.Timestamp.UnixNano())
+	}
+	return order.Command
+}
+
+// ParseInstruments: reads instrument definitions, one per line in the format
+// symbol, priceScale, tickSize, lotSize, minQty, maxQty, minPrice, maxPrice
+func (p *ParserService) ParseInstruments(path string) (map[string]Instrument, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening instruments file in ParseInstruments()")
+	}
+	defer file.Close()
+
+	instruments := make(map[string]Instrument)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0:1] == "#" {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) != 8 {
+			return nil, errors.Errorf("invalid instrument definition %v in ParseInstruments()", line)
+		}
+		symbol := strings.TrimSpace(fields[0])
+		scale, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+		if err != nil {
+			return nil, errors.Wrapf(err, "error converting price scale of instrument %v in ParseInstruments()", symbol)
+		}
+
+		// tick size and price limits are held at the instrument's own scale
+		prices := make([]Price, 3)
+		for i, field := range []string{fields[2], fields[6], fields[7]} {
+			price, err := ParsePrice(field)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error converting price of instrument %v in ParseInstruments()", symbol)
+			}
+			prices[i], err = price.Rescale(scale)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error scaling price of instrument %v in ParseInstruments()", symbol)
+			}
+		}
+		quantities := make([]int, 3)
+		for i, field := range []string{fields[3], fields[4], fields[5]} {
+			quantities[i], err = strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				return nil, errors.Wrapf(err, "error converting quantity of instrument %v in ParseInstruments()", symbol)
+			}
+		}
+
+		instruments[symbol] = Instrument{
+			Symbol:          symbol,
+			PriceScale:      scale,
+			TickSize:        prices[0],
+			LotSize:         quantities[0],
+			MinimumQuantity: quantities[1],
+			MaximumQuantity: quantities[2],
+			MinimumPrice:    prices[1],
+			MaximumPrice:    prices[2],
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "error reading instruments file in ParseInstruments()")
+	}
+	return instruments, nil
+}
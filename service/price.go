@@ -0,0 +1,133 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MAX_PRICE_SCALE: the most decimal places a price can carry, 10^18 is the largest power of ten in an int64
+const MAX_PRICE_SCALE = 18
+
+// ErrPriceOverflow: the price does not fit in an int64 at the scale it was asked for
+var ErrPriceOverflow = errors.New("price overflows")
+
+// NewPrice: builds the fixed-point price units / 10^scale
+func NewPrice(units int64, scale int) Price {
+	return Price{Units: units, Scale: scale}
+}
+
+// ParsePrice: converts a decimal string such as 10, 10.25 or -0.5 to a Price, keeping exactly as many
+// decimal places as were written
+func ParsePrice(value string) (Price, error) {
+	value = strings.TrimSpace(value)
+	whole, fraction := value, ""
+	if point := strings.Index(value, "."); point >= 0 {
+		whole, fraction = value[:point], value[point+1:]
+		if len(fraction) == 0 || strings.ContainsAny(fraction, "+-") {
+			return Price{}, errors.Errorf("invalid decimal price %v in ParsePrice()", value)
+		}
+	}
+	if len(fraction) > MAX_PRICE_SCALE {
+		return Price{}, errors.Errorf("price %v has more than %v decimal places in ParsePrice()", value, MAX_PRICE_SCALE)
+	}
+	if whole == "" || whole == "-" || whole == "+" {
+		whole += "0"
+	}
+	units, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return Price{}, errors.Wrapf(err, "error converting price %v in ParsePrice()", value)
+	}
+	return Price{Units: units, Scale: len(fraction)}, nil
+}
+
+// String: formats the price with all of its decimal places, so an instrument quoted in cents prints 10.50
+func (p Price) String() string {
+	if p.Scale == 0 {
+		return strconv.FormatInt(p.Units, 10)
+	}
+	digits := strconv.FormatInt(p.Units, 10)
+	sign := ""
+	if digits[0] == '-' {
+		sign, digits = "-", digits[1:]
+	}
+	if len(digits) <= p.Scale {
+		digits = strings.Repeat("0", p.Scale-len(digits)+1) + digits
+	}
+	point := len(digits) - p.Scale
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+func (p Price) IsZero() bool {
+	return p.Units == 0
+}
+
+// Cmp: returns -1, 0 or 1 as p is less than, equal to or greater than other. Prices of different scales
+// compare their whole and fractional parts separately so no intermediate value can overflow.
+func (p Price) Cmp(other Price) int {
+	if p.Scale == other.Scale {
+		return compareInt64(p.Units, other.Units)
+	}
+	pWhole, pFraction := p.split()
+	otherWhole, otherFraction := other.split()
+	if pWhole != otherWhole {
+		return compareInt64(pWhole, otherWhole)
+	}
+	scale := p.Scale
+	if other.Scale > scale {
+		scale = other.Scale
+	}
+	return compareInt64(pFraction*pow10(scale-p.Scale), otherFraction*pow10(scale-other.Scale))
+}
+
+// Rescale: expresses the price with a different number of decimal places, failing if that would lose precision
+func (p Price) Rescale(scale int) (Price, error) {
+	if scale < 0 || scale > MAX_PRICE_SCALE {
+		return Price{}, errors.Errorf("invalid price scale %v in Rescale()", scale)
+	}
+	if scale >= p.Scale {
+		factor := pow10(scale - p.Scale)
+		units := p.Units * factor
+		if units/factor != p.Units {
+			return Price{}, errors.Wrapf(ErrPriceOverflow, "price %v at scale %v in Rescale()", p, scale)
+		}
+		return Price{Units: units, Scale: scale}, nil
+	}
+	factor := pow10(p.Scale - scale)
+	if p.Units%factor != 0 {
+		return Price{}, errors.Errorf("price %v cannot be represented at scale %v in Rescale()", p, scale)
+	}
+	return Price{Units: p.Units / factor, Scale: scale}, nil
+}
+
+// Normalize: the same price without trailing zero decimal places, so 10, 10.0 and 10.00 all print as 10
+func (p Price) Normalize() Price {
+	for p.Scale > 0 && p.Units%10 == 0 {
+		p.Units /= 10
+		p.Scale--
+	}
+	return p
+}
+
+func (p Price) split() (int64, int64) {
+	factor := pow10(p.Scale)
+	return p.Units / factor, p.Units % factor
+}
+
+func pow10(exponent int) int64 {
+	result := int64(1)
+	for i := 0; i < exponent; i++ {
+		result *= 10
+	}
+	return result
+}
+
+func compareInt64(a int64, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParsePrice(t *testing.T) {
+	tests := map[string]struct {
+		input  string
+		price  Price
+		output string
+		err    bool
+	}{
+		"Whole number":       {input: " 10 ", price: NewPrice(10, 0), output: "10"},
+		"Cents":              {input: "10.25", price: NewPrice(1025, 2), output: "10.25"},
+		"Trailing zero kept": {input: "10.50", price: NewPrice(1050, 2), output: "10.50"},
+		"Negative fraction":  {input: "-0.05", price: NewPrice(-5, 2), output: "-0.05"},
+		"No whole part":      {input: ".5", price: NewPrice(5, 1), output: "0.5"},
+		"Missing fraction":   {input: "10.", err: true},
+		"Not a number":       {input: "1e5", err: true},
+		"Too many decimals":  {input: "0.1234567890123456789", err: true},
+	}
+
+	for name, test := range tests {
+		price, err := ParsePrice(test.input)
+		if test.err {
+			if err == nil {
+				t.Errorf("Expected an error, received price %v for test %s", price, name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Expected no error, received %s for test %s", err, name)
+		}
+		if price != test.price {
+			t.Errorf("Expected price %#v, received %#v for test %s", test.price, price, name)
+		}
+		if price.String() != test.output {
+			t.Errorf("Expected output %s, received %s for test %s", test.output, price.String(), name)
+		}
+	}
+}
+
+func TestPriceCmp(t *testing.T) {
+	tests := map[string]struct {
+		a      Price
+		b      Price
+		result int
+	}{
+		"Same scale":                         {a: NewPrice(1025, 2), b: NewPrice(1030, 2), result: -1},
+		"Equal across scales":                {a: NewPrice(105, 1), b: NewPrice(1050, 2), result: 0},
+		"Greater across scales":              {a: NewPrice(11, 0), b: NewPrice(1099, 2), result: 1},
+		"Negative fractions":                 {a: NewPrice(-15, 1), b: NewPrice(-12, 1), result: -1},
+		"Large whole part does not overflow": {a: NewPrice(math.MaxInt64, 0), b: NewPrice(1025, 2), result: 1},
+	}
+
+	for name, test := range tests {
+		if result := test.a.Cmp(test.b); result != test.result {
+			t.Errorf("Expected %v, received %v for test %s", test.result, result, name)
+		}
+	}
+}
+
+func TestPriceRescale(t *testing.T) {
+	tests := map[string]struct {
+		price Price
+		scale int
+		want  Price
+		err   bool
+	}{
+		"Widen":            {price: NewPrice(103, 1), scale: 2, want: NewPrice(1030, 2)},
+		"Narrow exactly":   {price: NewPrice(1030, 2), scale: 1, want: NewPrice(103, 1)},
+		"Narrow with loss": {price: NewPrice(1025, 2), scale: 1, err: true},
+		"Overflow":         {price: NewPrice(math.MaxInt64, 0), scale: 2, err: true},
+	}
+
+	for name, test := range tests {
+		price, err := test.price.Rescale(test.scale)
+		if test.err != (err != nil) {
+			t.Errorf("Expected error %v, received %v for test %s", test.err, err, name)
+		}
+		if !test.err && price != test.want {
+			t.Errorf("Expected price %#v, received %#v for test %s", test.want, price, name)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// FindDivergence: compares two outputs byte for byte and reports the first line where they differ with
+// up to context lines either side, nil when they are identical. A missing or extra trailing newline is a
+// difference on the last line.
+func FindDivergence(expected []byte, actual []byte, context int) *Divergence {
+	if bytes.Equal(expected, actual) {
+		return nil
+	}
+	expectedLines := strings.SplitAfter(string(expected), "\n")
+	actualLines := strings.SplitAfter(string(actual), "\n")
+	line := 0
+	for line < len(expectedLines) && line < len(actualLines) && expectedLines[line] == actualLines[line] {
+		line++
+	}
+
+	start := line - context
+	if start < 0 {
+		start = 0
+	}
+	return &Divergence{
+		Line:         line + 1,
+		ContextStart: start + 1,
+		Expected:     contextLines(expectedLines, start, line+context+1),
+		Actual:       contextLines(actualLines, start, line+context+1),
+	}
+}
+
+func contextLines(lines []string, start int, end int) []string {
+	if end > len(lines) {
+		end = len(lines)
+	}
+	if start >= end {
+		return nil
+	}
+	return lines[start:end]
+}
+
+// String: a report of the divergence with the differing line marked in both outputs. Lines are quoted so
+// a difference in whitespace or line endings alone is still visible, an output that ends early shows its
+// last line as empty.
+func (d Divergence) String() string {
+	var report strings.Builder
+	fmt.Fprintf(&report, "first divergence at line %v\n", d.Line)
+	for _, output := range []struct {
+		name  string
+		lines []string
+	}{{"expected", d.Expected}, {"actual", d.Actual}} {
+		fmt.Fprintf(&report, "%v:\n", output.name)
+		for i, line := range output.lines {
+			marker := " "
+			if d.ContextStart+i == d.Line {
+				marker = ">"
+			}
+			fmt.Fprintf(&report, "%v %5d  %q\n", marker, d.ContextStart+i, line)
+		}
+	}
+	return report.String()
+}
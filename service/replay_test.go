@@ -0,0 +1,105 @@
+package service
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindDivergence(t *testing.T) {
+	tests := map[string]struct {
+		expected   string
+		actual     string
+		divergence *Divergence
+	}{
+		"Identical output": {
+			expected: "A, 1, 1\nB, B, 10, 100\n",
+			actual:   "A, 1, 1\nB, B, 10, 100\n",
+		},
+		"Changed line": {
+			expected: "A, 1, 1\nB, B, 10, 100\nA, 2, 2\nB, S, 11, 100\n",
+			actual:   "A, 1, 1\nB, B, 10, 100\nR, 2, 2\nB, S, 11, 100\n",
+			divergence: &Divergence{
+				Line:         3,
+				ContextStart: 2,
+				Expected:     []string{"B, B, 10, 100\n", "A, 2, 2\n", "B, S, 11, 100\n"},
+				Actual:       []string{"B, B, 10, 100\n", "R, 2, 2\n", "B, S, 11, 100\n"},
+			},
+		},
+		"Output ends early": {
+			expected: "A, 1, 1\nB, B, 10, 100\n",
+			actual:   "A, 1, 1\n",
+			divergence: &Divergence{
+				Line:         2,
+				ContextStart: 1,
+				Expected:     []string{"A, 1, 1\n", "B, B, 10, 100\n", ""},
+				Actual:       []string{"A, 1, 1\n", ""},
+			},
+		},
+		"Missing trailing newline": {
+			expected: "A, 1, 1\n",
+			actual:   "A, 1, 1",
+			divergence: &Divergence{
+				Line:         1,
+				ContextStart: 1,
+				Expected:     []string{"A, 1, 1\n", ""},
+				Actual:       []string{"A, 1, 1"},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		divergence := FindDivergence([]byte(test.expected), []byte(test.actual), 1)
+		if !reflect.DeepEqual(divergence, test.divergence) {
+			t.Errorf("Expected divergence %v, received %v for test %s", test.divergence, divergence, name)
+		}
+	}
+}
+
+func TestReplayJournalIsDeterministic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "commands.journal")
+
+	var recorded bytes.Buffer
+	original := newJournaledService(t, path)
+	original.Output = &recorded
+	if err := original.ProcessOrderBook(journalCommands); err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	original.Journal.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	defer file.Close()
+	scan, err := ReadJournal(file)
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	var commands []Order
+	for _, record := range scan.Records {
+		commands = append(commands, record.Command)
+	}
+
+	var replayed bytes.Buffer
+	replayService := NewOrderBookService()
+	replayService.IsTradingEnabled = true
+	replayService.Output = &replayed
+	if err := replayService.ProcessOrderBook(commands); err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	if divergence := FindDivergence(recorded.Bytes(), replayed.Bytes(), 3); divergence != nil {
+		t.Errorf("Expected identical output, received %v", divergence)
+	}
+	if recorded.Len() == 0 {
+		t.Errorf("Expected the journaled commands to produce output")
+	}
+}
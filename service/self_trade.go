@@ -0,0 +1,45 @@
+package service
+
+// selfTradeKey: identifies who owns an order for self trade prevention. Orders are keyed on UserID for
+// now, account and firm level prevention only needs to change the key returned here.
+func selfTradeKey(order Order) int {
+	return order.UserID
+}
+
+// preventSelfTrade: resolves a crossed bid and ask from the same owner according to the configured mode,
+// publishing an event for every order that was cancelled or reduced
+//
+// X, userId, userOrderId, cancelledQuantity, remainingQuantity, mode
+func (o *OrderBookService) preventSelfTrade(bid Order, ask Order) []Event {
+	var events []Event
+	newest, oldest := bid, ask
+	if ask.OrderID > bid.OrderID {
+		newest, oldest = ask, bid
+	}
+
+	switch o.SelfTradePrevention {
+	case CANCEL_OLDEST:
+		events = append(events, o.cancelSelfTrade(oldest, oldest.Quantity))
+	case CANCEL_BOTH:
+		events = append(events, o.cancelSelfTrade(newest, newest.Quantity))
+		events = append(events, o.cancelSelfTrade(oldest, oldest.Quantity))
+	case DECREMENT_AND_CANCEL:
+		// the smaller order is cancelled outright and the larger one is reduced by the same quantity
+		quantity := bid.Quantity
+		if ask.Quantity < quantity {
+			quantity = ask.Quantity
+		}
+		events = append(events, o.cancelSelfTrade(bid, quantity))
+		events = append(events, o.cancelSelfTrade(ask, quantity))
+	default:
+		events = append(events, o.cancelSelfTrade(newest, newest.Quantity))
+	}
+	return events
+}
+
+// cancelSelfTrade: reduces an order by quantity, it is removed from the book once nothing is left
+func (o *OrderBookService) cancelSelfTrade(order Order, quantity int) Event {
+	o.reduceOrder(order.Side, order.UserOrderID, quantity)
+	order.Quantity -= quantity
+	return Event{Type: EVENT_SELF_TRADE, Order: order, Quantity: quantity, Reason: o.SelfTradePrevention}
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSelfTradePrevention(t *testing.T) {
+	testService := NewOrderBookService()
+	testService.IsTradingEnabled = true
+	tests := map[string]struct {
+		mode           string
+		askUserID      int
+		finalAskLength int
+		finalBidLength int
+		output         []string
+	}{
+		"Cancel newest": {
+			mode:           CANCEL_NEWEST,
+			askUserID:      1,
+			finalAskLength: 1,
+			output:         []string{"X, 1, 2, 60, 0, CANCEL_NEWEST"},
+		},
+		"Cancel oldest": {
+			mode:           CANCEL_OLDEST,
+			askUserID:      1,
+			finalBidLength: 1,
+			output:         []string{"X, 1, 1, 100, 0, CANCEL_OLDEST"},
+		},
+		"Cancel both": {
+			mode:      CANCEL_BOTH,
+			askUserID: 1,
+			output:    []string{"X, 1, 2, 60, 0, CANCEL_BOTH", "X, 1, 1, 100, 0, CANCEL_BOTH"},
+		},
+		"Decrement and cancel": {
+			mode:           DECREMENT_AND_CANCEL,
+			askUserID:      1,
+			finalAskLength: 1,
+			output:         []string{"X, 1, 2, 60, 0, DECREMENT_AND_CANCEL", "X, 1, 1, 60, 40, DECREMENT_AND_CANCEL"},
+		},
+		"Different users are not prevented": {
+			mode:           CANCEL_NEWEST,
+			askUserID:      2,
+			finalAskLength: 1,
+			output:         []string{"T, 1, 2, 2, 1, 10, 60"},
+		},
+	}
+
+	for name, test := range tests {
+		testService.SelfTradePrevention = test.mode
+		testService.orderBook = NewOrderBook()
+
+		ask := &Order{Command: NEW_ORDER, UserID: test.askUserID, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: SELL}
+		bid := &Order{Command: NEW_ORDER, UserID: 1, UserOrderID: 2, Price: intPrice(10), Quantity: 60, Side: BUY}
+		for _, order := range []*Order{ask, bid} {
+			if _, err := testService.newOrder(order); err != nil {
+				t.Fatalf("Expected no error, received %s for test %s", err, name)
+			}
+		}
+
+		events, err := testService.executeTrade(bid)
+		output := eventLines(events)
+		if err != nil {
+			t.Errorf("Expected no error, received %s for test %s", err, name)
+		}
+		if !reflect.DeepEqual(output, test.output) {
+			t.Errorf("Expected output %v, received %v for test %s", test.output, output, name)
+		}
+		if test.finalAskLength != len(testService.orderBook.Asks) {
+			t.Errorf("Expected ask length %v, received %v for test %s", test.finalAskLength, len(testService.orderBook.Asks), name)
+		}
+		if test.finalBidLength != len(testService.orderBook.Bids) {
+			t.Errorf("Expected bid length %v, received %v for test %s", test.finalBidLength, len(testService.orderBook.Bids), name)
+		}
+	}
+}
+
+func TestSelfTradePreventionFills(t *testing.T) {
+	tests := map[string]struct {
+		mode           string
+		allocation     AllocationStrategy
+		firstQuantity  int
+		finalAskLength int
+		output         []string
+	}{
+		"Older order from another user fills first": {
+			mode:           CANCEL_NEWEST,
+			allocation:     PriceTimeAllocation{},
+			firstQuantity:  100,
+			finalAskLength: 1,
+			output:         []string{"T, 1, 3, 2, 1, 10, 100"},
+		},
+		"Fills before the own order trade": {
+			mode:           CANCEL_NEWEST,
+			allocation:     PriceTimeAllocation{},
+			firstQuantity:  50,
+			finalAskLength: 1,
+			output:         []string{"T, 1, 3, 2, 1, 10, 50", "X, 1, 3, 50, 0, CANCEL_NEWEST"},
+		},
+		"Pro-rata share of the own order is reallocated": {
+			mode:          CANCEL_OLDEST,
+			allocation:    ProRataAllocation{MinimumAllocation: 1, Rounding: ROUND_DOWN},
+			firstQuantity: 100,
+			output:        []string{"T, 1, 3, 2, 1, 10, 50", "X, 1, 2, 100, 0, CANCEL_OLDEST", "T, 1, 3, 2, 1, 10, 50"},
+		},
+	}
+
+	for name, test := range tests {
+		testService := NewOrderBookService()
+		testService.IsTradingEnabled = true
+		testService.SelfTradePrevention = test.mode
+		testService.Allocation = test.allocation
+
+		bid := &Order{Command: NEW_ORDER, UserID: 1, UserOrderID: 3, Price: intPrice(10), Quantity: 100, Side: BUY}
+		for _, order := range []*Order{
+			{Command: NEW_ORDER, UserID: 2, UserOrderID: 1, Price: intPrice(10), Quantity: test.firstQuantity, Side: SELL},
+			{Command: NEW_ORDER, UserID: 1, UserOrderID: 2, Price: intPrice(10), Quantity: 100, Side: SELL},
+			bid,
+		} {
+			if _, err := testService.newOrder(order); err != nil {
+				t.Fatalf("Expected no error, received %s for test %s", err, name)
+			}
+		}
+
+		events, err := testService.executeTrade(bid)
+		output := eventLines(events)
+		if err != nil {
+			t.Errorf("Expected no error, received %s for test %s", err, name)
+		}
+		if !reflect.DeepEqual(output, test.output) {
+			t.Errorf("Expected output %v, received %v for test %s", test.output, output, name)
+		}
+		if test.finalAskLength != len(testService.orderBook.Asks) {
+			t.Errorf("Expected ask length %v, received %v for test %s", test.finalAskLength, len(testService.orderBook.Asks), name)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var ErrSequencerClosed = errors.New("sequencer is closed")
+
+// spins a waiting producer or consumer yields for before it starts sleeping between checks
+const ringSpinLimit = 100
+
+// NewRingBuffer: Initializes a ring buffer with size slots, rounded up to a power of two so a sequence
+// number finds its slot with a mask
+func NewRingBuffer(size int) *RingBuffer {
+	if size <= 0 {
+		size = RING_BUFFER_SIZE
+	}
+	slots := int64(1)
+	for slots < int64(size) {
+		slots <<= 1
+	}
+	ring := &RingBuffer{
+		size:     slots,
+		mask:     slots - 1,
+		slots:    make([]ringSlot, slots),
+		sequence: make([]int64, slots),
+	}
+	// no slot has been published yet, the first pass through the ring publishes sequence numbers 1 to size
+	for i := range ring.sequence {
+		ring.sequence[i] = int64(i) - slots
+	}
+	return ring
+}
+
+// claim: hands out the next sequence number, sequence numbers start at 1
+func (r *RingBuffer) claim() int64 {
+	return atomic.AddInt64(&r.claimed, 1)
+}
+
+// waitForRoom: waits until the consumer has released the slot sequence needs, giving up if stopped closes first
+func (r *RingBuffer) waitForRoom(sequence int64, stopped <-chan struct{}) bool {
+	for spins := 0; sequence-r.size > atomic.LoadInt64(&r.consumed); spins++ {
+		select {
+		case <-stopped:
+			return false
+		default:
+		}
+		backOff(spins)
+	}
+	return true
+}
+
+// publish: fills the slot of a claimed sequence number and hands it to the consumer
+func (r *RingBuffer) publish(sequence int64, slot ringSlot) {
+	r.slots[sequence&r.mask] = slot
+	atomic.StoreInt64(&r.sequence[sequence&r.mask], sequence)
+}
+
+// next: waits for the slot of sequence to be published and releases it back to the producers
+func (r *RingBuffer) next(sequence int64) ringSlot {
+	for spins := 0; atomic.LoadInt64(&r.sequence[sequence&r.mask]) != sequence; spins++ {
+		backOff(spins)
+	}
+	slot := r.slots[sequence&r.mask]
+	r.slots[sequence&r.mask] = ringSlot{}
+	atomic.StoreInt64(&r.consumed, sequence)
+	return slot
+}
+
+// backOff: yields while a wait is short, then sleeps so an idle sequencer does not hold a CPU
+func backOff(spins int) {
+	if spins < ringSpinLimit {
+		runtime.Gosched()
+		return
+	}
+	time.Sleep(50 * time.Microsecond)
+}
+
+// NewSequencer: Initializes a sequencer that applies commands to service from a ring buffer of size slots,
+// RING_BUFFER_SIZE when 0. From here on the sequencer's goroutine is the only one that may touch service,
+// until Close returns.
+func NewSequencer(service *OrderBookService, size int) *Sequencer {
+	sequencer := &Sequencer{
+		ring:    NewRingBuffer(size),
+		service: service,
+		done:    make(chan struct{}),
+	}
+	go sequencer.run()
+	return sequencer
+}
+
+// Submit: gives the command its place in the total order and queues it, waiting while the ring is full.
+// It returns the command's sequence number, which is also the sequence number the service stamps on it
+// when the service has not processed any commands of its own before.
+func (s *Sequencer) Submit(order Order) (int64, error) {
+	if atomic.LoadInt32(&s.closed) == 1 {
+		return 0, ErrSequencerClosed
+	}
+	sequence := s.ring.claim()
+	// Close marks the sequencer closed before it claims its own slot, so a command that still finds it open
+	// is ahead of the stop marker and will be applied
+	accepted := atomic.LoadInt32(&s.closed) == 0
+	if !s.ring.waitForRoom(sequence, s.done) {
+		return 0, ErrSequencerClosed
+	}
+	if !accepted {
+		s.ring.publish(sequence, ringSlot{skip: true})
+		return 0, ErrSequencerClosed
+	}
+	s.ring.publish(sequence, ringSlot{order: order})
+	return sequence, nil
+}
+
+// Close: turns away new commands, waits for every accepted command to be applied and returns the first
+// error the service ran into
+func (s *Sequencer) Close() error {
+	if atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		sequence := s.ring.claim()
+		s.ring.waitForRoom(sequence, s.done)
+		s.ring.publish(sequence, ringSlot{stop: true})
+	}
+	<-s.done
+	return s.err
+}
+
+// run: the consumer, applies the commands in sequence until it reaches the stop marker. Once a command has
+// failed the rest are still taken off the ring so producers are not held up, but none of them is applied.
+func (s *Sequencer) run() {
+	defer close(s.done)
+	for sequence := int64(1); ; sequence++ {
+		slot := s.ring.next(sequence)
+		if slot.stop {
+			return
+		}
+		if slot.skip || s.err != nil {
+			continue
+		}
+		if err := s.service.ProcessOrderBook([]Order{slot.order}); err != nil {
+			s.err = errors.Wrapf(err, "error applying command %v in run()", sequence)
+		}
+	}
+}
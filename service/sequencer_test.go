@@ -0,0 +1,208 @@
+package service
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func newSequencedService() *OrderBookService {
+	testService := NewOrderBookService()
+	testService.Output = ioutil.Discard
+	testService.Clock = NewSimulatedClock(time.Unix(0, 0))
+	return testService
+}
+
+func TestSequencer(t *testing.T) {
+	tests := map[string]struct {
+		producers int
+		commands  int
+		size      int
+	}{
+		"Single producer":             {producers: 1, commands: 500, size: 16},
+		"Producers wrap a small ring": {producers: 8, commands: 200, size: 4},
+		"Default ring size":           {producers: 4, commands: 300},
+	}
+
+	for name, test := range tests {
+		testService := newSequencedService()
+		applied := make(map[int]int) // user order id to the sequence number the service stamped on it
+		testService.OnEvent = func(event Event) {
+			if event.Type == EVENT_ACK {
+				applied[event.Order.UserOrderID] = event.Order.Sequence
+			}
+		}
+		sequencer := NewSequencer(testService, test.size)
+
+		var wait sync.WaitGroup
+		var mutex sync.Mutex
+		assigned := make(map[int]int64)
+		for p := 0; p < test.producers; p++ {
+			wait.Add(1)
+			go func(p int) {
+				defer wait.Done()
+				for i := 0; i < test.commands; i++ {
+					userOrderID := p*test.commands + i + 1
+					// every order rests at its own price, so the service acknowledges all of them
+					sequence, err := sequencer.Submit(Order{Command: NEW_ORDER, UserID: p, UserOrderID: userOrderID, Price: intPrice(int64(userOrderID)), Quantity: 1, Side: BUY})
+					if err != nil {
+						t.Errorf("Expected no error, received %s for test %s", err, name)
+						return
+					}
+					mutex.Lock()
+					assigned[userOrderID] = sequence
+					mutex.Unlock()
+				}
+			}(p)
+		}
+		wait.Wait()
+		if err := sequencer.Close(); err != nil {
+			t.Errorf("Expected no error, received %s for test %s", err, name)
+		}
+
+		total := test.producers * test.commands
+		if len(applied) != total || testService.commandSequence != total {
+			t.Errorf("Expected %v commands applied, received %v for test %s", total, len(applied), name)
+		}
+		for userOrderID, sequence := range assigned {
+			if int64(applied[userOrderID]) != sequence {
+				t.Errorf("Expected order %v applied as command %v, received %v for test %s", userOrderID, sequence, applied[userOrderID], name)
+			}
+		}
+		if _, err := sequencer.Submit(Order{Command: FLUSH_ORDER_BOOK}); err != ErrSequencerClosed {
+			t.Errorf("Expected %v after closing, received %v for test %s", ErrSequencerClosed, err, name)
+		}
+	}
+}
+
+// failingWriter: writes what comes before the given line and fails on the line, as an output that is cut
+// off part way through a write would
+type failingWriter struct {
+	writer io.Writer
+	line   string
+}
+
+func (f *failingWriter) Write(data []byte) (int, error) {
+	index := strings.Index(string(data), f.line+"\n")
+	if index < 0 {
+		return f.writer.Write(data)
+	}
+	written, err := f.writer.Write(data[:index])
+	if err == nil {
+		err = errors.New("output closed")
+	}
+	return written, err
+}
+
+func TestSequencerError(t *testing.T) {
+	testService := newSequencedService()
+	testService.Output = &failingWriter{writer: ioutil.Discard, line: "A, 1, 2"}
+	sequencer := NewSequencer(testService, 4)
+	// the ack of the second order can't be written, nothing after it is applied
+	commands := []Order{
+		{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 1, Side: BUY},
+		{Command: NEW_ORDER, UserID: 1, UserOrderID: 2, Price: intPrice(10), Quantity: 1, Side: BUY},
+		{Command: NEW_ORDER, UserID: 1, UserOrderID: 3, Price: intPrice(10), Quantity: 1, Side: BUY},
+	}
+	for _, command := range commands {
+		if _, err := sequencer.Submit(command); err != nil {
+			t.Fatalf("Expected no error, received %s", err)
+		}
+	}
+	if err := sequencer.Close(); err == nil {
+		t.Errorf("Expected an error for the order whose ack failed, received none")
+	}
+	if err := sequencer.Close(); err == nil {
+		t.Errorf("Expected closing again to return the same error, received none")
+	}
+}
+
+// benchmarkCommand: resting orders and cancels that keep the book small however long the benchmark runs
+func benchmarkCommand(i int) Order {
+	if i%2 == 1 {
+		return Order{Command: CANCEL_ORDER, UserID: 1, UserOrderID: i}
+	}
+	return Order{Command: NEW_ORDER, UserID: 1, UserOrderID: i + 1, Price: intPrice(int64(10 + i%7)), Quantity: 10, Side: BUY}
+}
+
+func BenchmarkSequencer(b *testing.B) {
+	sequencer := NewSequencer(newSequencedService(), RING_BUFFER_SIZE)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := sequencer.Submit(benchmarkCommand(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := sequencer.Close(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkChannelPipeline(b *testing.B) {
+	testService := newSequencedService()
+	commands := make(chan Order, RING_BUFFER_SIZE)
+	done := make(chan error)
+	go func() {
+		var failed error
+		for order := range commands {
+			if failed == nil {
+				failed = testService.ProcessOrderBook([]Order{order})
+			}
+		}
+		done <- failed
+	}()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		commands <- benchmarkCommand(i)
+	}
+	close(commands)
+	if err := <-done; err != nil {
+		b.Fatal(err)
+	}
+}
+
+// the handoff alone, with a consumer that does nothing with the commands, over several producers
+func BenchmarkRingBufferHandoff(b *testing.B) {
+	ring := NewRingBuffer(RING_BUFFER_SIZE)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for sequence := int64(1); !ring.next(sequence).stop; sequence++ {
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			sequence := ring.claim()
+			ring.waitForRoom(sequence, stopped)
+			ring.publish(sequence, ringSlot{order: Order{Command: FLUSH_ORDER_BOOK}})
+		}
+	})
+	sequence := ring.claim()
+	ring.waitForRoom(sequence, stopped)
+	ring.publish(sequence, ringSlot{stop: true})
+	<-stopped
+}
+
+func BenchmarkChannelHandoff(b *testing.B) {
+	commands := make(chan Order, RING_BUFFER_SIZE)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for range commands {
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			commands <- Order{Command: FLUSH_ORDER_BOOK}
+		}
+	})
+	close(commands)
+	<-stopped
+}
@@ -1,20 +1,212 @@
 package service
 
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
 const (
 	// CONFIGURATION
 	INPUT_PATH         = "input_file.csv"
+	INSTRUMENTS_PATH   = "" // optional instrument definitions, orders are unconstrained without them
 	IS_TRADING_ENABLED = false
+	DEPTH_LEVELS       = 0          // price levels per side published as depth updates, 0 publishes top of book only
+	ORDER_FEED_PATH    = ""         // optional file the order-by-order feed is written to
+	IS_OUTPUT_STAMPED  = false      // prefix every output line with its sequence number and timestamp
+	INPUT_FORMAT       = FORMAT_CSV // FORMAT_JSON_LINES reads the input file as one JSON command per line, FORMAT_BINARY as binary records
+	OUTPUT_FORMAT      = FORMAT_CSV // FORMAT_JSON_LINES prints one JSON object per event, FORMAT_BINARY writes binary records
+	CLOCK_SOURCE       = REAL_CLOCK // SIMULATED_CLOCK starts at the Unix epoch and only moves when advanced
+	SCENARIO_WORKERS   = 0          // goroutines processing the scenarios of the input file, 0 uses one per CPU
+	SHARD_QUEUE_SIZE   = 1024       // commands a ShardedEngine book can have waiting before submitters are held up
+	RING_BUFFER_SIZE   = 1024       // slots in a Sequencer's ring buffer, rounded up to a power of two
+
+	// GATEWAY CONFIGURATION
+	GATEWAY_ADDRESS       = ":9000"
+	HEARTBEAT_INTERVAL    = 30 * time.Second // silence after which the gateway sends a heartbeat, twice that disconnects a client
+	CANCEL_ON_DISCONNECT  = true             // cancel a user's resting orders when their session ends
+	SESSION_OUTBOUND_SIZE = 1024             // lines a session can have waiting to be written before it is disconnected
+
+	// FIX CONFIGURATION, counterparties and the users they trade for are given to cmd/fix
+	FIX_ADDRESS         = ":9878"
+	FIX_SENDER_COMP_ID  = "ORDERBOOK"
+	FIX_STORE_DIRECTORY = ""               // where each session's messages are kept for resending, in memory when empty
+	FIX_LOGON_TIMEOUT   = 10 * time.Second // a connection that has not logged on by then is dropped
+
+	// HTTP CONFIGURATION
+	HTTP_ADDRESS              = ":8080"
+	MARKET_DATA_DEPTH_LEVELS  = 10                     // levels per side cmd/http publishes on the depth channel
+	MARKET_DATA_QUEUE_SIZE    = 1024                   // messages a market data connection can have waiting to be written
+	MARKET_DATA_WRITE_TIMEOUT = 10 * time.Second       // a market data client that takes longer to accept a message is disconnected
+	SLOW_CONSUMER_POLICY      = SLOW_CONSUMER_CONFLATE // what happens to a market data client whose queue is full
+
+	// MULTICAST CONFIGURATION
+	MULTICAST_ADDRESS            = "239.255.0.1:30001" // group top of book and trades are published to
+	RETRANSMIT_ADDRESS           = ":30002"            // TCP address receivers fetch missed packets from
+	MULTICAST_HEARTBEAT_INTERVAL = time.Second         // silence after which the last sequence number is published again
+	RETRANSMIT_BUFFER_SIZE       = 65536               // packets kept for retransmission
+	RETRANSMIT_TIMEOUT           = 2 * time.Second     // a receiver gives up on a retransmission that takes longer
+
+	// JOURNAL CONFIGURATION, an empty JOURNAL_PATH turns the journal off
+	JOURNAL_PATH             = ""
+	JOURNAL_FSYNC_POLICY     = FSYNC_ALWAYS
+	JOURNAL_FSYNC_BATCH_SIZE = 100   // records written between syncs under FSYNC_BATCH
+	RECOVER_FROM_JOURNAL     = false // rebuild the book from the journal before processing the input file
+	SNAPSHOT_PATH            = ""    // optional snapshot recovery starts from
+
+	// CIRCUIT BREAKER CONFIGURATION, a band of 0 basis points disables it
+	STATIC_PRICE_BAND_BPS    = 0
+	DYNAMIC_PRICE_BAND_BPS   = 0
+	PRICE_BAND_ACTION        = HALT_BOOK
+	HALT_LENGTH              = 1
+	REOPENING_AUCTION_LENGTH = 1
+	SELF_TRADE_PREVENTION    = STP_NONE
+
+	// ALLOCATION CONFIGURATION
+	ALLOCATION_ALGORITHM        = PRICE_TIME
+	PRO_RATA_MINIMUM_ALLOCATION = 1
+	PRO_RATA_ROUNDING           = ROUND_DOWN
+	HYBRID_TOP_ORDER_MAXIMUM    = 0
+
+	// MULTICAST_VERSION: format version carried by every market data packet, receivers drop any other version
+	MULTICAST_VERSION = 1
+
+	// SNAPSHOT_VERSION: format version written to every snapshot, restoring any other version fails
+	SNAPSHOT_VERSION = 1
+
+	// JOURNAL_VERSION: format version written in every journal header
+	JOURNAL_VERSION = 3
+
+	// BINARY_VERSION: format version written in the header of binary command and event files, reading any other version fails
+	BINARY_VERSION = 1
+
+	// INPUT AND OUTPUT FORMATS
+	FORMAT_CSV        = "CSV"
+	FORMAT_JSON_LINES = "JSONL"
+	FORMAT_BINARY     = "BINARY"
+
+	// BINARY FILE CONTENTS
+	BINARY_COMMANDS = 'C'
+	BINARY_EVENTS   = 'E'
+
+	// CLOCK SOURCES
+	REAL_CLOCK      = "REAL"
+	SIMULATED_CLOCK = "SIMULATED"
+
+	// JOURNAL FSYNC POLICIES
+	FSYNC_ALWAYS = "ALWAYS" // every record is synced before its command is applied
+	FSYNC_BATCH  = "BATCH"  // records are synced every JOURNAL_FSYNC_BATCH_SIZE commands
+	FSYNC_NEVER  = "NEVER"  // syncing is left to the operating system
+
+	// DAMAGED JOURNAL TAILS
+	TORN_RECORD    = "TORN_RECORD"    // the last record was only partly written
+	CORRUPT_RECORD = "CORRUPT_RECORD" // a record failed its checksum or is out of sequence
 
 	// RESERVED COMMANDS AND SIDE SIGNIFIERS
 	NEW_ORDER        = "N"
 	CANCEL_ORDER     = "C"
 	FLUSH_ORDER_BOOK = "F"
+	SESSION_STATE    = "P" // not "S", which is already SELL and the session event
+	ADVANCE_CLOCK    = "K"
+	AMEND_ORDER      = "M"
 	BUY              = "B"
 	SELL             = "S"
 
-	UINT_SIZE = 32 << (^uint(0) >> 32 & 1)
-	MAX_INT   = 1<<(UINT_SIZE-1) - 1
-	MIN_INT   = -MAX_INT - 1
+	// GATEWAY PROTOCOL, lines a gateway session exchanges besides commands and events
+	HEARTBEAT      = "HEARTBEAT" // sent by either side to show the session is alive
+	PROTOCOL_ERROR = "ERROR"     // ERROR, message: a line the gateway could not parse
+
+	// HTTP RESULT STATUSES
+	HTTP_ACCEPTED = "ACCEPTED"
+	HTTP_REJECTED = "REJECTED"
+
+	// MARKET DATA CHANNELS, ACTIONS AND MESSAGE TYPES
+	CHANNEL_TOP          = "top"
+	CHANNEL_DEPTH        = "depth"
+	CHANNEL_TRADES       = "trades"
+	ACTION_SUBSCRIBE     = "subscribe"
+	ACTION_UNSUBSCRIBE   = "unsubscribe"
+	MESSAGE_SNAPSHOT     = "snapshot"
+	MESSAGE_UPDATE       = "update"
+	MESSAGE_TRADE        = "trade"
+	MESSAGE_UNSUBSCRIBED = "unsubscribed"
+	MESSAGE_ERROR        = "error"
+
+	// SLOW CONSUMER POLICIES
+	SLOW_CONSUMER_DROP       = "DROP"       // updates that do not fit are dropped, leaving a gap in the channel's sequence numbers
+	SLOW_CONSUMER_CONFLATE   = "CONFLATE"   // waiting top and depth updates for the same side and price are merged, the rest is dropped
+	SLOW_CONSUMER_DISCONNECT = "DISCONNECT" // the client is disconnected
+
+	// EVENT TYPES, the leading field of each output line
+	EVENT_ACK         = "A"
+	EVENT_REJECT      = "R"
+	EVENT_TOP_OF_BOOK = "B"
+	EVENT_TRADE       = "T"
+	EVENT_SESSION     = "S"
+	EVENT_HALT        = "H"
+	EVENT_SELF_TRADE  = "X"
+	EVENT_DEPTH       = "D"
+	EVENT_EXPIRE      = "E"
+	EVENT_FLUSH       = "F" // the book was cleared, nothing is printed for it
+
+	// ORDER FEED ACTIONS
+	ORDER_ADD     = "ADD"
+	ORDER_MODIFY  = "MODIFY"
+	ORDER_EXECUTE = "EXECUTE"
+	ORDER_DELETE  = "DELETE"
+
+	// REJECT REASONS
+	UNKNOWN_SYMBOL     = "UNKNOWN_SYMBOL"
+	INVALID_TICK       = "INVALID_TICK"
+	INVALID_LOT        = "INVALID_LOT"
+	BELOW_MIN_QTY      = "BELOW_MIN_QTY"
+	ABOVE_MAX_QTY      = "ABOVE_MAX_QTY"
+	OUTSIDE_PRICE_BAND = "OUTSIDE_PRICE_BAND"
+	ALREADY_EXPIRED    = "ALREADY_EXPIRED"
+	UNKNOWN_ORDER      = "UNKNOWN_ORDER"
+	WRONG_USER         = "WRONG_USER"         // the command is for another user than the one the session is bound to
+	USER_IN_USE        = "USER_IN_USE"        // the user is bound to another session
+	PRICE_OVERFLOW     = "PRICE_OVERFLOW"     // the price does not fit at its instrument's scale
+	UNKNOWN_STATE      = "UNKNOWN_STATE"      // the session command names no session state
+	INVALID_TRANSITION = "INVALID_TRANSITION" // the session state cannot be reached from the current one
+	DUPLICATE_ORDER    = "DUPLICATE_ORDER"    // the user order id is taken by one of the user's resting orders
+
+	// DEPTH UPDATE ACTIONS
+	LEVEL_ADD    = "ADD"
+	LEVEL_CHANGE = "CHANGE"
+	LEVEL_DELETE = "DELETE"
+
+	// SESSION STATES
+	PRE_OPEN        = "PRE_OPEN"
+	OPENING_AUCTION = "OPENING_AUCTION"
+	CONTINUOUS      = "CONTINUOUS"
+	HALTED          = "HALTED"
+	CLOSING_AUCTION = "CLOSING_AUCTION"
+	CLOSED          = "CLOSED"
+
+	// PRICE BAND ACTIONS
+	HALT_BOOK    = "HALT"
+	REJECT_ORDER = "REJECT"
+
+	// SELF TRADE PREVENTION MODES
+	STP_NONE             = "NONE"
+	CANCEL_NEWEST        = "CANCEL_NEWEST"
+	CANCEL_OLDEST        = "CANCEL_OLDEST"
+	CANCEL_BOTH          = "CANCEL_BOTH"
+	DECREMENT_AND_CANCEL = "DECREMENT_AND_CANCEL"
+
+	// ALLOCATION ALGORITHMS AND ROUNDING RULES
+	PRICE_TIME    = "PRICE_TIME"
+	PRO_RATA      = "PRO_RATA"
+	HYBRID        = "HYBRID"
+	ROUND_DOWN    = "ROUND_DOWN"
+	ROUND_NEAREST = "ROUND_NEAREST"
 )
 
 type OrderBook struct {
@@ -24,29 +216,643 @@ type OrderBook struct {
 	TopBookBid TopBook
 	TopBookAsk TopBook
 
-	OrderDict map[int]string
+	PublishedBidDepth []DepthLevel // depth as of the last published update
+	PublishedAskDepth []DepthLevel
+
+	OrderDict   map[int]string
+	NextOrderID int // last exchange order id handed out, ids grow with time priority and carry on across flushes and scenarios
+
+	SessionState  string
+	HaltCountdown int         // one more than the inbound commands left in a circuit breaker halt or re-opening auction, 0 without one
+	Expiries      ExpiryQueue // good-till-date timers of resting orders, soonest first
+
+	HasReferencePrice    bool  // false until the book's first trade or auction, the prices below mean nothing before
+	StaticReferencePrice Price // price of the last auction, or the first trade when there was none
+	LastTradePrice       Price
+}
+
+// SessionRules: describes which actions a session state accepts and how orders are matched
+type SessionRules struct {
+	AcceptNewOrders bool // new limit orders may be entered
+	AcceptCancels   bool // resting orders may be cancelled
+	MatchOnEntry    bool // crossing orders trade immediately when trading is enabled
+	UncrossOnExit   bool // resting crossed orders are uncrossed when the state ends
+}
+
+// CircuitBreaker: price bands that protect the book against runaway prices
+type CircuitBreaker struct {
+	StaticBandBps   int    // maximum deviation from the static reference price in basis points
+	DynamicBandBps  int    // maximum deviation from the last trade price in basis points
+	Action          string // HALT_BOOK or REJECT_ORDER
+	HaltLength      int    // inbound commands the book stays halted for
+	ReopeningLength int    // inbound commands the re-opening auction lasts for
+}
+
+// Instrument: trading constraints for a single symbol, a zero value leaves that constraint off
+type Instrument struct {
+	Symbol          string
+	PriceScale      int // decimal places prices are quoted in
+	TickSize        Price
+	LotSize         int
+	MinimumQuantity int
+	MaximumQuantity int
+	MinimumPrice    Price
+	MaximumPrice    Price
+}
+
+// Price: fixed-point decimal worth Units / 10^Scale
+type Price struct {
+	Units int64
+	Scale int
 }
 
+// TopBook: the best price of one side of the book, IsSet is false while that side is empty
 type TopBook struct {
 	UserID   int
-	Price    int
+	Price    Price
 	Quantity int
+	IsSet    bool
+}
+
+// Expiry: a timer that cancels a good-till-date order. Timers are left behind when their order leaves the
+// book some other way, a timer only fires if its order is still resting.
+type Expiry struct {
+	At          time.Time
+	OrderID     int
+	UserOrderID int
+	Side        string
+}
+
+// ExpiryQueue: a min-heap of timers ordered by time, then by exchange order id
+type ExpiryQueue []Expiry
+
+// DepthLevel: the aggregated orders resting at one price
+type DepthLevel struct {
+	Price      Price
+	Quantity   int
+	OrderCount int
 }
 
 type Order struct {
+	Sequence    int       // inbound command sequence number
+	Timestamp   time.Time // when the command was received
+	OrderID     int       // exchange assigned once the order rests in the book
 	UserID      int
 	UserOrderID int
 	Command     string
 	Symbol      string
-	Price       int
+	Price       Price
 	Quantity    int
 	Side        string
+	ExpiresAt   time.Time // good-till-date orders are cancelled once this time is reached, zero never expires
+
+	SessionState string // target state for SESSION_STATE commands
+}
+
+// Event: something the service publishes, Type decides which of the other fields are filled in
+type Event struct {
+	Type      string
+	Sequence  int        // events that print a line are numbered in the order they are published
+	Timestamp time.Time  // when the command that caused the event was received
+	Command   string     // A and R: the inbound command being answered
+	Previous  Order      // A of an amend: the order as it rested before
+	Order     Order      // A, R, X and E: the order concerned, Quantity is what it has left in the book
+	Bid       Order      // T: the buy order, Quantity is what it has left after the trade
+	Ask       Order      // T: the sell order
+	Side      string     // B and D
+	TopBook   TopBook    // B
+	Level     DepthLevel // D
+	Action    string     // D: LEVEL_ADD, LEVEL_CHANGE or LEVEL_DELETE
+	Price     Price      // T: the trade price, H: the price that breached the band
+	Reference Price      // H
+	Quantity  int        // T: traded quantity, X: cancelled quantity
+	Reason    string     // R: why the order was rejected, X: the self trade prevention mode
+	State     string     // S
+}
+
+// OrderFeed: order-by-order (L3) market data derived from the published events
+type OrderFeed struct {
+	Writer   io.Writer
+	Sequence int           // sequence number of the last message written
+	orders   map[int]Order // resting orders by exchange order id
+}
+
+// OrderFeedMessage: a single change to one order in the book. Price is where the order rests, Quantity
+// is the size added, executed or taken off and Remaining what is left of the order afterwards.
+type OrderFeedMessage struct {
+	Sequence  int
+	Timestamp time.Time
+	Action    string
+	OrderID   int
+	Side      string
+	Price     Price
+	Quantity  int
+	Remaining int
+}
+
+// Snapshot: the state of the service between two commands, enough to carry on processing from there
+type Snapshot struct {
+	Version         int
+	Sequence        int // order feed sequence number, 0 when there is no feed
+	JournalSequence int // last journal record applied to the book, 0 when there is no journal
+	EventSequence   int
+	CommandSequence int
+	Time            time.Time
+	OrderBook       OrderBook
+}
+
+// Journal: append-only log of inbound commands, each record is written before its command is applied
+type Journal struct {
+	Sequence   int    // sequence number of the last record written
+	SyncPolicy string // FSYNC_ALWAYS, FSYNC_BATCH or FSYNC_NEVER
+	BatchSize  int    // records between syncs under FSYNC_BATCH
+	file       *os.File
+	unsynced   int
+}
+
+// JournalRecord: a single inbound command as read back from a journal
+type JournalRecord struct {
+	Sequence int
+	Command  Order
 }
 
+// JournalScan: the intact records of a journal and what, if anything, was wrong with its tail
+type JournalScan struct {
+	Records   []JournalRecord
+	ValidSize int64  // bytes up to the end of the last intact record
+	Damage    string // TORN_RECORD or CORRUPT_RECORD when the journal ends in a damaged record
+}
+
+// Divergence: the first line at which two outputs differ, with the lines around it from each
+type Divergence struct {
+	Line         int      // 1-based line of the first difference
+	ContextStart int      // line number of the first context line
+	Expected     []string // context from the recorded output
+	Actual       []string // context from the replayed output
+}
+
+// Clock: where the service gets the time from
+type Clock interface {
+	Now() time.Time
+}
+
+// RealClock: the system clock
+type RealClock struct{}
+
+// SimulatedClock: a clock that only moves when it is told to, for tests and deterministic replay
+type SimulatedClock struct {
+	Time time.Time
+}
+
+// OrderBookService: the matching engine for one book. The configuration fields are set before the
+// service is used, everything it changes as it runs is unexported and guarded by mutex, so any number of
+// goroutines can call its methods at once.
 type OrderBookService struct {
-	IsTradingEnabled bool
-	OrderBook        OrderBook
+	IsTradingEnabled    bool
+	DepthLevels         int
+	CircuitBreaker      CircuitBreaker
+	SelfTradePrevention string
+	Allocation          AllocationStrategy
+	Instruments         map[string]Instrument
+	OrderFeed           *OrderFeed // nil leaves the order-by-order feed off
+	Journal             *Journal   // nil leaves journaling off
+	Output              io.Writer  // where the text output is printed, os.Stdout when nil
+	IsOutputStamped     bool
+	OutputFormat        string      // FORMAT_CSV, FORMAT_JSON_LINES or FORMAT_BINARY
+	OnEvent             func(Event) // optional, called with every event once it is published, it must not call back into the service
+	Clock               Clock
+
+	mutex           sync.Mutex
+	currentTime     time.Time // when the command being processed was received, it never goes backwards
+	commandSequence int       // sequence number of the last inbound command
+	eventSequence   int       // sequence number of the last published event
+	orderBook       OrderBook
+	subscribers     map[int]chan Event
+	nextSubscriber  int
+	binaryOutput    []byte // reused for every event record of binary output
+}
+
+// ShardedEngine: routes inbound commands by symbol to one goroutine per book, so books match in parallel
+// without sharing any state while each book sees its commands in the order they were submitted
+type ShardedEngine struct {
+	queueSize  int
+	newService func() *OrderBookService
+
+	closing sync.RWMutex // held for reading while a command is queued, for writing by Close
+	closed  bool
+
+	mutex        sync.Mutex          // guards the maps below
+	shards       map[string]*shard   // by symbol
+	orderSymbols map[orderKey]string // symbol of every resting order, cancels carry no symbol
+	queuedOrders map[orderKey]int    // new orders their book has yet to process
+
+	events chan ShardEvent
+	wait   sync.WaitGroup
+}
+
+// shard: the goroutine that owns one symbol's book and the queue of commands waiting for it
+type shard struct {
+	symbol   string
+	service  *OrderBookService
+	commands chan Order
+	touched  map[orderKey]bool // orders the events of the command being processed concern
+	flushed  bool              // the command being processed flushed the book
+}
+
+// orderKey: an order as its user knows it, user order ids are only unique for one user
+type orderKey struct {
+	userID      int
+	userOrderID int
+}
+
+// ShardEvent: an event published by one symbol's book, Err is set instead when a command failed
+type ShardEvent struct {
+	Symbol string
+	Event  Event
+	Err    error
+}
+
+// Sequencer: the single writer in front of an OrderBookService. Commands from any number of goroutines
+// are given a total order as they claim a slot of the ring buffer, and one goroutine applies them in that
+// order, so the service itself is never shared.
+type Sequencer struct {
+	ring    *RingBuffer
+	service *OrderBookService
+	closed  int32         // set by Close, commands claiming a slot afterwards are turned away
+	done    chan struct{} // closed once the consumer has stopped
+	err     error         // the first command that failed, read once done is closed
+}
+
+// RingBuffer: a preallocated multi-producer, single-consumer queue in the style of the disruptor.
+// Producers claim sequence numbers with an atomic add, fill the slot and publish it by storing its sequence
+// number, the consumer follows the published slots in sequence and releases them as it goes. The cursors
+// sit on cache lines of their own so producers and the consumer do not invalidate each other's.
+type RingBuffer struct {
+	claimed  int64 // last sequence number handed to a producer
+	_        [56]byte
+	consumed int64 // last sequence number the consumer has released
+	_        [56]byte
+	size     int64
+	mask     int64
+	slots    []ringSlot
+	sequence []int64 // sequence number each slot was last published under
+}
+
+// ringSlot: a published command, or a marker telling the consumer to skip the slot or stop
+type ringSlot struct {
+	order Order
+	skip  bool // claimed by a producer that found the sequencer closed
+	stop  bool // claimed by Close, nothing after it is applied
+}
+
+// Gateway: a TCP server for the line protocol of the input file, with a book for every symbol. Each client
+// session is bound to the user of its first order, gets the responses to its own commands and the events
+// that concern its user's orders from anyone else's.
+type Gateway struct {
+	HeartbeatInterval  time.Duration // 0 turns heartbeats and the idle timeout off
+	CancelOnDisconnect bool
+
+	newService func() *OrderBookService
+	parser     *ParserService
+	dispatch   sync.Mutex                   // held while a command is applied and its events handed out, so every session sees them in order
+	books      map[string]*OrderBookService // by symbol, guarded by dispatch
+
+	mutex    sync.Mutex // guards the fields below
+	listener net.Listener
+	sessions map[*gatewaySession]bool
+	users    map[int]*gatewaySession
+	closed   bool
+	wait     sync.WaitGroup
+}
+
+// gatewaySession: one client connection and the lines waiting to be written to it
+type gatewaySession struct {
+	connection net.Conn
+	userID     int
+	isBound    bool
+	outbound   chan string
+	finished   chan struct{} // the client stopped sending, the writer drains what is queued and disconnects
+	done       chan struct{} // the session is over, nothing more is written
+	finishOnce sync.Once
+	closeOnce  sync.Once
+}
+
+// FIXField: one tag=value field of a FIX message
+type FIXField struct {
+	Tag   int
+	Value string
+}
+
+// FIXMessage: the fields of a FIX message in order, without BeginString, BodyLength and CheckSum, which are
+// worked out when it is written
+type FIXMessage struct {
+	Fields []FIXField
+}
+
+// FIXStore: the sequence numbers of one FIX session and the messages it has sent, kept to answer
+// ResendRequests. Everything is written through to a file, so a session picks up where it left off after
+// a restart.
+type FIXStore struct {
+	NextSenderSeqNum int
+	NextTargetSeqNum int
+	messages         map[int][]byte // wire format by sequence number
+	file             *os.File       // nil keeps the store in memory
+}
+
+// FIXAcceptor: a FIX 4.4 order entry server. Every counterparty it is configured with has a session that
+// lasts across its connections, enters orders for one user in the book of each symbol and is sent an
+// ExecutionReport for everything that happens to them.
+type FIXAcceptor struct {
+	SenderCompID string
+	newService   func() *OrderBookService
+
+	mutex           sync.Mutex                   // guards everything below, held while a message is handled so sessions see events in order
+	books           map[string]*OrderBookService // by symbol
+	sessions        map[string]*fixSession       // by the counterparty's SenderCompID
+	orders          map[int]*fixOrder            // open orders entered through the acceptor by engine user order id
+	nextUserOrderID int
+	listener        net.Listener
+	connections     map[*fixConnection]bool
+	closed          bool
+	wait            sync.WaitGroup
+}
+
+// fixSession: one counterparty's session, which outlives its connections
+type fixSession struct {
+	targetCompID string
+	userID       int
+	store        *FIXStore
+	connection   *fixConnection // nil while the counterparty is logged out
+	clOrdIDs     map[string]int // engine user order id of every ClOrdID the counterparty has used
+	resendUntil  int            // sequence number that showed the last gap, no new ResendRequest until it has arrived
+}
+
+// fixConnection: one TCP connection, which belongs to a session once its Logon is accepted
+type fixConnection struct {
+	lastReceived int64 // Unix nanoseconds, the writer watches it to spot a silent counterparty
+	connection   net.Conn
+	session      *fixSession
+	heartbeat    time.Duration // agreed at logon, 0 turns heartbeats off
+	isLoggingOut bool          // the acceptor has sent a Logout and is waiting for the connection to end
+	outbound     chan []byte
+	loggedOn     chan struct{} // closed once the Logon is accepted
+	finished     chan struct{} // nothing more is read, the writer drains what is queued and disconnects
+	done         chan struct{} // the connection is closed, nothing more is written
+	finishOnce   sync.Once
+	closeOnce    sync.Once
+}
+
+// fixOrder: what the acceptor tells a counterparty about one of its orders
+type fixOrder struct {
+	userOrderID        int
+	targetCompID       string
+	clOrdID            string
+	origClOrdID        string
+	symbol             string
+	side               string
+	price              Price
+	orderQuantity      int
+	cumulativeQuantity int
+	notional           float64   // price times quantity of every fill, for the average price
+	pending            *fixOrder // the cancel or replace being applied, with its ClOrdID, price and quantity
+}
+
+// FIXClient: a FIX 4.4 initiator for trying out and testing an acceptor. It answers TestRequests and
+// ResendRequests by itself and hands every other message to the caller.
+type FIXClient struct {
+	SenderCompID string
+	TargetCompID string
+	Store        *FIXStore
+	connection   net.Conn
+	reader       *bufio.Reader
+	resendUntil  int // sequence number that showed the last gap
+}
+
+// HTTPServer: a JSON API over one OrderBookService per symbol. Orders, amends and cancels are applied one
+// at a time so the fills each user is sent come out in the order they happened.
+type HTTPServer struct {
+	SlowConsumerPolicy  string // what a market data connection does once MarketDataQueueSize messages are waiting
+	MarketDataQueueSize int
+
+	newService func() *OrderBookService
+
+	mutex           sync.Mutex // guards the fields below and is held while a command is applied
+	books           map[string]*OrderBookService
+	bookOrderIDs    map[httpOrderKey]int // the id the books know each user's order by, users pick their ids alone
+	userOrders      map[int]httpOrderKey // the other way around
+	orderSymbols    map[int]string       // the book each book order id was entered in
+	nextBookOrderID int
+	fills           map[int][]HTTPFill
+}
+
+// httpOrderKey: an order as its user knows it, user order ids are only unique for one user
+type httpOrderKey struct {
+	userID      int
+	userOrderID int
+}
+
+// HTTPOrder: an order as it is submitted and as it is listed among a user's open orders
+type HTTPOrder struct {
+	UserID      int         `json:"userId,omitempty"`
+	UserOrderID int         `json:"userOrderId"`
+	OrderID     int         `json:"orderId,omitempty"`
+	Symbol      string      `json:"symbol"`
+	Side        string      `json:"side"`  // B or S
+	Price       json.Number `json:"price"` // a number or a string holding one
+	Quantity    int         `json:"quantity"`
+	ExpiresAt   *time.Time  `json:"expiresAt,omitempty"`
+}
+
+// HTTPAmend: the new price and quantity of an order
+type HTTPAmend struct {
+	Price    json.Number `json:"price"`
+	Quantity int         `json:"quantity"`
+}
+
+// HTTPFill: one side of a trade
+type HTTPFill struct {
+	Sequence    int         `json:"sequence"`
+	Timestamp   time.Time   `json:"timestamp"`
+	UserOrderID int         `json:"userOrderId"`
+	Symbol      string      `json:"symbol"`
+	Side        string      `json:"side"`
+	Price       json.Number `json:"price"`
+	Quantity    int         `json:"quantity"`
+}
+
+// HTTPResult: the answer to an order, amend or cancel
+type HTTPResult struct {
+	Status string     `json:"status"` // HTTP_ACCEPTED or HTTP_REJECTED
+	Reason string     `json:"reason,omitempty"`
+	Order  *HTTPOrder `json:"order,omitempty"` // what is left resting in the book
+	Fills  []HTTPFill `json:"fills,omitempty"`
+}
+
+type HTTPLevel struct {
+	Price    json.Number `json:"price"`
+	Quantity int         `json:"quantity"`
+	Orders   int         `json:"orders,omitempty"` // not known for top of book updates
+}
+
+type HTTPBook struct {
+	Symbol string      `json:"symbol"`
+	Bids   []HTTPLevel `json:"bids"`
+	Asks   []HTTPLevel `json:"asks"`
+}
+
+type HTTPTopOfBook struct {
+	Symbol string     `json:"symbol"`
+	Bid    *HTTPLevel `json:"bid"`
+	Ask    *HTTPLevel `json:"ask"`
+}
+
+type HTTPError struct {
+	Error string `json:"error"`
+}
+
+// MarketDataRequest: what a market data client sends to start or stop a channel of a symbol
+type MarketDataRequest struct {
+	Action  string `json:"action"` // ACTION_SUBSCRIBE or ACTION_UNSUBSCRIBE
+	Symbol  string `json:"symbol"`
+	Channel string `json:"channel"` // CHANNEL_TOP, CHANNEL_DEPTH or CHANNEL_TRADES
+}
+
+// MarketDataMessage: what the server sends. Each subscription starts with a snapshot numbered 1 and every
+// message after it is numbered one higher than the last, a larger step means messages were dropped.
+type MarketDataMessage struct {
+	Type      string      `json:"type"`
+	Symbol    string      `json:"symbol,omitempty"`
+	Channel   string      `json:"channel,omitempty"`
+	Sequence  int         `json:"sequence,omitempty"`
+	Bid       *HTTPLevel  `json:"bid,omitempty"`       // top snapshot
+	Ask       *HTTPLevel  `json:"ask,omitempty"`       // top snapshot
+	Bids      []HTTPLevel `json:"bids,omitempty"`      // depth snapshot
+	Asks      []HTTPLevel `json:"asks,omitempty"`      // depth snapshot
+	Side      string      `json:"side,omitempty"`      // top and depth updates
+	Action    string      `json:"action,omitempty"`    // depth updates: LEVEL_ADD, LEVEL_CHANGE or LEVEL_DELETE
+	Level     *HTTPLevel  `json:"level,omitempty"`     // top and depth updates, a top update without one emptied the side
+	Price     json.Number `json:"price,omitempty"`     // trades
+	Quantity  int         `json:"quantity,omitempty"`  // trades
+	Timestamp *time.Time  `json:"timestamp,omitempty"` // trades
+	Error     string      `json:"error,omitempty"`
+}
+
+// marketDataSession: one market data connection, its subscriptions and the messages waiting to be written
+type marketDataSession struct {
+	server     *HTTPServer
+	connection *websocket.Conn
+
+	mutex         sync.Mutex // guards the fields below
+	subscriptions map[string]*marketDataSubscription
+	queue         []marketDataQueued
+	closed        bool
+	ready         chan struct{} // signalled when something is queued
+	done          chan struct{} // closed when the session ends
+}
+
+// marketDataSubscription: a channel of a symbol and the book events feeding it
+type marketDataSubscription struct {
+	symbol      string
+	channel     string
+	sequence    int  // last number written
+	dropped     int  // messages dropped since the last one queued
+	isActive    bool // false once unsubscribed, anything still queued for it is not written
+	unsubscribe func()
+}
+
+// marketDataQueued: a message waiting to be written with the number of messages of its subscription
+// dropped just before it
+type marketDataQueued struct {
+	subscription *marketDataSubscription
+	message      MarketDataMessage
+	key          string // top and depth updates with the same key can be conflated, trades have none
+	dropped      int
+}
+
+// MulticastPublisher: publishes a book's top of book and trades as UDP packets numbered from 1, keeps the
+// latest of them and sends them again to receivers that missed some
+type MulticastPublisher struct {
+	Symbol            string
+	HeartbeatInterval time.Duration // 0 turns heartbeats off
+
+	service *OrderBookService
+	writer  io.Writer // the UDP connection, swapped out by tests to lose packets
+
+	mutex         sync.Mutex // guards the fields below
+	connection    *net.UDPConn
+	sequence      int
+	lastPublished time.Time
+	packets       [][]byte // the latest packets by sequence modulo their count
+	listener      net.Listener
+	unsubscribe   func()
+	closed        bool
+	done          chan struct{}
+	wait          sync.WaitGroup
+}
+
+// MulticastPacket: one market data packet
+type MulticastPacket struct {
+	Type      string // EVENT_TOP_OF_BOOK, EVENT_TRADE or HEARTBEAT
+	Sequence  int    // a heartbeat carries the last sequence number published
+	Timestamp time.Time
+	Symbol    string
+	Side      string  // top of book
+	TopBook   TopBook // top of book
+	Price     Price   // trades
+	Quantity  int     // trades
+}
+
+// MulticastReceiver: joins a group and hands out its packets in sequence, fetching the ones it missed from
+// the publisher's retransmission service. It is read from a single goroutine.
+type MulticastReceiver struct {
+	Gaps int // gaps detected
+	Lost int // packets that could not be recovered
+
+	connection        *net.UDPConn
+	retransmitAddress string
+	buffer            []byte
+	sequence          int // last packet handed out
+	pending           map[int]MulticastPacket
+	lost              map[int]bool
+	books             map[string]MulticastBook
+}
+
+// MulticastBook: what a receiver knows of a symbol's book
+type MulticastBook struct {
+	Bid               TopBook
+	Ask               TopBook
+	LastTradePrice    Price
+	LastTradeQuantity int
+	Volume            int
 }
 
 type ParserService struct {
+	InputFormat string // FORMAT_CSV, FORMAT_JSON_LINES or FORMAT_BINARY
+}
+
+// BinaryDecoder: decodes binary command and event records, keeping one copy of every symbol it has seen so
+// decoding a record does not allocate
+type BinaryDecoder struct {
+	symbols map[string]string
+}
+
+// JSONCommand: a command as a line of JSON Lines input. Which fields a command needs is the same as for
+// its CSV line, times are Unix nanoseconds.
+type JSONCommand struct {
+	Command      string      `json:"command"`
+	UserID       int         `json:"userId"`
+	UserOrderID  int         `json:"userOrderId"`
+	Symbol       string      `json:"symbol"`
+	Price        json.Number `json:"price"`
+	Quantity     int         `json:"quantity"`
+	Side         string      `json:"side"`
+	ExpiresAt    int64       `json:"expiresAt"`
+	SessionState string      `json:"state"`
+	Timestamp    int64       `json:"timestamp"`
+}
+
+// jsonField: a named value of a JSON object written with its fields in a fixed order
+type jsonField struct {
+	name  string
+	value interface{}
 }
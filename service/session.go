@@ -0,0 +1,175 @@
+package service
+
+import (
+	"github.com/pkg/errors"
+)
+
+// sessionRules: what each session state accepts. A new book starts in CONTINUOUS so input streams
+// without session commands behave exactly as before.
+var sessionRules = map[string]SessionRules{
+	PRE_OPEN:        {AcceptNewOrders: true, AcceptCancels: true},
+	OPENING_AUCTION: {AcceptNewOrders: true, AcceptCancels: true, UncrossOnExit: true},
+	CONTINUOUS:      {AcceptNewOrders: true, AcceptCancels: true, MatchOnEntry: true},
+	HALTED:          {AcceptCancels: true},
+	CLOSING_AUCTION: {AcceptNewOrders: true, AcceptCancels: true, UncrossOnExit: true},
+	CLOSED:          {},
+}
+
+// sessionTransitions: the states each session state is allowed to move to
+var sessionTransitions = map[string][]string{
+	PRE_OPEN:        {OPENING_AUCTION, HALTED, CLOSED},
+	OPENING_AUCTION: {CONTINUOUS, HALTED},
+	CONTINUOUS:      {HALTED, CLOSING_AUCTION, CLOSED},
+	HALTED:          {OPENING_AUCTION, CONTINUOUS, CLOSED},
+	CLOSING_AUCTION: {CLOSED, HALTED},
+	CLOSED:          {PRE_OPEN},
+}
+
+// currentSessionRules: returns the rules of the book's current session state
+func (o *OrderBookService) currentSessionRules() SessionRules {
+	return sessionRules[o.orderBook.SessionState]
+}
+
+// checkSessionState: why a session command for state cannot be applied to the book, empty when it can
+func (o *OrderBookService) checkSessionState(state string) string {
+	if _, ok := sessionRules[state]; !ok {
+		return UNKNOWN_STATE
+	}
+	if !isValidTransition(o.orderBook.SessionState, state) {
+		return INVALID_TRANSITION
+	}
+	return ""
+}
+
+// changeSessionState: moves the book to a new session state. Leaving an auction for anything other than
+// a halt uncrosses the book at a single equilibrium price first.
+func (o *OrderBookService) changeSessionState(state string) ([]Event, error) {
+	var events []Event
+	current := o.orderBook.SessionState
+	if _, ok := sessionRules[state]; !ok {
+		return nil, errors.Errorf("unknown session state %v in changeSessionState()", state)
+	}
+	if !isValidTransition(current, state) {
+		return nil, errors.Errorf("invalid session transition from %v to %v in changeSessionState()", current, state)
+	}
+
+	if sessionRules[current].UncrossOnExit && state != HALTED && o.IsTradingEnabled {
+		trades, err := o.uncrossBook()
+		if err != nil {
+			return nil, errors.Wrap(err, "error uncrossing book in changeSessionState()")
+		}
+		events = append(events, trades...)
+	}
+	o.orderBook.SessionState = state
+	return append(events, sessionEvent(state)), nil
+}
+
+func isValidTransition(from string, to string) bool {
+	for _, state := range sessionTransitions[from] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// uncrossBook: executes every crossed order at the price that maximises matched volume.
+// Ties are broken by the smallest leftover imbalance, then towards the side with the surplus.
+func (o *OrderBookService) uncrossBook() ([]Event, error) {
+	var events []Event
+	price, volume := o.equilibriumPrice()
+	if volume == 0 {
+		return nil, nil
+	}
+	// the auction price becomes the reference the circuit breaker bands are measured from
+	o.orderBook.StaticReferencePrice = price
+	o.orderBook.LastTradePrice = price
+	o.orderBook.HasReferencePrice = true
+
+	for volume > 0 {
+		highestBid := &o.orderBook.Bids[0]
+		lowestAsk := &o.orderBook.Asks[0]
+		quantity := highestBid.Quantity
+		if lowestAsk.Quantity < quantity {
+			quantity = lowestAsk.Quantity
+		}
+		if volume < quantity {
+			quantity = volume
+		}
+		volume -= quantity
+		highestBid.Quantity -= quantity
+		lowestAsk.Quantity -= quantity
+		events = append(events, tradeEvent(*highestBid, *lowestAsk, price, quantity))
+
+		if lowestAsk.Quantity == 0 {
+			delete(o.orderBook.OrderDict, lowestAsk.UserOrderID)
+			orderList, err := remove(o.orderBook.Asks, 0)
+			if err != nil {
+				return nil, errors.Wrap(err, "error removing ask in uncrossBook()")
+			}
+			o.orderBook.Asks = orderList
+		}
+		if highestBid.Quantity == 0 {
+			delete(o.orderBook.OrderDict, highestBid.UserOrderID)
+			orderList, err := remove(o.orderBook.Bids, 0)
+			if err != nil {
+				return nil, errors.Wrap(err, "error removing bid in uncrossBook()")
+			}
+			o.orderBook.Bids = orderList
+		}
+	}
+
+	topOfBook, err := o.handleTopOfBook()
+	if err != nil {
+		return nil, errors.Wrap(err, "error handling top of book in uncrossBook()")
+	}
+	return append(events, topOfBook...), nil
+}
+
+// equilibriumPrice: finds the auction price and the volume that will trade at it
+func (o *OrderBookService) equilibriumPrice() (Price, int) {
+	var bestPrice Price
+	var bestVolume, bestImbalance int
+	candidates := make([]Price, 0, len(o.orderBook.Bids)+len(o.orderBook.Asks))
+	for _, bid := range o.orderBook.Bids {
+		candidates = append(candidates, bid.Price)
+	}
+	for _, ask := range o.orderBook.Asks {
+		candidates = append(candidates, ask.Price)
+	}
+
+	for _, price := range candidates {
+		demand, supply := 0, 0
+		for _, bid := range o.orderBook.Bids {
+			if bid.Price.Cmp(price) >= 0 {
+				demand += bid.Quantity
+			}
+		}
+		for _, ask := range o.orderBook.Asks {
+			if ask.Price.Cmp(price) <= 0 {
+				supply += ask.Quantity
+			}
+		}
+		volume, imbalance := demand, demand-supply
+		if supply < volume {
+			volume = supply
+		}
+		if volume == 0 {
+			continue
+		}
+		if volume > bestVolume ||
+			(volume == bestVolume && abs(imbalance) < abs(bestImbalance)) ||
+			(volume == bestVolume && abs(imbalance) == abs(bestImbalance) &&
+				((imbalance > 0 && price.Cmp(bestPrice) > 0) || (imbalance <= 0 && price.Cmp(bestPrice) < 0))) {
+			bestPrice, bestVolume, bestImbalance = price, volume, imbalance
+		}
+	}
+	return bestPrice, bestVolume
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
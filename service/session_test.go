@@ -0,0 +1,182 @@
+package service
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestChangeSessionState(t *testing.T) {
+	testService := NewOrderBookService()
+	testService.IsTradingEnabled = true
+	tests := map[string]struct {
+		currentState   string
+		newState       string
+		existingOrders []Order
+		finalAskLength int
+		finalBidLength int
+		output         []string
+		err            error
+	}{
+		"Valid transition": {
+			currentState: CONTINUOUS,
+			newState:     HALTED,
+			output:       []string{"S, HALTED"},
+			err:          nil,
+		},
+		"Invalid transition": {
+			currentState: CLOSED,
+			newState:     CONTINUOUS,
+			err:          errors.New("invalid session transition from CLOSED to CONTINUOUS in changeSessionState()"),
+		},
+		"Unknown state": {
+			currentState: CONTINUOUS,
+			newState:     "LUNCH",
+			err:          errors.New("unknown session state LUNCH in changeSessionState()"),
+		},
+		"Opening auction uncross": {
+			currentState: OPENING_AUCTION,
+			newState:     CONTINUOUS,
+			existingOrders: []Order{
+				{Side: BUY, UserID: 1, UserOrderID: 1, Price: intPrice(11), Quantity: 100},
+				{Side: BUY, UserID: 1, UserOrderID: 2, Price: intPrice(10), Quantity: 50},
+				{Side: SELL, UserID: 2, UserOrderID: 3, Price: intPrice(9), Quantity: 80},
+				{Side: SELL, UserID: 2, UserOrderID: 4, Price: intPrice(10), Quantity: 100},
+			},
+			finalAskLength: 1,
+			output: []string{
+				"T, 1, 1, 2, 3, 10, 80",
+				"T, 1, 1, 2, 4, 10, 20",
+				"T, 1, 2, 2, 4, 10, 50",
+				"B, S, 10, 30",
+				"S, CONTINUOUS",
+			},
+			err: nil,
+		},
+		"Halting an auction does not uncross": {
+			currentState: CLOSING_AUCTION,
+			newState:     HALTED,
+			existingOrders: []Order{
+				{Side: BUY, UserID: 1, UserOrderID: 1, Price: intPrice(11), Quantity: 100},
+				{Side: SELL, UserID: 2, UserOrderID: 2, Price: intPrice(10), Quantity: 100},
+			},
+			finalAskLength: 1,
+			finalBidLength: 1,
+			output:         []string{"S, HALTED"},
+			err:            nil,
+		},
+	}
+
+	for name, test := range tests {
+		testService.orderBook = NewOrderBook()
+		testService.orderBook.SessionState = test.currentState
+
+		for _, existingOrder := range test.existingOrders {
+			if existingOrder.Side == BUY {
+				testService.orderBook.Bids = append(testService.orderBook.Bids, existingOrder)
+			} else if existingOrder.Side == SELL {
+				testService.orderBook.Asks = append(testService.orderBook.Asks, existingOrder)
+			}
+			testService.orderBook.OrderDict[existingOrder.UserOrderID] = existingOrder.Side
+		}
+		events, err := testService.changeSessionState(test.newState)
+		output := eventLines(events)
+		if !compareErrors(test.err, err) {
+			t.Errorf("Expected error %s, received %s for test %s", test.err, err, name)
+		}
+		if !reflect.DeepEqual(output, test.output) {
+			t.Errorf("Expected output %v, received %v for test %s", test.output, output, name)
+		}
+		if test.finalAskLength != len(testService.orderBook.Asks) {
+			t.Errorf("Expected ask length %v, received %v for test %s", test.finalAskLength, len(testService.orderBook.Asks), name)
+		}
+		if test.finalBidLength != len(testService.orderBook.Bids) {
+			t.Errorf("Expected bid length %v, received %v for test %s", test.finalBidLength, len(testService.orderBook.Bids), name)
+		}
+	}
+}
+
+func TestSessionRules(t *testing.T) {
+	testService := NewOrderBookService()
+	tests := map[string]struct {
+		state  string
+		order  *Order
+		output string
+	}{
+		"New order rejected while halted": {
+			state:  HALTED,
+			order:  &Order{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: BUY},
+			output: "R, 1, 1",
+		},
+		"New order accepted in pre-open": {
+			state:  PRE_OPEN,
+			order:  &Order{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: BUY},
+			output: "A, 1, 1",
+		},
+		"Cancel rejected while closed": {
+			state:  CLOSED,
+			order:  &Order{Command: CANCEL_ORDER, UserID: 1, UserOrderID: 1, Side: BUY},
+			output: "R, 1, 1",
+		},
+	}
+
+	for name, test := range tests {
+		testService.orderBook = NewOrderBook()
+		testService.orderBook.SessionState = test.state
+
+		var events []Event
+		var err error
+		if test.order.Command == NEW_ORDER {
+			events, err = testService.newOrder(test.order)
+		} else {
+			events, err = testService.cancelOrder(test.order)
+		}
+		output := eventOutput(events)
+		if err != nil {
+			t.Errorf("Expected no error, received %s for test %s", err, name)
+		}
+		if output != test.output {
+			t.Errorf("Expected output %s, received %s for test %s", test.output, output, name)
+		}
+	}
+}
+
+func TestSessionCommandReject(t *testing.T) {
+	tests := map[string]struct {
+		orders []Order
+		output []string
+	}{
+		"Invalid transition carries on": {
+			orders: []Order{
+				{Command: SESSION_STATE, SessionState: CLOSED},
+				{Command: SESSION_STATE, SessionState: CONTINUOUS},
+				{Command: SESSION_STATE, SessionState: PRE_OPEN},
+				{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: BUY},
+			},
+			output: []string{"S, CLOSED", "R, 0, 0, INVALID_TRANSITION", "S, PRE_OPEN", "A, 1, 1", "B, B, 10, 100"},
+		},
+		"Unknown state carries on": {
+			orders: []Order{
+				{Command: SESSION_STATE, SessionState: "LUNCH"},
+				{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: BUY},
+			},
+			output: []string{"R, 0, 0, UNKNOWN_STATE", "A, 1, 1", "B, B, 10, 100"},
+		},
+	}
+
+	for name, test := range tests {
+		var buffer bytes.Buffer
+		testService := NewOrderBookService()
+		testService.Output = &buffer
+		if err := testService.ProcessOrderBook(test.orders); err != nil {
+			t.Fatalf("Expected no error, received %s for test %s", err, name)
+		}
+		output := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+		if !reflect.DeepEqual(output, test.output) {
+			t.Errorf("Expected output %v, received %v for test %s", test.output, output, name)
+		}
+	}
+}
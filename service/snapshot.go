@@ -0,0 +1,174 @@
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// TakeSnapshot: captures the book as it stands between two commands. Resting orders keep their priority
+// through their position in Bids and Asks.
+func (o *OrderBookService) TakeSnapshot() Snapshot {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	snapshot := Snapshot{
+		Version:         SNAPSHOT_VERSION,
+		EventSequence:   o.eventSequence,
+		CommandSequence: o.commandSequence,
+		Time:            o.currentTime,
+		OrderBook:       copyBook(o.orderBook),
+	}
+	if o.OrderFeed != nil {
+		snapshot.Sequence = o.OrderFeed.Sequence
+	}
+	if o.Journal != nil {
+		snapshot.JournalSequence = o.Journal.Sequence
+	}
+	return snapshot
+}
+
+// RestoreSnapshot: replaces the book with a snapshot's after checking it is consistent. Sequence numbers
+// and the service's time carry on from the snapshot, and an order feed starts with the restored orders
+// already resting.
+func (o *OrderBookService) RestoreSnapshot(snapshot Snapshot) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.restoreSnapshot(snapshot)
+}
+
+func (o *OrderBookService) restoreSnapshot(snapshot Snapshot) error {
+	if snapshot.Version != SNAPSHOT_VERSION {
+		return errors.Errorf("unsupported snapshot version %v in RestoreSnapshot()", snapshot.Version)
+	}
+	book := copyBook(snapshot.OrderBook)
+	if book.OrderDict == nil {
+		book.OrderDict = make(map[int]string)
+	}
+	if err := validateBook(book); err != nil {
+		return errors.Wrap(err, "error validating snapshot in RestoreSnapshot()")
+	}
+
+	o.orderBook = book
+	o.eventSequence = snapshot.EventSequence
+	o.commandSequence = snapshot.CommandSequence
+	o.currentTime = snapshot.Time
+	if o.OrderFeed != nil {
+		o.OrderFeed.Sequence = snapshot.Sequence
+		o.OrderFeed.orders = make(map[int]Order)
+		for _, orders := range [][]Order{book.Bids, book.Asks} {
+			for _, order := range orders {
+				o.OrderFeed.orders[order.OrderID] = order
+			}
+		}
+	}
+	return nil
+}
+
+// WriteSnapshot: encodes a snapshot of the book to writer
+func (o *OrderBookService) WriteSnapshot(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(o.TakeSnapshot()); err != nil {
+		return errors.Wrap(err, "error encoding snapshot in WriteSnapshot()")
+	}
+	return nil
+}
+
+// ReadSnapshot: decodes a snapshot from reader and restores the book from it
+func (o *OrderBookService) ReadSnapshot(reader io.Reader) error {
+	var snapshot Snapshot
+	if err := json.NewDecoder(reader).Decode(&snapshot); err != nil {
+		return errors.Wrap(err, "error decoding snapshot in ReadSnapshot()")
+	}
+	if err := o.RestoreSnapshot(snapshot); err != nil {
+		return errors.Wrap(err, "error restoring snapshot in ReadSnapshot()")
+	}
+	return nil
+}
+
+// SaveSnapshot: writes a snapshot to path. It goes to a temporary file first and is renamed into place,
+// so a crash part way through never leaves a truncated snapshot behind.
+func (o *OrderBookService) SaveSnapshot(path string) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "error creating temporary snapshot file in SaveSnapshot()")
+	}
+	defer os.Remove(file.Name())
+
+	if err := o.WriteSnapshot(file); err != nil {
+		file.Close()
+		return errors.Wrap(err, "error writing snapshot in SaveSnapshot()")
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return errors.Wrap(err, "error syncing snapshot in SaveSnapshot()")
+	}
+	if err := file.Close(); err != nil {
+		return errors.Wrap(err, "error closing snapshot in SaveSnapshot()")
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return errors.Wrap(err, "error moving snapshot into place in SaveSnapshot()")
+	}
+	return nil
+}
+
+// LoadSnapshot: restores the book from the snapshot saved at path
+func (o *OrderBookService) LoadSnapshot(path string) error {
+	snapshot, err := readSnapshotFile(path)
+	if err != nil {
+		return errors.Wrap(err, "error reading snapshot in LoadSnapshot()")
+	}
+	if err := o.RestoreSnapshot(snapshot); err != nil {
+		return errors.Wrapf(err, "error loading snapshot %v in LoadSnapshot()", path)
+	}
+	return nil
+}
+
+func readSnapshotFile(path string) (Snapshot, error) {
+	var snapshot Snapshot
+	file, err := os.Open(path)
+	if err != nil {
+		return snapshot, errors.Wrap(err, "error opening snapshot in readSnapshotFile()")
+	}
+	defer file.Close()
+	if err := json.NewDecoder(file).Decode(&snapshot); err != nil {
+		return snapshot, errors.Wrapf(err, "error decoding snapshot %v in readSnapshotFile()", path)
+	}
+	return snapshot, nil
+}
+
+// validateBook: every resting order must sit on the right side in price priority, be indexed under that
+// side and carry an exchange order id that has already been handed out
+func validateBook(book OrderBook) error {
+	sides := map[string][]Order{BUY: book.Bids, SELL: book.Asks}
+	resting := 0
+	for side, orders := range sides {
+		for i, order := range orders {
+			if order.Side != side {
+				return errors.Errorf("order %v is on the wrong side of the book in validateBook()", order.UserOrderID)
+			}
+			if book.OrderDict[order.UserOrderID] != side {
+				return errors.Errorf("order %v is missing from the order index in validateBook()", order.UserOrderID)
+			}
+			if order.OrderID <= 0 || order.OrderID > book.NextOrderID {
+				return errors.Errorf("order %v has invalid exchange order id %v in validateBook()", order.UserOrderID, order.OrderID)
+			}
+			if i > 0 {
+				previous := orders[i-1]
+				if (side == BUY && order.Price.Cmp(previous.Price) > 0) || (side == SELL && order.Price.Cmp(previous.Price) < 0) ||
+					(order.Price.Cmp(previous.Price) == 0 && order.OrderID < previous.OrderID) {
+					return errors.Errorf("order %v is out of priority in validateBook()", order.UserOrderID)
+				}
+			}
+			resting++
+		}
+	}
+	if len(book.OrderDict) != resting {
+		return errors.Errorf("order index holds %v orders but %v are resting in validateBook()", len(book.OrderDict), resting)
+	}
+	return nil
+}
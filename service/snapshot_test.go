@@ -0,0 +1,120 @@
+package service
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "book.snapshot")
+
+	before := []Order{
+		{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: BUY},
+		{Command: NEW_ORDER, UserID: 2, UserOrderID: 2, Price: intPrice(10), Quantity: 50, Side: BUY},
+		{Command: NEW_ORDER, UserID: 3, UserOrderID: 3, Price: NewPrice(1150, 2), Quantity: 100, Side: SELL},
+		{Command: CANCEL_ORDER, UserID: 1, UserOrderID: 1},
+		{Command: SESSION_STATE, SessionState: HALTED},
+	}
+	after := []Order{
+		{Command: SESSION_STATE, SessionState: CONTINUOUS},
+		{Command: NEW_ORDER, UserID: 4, UserOrderID: 4, Price: intPrice(10), Quantity: 70, Side: SELL},
+		{Command: NEW_ORDER, UserID: 5, UserOrderID: 5, Price: intPrice(10), Quantity: 10, Side: BUY},
+	}
+
+	var originalFeed, restoredFeed bytes.Buffer
+	original := NewOrderBookService()
+	original.IsTradingEnabled = true
+	original.OrderFeed = NewOrderFeed(&originalFeed)
+	original.Clock = NewSimulatedClock(time.Unix(1000, 0))
+	original.Output = ioutil.Discard
+	original.orderBook = NewOrderBook()
+	if err := original.ProcessOrderBook(before); err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	if err := original.SaveSnapshot(path); err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	originalFeed.Reset()
+
+	restored := NewOrderBookService()
+	restored.IsTradingEnabled = true
+	restored.OrderFeed = NewOrderFeed(&restoredFeed)
+	restored.Clock = NewSimulatedClock(time.Unix(1000, 0))
+	restored.Output = ioutil.Discard
+	if err := restored.LoadSnapshot(path); err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	if !reflect.DeepEqual(restored.orderBook, original.orderBook) {
+		t.Errorf("Expected restored book %v, received %v", original.orderBook, restored.orderBook)
+	}
+
+	// both services carry on identically from the snapshot
+	if err := original.ProcessOrderBook(after); err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	if err := restored.ProcessOrderBook(after); err != nil {
+		t.Fatalf("Expected no error, received %s", err)
+	}
+	if !reflect.DeepEqual(restored.orderBook, original.orderBook) {
+		t.Errorf("Expected book %v, received %v", original.orderBook, restored.orderBook)
+	}
+	if restoredFeed.String() != originalFeed.String() {
+		t.Errorf("Expected feed %q, received %q", originalFeed.String(), restoredFeed.String())
+	}
+}
+
+func TestRestoreSnapshotValidation(t *testing.T) {
+	bid := Order{OrderID: 1, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: BUY}
+	tests := map[string]struct {
+		snapshot Snapshot
+		err      error
+	}{
+		"Consistent book": {
+			snapshot: Snapshot{Version: SNAPSHOT_VERSION, OrderBook: OrderBook{Bids: []Order{bid}, OrderDict: map[int]string{1: BUY}, NextOrderID: 1}},
+		},
+		"Unsupported version": {
+			snapshot: Snapshot{Version: 2},
+			err:      errors.New("unsupported snapshot version 2 in RestoreSnapshot()"),
+		},
+		"Order missing from index": {
+			snapshot: Snapshot{Version: SNAPSHOT_VERSION, OrderBook: OrderBook{Bids: []Order{bid}, NextOrderID: 1}},
+			err:      errors.New("error validating snapshot in RestoreSnapshot(): order 1 is missing from the order index in validateBook()"),
+		},
+		"Order on the wrong side": {
+			snapshot: Snapshot{Version: SNAPSHOT_VERSION, OrderBook: OrderBook{Asks: []Order{bid}, OrderDict: map[int]string{1: SELL}, NextOrderID: 1}},
+			err:      errors.New("error validating snapshot in RestoreSnapshot(): order 1 is on the wrong side of the book in validateBook()"),
+		},
+		"Order id never handed out": {
+			snapshot: Snapshot{Version: SNAPSHOT_VERSION, OrderBook: OrderBook{Bids: []Order{bid}, OrderDict: map[int]string{1: BUY}}},
+			err:      errors.New("error validating snapshot in RestoreSnapshot(): order 1 has invalid exchange order id 1 in validateBook()"),
+		},
+		"Orders out of priority": {
+			snapshot: Snapshot{Version: SNAPSHOT_VERSION, OrderBook: OrderBook{
+				Bids:        []Order{bid, {OrderID: 2, UserID: 2, UserOrderID: 2, Price: intPrice(11), Quantity: 100, Side: BUY}},
+				OrderDict:   map[int]string{1: BUY, 2: BUY},
+				NextOrderID: 2,
+			}},
+			err: errors.New("error validating snapshot in RestoreSnapshot(): order 2 is out of priority in validateBook()"),
+		},
+	}
+
+	for name, test := range tests {
+		testService := NewOrderBookService()
+		err := testService.RestoreSnapshot(test.snapshot)
+		if (test.err == nil) != (err == nil) || (err != nil && err.Error() != test.err.Error()) {
+			t.Errorf("Expected error %s, received %s for test %s", test.err, err, name)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+// scenarioResult: everything one scenario printed, held back until the scenarios before it are written
+type scenarioResult struct {
+	service *OrderBookService
+	output  bytes.Buffer
+	err     error
+	done    chan struct{}
+}
+
+// ProcessOrderBooksParallel: processes the scenarios of an input file on a pool of workers goroutines,
+// one per CPU when workers is 0 or less. Each scenario runs on its own service configured like this one,
+// its output is buffered and written out in scenario order, so the result is identical to
+// ProcessOrderBooks. The first scenario carries on from the book this service holds, and when every
+// scenario is done the service holds the last one's, as it would after a sequential run.
+//
+// Scenarios only depend on each other through the journal, the stamped sequence numbers, the exchange
+// order ids the order feed prints and the service's time, so with any of those on, or with commands that
+// move the time or expire with it, they are processed one after another instead. So are they when OnEvent
+// or a subscriber is there to be handed every event as it is published. Otherwise the order ids
+// of each scenario are renumbered on from the one before once it is done. The Clock is shared by every
+// worker and has to be safe for concurrent use, as RealClock and an idle SimulatedClock are.
+func (o *OrderBookService) ProcessOrderBooksParallel(orderBooks [][]Order, workers int) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(orderBooks) {
+		workers = len(orderBooks)
+	}
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if workers <= 1 || o.Journal != nil || o.IsOutputStamped || o.OrderFeed != nil || dependsOnTime(orderBooks) ||
+		o.OnEvent != nil || len(o.subscribers) > 0 {
+		return o.processOrderBooks(orderBooks)
+	}
+
+	// every command takes one sequence number, so each scenario knows up front where its numbering starts
+	results := make([]*scenarioResult, len(orderBooks))
+	commandSequence := o.commandSequence
+	for i := range orderBooks {
+		result := &scenarioResult{done: make(chan struct{})}
+		orderBook := NewOrderBook()
+		if i == 0 {
+			orderBook = o.orderBook
+		}
+		result.service = o.scenarioService(orderBook, &result.output)
+		result.service.commandSequence = commandSequence
+		commandSequence += len(orderBooks[i])
+		results[i] = result
+	}
+
+	// stop tells the workers to leave the remaining scenarios alone once the output has run into an error
+	jobs := make(chan int, len(orderBooks))
+	stop := make(chan struct{})
+	defer close(stop)
+	for i := range orderBooks {
+		jobs <- i
+	}
+	close(jobs)
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				results[i].err = results[i].service.ProcessOrderBook(orderBooks[i])
+				close(results[i].done)
+			}
+		}()
+	}
+
+	// every scenario numbered its events on from where this service stood, and the scenarios after the
+	// first handed out exchange order ids from 0
+	eventSequence := o.eventSequence
+	nextOrderID := 0
+	for i, result := range results {
+		<-result.done
+		if i > 0 {
+			result.service.orderBook.shiftOrderIDs(nextOrderID)
+		}
+		nextOrderID = result.service.orderBook.NextOrderID
+		if err := o.writeScenario(i, result); err != nil {
+			return err
+		}
+		o.commandSequence = result.service.commandSequence
+		o.eventSequence += result.service.eventSequence - eventSequence
+		if result.service.currentTime.After(o.currentTime) {
+			o.currentTime = result.service.currentTime
+		}
+		o.orderBook = result.service.orderBook
+	}
+	return nil
+}
+
+// dependsOnTime: whether any command moves the service's time or expires with it, which makes a scenario
+// depend on the time the scenarios before it left the service at
+func dependsOnTime(orderBooks [][]Order) bool {
+	for _, orderBook := range orderBooks {
+		for _, command := range orderBook {
+			if command.Command == ADVANCE_CLOCK || !command.Timestamp.IsZero() || !command.ExpiresAt.IsZero() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeScenario: prints a finished scenario exactly as ProcessOrderBooks would have, stopping at its error
+func (o *OrderBookService) writeScenario(i int, result *scenarioResult) error {
+	o.writeScenarioStart(i)
+	if _, err := o.output().Write(result.output.Bytes()); err != nil {
+		return errors.Wrapf(err, "error writing output of order book %v in writeScenario()", i+1)
+	}
+	if result.err != nil {
+		return errors.Wrapf(result.err, "error Processing order book %v in ProcessOrderBooksParallel()", i+1)
+	}
+	o.writeScenarioEnd()
+	return nil
+}
+
+// scenarioService: a service with this one's configuration that prints to output and holds orderBook
+func (o *OrderBookService) scenarioService(orderBook OrderBook, output io.Writer) *OrderBookService {
+	return &OrderBookService{
+		IsTradingEnabled:    o.IsTradingEnabled,
+		DepthLevels:         o.DepthLevels,
+		CircuitBreaker:      o.CircuitBreaker,
+		SelfTradePrevention: o.SelfTradePrevention,
+		Allocation:          o.Allocation,
+		Instruments:         o.Instruments,
+		Output:              output,
+		OutputFormat:        o.OutputFormat,
+		Clock:               o.Clock,
+		currentTime:         o.currentTime,
+		eventSequence:       o.eventSequence,
+		orderBook:           orderBook,
+	}
+}
+
+// shiftOrderIDs: renumbers the exchange order ids of a book that handed them out from 0 on from offset
+func (b *OrderBook) shiftOrderIDs(offset int) {
+	for i := range b.Bids {
+		b.Bids[i].OrderID += offset
+	}
+	for i := range b.Asks {
+		b.Asks[i].OrderID += offset
+	}
+	for i := range b.Expiries {
+		b.Expiries[i].OrderID += offset
+	}
+	b.NextOrderID += offset
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func parallelScenarios() [][]Order {
+	var scenarios [][]Order
+	for i := 0; i < 12; i++ {
+		scenarios = append(scenarios, []Order{
+			{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: BUY},
+			{Command: NEW_ORDER, UserID: 2, UserOrderID: 2, Price: intPrice(int64(11 + i)), Quantity: 50, Side: SELL},
+			{Command: NEW_ORDER, UserID: 2, UserOrderID: 3, Price: intPrice(10), Quantity: 10 * (i + 1), Side: SELL},
+			{Command: CANCEL_ORDER, UserID: 2, UserOrderID: 2},
+			{Command: NEW_ORDER, UserID: 3, UserOrderID: 4, Price: intPrice(9), Quantity: 20, Side: BUY},
+			{Command: FLUSH_ORDER_BOOK},
+			{Command: NEW_ORDER, UserID: 1, UserOrderID: 5, Price: intPrice(12), Quantity: i + 1, Side: SELL},
+		})
+	}
+	return scenarios
+}
+
+// clockScenarios: good-till-date orders and clock commands whose outcome depends on where the scenarios
+// before them left the time
+func clockScenarios() [][]Order {
+	var scenarios [][]Order
+	for i := 0; i < 6; i++ {
+		scenarios = append(scenarios, []Order{
+			{Command: NEW_ORDER, UserID: 1, UserOrderID: 1, Price: intPrice(10), Quantity: 100, Side: BUY, ExpiresAt: time.Unix(150, 0)},
+			{Command: NEW_ORDER, UserID: 2, UserOrderID: 2, Price: intPrice(11), Quantity: 50, Side: SELL},
+			{Command: ADVANCE_CLOCK, Timestamp: time.Unix(int64(40*(i+1)), 0)},
+		})
+	}
+	return scenarios
+}
+
+func newParallelService(output *bytes.Buffer, feed *bytes.Buffer) *OrderBookService {
+	testService := NewOrderBookService()
+	testService.IsTradingEnabled = true
+	testService.DepthLevels = 2
+	testService.Output = output
+	if feed != nil {
+		testService.OrderFeed = NewOrderFeed(feed)
+	}
+	testService.Clock = NewSimulatedClock(time.Unix(0, 0))
+	return testService
+}
+
+func TestProcessOrderBooksParallel(t *testing.T) {
+	invalid := parallelScenarios()
+	invalid[4] = append(invalid[4][:2], Order{Command: NEW_ORDER, UserID: 1, UserOrderID: 9, Price: intPrice(8), Quantity: 10, Side: BUY})
+	tests := map[string]struct {
+		scenarios  [][]Order
+		workers    int
+		failOnLine string // the output is cut off at this line
+		hasFeed    bool
+		hasEvents  bool // OnEvent and a subscriber are handed the events
+	}{
+		"One worker":              {scenarios: parallelScenarios(), workers: 1},
+		"Fewer workers":           {scenarios: parallelScenarios(), workers: 3},
+		"One worker per CPU":      {scenarios: parallelScenarios(), workers: 0},
+		"More workers":            {scenarios: parallelScenarios(), workers: 20},
+		"Error in a scenario":     {scenarios: invalid, workers: 4, failOnLine: "A, 1, 9"},
+		"Single scenario":         {scenarios: parallelScenarios()[:1], workers: 4},
+		"No scenarios to process": {workers: 4},
+		"Order feed":              {scenarios: parallelScenarios(), workers: 4, hasFeed: true},
+		"Clock commands":          {scenarios: clockScenarios(), workers: 4},
+		"Event consumers":         {scenarios: parallelScenarios(), workers: 4, hasEvents: true},
+	}
+
+	for name, test := range tests {
+		var expectedOutput, expectedFeed, output, feed bytes.Buffer
+		sequential := newParallelService(&expectedOutput, nil)
+		parallel := newParallelService(&output, nil)
+		if test.hasFeed {
+			sequential = newParallelService(&expectedOutput, &expectedFeed)
+			parallel = newParallelService(&output, &feed)
+		}
+		if test.failOnLine != "" {
+			sequential.Output = &failingWriter{writer: &expectedOutput, line: test.failOnLine}
+			parallel.Output = &failingWriter{writer: &output, line: test.failOnLine}
+		}
+		var expectedEvents, events []string
+		var expectedSubscriber, subscriber <-chan Event
+		if test.hasEvents {
+			sequential.OnEvent = func(event Event) { expectedEvents = append(expectedEvents, event.String()) }
+			parallel.OnEvent = func(event Event) { events = append(events, event.String()) }
+			expectedSubscriber, _ = sequential.Subscribe(1024)
+			subscriber, _ = parallel.Subscribe(1024)
+		}
+		expectedErr := sequential.ProcessOrderBooks(test.scenarios)
+		err := parallel.ProcessOrderBooksParallel(test.scenarios, test.workers)
+		if test.hasEvents {
+			expectedSubscribed, subscribed := receivedLines(expectedSubscriber), receivedLines(subscriber)
+			if len(events) == 0 || !reflect.DeepEqual(events, expectedEvents) || !reflect.DeepEqual(subscribed, expectedSubscribed) {
+				t.Errorf("Expected events %v and %v, received %v and %v for test %s", expectedEvents, expectedSubscribed, events, subscribed, name)
+			}
+		}
+
+		if (err == nil) != (expectedErr == nil) || (test.failOnLine != "") != (err != nil) {
+			t.Errorf("Expected error %v, received %v for test %s", expectedErr, err, name)
+		}
+		if output.String() != expectedOutput.String() {
+			t.Errorf("Expected output %q, received %q for test %s", expectedOutput.String(), output.String(), name)
+		}
+		if expectedErr != nil {
+			continue
+		}
+		if feed.String() != expectedFeed.String() {
+			t.Errorf("Expected feed %q, received %q for test %s", expectedFeed.String(), feed.String(), name)
+		}
+		if !reflect.DeepEqual(parallel.orderBook, sequential.orderBook) {
+			t.Errorf("Expected book %v, received %v for test %s", sequential.orderBook, parallel.orderBook, name)
+		}
+		if parallel.commandSequence != sequential.commandSequence || parallel.eventSequence != sequential.eventSequence {
+			t.Errorf("Expected sequences %v and %v, received %v and %v for test %s", sequential.commandSequence, sequential.eventSequence, parallel.commandSequence, parallel.eventSequence, name)
+		}
+		if test.hasFeed && parallel.OrderFeed.Sequence != sequential.OrderFeed.Sequence {
+			t.Errorf("Expected feed sequence %v, received %v for test %s", sequential.OrderFeed.Sequence, parallel.OrderFeed.Sequence, name)
+		}
+	}
+}
+
+// receivedLines: the lines of the events waiting on a subscription
+func receivedLines(events <-chan Event) []string {
+	var lines []string
+	for {
+		select {
+		case event := <-events:
+			lines = append(lines, event.String())
+		default:
+			return lines
+		}
+	}
+}